@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// acpMcpServerName is the name the CLI knows the tool server by, which
+// gives its tools the ACPToolNamePrefix.
+const acpMcpServerName = "acp"
+
+// acpToolsVersion versions the tool definitions served to the CLI. Bump it
+// when a tool's name, schema or description changes.
+const acpToolsVersion = "1.3.0"
+
+// acpTool is a built-in tool served to the CLI, and the CLI's own tools it
+// replaces.
+type acpTool struct {
+	name     string
+	add      func(*mcp.Server, mcp.ToolHandler)
+	replaces []string
+	// available reports whether the client supports what the tool needs.
+	available func(acp.ClientCapabilities) bool
+}
+
+// Typed inputs of the served tools. Their JSON schemas are derived from
+// these, and match the inputs of the CLI tools they replace.
+type (
+	readToolInput struct {
+		FilePath string `json:"file_path" jsonschema:"The absolute path to the file to read"`
+		Offset   *int   `json:"offset,omitempty" jsonschema:"The line number to start reading from. Only provide if the file is too large to read at once"`
+		Limit    *int   `json:"limit,omitempty" jsonschema:"The number of lines to read. Only provide if the file is too large to read at once."`
+	}
+	writeToolInput struct {
+		FilePath string `json:"file_path" jsonschema:"The absolute path to the file to write (must be absolute, not relative)"`
+		Content  string `json:"content" jsonschema:"The content to write to the file"`
+	}
+	editToolInput struct {
+		FilePath             string `json:"file_path" jsonschema:"The absolute path to the file to modify"`
+		OldString            string `json:"old_string" jsonschema:"The text to replace"`
+		NewString            string `json:"new_string" jsonschema:"The text to replace it with (must be different from old_string)"`
+		ReplaceAll           bool   `json:"replace_all,omitempty" jsonschema:"Replace all occurrences of old_string (default false)"`
+		OccurrenceIndex      *int   `json:"occurrence_index,omitempty" jsonschema:"Replace only this occurrence of old_string, counting from 1"`
+		ExpectedReplacements *int   `json:"expected_replacements,omitempty" jsonschema:"The exact number of occurrences of old_string, all of which are replaced"`
+	}
+	multiEditToolInput struct {
+		FilePath string `json:"file_path" jsonschema:"The absolute path to the file to modify"`
+		Edits    []struct {
+			OldString  string `json:"old_string" jsonschema:"The text to replace"`
+			NewString  string `json:"new_string" jsonschema:"The text to replace it with"`
+			ReplaceAll bool   `json:"replace_all,omitempty" jsonschema:"Replace all occurrences of old_string (default false)"`
+		} `json:"edits" jsonschema:"Edits to perform in order, each on the result of the previous one"`
+	}
+	bashToolInput struct {
+		Command         string `json:"command" jsonschema:"The command to execute"`
+		Timeout         *int   `json:"timeout,omitempty" jsonschema:"Optional timeout in milliseconds (max 600000)"`
+		Description     string `json:"description,omitempty" jsonschema:"Clear, concise description of what this command does in 5-10 words"`
+		RunInBackground bool   `json:"run_in_background,omitempty" jsonschema:"Set to true to run this command in the background. Use BashOutput to read the output later."`
+		Cwd             string `json:"cwd,omitempty" jsonschema:"The directory to run the command in, absolute or relative to the session's working directory (default: the session's working directory)"`
+	}
+	bashOutputToolInput struct {
+		TaskID  string `json:"task_id" jsonschema:"The id of the background shell to retrieve output from"`
+		Block   bool   `json:"block,omitempty" jsonschema:"Wait for the command to finish before returning"`
+		Timeout *int   `json:"timeout,omitempty" jsonschema:"Maximum time to wait in milliseconds when blocking"`
+	}
+	killShellToolInput struct {
+		ShellID string `json:"shell_id" jsonschema:"The id of the background shell to kill"`
+	}
+	lsToolInput struct {
+		Path   string   `json:"path" jsonschema:"The absolute path to the directory to list"`
+		Depth  *int     `json:"depth,omitempty" jsonschema:"How many directory levels to list (default 1, max 5)"`
+		Ignore []string `json:"ignore,omitempty" jsonschema:"Glob patterns of entry names to leave out"`
+	}
+	notebookEditToolInput struct {
+		NotebookPath string `json:"notebook_path" jsonschema:"The absolute path to the Jupyter notebook file to edit"`
+		CellID       string `json:"cell_id,omitempty" jsonschema:"The ID of the cell to edit. When inserting, the new cell goes after this cell, or first if omitted"`
+		NewSource    string `json:"new_source" jsonschema:"The new source for the cell"`
+		CellType     string `json:"cell_type,omitempty" jsonschema:"The type of the cell (code or markdown). Required for insert"`
+		EditMode     string `json:"edit_mode,omitempty" jsonschema:"The type of edit to make (replace, insert or delete). Defaults to replace"`
+	}
+	webFetchToolInput struct {
+		URL    string `json:"url" jsonschema:"The URL to fetch content from"`
+		Prompt string `json:"prompt,omitempty" jsonschema:"What to look for in the fetched content"`
+	}
+)
+
+// acpTools are the tools the agent can serve, in the order they are listed.
+var acpTools = []acpTool{
+	{
+		name: "Read",
+		add: addACPTool[readToolInput](&mcp.Tool{
+			Name:        "Read",
+			Description: "Reads a file through the editor, including unsaved changes. The file_path must be absolute. By default it reads up to 2000 lines from the start of the file; use offset and limit for longer files. Lines are numbered starting at 1. Images are returned as image content when the editor can read them.",
+			Annotations: &mcp.ToolAnnotations{Title: "Read", ReadOnlyHint: true},
+		}),
+		replaces:  []string{"Read"},
+		available: func(c acp.ClientCapabilities) bool { return c.Fs.ReadTextFile },
+	},
+	{
+		name: "Write",
+		add: addACPTool[writeToolInput](&mcp.Tool{
+			Name:        "Write",
+			Description: "Writes a file through the editor, overwriting it if it exists. The file_path must be absolute. Read an existing file before overwriting it, and prefer Edit for changes to existing files.",
+			Annotations: &mcp.ToolAnnotations{Title: "Write", DestructiveHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"Write"},
+		available: func(c acp.ClientCapabilities) bool { return c.Fs.ReadTextFile && c.Fs.WriteTextFile },
+	},
+	{
+		name: "Edit",
+		add: addACPTool[editToolInput](&mcp.Tool{
+			Name:        "Edit",
+			Description: "Performs exact string replacements in a file through the editor. old_string must match the file exactly, including indentation, and be unique unless replace_all, occurrence_index or expected_replacements is given.",
+			Annotations: &mcp.ToolAnnotations{Title: "Edit", DestructiveHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"Edit"},
+		available: func(c acp.ClientCapabilities) bool { return c.Fs.ReadTextFile && c.Fs.WriteTextFile },
+	},
+	{
+		name: "MultiEdit",
+		add: addACPTool[multiEditToolInput](&mcp.Tool{
+			Name:        "MultiEdit",
+			Description: "Performs several exact string replacements in one file through the editor, in order. Each old_string must match the file as left by the previous edits and be unique unless replace_all is set. Either every edit is applied or none is.",
+			Annotations: &mcp.ToolAnnotations{Title: "MultiEdit", DestructiveHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"MultiEdit"},
+		available: func(c acp.ClientCapabilities) bool { return c.Fs.ReadTextFile && c.Fs.WriteTextFile },
+	},
+	{
+		name: "Bash",
+		add: addACPTool[bashToolInput](&mcp.Tool{
+			Name:        "Bash",
+			Description: "Executes a shell command in a terminal provided by the editor, with an optional timeout. Output is truncated past the session's output limit. Set run_in_background to start long-running commands and read their output with BashOutput.",
+			Annotations: &mcp.ToolAnnotations{Title: "Bash", DestructiveHint: acp.Ptr(true), OpenWorldHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"Bash"},
+		available: func(c acp.ClientCapabilities) bool { return c.Terminal },
+	},
+	{
+		name: "BashOutput",
+		add: addACPTool[bashOutputToolInput](&mcp.Tool{
+			Name:        "BashOutput",
+			Description: "Retrieves the output of a background shell started by Bash, optionally waiting for it to finish.",
+			Annotations: &mcp.ToolAnnotations{Title: "BashOutput", ReadOnlyHint: true},
+		}),
+		replaces:  []string{"BashOutput"},
+		available: func(c acp.ClientCapabilities) bool { return c.Terminal },
+	},
+	{
+		name: "KillShell",
+		add: addACPTool[killShellToolInput](&mcp.Tool{
+			Name:        "KillShell",
+			Description: "Kills a background shell started by Bash.",
+			Annotations: &mcp.ToolAnnotations{Title: "KillShell", DestructiveHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"KillShell"},
+		available: func(c acp.ClientCapabilities) bool { return c.Terminal },
+	},
+	{
+		name: "LS",
+		add: addACPTool[lsToolInput](&mcp.Tool{
+			Name:        "LS",
+			Description: "Lists the files and directories in a directory through the editor, with file sizes. The path must be absolute. Use depth to list subdirectories and ignore to leave out entries such as node_modules.",
+			Annotations: &mcp.ToolAnnotations{Title: "LS", ReadOnlyHint: true},
+		}),
+		replaces:  []string{"LS"},
+		available: func(c acp.ClientCapabilities) bool { return supportsExt(c, listDirectoryMethod) },
+	},
+	{
+		name: "NotebookEdit",
+		add: addACPTool[notebookEditToolInput](&mcp.Tool{
+			Name:        "NotebookEdit",
+			Description: "Replaces, inserts or deletes a cell of a Jupyter notebook (.ipynb file) through the editor. The notebook_path must be absolute. Cells are identified by cell_id; notebooks without cell IDs use cell-N, counting from 0.",
+			Annotations: &mcp.ToolAnnotations{Title: "NotebookEdit", DestructiveHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"NotebookEdit"},
+		available: func(c acp.ClientCapabilities) bool { return c.Fs.ReadTextFile && c.Fs.WriteTextFile },
+	},
+	{
+		name: "WebFetch",
+		add: addACPTool[webFetchToolInput](&mcp.Tool{
+			Name:        "WebFetch",
+			Description: "Fetches a URL and returns its content, with HTML converted to markdown. The URL must be absolute http or https. Redirects to another host are not followed; call WebFetch again with the redirect URL. Large pages are truncated.",
+			Annotations: &mcp.ToolAnnotations{Title: "WebFetch", ReadOnlyHint: true, OpenWorldHint: acp.Ptr(true)},
+		}),
+		replaces:  []string{"WebFetch"},
+		available: func(acp.ClientCapabilities) bool { return true },
+	},
+}
+
+// addACPTool returns a function registering tool with its schema derived
+// from In. The server validates arguments against the schema before the
+// handler sees them.
+func addACPTool[In any](tool *mcp.Tool) func(*mcp.Server, mcp.ToolHandler) {
+	return func(s *mcp.Server, h mcp.ToolHandler) {
+		t := *tool
+		t.Meta = mcp.Meta{"version": acpToolsVersion}
+		mcp.AddTool(s, &t, func(ctx context.Context, req *mcp.CallToolRequest, _ In) (*mcp.CallToolResult, any, error) {
+			result, err := h(ctx, req)
+			return result, nil, err
+		})
+	}
+}
+
+// acpToolServer serves the built-in tools to a session's CLI over
+// streamable HTTP on a loopback port. The URL carries a random token so
+// other local processes cannot call the tools.
+type acpToolServer struct {
+	URL   string
+	Tools []string // names of the served built-in tools
+	srv   *http.Server
+}
+
+// startACPToolServer starts the tool server for a session when the
+// agent's Tools.Serve option is set. It serves the permission prompt tool
+// and the built-in tools the client's capabilities allow. It returns nil
+// without the option or a client to serve. Calls are routed to the session
+// by ID when they arrive, so the server can start before the session
+// exists.
+func (a *ClaudeAcpAgent) startACPToolServer(sessionID string) (*acpToolServer, error) {
+	if !a.opts.Tools.Serve || a.clientCapabilities == nil {
+		return nil, nil
+	}
+	server := mcp.NewServer(&mcp.Implementation{Name: acpMcpServerName, Title: "ACP client tools", Version: acpToolsVersion}, &mcp.ServerOptions{
+		InitializedHandler: func(ctx context.Context, req *mcp.InitializedRequest) {
+			a.logger.Debug("ACP tool server initialized", "session", sessionID, "client", req.Session.InitializeParams().ClientInfo)
+		},
+	})
+	addPermissionPromptTool(server, a.permissionPromptHandler(sessionID))
+	var names []string
+	for _, tool := range acpTools {
+		if tool.available(*a.clientCapabilities) {
+			tool.add(server, a.acpToolHandler(sessionID))
+			names = append(names, tool.name)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ACP tool server: %w", err)
+	}
+	token := make([]byte, 16)
+	rand.Read(token)
+	path := "/mcp/" + hex.EncodeToString(token)
+	mux := http.NewServeMux()
+	mux.Handle(path, mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	s := &acpToolServer{
+		URL:   "http://" + l.Addr().String() + path,
+		Tools: names,
+		srv:   &http.Server{Handler: mux},
+	}
+	go func() {
+		if err := s.srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Warn("ACP tool server stopped", "session", sessionID, "error", err)
+		}
+	}()
+	return s, nil
+}
+
+// mcpConfig is the server's entry in the CLI's MCP configuration.
+func (s *acpToolServer) mcpConfig() McpServerConfig {
+	return McpServerConfig{Type: "http", URL: s.URL}
+}
+
+// replacedTools returns the CLI tools the served tools stand in for, which
+// the CLI is told not to use.
+func (s *acpToolServer) replacedTools() []string {
+	var names []string
+	for _, tool := range acpTools {
+		for _, name := range s.Tools {
+			if tool.name == name {
+				names = append(names, tool.replaces...)
+			}
+		}
+	}
+	return names
+}
+
+// acpEquivalents returns the served tool names, mcp__acp__ prefixed, of the
+// CLI tools among names. A rule such as "Bash(git:*)" maps to the served
+// tool with the same rule.
+func acpEquivalents(names []string) []string {
+	var out []string
+	for _, name := range names {
+		tool, rule, _ := strings.Cut(name, "(")
+		if slices.ContainsFunc(acpTools, func(t acpTool) bool { return t.name == tool }) {
+			if rule != "" {
+				rule = "(" + rule
+			}
+			out = append(out, ACPToolNamePrefix+tool+rule)
+		}
+	}
+	return out
+}
+
+// Close stops the server. It is safe to call on nil.
+func (s *acpToolServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
+
+// acpToolHandler returns the MCP handler running a session's tool calls
+// through handleBuiltinTool.
+func (a *ClaudeAcpAgent) acpToolHandler(sessionID string) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		session, err := a.lookupSession(sessionID)
+		if err != nil {
+			return toolErrorResult(err.Error()), nil
+		}
+		var input map[string]any
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &input); err != nil {
+				return toolErrorResult(err.Error()), nil
+			}
+		}
+		log := session.log().With("tool", req.Params.Name, "toolCallId", toolUseIDFromMeta(req.Params.Meta))
+		// The CLI is quiet while it waits for the tool, which may run a
+		// long Bash command.
+		watchdog := session.runningWatchdog()
+		watchdog.pause()
+		defer watchdog.resume()
+		var result BuiltinToolResult
+		err = session.toolOptions.scheduler.run(ctx, req.Params.Name, func() {
+			result, err = handleBuiltinTool(ctx, a.conn, sessionID, req.Params.Name, input, session.toolOptions)
+		})
+		if err != nil {
+			log.Warn("Built-in tool call failed", "error", err)
+			session.recordError(err)
+			return toolErrorResult(err.Error()), nil
+		}
+		log.Debug("Built-in tool call finished", "isError", result.IsError)
+		return toMCPResult(result), nil
+	}
+}
+
+// toMCPResult converts a built-in tool result to MCP content.
+func toMCPResult(result BuiltinToolResult) *mcp.CallToolResult {
+	res := &mcp.CallToolResult{IsError: result.IsError}
+	if result.Text != "" || len(result.Images) == 0 {
+		res.Content = append(res.Content, &mcp.TextContent{Text: result.Text})
+	}
+	for _, img := range result.Images {
+		data, err := base64.StdEncoding.DecodeString(img.Data)
+		if err != nil {
+			continue
+		}
+		res.Content = append(res.Content, &mcp.ImageContent{Data: data, MIMEType: img.MimeType})
+	}
+	return res
+}
+
+func toolErrorResult(msg string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{IsError: true, Content: []mcp.Content{&mcp.TextContent{Text: msg}}}
+}
+
+// toolUseIDFromMeta returns the ID of the tool_use block a tool call runs,
+// which the CLI passes in the call's _meta.
+func toolUseIDFromMeta(meta mcp.Meta) string {
+	id, _ := meta["claudecode/toolUseId"].(string)
+	return id
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestACPToolServer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{Tools: BuiltinToolOptions{Serve: true}})
+	agent.clientCapabilities = &acp.ClientCapabilities{
+		Fs:   acp.FileSystemCapability{ReadTextFile: true},
+		Meta: map[string]any{listDirectoryMethod: true},
+	}
+	agent.sessions["s1"] = &Session{cwd: dir}
+
+	server, err := agent.startACPToolServer("s1")
+	if err != nil || server == nil {
+		t.Fatalf("start: %v, %v", server, err)
+	}
+	defer server.Close()
+	if got := server.replacedTools(); !slices.Equal(got, []string{"Read", "LS", "WebFetch"}) {
+		t.Errorf("replaced tools = %v", got)
+	}
+
+	ctx := context.Background()
+	client := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "1"}, nil)
+	cs, err := client.Connect(ctx, &mcp.StreamableClientTransport{Endpoint: server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	if info := cs.InitializeResult().ServerInfo; info.Name != acpMcpServerName || info.Version != acpToolsVersion {
+		t.Errorf("server info = %+v", info)
+	}
+
+	tools, err := cs.ListTools(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tool := range tools.Tools {
+		names = append(names, tool.Name)
+	}
+	if slices.Sort(names); !slices.Equal(names, []string{"LS", "Read", "WebFetch", permissionPromptToolName}) {
+		t.Fatalf("tools = %v", names)
+	}
+
+	path := filepath.Join(dir, "notes.md")
+	writeTestFile(t, path, "hello\n")
+	res, err := cs.CallTool(ctx, &mcp.CallToolParams{Name: "Read", Arguments: map[string]any{"file_path": path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := res.Content[0].(*mcp.TextContent); res.IsError || !ok || !strings.HasPrefix(text.Text, "     1→hello\n") {
+		t.Errorf("Read result = %+v", res.Content[0])
+	}
+
+	// Arguments are checked against the schema.
+	if res, err := cs.CallTool(ctx, &mcp.CallToolParams{Name: "Read", Arguments: map[string]any{"offset": 1}}); err == nil && !res.IsError {
+		t.Error("expected an error for a call missing file_path")
+	}
+}
+
+func TestACPToolServer_ToolsDisabled(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{Tools: BuiltinToolOptions{Serve: true}})
+	if server, err := agent.startACPToolServer("s1"); server != nil || err != nil {
+		t.Errorf("got %v, %v; want no server before initialize", server, err)
+	}
+
+	// Without the option there is no server, and so no permission prompt
+	// tool, whatever the client can do.
+	agent.opts.Tools.Serve = false
+	agent.clientCapabilities = &acp.ClientCapabilities{Fs: acp.FileSystemCapability{ReadTextFile: true}}
+	if server, err := agent.startACPToolServer("s1"); server != nil || err != nil {
+		t.Errorf("got %v, %v; want no server without the option", server, err)
+	}
+}
+
+// The served tools, and so the CLI tools they replace, follow the client's
+// capabilities, so the model never sees a tool the client cannot run.
+func TestACPToolServer_CapabilityGating(t *testing.T) {
+	tests := []struct {
+		name string
+		caps acp.ClientCapabilities
+		want []string
+	}{
+		{"none", acp.ClientCapabilities{}, []string{"WebFetch"}},
+		{"write only", acp.ClientCapabilities{Fs: acp.FileSystemCapability{WriteTextFile: true}}, []string{"WebFetch"}},
+		{"terminal", acp.ClientCapabilities{Terminal: true}, []string{"Bash", "BashOutput", "KillShell", "WebFetch"}},
+		{
+			"files",
+			acp.ClientCapabilities{Fs: acp.FileSystemCapability{ReadTextFile: true, WriteTextFile: true}},
+			[]string{"Read", "Write", "Edit", "MultiEdit", "NotebookEdit", "WebFetch"},
+		},
+		{"list directory", acp.ClientCapabilities{Meta: map[string]any{listDirectoryMethod: true}}, []string{"LS", "WebFetch"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{Tools: BuiltinToolOptions{Serve: true}})
+			agent.clientCapabilities = &tt.caps
+			server, err := agent.startACPToolServer("s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			if !slices.Equal(server.Tools, tt.want) {
+				t.Errorf("tools = %v, want %v", server.Tools, tt.want)
+			}
+			if got := server.replacedTools(); !slices.Equal(got, tt.want) {
+				t.Errorf("replaced tools = %v", got)
+			}
+		})
+	}
+}
+
+func TestMetaToolNames(t *testing.T) {
+	meta := map[string]any{
+		"allowedTools":    []any{"Read", "Bash(git:*)", "WebSearch"},
+		"disallowedTools": []any{"Read", 3},
+	}
+	got, err := metaToolNames(meta, "allowedTools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Read", "Bash(git:*)", "WebSearch", ACPToolNamePrefix + "Read", ACPToolNamePrefix + "Bash(git:*)"}
+	if !slices.Equal(got, want) {
+		t.Errorf("allowed tools = %v, want %v", got, want)
+	}
+	if _, err := metaToolNames(meta, "disallowedTools"); err == nil {
+		t.Error("expected an error for a non-string tool name")
+	}
+	if got, err := metaToolNames(nil, "allowedTools"); got != nil || err != nil {
+		t.Errorf("without meta: got %v, %v", got, err)
+	}
+}
+
+// A long built-in tool call does not count as the turn going idle.
+func TestACPToolHandler_PausesWatchdog(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	client := newFakeTerminals()
+	session := &Session{toolOptions: BuiltinToolOptions{limiter: newToolLimiter(ToolLimits{})}}
+	session.toolOptions.terminals = newTerminalManager(client, "s1", session.toolOptions)
+	session.watchdog = newTurnWatchdog(20*time.Millisecond, func() {}, func() {})
+	defer session.watchdog.stop()
+	agent.sessions["s1"] = session
+
+	done := make(chan *mcp.CallToolResult)
+	go func() {
+		res, _ := agent.acpToolHandler("s1")(context.Background(), &mcp.CallToolRequest{
+			Params: &mcp.CallToolParamsRaw{Name: "Bash", Arguments: json.RawMessage(`{"command":"make"}`)},
+		})
+		done <- res
+	}()
+	time.Sleep(80 * time.Millisecond)
+	if session.watchdog.expired() {
+		t.Error("watchdog fired during the tool call")
+	}
+	client.exit("term-1")
+	if res := <-done; res.IsError {
+		t.Errorf("unexpected result %+v", res.Content[0])
+	}
+}
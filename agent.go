@@ -2,18 +2,21 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"acp4all/extplugin"
 	acp "github.com/coder/acp-go-sdk"
 )
 
@@ -23,27 +26,99 @@ type ClaudeAcpAgent struct {
 	conn               *acp.AgentSideConnection
 	sessions           map[string]*Session
 	mu                 sync.RWMutex
-	toolUseCache       map[string]ToolUseEntry
 	clientCapabilities *acp.ClientCapabilities
 	logger             *slog.Logger
 	allowBypass        bool
+	opts               AgentOptions
+	extMethods         map[string]extMethodHandler
+	extNotifications   map[string]extMethodHandler
+	extCalls           *extCalls
+	extOut             io.Writer // connection writer for extension notifications
+	cliVerified        sync.Map  // backend/executable pairs that passed Verify
+	apiKey             string    // from Authenticate; guarded by mu, never logged
+	// hub shares sessions with other connections; nil outside the websocket and socket transports.
+	hub *sessionHub
+	ids IDSource
+}
+
+// AgentOptions configures agent-wide behavior shared by all sessions.
+type AgentOptions struct {
+	// CoalesceWindow is how long streamed text deltas are buffered before
+	// being sent as one update. Zero or negative disables coalescing.
+	CoalesceWindow time.Duration
+	// CoalesceBytes flushes buffered text once it reaches this size.
+	CoalesceBytes int
+	// IDs generates session, tool call and other IDs; nil uses random
+	// IDs.
+	IDs IDSource
+	// UpdateQueueSize bounds the session updates waiting to be written to
+	// the client; zero uses DefaultUpdateQueueSize.
+	UpdateQueueSize int
+	// SettingsFile is an extra settings file merged into every session
+	// above the project's local settings.
+	SettingsFile string
+	// Executable is the claude CLI path; empty falls back to
+	// $CLAUDE_CODE_EXECUTABLE and then "claude" on PATH.
+	Executable string
+	// MaxTurns bounds agentic turns per prompt; zero uses 200.
+	MaxTurns int
+	// MaxMessageSize is the largest CLI message accepted; zero uses
+	// MaxMessageSize.
+	MaxMessageSize int
+	// MaxImageBytes is the largest decoded image, such as a tool's
+	// screenshot, sent to the client; larger ones are downscaled or
+	// dropped. Zero uses DefaultMaxImageBytes.
+	MaxImageBytes int
+	// MaxToolOutput caps the text of each tool call content sent to
+	// the client; longer text keeps its head and tail, and the full text
+	// is saved to a temporary file. Zero uses DefaultMaxToolOutputBytes.
+	MaxToolOutput int
+	// StrictProtocol checks each CLI message against the known message
+	// types and fields: "warn" logs mismatches and "fail" also ends the
+	// turn with an error. Empty turns checking off.
+	StrictProtocol string
+	// PermissionMode is the mode new sessions start in, overriding the
+	// settings' defaultMode; empty uses the settings. Where bypass is not
+	// allowed, bypassPermissions falls back to default.
+	PermissionMode string
+	// AllowBypass offers bypassPermissions mode, in which the CLI runs
+	// every tool without asking. It stays unavailable to root outside a
+	// sandbox.
+	AllowBypass bool
+	// TurnIdleTimeout interrupts a turn, which then fails, once the CLI
+	// has sent nothing for this long. Zero or less waits forever.
+	TurnIdleTimeout time.Duration
+	// SuppressThoughts drops thinking output instead of sending agent
+	// thought updates. Sessions may override it with _meta.suppressThoughts.
+	SuppressThoughts bool
+	// Tools configures the built-in file and shell tools.
+	Tools BuiltinToolOptions
+	// Backend is the agent CLI sessions run; nil uses Claude Code.
+	Backend Backend
+	// ExtPlugins serve extension methods the agent does not handle itself.
+	ExtPlugins []extplugin.Plugin
+	// EnvFilter limits the agent environment the CLI inherits.
+	EnvFilter EnvFilter
+	// Proxy is the default for sessions whose settings and meta set no
+	// proxy.
+	Proxy ProxySettings
 }
 
 // Compile-time interface checks.
 var _ acp.Agent = (*ClaudeAcpAgent)(nil)
 
 // NewClaudeAcpAgent creates a new ClaudeAcpAgent.
-func NewClaudeAcpAgent(logger *slog.Logger) *ClaudeAcpAgent {
-	allowBypass := true
-	if isRootUser() && os.Getenv("IS_SANDBOX") == "" {
-		allowBypass = false
-	}
-	return &ClaudeAcpAgent{
-		sessions:     make(map[string]*Session),
-		toolUseCache: make(map[string]ToolUseEntry),
-		logger:       logger,
-		allowBypass:  allowBypass,
+func NewClaudeAcpAgent(logger *slog.Logger, opts AgentOptions) *ClaudeAcpAgent {
+	a := &ClaudeAcpAgent{
+		sessions:    make(map[string]*Session),
+		logger:      logger,
+		allowBypass: opts.AllowBypass && bypassUnavailableReason(opts) == "",
+		opts:        opts,
+		ids:         idsOr(opts.IDs),
 	}
+	a.registerExtMethods()
+	a.installExtPlugins(opts.ExtPlugins)
+	return a
 }
 
 // SetAgentConnection stores the ACP connection for sending notifications.
@@ -60,13 +135,19 @@ var validModes = []acp.SessionMode{
 	{Id: "bypassPermissions", Name: "Bypass Permissions", Description: acp.Ptr("Skip all permission prompts")},
 }
 
+// validPermissionMode reports whether mode is a --permission-mode value;
+// empty leaves the mode to the settings.
+func validPermissionMode(mode string) bool {
+	return mode == "" || slices.ContainsFunc(validModes, func(m acp.SessionMode) bool { return string(m.Id) == mode })
+}
+
 // Initialize handles the ACP initialize handshake.
 func (a *ClaudeAcpAgent) Initialize(ctx context.Context, params acp.InitializeRequest) (acp.InitializeResponse, error) {
 	caps := params.ClientCapabilities
 	a.clientCapabilities = &caps
 
 	authMethod := acp.AuthMethod{
-		Id:          "claude-login",
+		Id:          authMethodClaudeLogin,
 		Name:        "Log in with Claude Code",
 		Description: acp.Ptr("Run `claude /login` in the terminal"),
 	}
@@ -104,27 +185,50 @@ func (a *ClaudeAcpAgent) Initialize(ctx context.Context, params acp.InitializeRe
 		AgentInfo: &acp.Implementation{
 			Name:    "claude-code-acp",
 			Title:   &title,
-			Version: "0.1.0",
+			Version: versionString(),
 		},
-		AuthMethods: []acp.AuthMethod{authMethod},
+		AuthMethods: []acp.AuthMethod{authMethod, apiKeyAuthMethod},
+		Meta:        map[string]any{"claudeCode": map[string]any{"sessionModes": a.sessionModesMeta()}},
 	}, nil
 }
 
-// Authenticate handles authentication requests.
-func (a *ClaudeAcpAgent) Authenticate(_ context.Context, _ acp.AuthenticateRequest) (acp.AuthenticateResponse, error) {
-	return acp.AuthenticateResponse{}, nil
+// bypassUnavailableReason explains why bypassPermissions is not offered,
+// or returns "" if it is.
+func bypassUnavailableReason(opts AgentOptions) string {
+	switch {
+	case !opts.AllowBypass:
+		return "Bypass Permissions is disabled; start the agent with --dangerously-allow-bypass to enable it"
+	case isRootUser() && os.Getenv("IS_SANDBOX") == "":
+		return "Bypass Permissions is unavailable because the agent runs as root; set IS_SANDBOX=1 when running in a sandbox"
+	}
+	return ""
+}
+
+// sessionModesMeta describes the modes sessions offer, so clients can show
+// a mode picker before session/new. A session's project settings may still
+// disable bypassPermissions.
+func (a *ClaudeAcpAgent) sessionModesMeta() map[string]any {
+	meta := map[string]any{"availableModes": filterModes(a.allowBypass)}
+	if !a.allowBypass {
+		meta["bypassUnavailableReason"] = bypassUnavailableReason(a.opts)
+	}
+	return meta
 }
 
 // NewSession creates a new Claude Code session.
 func (a *ClaudeAcpAgent) NewSession(ctx context.Context, params acp.NewSessionRequest) (acp.NewSessionResponse, error) {
-	if backupExistsWithoutPrimary() {
-		return acp.NewSessionResponse{}, acp.NewAuthRequired(nil)
+	if !a.hasAPIKey() && backupExistsWithoutPrimary() {
+		return acp.NewSessionResponse{}, errAuthRequired("", "Claude Code is not logged in", authMethodClaudeLogin)
 	}
-	sessionID := generateID()
+	sessionID := a.ids.NewID()
+	logger := a.logger.With("session", sessionID)
 
-	settingsMgr := NewSettingsManager(params.Cwd, a.logger)
+	settingsMgr := NewSettingsManager(params.Cwd, logger)
+	if err := a.addExtraSettings(settingsMgr, params); err != nil {
+		return acp.NewSessionResponse{}, err
+	}
 	if err := settingsMgr.Initialize(); err != nil {
-		a.logger.Error("Failed to initialize settings", "error", err)
+		logger.Error("Failed to initialize settings", "error", err)
 	}
 
 	settings := settingsMgr.GetSettings()
@@ -132,9 +236,16 @@ func (a *ClaudeAcpAgent) NewSession(ctx context.Context, params acp.NewSessionRe
 	if settings.Permissions != nil && settings.Permissions.DefaultMode != "" {
 		permissionMode = settings.Permissions.DefaultMode
 	}
-	if permissionMode == "bypassPermissions" && !a.allowBypass {
+	if a.opts.PermissionMode != "" {
+		permissionMode = a.opts.PermissionMode
+	}
+	allowBypass := a.allowBypass && !settings.BypassPermissionsDisabled()
+	if permissionMode == "bypassPermissions" && !allowBypass {
 		permissionMode = "default"
 	}
+	if permissionMode == "bypassPermissions" {
+		auditPermission(logger, "Session starts in bypassPermissions mode")
+	}
 
 	var maxThinkingTokens int
 	if v := os.Getenv("MAX_THINKING_TOKENS"); v != "" {
@@ -143,10 +254,45 @@ func (a *ClaudeAcpAgent) NewSession(ctx context.Context, params acp.NewSessionRe
 		}
 	}
 
-	executable := os.Getenv("CLAUDE_CODE_EXECUTABLE")
+	executable := a.opts.Executable
+	if executable == "" {
+		executable = os.Getenv("CLAUDE_CODE_EXECUTABLE")
+	}
 
-	// Extract system prompt from _meta if provided
+	// Extract system prompt and thinking options from _meta if provided.
+	// suppressThoughts drops thought updates for this session;
+	// maxThinkingTokens overrides MAX_THINKING_TOKENS, and 0 turns
+	// extended thinking off in the CLI. agent selects a subagent to run
+	// the session as; includeMentionedFiles attaches files mentioned in
+	// prompts as context; readOnly, like the readOnly setting, rejects
+	// Write, Edit and Bash whatever the permission mode; httpProxy,
+	// httpsProxy and noProxy override the proxy settings; autoCommit, like
+	// the autoCommit setting, commits the files each successful turn edits;
+	// allowedTools and disallowedTools are passed to the CLI's flags of the
+	// same name, and apply to the served equivalents of the tools too.
+	sessionMeta, _ := params.Meta.(map[string]any)
 	var systemPrompt string
+	agentName, _ := sessionMeta["agent"].(string)
+	includeMentions, _ := sessionMeta["includeMentionedFiles"].(bool)
+	readOnly, _ := sessionMeta["readOnly"].(bool)
+	readOnly = readOnly || (settings.ReadOnly != nil && *settings.ReadOnly)
+	autoCommit, ok := sessionMeta["autoCommit"].(bool)
+	if !ok {
+		autoCommit = settings.AutoCommit != nil && *settings.AutoCommit
+	}
+	allowedTools, err := metaToolNames(sessionMeta, "allowedTools")
+	if err != nil {
+		return acp.NewSessionResponse{}, err
+	}
+	disallowedTools, err := metaToolNames(sessionMeta, "disallowedTools")
+	if err != nil {
+		return acp.NewSessionResponse{}, err
+	}
+	if readOnly {
+		disallowedTools = append(disallowedTools, readOnlyDisallowedTools()...)
+	}
+	suppressThoughts := a.opts.SuppressThoughts
+	disableThinking := false
 	if params.Meta != nil {
 		if meta, ok := params.Meta.(map[string]any); ok {
 			if sp, ok := meta["systemPrompt"]; ok {
@@ -154,60 +300,339 @@ func (a *ClaudeAcpAgent) NewSession(ctx context.Context, params acp.NewSessionRe
 					systemPrompt = s
 				}
 			}
+			if v, ok := meta["suppressThoughts"].(bool); ok {
+				suppressThoughts = v
+			}
+			if v, ok := meta["maxThinkingTokens"].(float64); ok && v >= 0 {
+				maxThinkingTokens = int(v)
+				disableThinking = maxThinkingTokens == 0
+			}
+		}
+	}
+
+	env, err := resolveSessionEnv(ctx, settings, params.Cwd)
+	if err != nil {
+		return acp.NewSessionResponse{}, newAgentError(acp.NewInternalError, errKindSettings, "", true, "failed to resolve settings env: "+err.Error())
+	}
+	env = a.withAPIKey(env)
+	env = sessionProxy(sessionMeta, settings).or(a.opts.Proxy).withEnv(env)
+
+	var extraSettingsJSON string
+	if extra, ok := settingsMgr.ExtraSettings(); ok {
+		b, err := json.Marshal(extra)
+		if err != nil {
+			return acp.NewSessionResponse{}, newAgentError(acp.NewInternalError, errKindSettings, "", false, "failed to encode extra settings: "+err.Error())
+		}
+		extraSettingsJSON = string(b)
+	}
+
+	mcpServers := mapMcpServers(params.McpServers)
+	toolServer, err := a.startACPToolServer(sessionID)
+	if err != nil {
+		return acp.NewSessionResponse{}, errCLIStart(err)
+	}
+	var permissionPromptTool string
+	if toolServer != nil {
+		permissionPromptTool = ACPToolNamePrefix + permissionPromptToolName
+		if mcpServers == nil {
+			mcpServers = map[string]McpServerConfig{}
+		}
+		mcpServers[acpMcpServerName] = toolServer.mcpConfig()
+		disallowedTools = append(disallowedTools, toolServer.replacedTools()...)
+	}
+
+	backend := a.backend()
+	verifyKey := backend.Name() + "\x00" + executable
+	if _, ok := a.cliVerified.Load(verifyKey); !ok {
+		if err := backend.Verify(executable); err != nil {
+			toolServer.Close()
+			return acp.NewSessionResponse{}, errCLIStart(err)
 		}
+		a.cliVerified.Store(verifyKey, true)
 	}
 
-	proc, err := NewClaudeCodeProcess(ClaudeCodeOptions{
+	startOpts := ProcessOptions{
 		Cwd:               params.Cwd,
 		SessionID:         sessionID,
 		PermissionMode:    permissionMode,
-		MaxTurns:          200,
+		MaxTurns:          a.opts.MaxTurns,
+		MaxMessageSize:    a.opts.MaxMessageSize,
 		MaxThinkingTokens: maxThinkingTokens,
+		DisableThinking:   disableThinking,
 		Executable:        executable,
 		SystemPrompt:      systemPrompt,
-		McpServers:        mapMcpServers(params.McpServers),
-	})
+		McpServers:        mcpServers,
+		Model:             settings.Model,
+		Env:               env,
+		Settings:          extraSettingsJSON,
+		Agent:             agentName,
+		AllowedTools:      allowedTools,
+		DisallowedTools:   disallowedTools,
+		EnvFilter:         a.opts.EnvFilter,
+		PermissionPrompt:  permissionPromptTool,
+		Logger:            logger,
+	}
+	logger.Debug("Starting CLI", "mcpServers", mcpServersLog(mcpServers))
+	proc, err := backend.Start(startOpts)
 	if err != nil {
-		return acp.NewSessionResponse{}, fmt.Errorf("failed to start Claude Code: %w", err)
+		toolServer.Close()
+		return acp.NewSessionResponse{}, errCLIStart(err)
 	}
 
 	session := &Session{
-		process:         proc,
-		permissionMode:  permissionMode,
-		settingsManager: settingsMgr,
+		process:          proc,
+		backend:          backend,
+		startOptions:     startOpts,
+		cwd:              params.Cwd,
+		permissionMode:   permissionMode,
+		settingsManager:  settingsMgr,
+		allowBypass:      allowBypass,
+		suppressThoughts: suppressThoughts,
+		includeMentions:  includeMentions,
+		autoCommit:       autoCommit,
+		toolServer:       toolServer,
+		toolOptions:      a.opts.Tools.withLimits(sessionMeta, env),
+		toolUseCache:     NewToolUseCache(DefaultToolUseCacheSize),
+		logger:           logger,
+		created:          time.Now(),
+	}
+	session.toolOptions.ReadOnly = readOnly
+	session.toolOptions.cwd = params.Cwd
+	session.toolOptions.limiter = newToolLimiter(session.toolOptions.Limits)
+	session.toolOptions.ids = a.ids
+	session.compaction.ids = a.ids
+	session.toolOptions.checkpoints = &session.checkpoints
+	session.toolOptions.listDir = a.clientDirLister(sessionID)
+	session.toolOptions.readFile = a.clientFileReader(sessionID)
+	session.toolOptions.fetch = a.clientWebFetcher(sessionID)
+	if session.toolOptions.fetch == nil {
+		session.toolOptions.fetch = httpWebFetcher(sessionProxy(sessionMeta, settings).or(a.opts.Proxy))
+	}
+	session.toolOptions.settings = settingsMgr
+	session.toolOptions.changes = &session.changedFiles
+	session.toolOptions.scheduler = newToolScheduler(session.toolOptions.parallelLimit())
+	if a.conn != nil {
+		session.toolOptions.terminals = newTerminalManager(a.conn, sessionID, session.toolOptions)
 	}
+	if a.clientSupportsExt(fileChangedMethod) {
+		session.toolOptions.files = newFileCache()
+	}
+	session.toolUseCache.SetToolAnnotations(parseMCPToolAnnotations(sessionMeta))
 
 	a.mu.Lock()
 	a.sessions[sessionID] = session
 	a.mu.Unlock()
+	if a.hub != nil {
+		a.hub.add(sessionID, a)
+	}
 
 	return acp.NewSessionResponse{
 		SessionId: acp.SessionId(sessionID),
 		Modes: &acp.SessionModeState{
 			CurrentModeId:  acp.SessionModeId(permissionMode),
-			AvailableModes: filterModes(a.allowBypass),
+			AvailableModes: filterModes(allowBypass),
 		},
+		Meta: customizationsMeta(params.Cwd, agentName, settingsMgr),
 	}, nil
 }
 
+// closeSessions disposes of the agent once its client connection has
+// closed: it leaves the session hub, then cancels every session it owns and
+// closes their CLI processes, waiting for them to exit.
+func (a *ClaudeAcpAgent) closeSessions() {
+	if a.hub != nil {
+		a.hub.removeAgent(a)
+	}
+	a.mu.Lock()
+	sessions := a.sessions
+	a.sessions = make(map[string]*Session)
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.Cancel()
+			if proc := session.proc(); proc != nil {
+				if err := proc.Close(); err != nil {
+					session.log().Debug("Closing session process failed", "error", err)
+				}
+			}
+			if session.settingsManager != nil {
+				session.settingsManager.Dispose()
+			}
+			session.toolServer.Close()
+			closeCtx, cancel := context.WithTimeout(context.Background(), terminalCloseTimeout)
+			session.toolOptions.terminals.Close(closeCtx)
+			cancel()
+			if err := session.toolOutputs.remove(); err != nil {
+				session.log().Debug("Removing saved tool output failed", "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// backend returns the backend sessions run.
+func (a *ClaudeAcpAgent) backend() Backend {
+	if a.opts.Backend == nil {
+		return defaultBackend
+	}
+	return a.opts.Backend
+}
+
+// addExtraSettings registers the agent's --settings file and any settings
+// passed in the session's _meta.settings, which may be a file path
+// (relative to the session cwd) or an inline settings object.
+func (a *ClaudeAcpAgent) addExtraSettings(mgr *SettingsManager, params acp.NewSessionRequest) error {
+	if a.opts.SettingsFile != "" {
+		if err := mgr.AddSettingsFile(a.opts.SettingsFile); err != nil {
+			return newAgentError(acp.NewInternalError, errKindSettings, "", false, "failed to load settings file: "+err.Error())
+		}
+	}
+
+	meta, _ := params.Meta.(map[string]any)
+	switch v := meta["settings"].(type) {
+	case nil:
+	case string:
+		path := v
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(params.Cwd, path)
+		}
+		if err := mgr.AddSettingsFile(path); err != nil {
+			return newAgentError(acp.NewInvalidParams, errKindSettings, "", false, err.Error())
+		}
+	case map[string]any:
+		b, _ := json.Marshal(v)
+		var settings ClaudeCodeSettings
+		if err := json.Unmarshal(b, &settings); err != nil {
+			return newAgentError(acp.NewInvalidParams, errKindSettings, "", false, "invalid _meta.settings: "+err.Error())
+		}
+		mgr.AddSettings(settings)
+	default:
+		return newAgentError(acp.NewInvalidParams, errKindSettings, "", false, "_meta.settings must be a path or an object")
+	}
+
+	// _meta.outputStyle selects an output style for this session only.
+	if style, ok := meta["outputStyle"].(string); ok && style != "" {
+		mgr.AddSettings(ClaudeCodeSettings{OutputStyle: style})
+	}
+	return nil
+}
+
+// metaToolNames returns the tool names in _meta[key] together with the
+// names of the served tools equivalent to them.
+func metaToolNames(meta map[string]any, key string) ([]string, error) {
+	v, ok := meta[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	var names []string
+	for _, item := range list {
+		name, isString := item.(string)
+		if !isString || name == "" {
+			ok = false
+			break
+		}
+		names = append(names, name)
+	}
+	if !ok {
+		return nil, acp.NewInvalidParams(map[string]any{"error": fmt.Sprintf("_meta.%s must be a list of tool names", key)})
+	}
+	return append(names, acpEquivalents(names)...), nil
+}
+
 // Prompt handles a user prompt by forwarding it to the Claude Code subprocess.
 func (a *ClaudeAcpAgent) Prompt(ctx context.Context, params acp.PromptRequest) (acp.PromptResponse, error) {
+	session, err := a.lookupSession(string(params.SessionId))
+	if err != nil {
+		return acp.PromptResponse{}, err
+	}
+	session.turnMu.Lock()
+	defer session.turnMu.Unlock()
+	return a.runTurn(ctx, session, params)
+}
+
+// runTurn runs a prompt as the session's turn. The caller holds turnMu.
+func (a *ClaudeAcpAgent) runTurn(ctx context.Context, session *Session, params acp.PromptRequest) (response acp.PromptResponse, err error) {
 	sessionID := string(params.SessionId)
+	defer func() {
+		if err != nil {
+			session.recordError(err)
+		}
+	}()
 
-	a.mu.RLock()
-	session, ok := a.sessions[sessionID]
-	a.mu.RUnlock()
-	if !ok {
-		return acp.PromptResponse{}, fmt.Errorf("session not found: %s", sessionID)
+	log := session.beginTurn()
+	turnID := session.turnID()
+	defer func() {
+		if err == nil {
+			response.Meta = withClaudeCodeMeta(response.Meta, "turnId", turnID)
+		}
+	}()
+	session.ResetCancelled()
+	// The client sent the prompt, so it can be reached again.
+	session.updateFailures.Store(0)
+	session.toolOptions.limiter.resetTurn()
+	session.toolOptions.terminals.beginTurn()
+	session.history.addPrompt(params.Prompt)
+	session.checkpoints.begin()
+	session.takeEditedFiles()
+	if session.autoCommit {
+		session.dirtyBeforeTurn, _ = gitChangedFiles(ctx, session.cwd)
 	}
 
-	session.ResetCancelled()
+	if text, ok := memoryShortcut(params.Prompt); ok {
+		return a.handleMemoryShortcut(ctx, sessionID, session, text)
+	}
 
+	params.Prompt = a.resolveResourceLinks(ctx, sessionID, session, params.Prompt)
+	if session.includeMentions {
+		params.Prompt = a.includeMentionedFiles(ctx, sessionID, session, params.Prompt)
+	}
+	if block, ok := session.diagnostics.take(session.cwd); ok {
+		params.Prompt = append(params.Prompt, block)
+	}
+	if block, ok := session.changedFiles.take(session.cwd); ok {
+		params.Prompt = append(params.Prompt, block)
+	}
 	msg := promptToClaude(params)
-	if err := session.process.SendMessage(msg); err != nil {
-		return acp.PromptResponse{}, fmt.Errorf("failed to send message: %w", err)
+	if err := session.sendMessage(msg); err != nil {
+		return acp.PromptResponse{}, errCLISend(sessionID, err)
 	}
 
+	// Updates are written as the client takes them; the turn ends once they
+	// all have been.
+	updates := a.sessionUpdates(session)
+	defer updates.wait(ctx)
+	out := newNotificationCoalescer(func(n acp.SessionNotification) {
+		if session.suppressThoughts && n.Update.AgentThoughtChunk != nil {
+			return
+		}
+		n.Meta = newTurnMeta(turnID)
+		updates.push(ctx, n)
+	}, a.opts.CoalesceWindow, a.opts.CoalesceBytes)
+	defer out.Flush()
+
+	progress := a.startTurnProgress(sessionID, session)
+	defer progress.stop()
+	watchdog := a.startTurnWatchdog(sessionID, session, out)
+	defer session.stopWatchdog()
+	defer func() {
+		// An idle turn ends as the interrupt leaves it, or with EOF once
+		// the CLI is stopped; either way it failed.
+		if watchdog.expired() && err == nil {
+			err = errTurnTimeout(sessionID, a.opts.TurnIdleTimeout)
+		}
+	}()
+
+	// turn accounts for the streamed API calls; its stop_reason is used
+	// when the result message does not report one.
+	var turn turnUsage
+	// authErr is set when the CLI reports an authentication failure; the
+	// turn keeps reading until the CLI ends it, then fails with it.
+	var authErr string
 	for {
 		select {
 		case <-ctx.Done():
@@ -218,48 +643,110 @@ func (a *ClaudeAcpAgent) Prompt(ctx context.Context, params acp.PromptRequest) (
 		if session.IsCancelled() {
 			return acp.PromptResponse{StopReason: acp.StopReasonCancelled}, nil
 		}
+		if session.clientUnreachable() {
+			// Stop the turn's CLI; the next prompt restarts it.
+			log.Warn("Client unreachable, ending the turn")
+			_ = session.proc().Close()
+			return acp.PromptResponse{}, errClientUnreachable(sessionID)
+		}
 
-		resp, err := session.process.ReadMessage()
+		resp, err := session.proc().ReadMessage()
+		watchdog.activity()
 		if err != nil {
+			var tooLarge *MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				log.Warn("Skipping oversized CLI message", "size", tooLarge.Size, "limit", tooLarge.Limit, "type", tooLarge.Type)
+				continue
+			}
 			if err == io.EOF {
 				if session.IsCancelled() {
 					return acp.PromptResponse{StopReason: acp.StopReasonCancelled}, nil
 				}
+				if authErr != "" {
+					return acp.PromptResponse{}, errAuthRequired(sessionID, authErr, a.authMethodID())
+				}
 				return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
 			}
-			return acp.PromptResponse{}, fmt.Errorf("read error: %w", err)
+			return acp.PromptResponse{}, errCLIRead(sessionID, err)
+		}
+		progress.observe(resp)
+		// Messages of other backends are converted, not the CLI's own.
+		if a.opts.StrictProtocol != "" && resp.RawLine != nil {
+			if problems := checkCLIMessage(resp.Raw()); len(problems) > 0 {
+				log.Warn("CLI message does not match the protocol", "type", resp.Type, "problems", problems)
+				if a.opts.StrictProtocol == strictProtocolFail {
+					return acp.PromptResponse{}, errCLIProtocol(sessionID, resp.Type, problems)
+				}
+			}
 		}
 
 		switch resp.Type {
 		case "system":
-			// Skip system messages
-			a.logger.Debug("Received system message", "subtype", resp.Subtype)
+			// Only compaction progress and MCP server status are surfaced;
+			// other system messages are skipped
+			log.Debug("Received system message", "subtype", resp.Subtype)
+			a.reportMCPStatus(sessionID, session, resp.Raw())
+			for _, n := range session.compaction.handleSystem(resp.Raw(), sessionID) {
+				out.Push(session.stampToolMeta(n, resp.Type, nil))
+			}
 			continue
 
 		case "result":
-			a.logger.Debug("Received result", "subtype", resp.Subtype)
+			log.Debug("Received result", "subtype", resp.Subtype)
+			for _, n := range session.compaction.interrupt(sessionID) {
+				out.Push(session.stampToolMeta(n, resp.Type, nil))
+			}
+			if modelUsage, ok := resp.Raw()["modelUsage"].(map[string]any); ok && session.usage.updateModelUsage(modelUsage) {
+				a.sendContextUsage(sessionID, session)
+			}
+			session.history.addTurn(resp)
 			if session.IsCancelled() {
 				return acp.PromptResponse{StopReason: acp.StopReasonCancelled}, nil
 			}
-			return a.handleResult(resp)
+			if msg, ok := authFailure(resp); ok && authErr == "" {
+				authErr = msg
+			}
+			if authErr != "" {
+				return acp.PromptResponse{}, errAuthRequired(sessionID, authErr, a.authMethodID())
+			}
+			if resp.StopReason == "" {
+				resp.StopReason = turn.stopReason
+			}
+			result, err := a.handleResult(resp, sessionID)
+			if err == nil {
+				result.Meta = turn.meta()
+				a.sendTurnUsage(sessionID, session, &turn)
+			}
+			if err == nil && result.StopReason == acp.StopReasonEndTurn && session.autoCommit {
+				a.autoCommitTurn(ctx, sessionID, session, promptText(params.Prompt), out)
+			}
+			return result, err
 
 		case "stream_event":
 			if session.IsCancelled() {
 				continue
 			}
 			// Use the raw line preserved in SDKResponse for accurate field access
-			var raw map[string]any
-			if resp.RawLine != nil {
-				_ = json.Unmarshal(resp.RawLine, &raw)
-			} else {
-				line, _ := json.Marshal(resp)
-				_ = json.Unmarshal(line, &raw)
-			}
-			parentID := getParentToolUseID(raw)
-			notifications := streamEventToAcpNotifications(raw, sessionID, a.toolUseCache, parentID)
-			a.logger.Debug("stream_event", "event_raw_keys", mapKeys(raw), "notifications", len(notifications))
+			raw := resp.Raw()
+			if event, ok := raw["event"].(map[string]any); ok && resp.ParentToolUseID == nil {
+				if compaction := session.compaction.handleStreamEvent(event, sessionID); len(compaction) > 0 {
+					for _, n := range compaction {
+						out.Push(session.stampToolMeta(n, resp.Type, nil))
+					}
+					session.MarkStreamEventsReceived()
+				}
+				turn.observe(event)
+				if usage, model := streamEventUsage(event); usage != nil {
+					session.usage.update(usage, model)
+					if event["type"] == "message_delta" {
+						a.sendContextUsage(sessionID, session)
+					}
+				}
+			}
+			notifications := streamEventToAcpNotifications(raw, sessionID, session.toolUseCache, resp.ParentToolUseID)
+			log.Debug("stream_event", "event_raw_keys", mapKeys(raw), "notifications", len(notifications))
 			for _, n := range notifications {
-				_ = a.conn.SessionUpdate(ctx, n)
+				out.Push(session.stampToolMeta(n, resp.Type, nil))
 			}
 			if len(notifications) > 0 {
 				session.MarkStreamEventsReceived()
@@ -269,35 +756,42 @@ func (a *ClaudeAcpAgent) Prompt(ctx context.Context, params acp.PromptRequest) (
 			if session.IsCancelled() {
 				continue
 			}
-			a.logger.Debug("Received message", "type", resp.Type)
-			a.handleMessage(ctx, resp, sessionID, session)
+			log.Debug("Received message", "type", resp.Type)
+			if msg, ok := authFailure(resp); ok && authErr == "" {
+				authErr = msg
+			}
+			session.noteEdits(resp)
+			a.handleMessage(resp, sessionID, session, out)
+
+		case "auth_status":
+			if msg, ok := authFailure(resp); ok && authErr == "" {
+				log.Warn("Claude Code authentication failed", "error", msg)
+				authErr = msg
+			}
 
-		case "tool_progress", "tool_use_summary", "auth_status":
+		case "tool_progress", "tool_use_summary":
 			continue
 
 		default:
-			a.logger.Warn("Unknown message type", "type", resp.Type)
+			log.Warn("Unknown message type", "type", resp.Type)
 		}
 	}
 }
 
-func (a *ClaudeAcpAgent) handleResult(resp *SDKResponse) (acp.PromptResponse, error) {
+func (a *ClaudeAcpAgent) handleResult(resp *SDKResponse, sessionID string) (acp.PromptResponse, error) {
 	switch resp.Subtype {
 	case "success":
-		if strings.Contains(resp.Result, "Please run /login") {
-			return acp.PromptResponse{}, acp.NewAuthRequired(nil)
-		}
 		if resp.IsError {
-			return acp.PromptResponse{}, acp.NewInternalError(map[string]any{"error": resp.Result})
+			return acp.PromptResponse{}, errCLIResult(sessionID, resp.Result)
 		}
-		return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
+		return acp.PromptResponse{StopReason: stopReasonFromAPI(resp.StopReason)}, nil
 	case "error_max_turns", "error_max_budget_usd", "error_max_structured_output_retries":
 		if resp.IsError {
 			errMsg := strings.Join(resp.Errors, ", ")
 			if errMsg == "" {
 				errMsg = resp.Subtype
 			}
-			return acp.PromptResponse{}, acp.NewInternalError(map[string]any{"error": errMsg})
+			return acp.PromptResponse{}, errCLIResult(sessionID, errMsg)
 		}
 		return acp.PromptResponse{StopReason: acp.StopReasonMaxTurnRequests}, nil
 	case "error_during_execution":
@@ -306,15 +800,28 @@ func (a *ClaudeAcpAgent) handleResult(resp *SDKResponse) (acp.PromptResponse, er
 			if errMsg == "" {
 				errMsg = resp.Subtype
 			}
-			return acp.PromptResponse{}, acp.NewInternalError(map[string]any{"error": errMsg})
+			return acp.PromptResponse{}, errCLIResult(sessionID, errMsg)
 		}
-		return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
+		return acp.PromptResponse{StopReason: stopReasonFromAPI(resp.StopReason)}, nil
 	default:
 		return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
 	}
 }
 
-func (a *ClaudeAcpAgent) handleMessage(ctx context.Context, resp *SDKResponse, sessionID string, session *Session) {
+// stopReasonFromAPI maps a Messages API stop_reason to an ACP stop reason.
+// Reasons without an ACP counterpart end the turn normally.
+func stopReasonFromAPI(reason string) acp.StopReason {
+	switch reason {
+	case "refusal":
+		return acp.StopReasonRefusal
+	case "max_tokens", "model_context_window_exceeded":
+		return acp.StopReasonMaxTokens
+	default:
+		return acp.StopReasonEndTurn
+	}
+}
+
+func (a *ClaudeAcpAgent) handleMessage(resp *SDKResponse, sessionID string, session *Session, out *notificationCoalescer) {
 	var msgData map[string]any
 	if resp.Message != nil {
 		json.Unmarshal(resp.Message, &msgData)
@@ -323,22 +830,40 @@ func (a *ClaudeAcpAgent) handleMessage(ctx context.Context, resp *SDKResponse, s
 		return
 	}
 
+	// usage is the API call's, reported by assistant messages.
+	usage, _ := msgData["usage"].(map[string]any)
+	if resp.Type == "assistant" && resp.ParentToolUseID == nil && !session.HasStreamEventsReceived() {
+		model, _ := msgData["model"].(string)
+		if session.usage.update(usage, model) {
+			a.sendContextUsage(sessionID, session)
+		}
+	}
+
 	role, _ := msgData["role"].(string)
 	content := msgData["content"]
+	if blocks, ok := content.([]any); ok && resp.Type == "assistant" && !session.HasStreamEventsReceived() {
+		for _, block := range blocks {
+			if item, ok := block.(map[string]any); ok && item["type"] == "compaction" {
+				for _, n := range session.compaction.handleBlock(item, sessionID) {
+					out.Push(session.stampToolMeta(n, resp.Type, usage))
+				}
+			}
+		}
+	}
 	textContent, _ := content.(string)
 	if textContent != "" {
 		if strings.Contains(textContent, "<local-command-stdout>") {
 			if strings.Contains(textContent, "Context Usage") {
 				cleaned := strings.ReplaceAll(textContent, "<local-command-stdout>", "")
 				cleaned = strings.ReplaceAll(cleaned, "</local-command-stdout>", "")
-				for _, n := range toAcpNotifications(cleaned, "assistant", sessionID, a.toolUseCache, getParentToolUseIDFromResp(resp)) {
-					_ = a.conn.SessionUpdate(ctx, n)
+				for _, n := range toAcpNotifications(cleaned, "assistant", sessionID, session.toolUseCache, resp.ParentToolUseID) {
+					out.Push(n)
 				}
 			}
 			return
 		}
 		if strings.Contains(textContent, "<local-command-stderr>") {
-			a.logger.Error(textContent)
+			session.log().Error(textContent)
 			return
 		}
 	}
@@ -386,24 +911,26 @@ func (a *ClaudeAcpAgent) handleMessage(ctx context.Context, resp *SDKResponse, s
 	// Since our CLI setup produces full messages, pass all content through.
 
 	// Get parent_tool_use_id from the raw response
-	parentID := getParentToolUseIDFromResp(resp)
+	parentID := resp.ParentToolUseID
 
-	for _, n := range toAcpNotifications(content, role, sessionID, a.toolUseCache, parentID) {
-		_ = a.conn.SessionUpdate(ctx, n)
+	for _, n := range toAcpNotifications(content, role, sessionID, session.toolUseCache, parentID) {
+		n = session.stampToolMeta(limitImages(n, a.opts.MaxImageBytes), resp.Type, usage)
+		out.Push(session.limitToolOutput(n, a.opts.MaxToolOutput))
 	}
 }
 
 // Cancel cancels an ongoing session operation.
 func (a *ClaudeAcpAgent) Cancel(_ context.Context, params acp.CancelNotification) error {
-	sessionID := string(params.SessionId)
-	a.mu.RLock()
-	session, ok := a.sessions[sessionID]
-	a.mu.RUnlock()
-	if !ok {
-		return fmt.Errorf("session not found: %s", sessionID)
+	session, err := a.lookupSession(string(params.SessionId))
+	if err != nil {
+		return err
 	}
 	session.Cancel()
-	_ = session.process.Close()
+	_ = session.proc().Close()
+	// The turn's Bash commands would otherwise keep running in the client.
+	cancelCtx, cancel := context.WithTimeout(context.Background(), terminalCloseTimeout)
+	defer cancel()
+	session.toolOptions.terminals.cancelTurn(cancelCtx)
 	return nil
 }
 
@@ -412,24 +939,25 @@ func (a *ClaudeAcpAgent) SetSessionMode(_ context.Context, params acp.SetSession
 	sessionID := string(params.SessionId)
 	modeID := string(params.ModeId)
 
-	a.mu.RLock()
-	session, ok := a.sessions[sessionID]
-	a.mu.RUnlock()
-	if !ok {
-		return acp.SetSessionModeResponse{}, fmt.Errorf("session not found: %s", sessionID)
+	session, err := a.lookupSession(sessionID)
+	if err != nil {
+		return acp.SetSessionModeResponse{}, err
 	}
 
 	validMode := false
-	for _, m := range filterModes(a.allowBypass) {
+	for _, m := range filterModes(session.allowBypass) {
 		if string(m.Id) == modeID {
 			validMode = true
 			break
 		}
 	}
 	if !validMode {
-		return acp.SetSessionModeResponse{}, fmt.Errorf("invalid mode: %s", modeID)
+		return acp.SetSessionModeResponse{}, newAgentError(acp.NewInvalidParams, errKindInvalidMode, sessionID, false, "invalid mode: "+modeID)
 	}
 
+	if modeID == "bypassPermissions" && session.GetPermissionMode() != modeID {
+		auditPermission(session.log(), "Session switched to bypassPermissions mode")
+	}
 	session.SetPermissionMode(modeID)
 	return acp.SetSessionModeResponse{}, nil
 }
@@ -465,6 +993,10 @@ func promptToClaude(req acp.PromptRequest) SDKUserMessage {
 					"type": "text",
 					"text": fmt.Sprintf("\n<context ref=%q>\n%s\n</context>", uri, text),
 				})
+			} else if res.BlobResourceContents != nil {
+				blocks, blobContext := blobResourceBlocks(res.BlobResourceContents)
+				content = append(content, blocks...)
+				contextBlocks = append(contextBlocks, blobContext...)
 			}
 		} else if block.Image != nil {
 			if block.Image.Data != "" {
@@ -500,34 +1032,6 @@ func promptToClaude(req acp.PromptRequest) SDKUserMessage {
 	}
 }
 
-func getParentToolUseID(raw map[string]any) *string {
-	if v, ok := raw["parent_tool_use_id"]; ok {
-		if s, ok := v.(string); ok {
-			return &s
-		}
-	}
-	return nil
-}
-
-func getParentToolUseIDFromResp(resp *SDKResponse) *string {
-	if resp.RawLine == nil {
-		return nil
-	}
-	var raw map[string]any
-	_ = json.Unmarshal(resp.RawLine, &raw)
-	return getParentToolUseID(raw)
-}
-
-func generateID() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	// Format as UUID v4: xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx
-	b[6] = (b[6] & 0x0f) | 0x40 // version 4
-	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
-	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
-		b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
-}
-
 func backupExistsWithoutPrimary() bool {
 	home, err := os.UserHomeDir()
 	if err != nil {
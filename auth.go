@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// Auth method IDs advertised in the initialize response.
+const (
+	authMethodClaudeLogin = "claude-login"
+	authMethodAPIKey      = "anthropic-api-key"
+)
+
+// apiKeyAuthMethod lets clients that cannot run `claude /login`, such as
+// headless or remote deployments, authenticate with an Anthropic API key.
+// The key is passed in the authenticate request's _meta.apiKey; without
+// one, the agent's own ANTHROPIC_API_KEY environment variable is used.
+var apiKeyAuthMethod = acp.AuthMethod{
+	Id:          authMethodAPIKey,
+	Name:        "Use an Anthropic API key",
+	Description: acp.Ptr("Pass the key in _meta.apiKey, or set ANTHROPIC_API_KEY for the agent"),
+	Meta: map[string]any{
+		"env-var-auth": map[string]any{"name": "ANTHROPIC_API_KEY"},
+	},
+}
+
+// Authenticate handles authentication requests. The API key method keeps
+// the key in memory only and passes it to the CLI processes of sessions
+// created afterwards; logging in through Claude Code clears it.
+func (a *ClaudeAcpAgent) Authenticate(_ context.Context, params acp.AuthenticateRequest) (acp.AuthenticateResponse, error) {
+	switch params.MethodId {
+	case authMethodClaudeLogin:
+		a.setAPIKey("")
+	case authMethodAPIKey:
+		key := strings.TrimSpace(metaString(params.Meta, "apiKey"))
+		if key == "" && os.Getenv("ANTHROPIC_API_KEY") == "" {
+			return acp.AuthenticateResponse{}, acp.NewInvalidParams(map[string]any{"error": "_meta.apiKey is required when ANTHROPIC_API_KEY is not set"})
+		}
+		a.setAPIKey(key)
+	default:
+		return acp.AuthenticateResponse{}, acp.NewInvalidParams(map[string]any{"error": "unknown auth method: " + string(params.MethodId)})
+	}
+	return acp.AuthenticateResponse{}, nil
+}
+
+func (a *ClaudeAcpAgent) setAPIKey(key string) {
+	a.mu.Lock()
+	a.apiKey = key
+	a.mu.Unlock()
+}
+
+// hasAPIKey reports whether sessions authenticate with an API key, either
+// from Authenticate or the agent's environment.
+func (a *ClaudeAcpAgent) hasAPIKey() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.apiKey != "" || os.Getenv("ANTHROPIC_API_KEY") != ""
+}
+
+// withAPIKey returns env with ANTHROPIC_API_KEY set to the key passed to
+// Authenticate, if any. The key overrides one from settings, since the
+// client chose it explicitly.
+func (a *ClaudeAcpAgent) withAPIKey(env map[string]string) map[string]string {
+	a.mu.RLock()
+	key := a.apiKey
+	a.mu.RUnlock()
+	if key == "" {
+		return env
+	}
+	if env == nil {
+		env = make(map[string]string)
+	} else {
+		env = maps.Clone(env)
+	}
+	env["ANTHROPIC_API_KEY"] = key
+	return env
+}
+
+// metaString returns the string value of key in a _meta object.
+func metaString(meta any, key string) string {
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return ""
+	}
+	s, _ := m[key].(string)
+	return s
+}
+
+// authErrorMarkers are substrings of CLI and API errors caused by missing,
+// expired or rejected credentials, lowercased.
+var authErrorMarkers = []string{
+	"please run /login",
+	"authentication_error",
+	"authentication_failed",
+	"oauth token has expired",
+	"oauth token revoked",
+	"invalid api key",
+	"invalid x-api-key",
+	"invalid bearer token",
+}
+
+func isAuthErrorText(text string) bool {
+	text = strings.ToLower(text)
+	for _, marker := range authErrorMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// authFailure reports whether a CLI message signals that authentication
+// failed: an auth_status message with an error, an assistant message the
+// CLI flagged as an authentication failure, or an error result caused by
+// credentials. Success results asking to log in count too.
+func authFailure(resp *SDKResponse) (string, bool) {
+	switch resp.Type {
+	case "auth_status", "assistant":
+		if resp.Error != nil && (resp.Type == "auth_status" || isAuthErrorText(resp.Error.Message)) {
+			return resp.Error.Message, true
+		}
+	case "result":
+		if strings.Contains(resp.Result, "Please run /login") {
+			return resp.Result, true
+		}
+		if !resp.IsError {
+			return "", false
+		}
+		for _, text := range append([]string{resp.Result}, resp.Errors...) {
+			if isAuthErrorText(text) {
+				return text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// authMethodID is the method a client should authenticate with again after
+// an authentication failure.
+func (a *ClaudeAcpAgent) authMethodID() string {
+	if a.hasAPIKey() {
+		return authMethodAPIKey
+	}
+	return authMethodClaudeLogin
+}
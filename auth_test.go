@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestAuthenticate_APIKey(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	agent := &ClaudeAcpAgent{}
+	ctx := context.Background()
+
+	_, err := agent.Authenticate(ctx, acp.AuthenticateRequest{MethodId: authMethodAPIKey})
+	if err == nil {
+		t.Fatal("expected an error without a key")
+	}
+	if agent.hasAPIKey() {
+		t.Fatal("no key should be stored after a failed authenticate")
+	}
+
+	_, err = agent.Authenticate(ctx, acp.AuthenticateRequest{
+		MethodId: authMethodAPIKey,
+		Meta:     map[string]any{"apiKey": " sk-ant-test "},
+	})
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !agent.hasAPIKey() {
+		t.Fatal("expected the key to be stored")
+	}
+	settingsEnv := map[string]string{"FOO": "bar", "ANTHROPIC_API_KEY": "from-settings"}
+	env := agent.withAPIKey(settingsEnv)
+	if env["ANTHROPIC_API_KEY"] != "sk-ant-test" || env["FOO"] != "bar" {
+		t.Errorf("env = %v", env)
+	}
+	if settingsEnv["ANTHROPIC_API_KEY"] != "from-settings" {
+		t.Error("withAPIKey modified its argument")
+	}
+
+	if _, err := agent.Authenticate(ctx, acp.AuthenticateRequest{MethodId: authMethodClaudeLogin}); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if env := agent.withAPIKey(nil); env != nil {
+		t.Errorf("logging in should clear the key, env = %v", env)
+	}
+}
+
+func TestAuthenticate_APIKeyFromEnvironment(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-env")
+	agent := &ClaudeAcpAgent{}
+	if _, err := agent.Authenticate(context.Background(), acp.AuthenticateRequest{MethodId: authMethodAPIKey}); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !agent.hasAPIKey() {
+		t.Error("expected the environment key to count")
+	}
+	if env := agent.withAPIKey(nil); env != nil {
+		t.Errorf("the environment key is inherited, not injected; env = %v", env)
+	}
+}
+
+func TestAuthenticate_UnknownMethod(t *testing.T) {
+	agent := &ClaudeAcpAgent{}
+	if _, err := agent.Authenticate(context.Background(), acp.AuthenticateRequest{MethodId: "oauth"}); err == nil {
+		t.Error("expected an error for an unknown method")
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		resp SDKResponse
+		want bool
+	}{
+		{"auth_status error", SDKResponse{Type: "auth_status", Error: &SDKError{Message: "token refresh failed"}}, true},
+		{"auth_status progress", SDKResponse{Type: "auth_status"}, false},
+		{"assistant authentication_failed", SDKResponse{Type: "assistant", Error: &SDKError{Message: "authentication_failed"}}, true},
+		{"assistant rate limit", SDKResponse{Type: "assistant", Error: &SDKError{Message: "rate_limit"}}, false},
+		{"login result", SDKResponse{Type: "result", Subtype: "success", Result: "Invalid API key · Please run /login"}, true},
+		{"expired token", SDKResponse{Type: "result", Subtype: "error_during_execution", IsError: true, Errors: []string{"API Error: 401 OAuth token has expired"}}, true},
+		{"other error", SDKResponse{Type: "result", Subtype: "error_during_execution", IsError: true, Errors: []string{"overloaded"}}, false},
+		{"success mentioning keys", SDKResponse{Type: "result", Subtype: "success", Result: "Fixed the invalid API key check"}, false},
+	}
+	for _, tt := range tests {
+		if _, got := authFailure(&tt.resp); got != tt.want {
+			t.Errorf("%s: authFailure = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrompt_AuthStatusError(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	var stdin strings.Builder
+	cliOutput := `{"type":"auth_status","isAuthenticating":false,"output":[],"error":"OAuth token has expired"}` + "\n" +
+		`{"type":"result","subtype":"error_during_execution","is_error":true,"errors":["API Error: 401"]}` + "\n"
+	agent.sessions["s1"] = &Session{process: &ClaudeCodeProcess{
+		stdin:   nopWriteCloser{&stdin},
+		decoder: newNDJSONDecoder(strings.NewReader(cliOutput)),
+	}}
+	extTestConn(t, agent)
+
+	_, err := agent.Prompt(context.Background(), acp.PromptRequest{
+		SessionId: "s1",
+		Prompt:    []acp.ContentBlock{acp.TextBlock("hello")},
+	})
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != acp.NewAuthRequired(nil).Code {
+		t.Fatalf("expected auth_required, got %v", err)
+	}
+	data, ok := reqErr.Data.(errorData)
+	if !ok || data.Kind != errKindAuthRequired || data.AuthMethodID != authMethodClaudeLogin || data.Error != "OAuth token has expired" || data.Retryable {
+		t.Errorf("unexpected data: %+v", reqErr.Data)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// coAuthoredByTrailer credits Claude in commits, as the CLI does unless
+// includeCoAuthoredBy is false.
+const coAuthoredByTrailer = "Co-Authored-By: Claude <noreply@anthropic.com>"
+
+// editToolNames are the tools whose file_path (notebook_path for
+// NotebookEdit) input names a file they modify.
+var editToolNames = []string{"Edit", "Write", "MultiEdit", "NotebookEdit"}
+
+// editToolUses returns the files named by the edit tool uses in an
+// assistant message, by tool use ID, resolved against cwd.
+func editToolUses(resp *SDKResponse, cwd string) map[string]string {
+	if resp.Type != "assistant" {
+		return nil
+	}
+	var msg struct {
+		Content []struct {
+			Type  string         `json:"type"`
+			ID    string         `json:"id"`
+			Name  string         `json:"name"`
+			Input map[string]any `json:"input"`
+		} `json:"content"`
+	}
+	if json.Unmarshal(resp.Message, &msg) != nil {
+		return nil
+	}
+	paths := map[string]string{}
+	for _, block := range msg.Content {
+		if block.Type != "tool_use" || !slices.Contains(editToolNames, strings.TrimPrefix(block.Name, ACPToolNamePrefix)) {
+			continue
+		}
+		path := inputStr(block.Input, "file_path")
+		if path == "" {
+			path = inputStr(block.Input, "notebook_path")
+		}
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		paths[block.ID] = path
+	}
+	return paths
+}
+
+// toolResultErrors returns whether each tool result in a user message is
+// an error, by tool use ID.
+func toolResultErrors(resp *SDKResponse) map[string]bool {
+	if resp.Type != "user" {
+		return nil
+	}
+	var msg struct {
+		Content []struct {
+			Type      string `json:"type"`
+			ToolUseID string `json:"tool_use_id"`
+			IsError   bool   `json:"is_error"`
+		} `json:"content"`
+	}
+	if json.Unmarshal(resp.Message, &msg) != nil {
+		return nil
+	}
+	results := map[string]bool{}
+	for _, block := range msg.Content {
+		if block.Type == "tool_result" {
+			results[block.ToolUseID] = block.IsError
+		}
+	}
+	return results
+}
+
+// noteEdits records the files of a message's edit tool uses, and once
+// their results arrive, keeps those whose edits succeeded. Denied and
+// failed edits changed nothing.
+func (s *Session) noteEdits(resp *SDKResponse) {
+	uses, results := editToolUses(resp, s.cwd), toolResultErrors(resp)
+	if len(uses) == 0 && len(results) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.editToolUses == nil {
+		s.editToolUses = map[string]string{}
+	}
+	maps.Copy(s.editToolUses, uses)
+	for id, isError := range results {
+		path, ok := s.editToolUses[id]
+		if !ok {
+			continue
+		}
+		delete(s.editToolUses, id)
+		if isError {
+			continue
+		}
+		if s.editedFiles == nil {
+			s.editedFiles = map[string]bool{}
+		}
+		s.editedFiles[path] = true
+	}
+}
+
+// takeEditedFiles returns the recorded files, sorted, and forgets them.
+func (s *Session) takeEditedFiles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.editedFiles))
+	for p := range s.editedFiles {
+		paths = append(paths, p)
+	}
+	s.editedFiles = nil
+	s.editToolUses = nil
+	slices.Sort(paths)
+	return paths
+}
+
+// autoCommitTurn commits the files the turn modified, if the session's cwd
+// is in a git repository and any of them changed, and reports the commit
+// to the client as a completed (or failed) execute tool call. Other staged
+// changes are left out of the commit, and so are files that already had
+// uncommitted changes when the turn began, since those are the user's.
+func (a *ClaudeAcpAgent) autoCommitTurn(ctx context.Context, sessionID string, session *Session, prompt string, out *notificationCoalescer) {
+	touched := append(session.takeEditedFiles(), session.checkpoints.currentPaths()...)
+	slices.Sort(touched)
+	touched = slices.Compact(touched)
+	if len(touched) == 0 {
+		return
+	}
+	changed, err := gitChangedFiles(ctx, session.cwd)
+	if err != nil {
+		session.log().Debug("Skipping auto-commit outside a git repository", "error", err)
+		return
+	}
+	// Keep the files git sees as changed; this drops unchanged, ignored and
+	// never-created files, which git add would reject.
+	var paths, skipped []string
+	for _, p := range touched {
+		switch key := resolveRepoPath(p); {
+		case !changed[key]:
+		case session.dirtyBeforeTurn[key]:
+			skipped = append(skipped, p)
+		default:
+			paths = append(paths, p)
+		}
+	}
+	if len(skipped) > 0 {
+		session.log().Info("Leaving files changed before the turn out of the auto-commit", "files", skipped)
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	settings := session.settingsManager.GetSettings()
+	message := autoCommitMessage(prompt, session.cwd, paths, settings.IncludeCoAuthoredBy == nil || *settings.IncludeCoAuthoredBy)
+	subject, _, _ := strings.Cut(message, "\n")
+	id := acp.ToolCallId("autocommit-" + a.ids.RandomString(8))
+	input := map[string]any{"message": message, "files": paths}
+
+	_, err = runGit(ctx, session.cwd, append([]string{"add", "--"}, paths...)...)
+	var output string
+	if err == nil {
+		output, err = runGit(ctx, session.cwd, append([]string{"commit", "-m", message, "--"}, paths...)...)
+	}
+	status := acp.ToolCallStatusCompleted
+	if err != nil {
+		session.log().Warn("Auto-commit failed", "error", err)
+		output, status = err.Error(), acp.ToolCallStatusFailed
+	}
+	out.Push(acp.SessionNotification{
+		SessionId: acp.SessionId(sessionID),
+		Update: acp.StartToolCall(id, "git commit: "+subject,
+			acp.WithStartKind(acp.ToolKindExecute),
+			acp.WithStartStatus(status),
+			acp.WithStartRawInput(input),
+			acp.WithStartContent([]acp.ToolCallContent{acp.ToolContent(acp.TextBlock(strings.TrimSpace(output)))}),
+		),
+	})
+}
+
+// autoCommitMessage builds a commit message: the prompt as the subject,
+// the committed files in the body, and the co-author trailer if wanted.
+func autoCommitMessage(prompt, cwd string, paths []string, coAuthored bool) string {
+	subject := sanitizeTitle(prompt, 72)
+	if subject == "" {
+		subject = fmt.Sprintf("Update %d file(s)", len(paths))
+	}
+	var sb strings.Builder
+	sb.WriteString(subject + "\n\n")
+	for _, p := range paths {
+		if rel, err := filepath.Rel(cwd, p); err == nil && !strings.HasPrefix(rel, "..") {
+			p = rel
+		}
+		sb.WriteString("- " + filepath.ToSlash(p) + "\n")
+	}
+	if coAuthored {
+		sb.WriteString("\n" + coAuthoredByTrailer + "\n")
+	}
+	return sb.String()
+}
+
+// gitChangedFiles returns the files with uncommitted changes, including
+// untracked ones, in the git repository containing dir. Keys are absolute
+// paths with symlinks resolved, as resolveRepoPath returns.
+func gitChangedFiles(ctx context.Context, dir string) (map[string]bool, error) {
+	top, err := runGit(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = resolveRepoPath(strings.TrimSpace(top))
+	status, err := runGit(ctx, dir, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		changed[filepath.Join(top, filepath.FromSlash(entry[3:]))] = true
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++ // the source of a rename or copy
+		}
+	}
+	return changed, nil
+}
+
+// resolveRepoPath resolves symlinks in the directory of an absolute path,
+// so it matches the paths git reports under its resolved top level. The
+// file itself may not exist.
+func resolveRepoPath(path string) string {
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		return filepath.Join(dir, filepath.Base(path))
+	}
+	return filepath.Clean(path)
+}
+
+// runGit runs git in dir, returning its output. Errors include stderr.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
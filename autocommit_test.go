@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestNoteEdits(t *testing.T) {
+	session := &Session{cwd: "/w"}
+	uses, _ := json.Marshal(map[string]any{"content": []map[string]any{
+		{"type": "text", "text": "editing"},
+		{"type": "tool_use", "id": "t1", "name": "Edit", "input": map[string]any{"file_path": "/w/a.go"}},
+		{"type": "tool_use", "id": "t2", "name": ACPToolNamePrefix + "Write", "input": map[string]any{"file_path": "b.go"}},
+		{"type": "tool_use", "id": "t3", "name": "NotebookEdit", "input": map[string]any{"notebook_path": "/w/c.ipynb"}},
+		{"type": "tool_use", "id": "t4", "name": "Read", "input": map[string]any{"file_path": "/w/d.go"}},
+	}})
+	session.noteEdits(&SDKResponse{Type: "assistant", Message: uses})
+	if got := session.takeEditedFiles(); len(got) != 0 {
+		t.Errorf("expected no files before the results, got %v", got)
+	}
+
+	session.noteEdits(&SDKResponse{Type: "assistant", Message: uses})
+	results, _ := json.Marshal(map[string]any{"content": []map[string]any{
+		{"type": "tool_result", "tool_use_id": "t1", "content": "ok"},
+		{"type": "tool_result", "tool_use_id": "t2", "content": "ok"},
+		{"type": "tool_result", "tool_use_id": "t3", "is_error": true, "content": "The user denied the edit"},
+		{"type": "tool_result", "tool_use_id": "t4", "content": "package d"},
+	}})
+	session.noteEdits(&SDKResponse{Type: "user", Message: results})
+	want := []string{filepath.Join("/w", "b.go"), "/w/a.go"}
+	slices.Sort(want)
+	if got := session.takeEditedFiles(); !slices.Equal(got, want) {
+		t.Errorf("got %v, want the successful edits %v", got, want)
+	}
+}
+
+func TestAutoCommitTurn(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	ctx := context.Background()
+	dir := t.TempDir()
+	if _, err := runGit(ctx, dir, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	// The user's own change from before the turn.
+	writeTestFile(t, filepath.Join(dir, "dirty.go"), "package z\n")
+	dirty, err := gitChangedFiles(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "edited.go"), "package x\n")
+	writeTestFile(t, filepath.Join(dir, "other.go"), "package y\n")
+
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	mgr := NewSettingsManager(dir, agent.logger)
+	mgr.Initialize()
+	session := &Session{cwd: dir, settingsManager: mgr, dirtyBeforeTurn: dirty}
+	session.editedFiles = map[string]bool{filepath.Join(dir, "edited.go"): true, filepath.Join(dir, "missing.go"): true, filepath.Join(dir, "dirty.go"): true}
+	rec := &notificationRecorder{}
+	out := newNotificationCoalescer(rec.send, time.Hour, 1024)
+
+	agent.autoCommitTurn(ctx, "s1", session, "Add the x package", out)
+	out.Flush()
+
+	log, err := runGit(ctx, dir, "log", "--format=%B", "--name-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(log, "Add the x package\n") || !strings.Contains(log, coAuthoredByTrailer) {
+		t.Errorf("unexpected commit message:\n%s", log)
+	}
+	if !strings.Contains(log, "\nedited.go") || strings.Contains(log, "\nother.go") || strings.Contains(log, "dirty.go") {
+		t.Errorf("unexpected committed files:\n%s", log)
+	}
+	sent := rec.get()
+	if len(sent) != 1 || sent[0].Update.ToolCall == nil || sent[0].Update.ToolCall.Status != acp.ToolCallStatusCompleted {
+		t.Fatalf("expected a completed tool call, got %+v", sent)
+	}
+
+	// Nothing left to commit.
+	agent.autoCommitTurn(ctx, "s1", session, "again", out)
+	out.Flush()
+	if len(rec.get()) != 1 {
+		t.Error("committed with no edited files")
+	}
+}
+
+func TestAutoCommitMessage_NoCoAuthor(t *testing.T) {
+	msg := autoCommitMessage("", "/w", []string{"/w/a.go", "/elsewhere/b.go"}, false)
+	want := "Update 2 file(s)\n\n- a.go\n- /elsewhere/b.go\n"
+	if msg != want {
+		t.Errorf("got %q, want %q", msg, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Backend is an agent CLI that sessions are bridged to. It starts one
+// subprocess per session; the agent drives the process with messages in
+// the Claude Code stream-JSON format, which the process's MessageConverter
+// translates to and from the CLI's own protocol.
+type Backend interface {
+	// Name identifies the backend in the --backend flag.
+	Name() string
+	// Verify checks that the CLI executable exists and is supported.
+	Verify(executable string) error
+	// Start spawns the CLI for a new session.
+	Start(opts ProcessOptions) (Process, error)
+}
+
+// ProcessOptions describes the CLI process of a session. Each backend
+// translates them to its CLI's flags and environment.
+type ProcessOptions struct {
+	Cwd               string
+	SessionID         string
+	PermissionMode    string // an ACP session mode ID
+	McpServers        map[string]McpServerConfig
+	SystemPrompt      string
+	Resume            string // session ID to resume instead of starting SessionID
+	Executable        string // CLI path; empty uses the backend's default
+	MaxTurns          int
+	MaxThinkingTokens int  // 0 means not set
+	DisableThinking   bool // turn extended thinking off
+	Model             string
+	Env               map[string]string // added to the inherited environment
+	Settings          string            // extra settings JSON
+	MaxMessageSize    int               // 0 means MaxMessageSize
+	Agent             string            // subagent to run the session as
+	AllowedTools      []string          // tools the CLI runs without asking
+	DisallowedTools   []string          // tools the CLI must not use
+	PermissionPrompt  string            // MCP tool the CLI asks for permission decisions
+	EnvFilter         EnvFilter         // applied to the inherited environment
+	Logger            *slog.Logger      // logs skipped stdout lines; nil uses the default
+}
+
+// Process is the running CLI of one session.
+type Process interface {
+	// SendMessage sends a user prompt.
+	SendMessage(msg SDKUserMessage) error
+	// ReadMessage returns the next message, or io.EOF once the CLI exits.
+	ReadMessage() (*SDKResponse, error)
+	// Interrupt asks the CLI to stop the current turn. The turn still ends
+	// with a result message.
+	Interrupt() error
+	// Close shuts the CLI down and waits for it to exit.
+	Close() error
+	// Done is closed when the process has exited.
+	Done() <-chan struct{}
+}
+
+// MessageConverter translates between the agent's stream-JSON messages and
+// the ndjson lines a CLI reads and writes.
+type MessageConverter interface {
+	// EncodeUserMessage returns the line sent to the CLI for a prompt.
+	EncodeUserMessage(msg SDKUserMessage) ([]byte, error)
+	// EncodeInterrupt returns the line that interrupts the current turn.
+	EncodeInterrupt(requestID string) ([]byte, error)
+	// DecodeMessage converts a line of CLI output. It returns nil for lines
+	// with no counterpart, which are skipped. Responses without a RawLine
+	// are re-encoded when their raw fields are needed.
+	DecodeMessage(line json.RawMessage) (*SDKResponse, error)
+}
+
+// backends are the available backends, by name.
+var backends = map[string]Backend{
+	"claude": claudeBackend{},
+}
+
+// defaultBackend is used when AgentOptions.Backend is nil.
+var defaultBackend Backend = claudeBackend{}
+
+// lookupBackend returns the backend registered under name.
+func lookupBackend(name string) (Backend, error) {
+	if b, ok := backends[name]; ok {
+		return b, nil
+	}
+	names := make([]string, 0, len(backends))
+	for n := range backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// claudeBackend runs the Claude Code CLI, whose messages need no
+// conversion.
+type claudeBackend struct{}
+
+func (claudeBackend) Name() string { return "claude" }
+
+func (claudeBackend) Verify(executable string) error {
+	return verifyCLI(cliExecutable(executable))
+}
+
+func (claudeBackend) Start(opts ProcessOptions) (Process, error) {
+	return NewClaudeCodeProcess(claudeCodeOptions(opts))
+}
+
+// claudeCodeOptions returns the Claude Code options for opts.
+func claudeCodeOptions(opts ProcessOptions) ClaudeCodeOptions {
+	return ClaudeCodeOptions{
+		Cwd:               opts.Cwd,
+		SessionID:         opts.SessionID,
+		PermissionMode:    opts.PermissionMode,
+		McpServers:        opts.McpServers,
+		SystemPrompt:      opts.SystemPrompt,
+		Resume:            opts.Resume,
+		Executable:        opts.Executable,
+		MaxTurns:          opts.MaxTurns,
+		MaxThinkingTokens: opts.MaxThinkingTokens,
+		DisableThinking:   opts.DisableThinking,
+		Model:             opts.Model,
+		Env:               opts.Env,
+		Settings:          opts.Settings,
+		MaxMessageSize:    opts.MaxMessageSize,
+		Agent:             opts.Agent,
+		AllowedTools:      opts.AllowedTools,
+		DisallowedTools:   opts.DisallowedTools,
+		PermissionPrompt:  opts.PermissionPrompt,
+		EnvFilter:         opts.EnvFilter,
+		Logger:            opts.Logger,
+	}
+}
+
+// claudeConverter passes Claude Code stream-JSON through unchanged.
+type claudeConverter struct{}
+
+func (claudeConverter) EncodeUserMessage(msg SDKUserMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (claudeConverter) EncodeInterrupt(requestID string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":       "control_request",
+		"request_id": requestID,
+		"request":    map[string]any{"subtype": "interrupt"},
+	})
+}
+
+func (claudeConverter) DecodeMessage(line json.RawMessage) (*SDKResponse, error) {
+	var resp SDKResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	resp.RawLine = line
+	return &resp, nil
+}
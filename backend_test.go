@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLookupBackend(t *testing.T) {
+	b, err := lookupBackend("claude")
+	if err != nil || b.Name() != "claude" {
+		t.Fatalf("lookupBackend(claude) = %v, %v", b, err)
+	}
+	if _, err := lookupBackend("codex"); err == nil || !strings.Contains(err.Error(), "available: claude") {
+		t.Errorf("expected an error listing the available backends, got %v", err)
+	}
+}
+
+// echoConverter is a converter for a CLI that reads {"prompt": ...} lines
+// and writes {"kind": "text"|"done"|"log", "text": ...} lines.
+type echoConverter struct{}
+
+func (echoConverter) EncodeUserMessage(msg SDKUserMessage) ([]byte, error) {
+	return json.Marshal(map[string]any{"prompt": msg.Message.Content})
+}
+
+func (echoConverter) EncodeInterrupt(string) ([]byte, error) {
+	return []byte(`{"stop":true}`), nil
+}
+
+func (echoConverter) DecodeMessage(line json.RawMessage) (*SDKResponse, error) {
+	var m struct{ Kind, Text string }
+	if err := json.Unmarshal(line, &m); err != nil {
+		return nil, err
+	}
+	switch m.Kind {
+	case "text":
+		msg, _ := json.Marshal(map[string]any{"role": "assistant", "content": m.Text})
+		return &SDKResponse{Type: "assistant", Message: msg}, nil
+	case "done":
+		return &SDKResponse{Type: "result", Subtype: "success", Result: m.Text}, nil
+	}
+	return nil, nil
+}
+
+func TestClaudeCodeProcess_Converter(t *testing.T) {
+	var stdin strings.Builder
+	output := `{"kind":"log","text":"starting"}` + "\n" +
+		`{"kind":"text","text":"hi"}` + "\n" +
+		`{"kind":"done","text":"hi"}` + "\n"
+	p := &ClaudeCodeProcess{
+		stdin:     nopWriteCloser{&stdin},
+		decoder:   newNDJSONDecoder(strings.NewReader(output)),
+		converter: echoConverter{},
+	}
+
+	if err := p.SendMessage(SDKUserMessage{Type: "user", Message: SDKMessage{Role: "user", Content: "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdin.String(); got != `{"prompt":"hello"}`+"\n"+`{"stop":true}`+"\n" {
+		t.Errorf("stdin = %q", got)
+	}
+
+	resp, err := p.ReadMessage()
+	if err != nil || resp.Type != "assistant" {
+		t.Fatalf("expected the log line to be skipped, got %+v, %v", resp, err)
+	}
+	if resp.Raw()["message"].(map[string]any)["content"] != "hi" {
+		t.Errorf("Raw() = %v", resp.Raw())
+	}
+	resp, err = p.ReadMessage()
+	if err != nil || resp.Type != "result" || resp.Result != "hi" {
+		t.Fatalf("unexpected result %+v, %v", resp, err)
+	}
+	if _, err := p.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestClaudeCodeProcess_Interrupt(t *testing.T) {
+	var stdin strings.Builder
+	p := &ClaudeCodeProcess{stdin: nopWriteCloser{&stdin}}
+	if err := p.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	var req map[string]any
+	if err := json.Unmarshal([]byte(stdin.String()), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req["type"] != "control_request" || req["request_id"] != "interrupt-1" || req["request"].(map[string]any)["subtype"] != "interrupt" {
+		t.Errorf("unexpected interrupt request %v", req)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// blobResourceBlocks converts an embedded binary resource to Claude content
+// blocks. Supported images become image blocks and PDFs document blocks,
+// each preceded by a link to the resource. Blobs that decode to UTF-8 text
+// are returned as a context block like text resources. Other types are
+// replaced by a note so the model knows the attachment was dropped.
+func blobResourceBlocks(res *acp.BlobResourceContents) (content, contextBlocks []any) {
+	link := textBlock(formatUriAsLink(res.Uri))
+	mimeType := blobMimeType(res)
+	data, err := base64.StdEncoding.DecodeString(res.Blob)
+	if err != nil {
+		return []any{textBlock(fmt.Sprintf("[Attachment %s could not be decoded: %v]", res.Uri, err))}, nil
+	}
+
+	switch {
+	case isSupportedImageType(mimeType):
+		return []any{link, map[string]any{
+			"type": "image",
+			"source": map[string]any{
+				"type":       "base64",
+				"data":       res.Blob,
+				"media_type": mimeType,
+			},
+		}}, nil
+	case mimeType == "application/pdf":
+		return []any{link, map[string]any{
+			"type":  "document",
+			"title": pathBase(res.Uri),
+			"source": map[string]any{
+				"type":       "base64",
+				"data":       res.Blob,
+				"media_type": mimeType,
+			},
+		}}, nil
+	case utf8.Valid(data) && !bytes.Contains(data, []byte{0}):
+		return []any{link}, []any{textBlock(fmt.Sprintf("\n<context ref=%q>\n%s\n</context>", res.Uri, data))}
+	}
+	if mimeType == "" {
+		mimeType = "unknown type"
+	}
+	return []any{textBlock(fmt.Sprintf("[Attachment %s (%s, %d bytes) is not supported]", res.Uri, mimeType, len(data)))}, nil
+}
+
+// blobMimeType returns the declared MIME type of res, or one inferred from
+// its URI's extension.
+func blobMimeType(res *acp.BlobResourceContents) string {
+	if res.MimeType != nil && *res.MimeType != "" {
+		mimeType, _, _ := strings.Cut(*res.MimeType, ";")
+		return strings.ToLower(strings.TrimSpace(mimeType))
+	}
+	ext := strings.ToLower(filepath.Ext(res.Uri))
+	if mimeType, ok := imageMimeTypes[ext]; ok {
+		return mimeType
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	return mimeType
+}
+
+// isSupportedImageType reports whether the model accepts images of the
+// given MIME type.
+func isSupportedImageType(mimeType string) bool {
+	for _, t := range imageMimeTypes {
+		if t == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+func textBlock(text string) map[string]any {
+	return map[string]any{"type": "text", "text": text}
+}
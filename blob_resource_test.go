@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestPromptToClaude_BlobResources(t *testing.T) {
+	blob := func(uri, mimeType string, data []byte) acp.ContentBlock {
+		res := &acp.BlobResourceContents{Uri: uri, Blob: base64.StdEncoding.EncodeToString(data)}
+		if mimeType != "" {
+			res.MimeType = acp.Ptr(mimeType)
+		}
+		return acp.ContentBlock{Resource: &acp.ContentBlockResource{
+			Resource: acp.EmbeddedResourceResource{BlobResourceContents: res},
+		}}
+	}
+	tests := []struct {
+		name  string
+		block acp.ContentBlock
+		want  []string // block types of the message content
+		check func(t *testing.T, content []any)
+	}{
+		{
+			name:  "image",
+			block: blob("file:///tmp/shot.png", "image/png", []byte("\x89PNG")),
+			want:  []string{"text", "image"},
+			check: func(t *testing.T, content []any) {
+				src := content[1].(map[string]any)["source"].(map[string]any)
+				if src["media_type"] != "image/png" {
+					t.Errorf("media_type = %v", src["media_type"])
+				}
+			},
+		},
+		{
+			name:  "pdf inferred from uri",
+			block: blob("file:///tmp/spec.pdf", "", []byte("%PDF-1.7\x00")),
+			want:  []string{"text", "document"},
+			check: func(t *testing.T, content []any) {
+				doc := content[1].(map[string]any)
+				if doc["title"] != "spec.pdf" {
+					t.Errorf("title = %v", doc["title"])
+				}
+			},
+		},
+		{
+			name:  "text blob",
+			block: blob("file:///tmp/notes.txt", "application/octet-stream", []byte("hello")),
+			want:  []string{"text", "text"},
+			check: func(t *testing.T, content []any) {
+				if text := content[1].(map[string]any)["text"].(string); !strings.Contains(text, "hello") {
+					t.Errorf("context block = %q", text)
+				}
+			},
+		},
+		{
+			name:  "unsupported",
+			block: blob("file:///tmp/a.zip", "application/zip", []byte("PK\x03\x04\x00")),
+			want:  []string{"text"},
+			check: func(t *testing.T, content []any) {
+				if text := content[0].(map[string]any)["text"].(string); !strings.Contains(text, "not supported") {
+					t.Errorf("note = %q", text)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := promptToClaude(acp.PromptRequest{SessionId: "s1", Prompt: []acp.ContentBlock{tt.block}})
+			content := msg.Message.Content.([]any)
+			var types []string
+			for _, b := range content {
+				types = append(types, b.(map[string]any)["type"].(string))
+			}
+			if strings.Join(types, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("block types = %v, want %v", types, tt.want)
+			}
+			tt.check(t, content)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// maxCheckpointTurns bounds how many turns can be reverted one after
+// another.
+const maxCheckpointTurns = 20
+
+// fileCheckpoints records the original contents of the files the built-in
+// Edit and Write tools change, per turn, so a turn's edits can be undone
+// without relying on git.
+type fileCheckpoints struct {
+	mu    sync.Mutex
+	turns []*turnCheckpoint
+}
+
+// turnCheckpoint holds the contents files had before a turn first changed
+// them, in the order they were changed.
+type turnCheckpoint struct {
+	files []fileSnapshot
+	seen  map[string]bool
+}
+
+type fileSnapshot struct {
+	path    string
+	content string
+	existed bool
+}
+
+// begin starts the checkpoint of a new turn, dropping the previous one if
+// it changed nothing.
+func (c *fileCheckpoints) begin() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.turns); n > 0 && len(c.turns[n-1].files) == 0 {
+		c.turns = c.turns[:n-1]
+	}
+	if len(c.turns) >= maxCheckpointTurns {
+		c.turns = c.turns[1:]
+	}
+	c.turns = append(c.turns, &turnCheckpoint{seen: map[string]bool{}})
+}
+
+// record saves a file's contents before the current turn first changes it.
+// existed is false for files the turn creates.
+func (c *fileCheckpoints) record(path, content string, existed bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.turns) == 0 {
+		c.turns = append(c.turns, &turnCheckpoint{seen: map[string]bool{}})
+	}
+	turn := c.turns[len(c.turns)-1]
+	if turn.seen[path] {
+		return
+	}
+	turn.seen[path] = true
+	turn.files = append(turn.files, fileSnapshot{path: path, content: content, existed: existed})
+}
+
+// reset forgets every turn's checkpoint, as when the conversation is
+// cleared.
+func (c *fileCheckpoints) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.turns = nil
+}
+
+// currentPaths returns the files changed in the current turn.
+func (c *fileCheckpoints) currentPaths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.turns) == 0 {
+		return nil
+	}
+	var paths []string
+	for _, f := range c.turns[len(c.turns)-1].files {
+		paths = append(paths, f.path)
+	}
+	return paths
+}
+
+// popLast removes and returns the most recent turn that changed files.
+func (c *fileCheckpoints) popLast() *turnCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.turns) > 0 {
+		turn := c.turns[len(c.turns)-1]
+		c.turns = c.turns[:len(c.turns)-1]
+		if len(turn.files) > 0 {
+			return turn
+		}
+	}
+	return nil
+}
+
+// sessionRevertParams is the payload of _claude/session/revert_last_turn.
+type sessionRevertParams struct {
+	SessionID string `json:"sessionId"`
+}
+
+// sessionRevertResult lists what a revert did. Files the turn created are
+// deleted when they are agent-internal; the client has no way to delete
+// files, so others are listed in Created and left in place.
+type sessionRevertResult struct {
+	Restored []string       `json:"restored"`
+	Removed  []string       `json:"removed,omitempty"`
+	Created  []string       `json:"created,omitempty"`
+	Failed   []revertFailed `json:"failed,omitempty"`
+}
+
+type revertFailed struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// extRevertLastTurn restores the files changed by the built-in Edit and
+// Write tools in the session's most recent turn that changed any. It fails
+// while a prompt is running.
+func (a *ClaudeAcpAgent) extRevertLastTurn(ctx context.Context, params json.RawMessage) (any, error) {
+	var p sessionRevertParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.turnMu.TryLock() {
+		return nil, newAgentError(acp.NewInvalidRequest, errKindSessionBusy, p.SessionID, true, "cannot revert a turn while a prompt is running")
+	}
+	defer session.turnMu.Unlock()
+
+	turn := session.checkpoints.popLast()
+	if turn == nil {
+		return nil, acp.NewInvalidRequest(map[string]any{"error": "no file changes to revert"})
+	}
+	result := sessionRevertResult{Restored: []string{}}
+	for _, f := range turn.files {
+		switch {
+		case f.existed:
+			session.toolOptions.files.invalidate(f.path)
+			if err := a.restoreFile(ctx, p.SessionID, f); err != nil {
+				result.Failed = append(result.Failed, revertFailed{Path: f.path, Error: err.Error()})
+				continue
+			}
+			result.Restored = append(result.Restored, f.path)
+		case isInternalPath(f.path):
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				result.Failed = append(result.Failed, revertFailed{Path: f.path, Error: err.Error()})
+				continue
+			}
+			result.Removed = append(result.Removed, f.path)
+		default:
+			result.Created = append(result.Created, f.path)
+		}
+	}
+	return result, nil
+}
+
+// restoreFile writes a snapshot back, to disk for internal paths and
+// through the client otherwise.
+func (a *ClaudeAcpAgent) restoreFile(ctx context.Context, sessionID string, f fileSnapshot) error {
+	if isInternalPath(f.path) {
+		return os.WriteFile(f.path, []byte(f.content), 0o644)
+	}
+	_, err := a.conn.WriteTextFile(ctx, acp.WriteTextFileRequest{
+		SessionId: acp.SessionId(sessionID),
+		Path:      f.path,
+		Content:   f.content,
+	})
+	return err
+}
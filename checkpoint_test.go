@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtRevertLastTurn(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	session := &Session{cwd: dir}
+	agent.sessions["s1"] = session
+	opts := BuiltinToolOptions{checkpoints: &session.checkpoints}
+	send, recv := extTestConn(t, agent)
+
+	edited := filepath.Join(dir, "notes.md")
+	created := filepath.Join(dir, "todo.md")
+	writeTestFile(t, edited, "v1\n")
+
+	// Turn 1 edits the file; turn 2 edits it twice and creates another;
+	// turn 3 changes nothing.
+	session.checkpoints.begin()
+	handleEdit(context.Background(), nil, "s1", map[string]any{"file_path": edited, "old_string": "v1", "new_string": "v2"}, opts)
+	session.checkpoints.begin()
+	handleEdit(context.Background(), nil, "s1", map[string]any{"file_path": edited, "old_string": "v2", "new_string": "v3"}, opts)
+	handleWrite(context.Background(), nil, "s1", map[string]any{"file_path": edited, "content": "v4\n"}, opts)
+	handleWrite(context.Background(), nil, "s1", map[string]any{"file_path": created, "content": "- [ ] ship\n"}, opts)
+	session.checkpoints.begin()
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/revert_last_turn","params":{"sessionId":"s1"}}`)
+	if msg := recv(); msg["error"] != nil {
+		t.Fatalf("revert failed: %v", msg)
+	}
+	if data, _ := os.ReadFile(edited); string(data) != "v2\n" {
+		t.Errorf("after first revert: %q", data)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Error("created file not removed")
+	}
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_claude/session/revert_last_turn","params":{"sessionId":"s1"}}`)
+	recv()
+	if data, _ := os.ReadFile(edited); string(data) != "v1\n" {
+		t.Errorf("after second revert: %q", data)
+	}
+
+	send(`{"jsonrpc":"2.0","id":3,"method":"_claude/session/revert_last_turn","params":{"sessionId":"s1"}}`)
+	if msg := recv(); msg["error"] == nil {
+		t.Errorf("expected an error with nothing to revert, got %v", msg)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import acp "github.com/coder/acp-go-sdk"
+
+// claudeCodeMetaVersion is the version of the tool call claudeCode object
+// described below. It changes when a field is removed or changes meaning;
+// new fields do not change it.
+const claudeCodeMetaVersion = 1
+
+// The _meta.claudeCode object of every tool call and tool call update the
+// agent sends has these fields:
+//
+//	version                always claudeCodeMetaVersion
+//	toolName               the CLI tool, e.g. "Bash" or "mcp__server__tool"
+//	parentToolCallId       the Task tool call running it, or null
+//	turnId                 the session's turn, counting from 1
+//	messageType            the CLI message that produced the update:
+//	                       "assistant", "user", "stream_event" or "system"
+//	usage                  tokens of the API call that requested the tool,
+//	                       when its message reported them
+//	rawOutputOmittedBytes  size of a rawOutput dropped for being too large
+//
+// The claudeCode objects of tool calls are held as maps, so the helpers
+// below can each add fields without dropping the others'. The turn meta of
+// other updates is the exception; see turnMeta.
+
+// turnRef names the turn a session update belongs to.
+type turnRef struct {
+	TurnID int `json:"turnId"`
+}
+
+// turnMeta is the _meta of every session update sent during a turn. Its
+// turnId is the one in the tool calls' claudeCodeMeta and in the turn's
+// PromptResponse, so clients can tell the updates of a cancelled turn from
+// those of the next.
+//
+// It is a comparable struct on purpose, not a map: the update queue merges
+// consecutive text chunks only when their _meta values are equal
+// (updateQueue.mergeLocked), and comparing maps held in an interface
+// panics.
+type turnMeta struct {
+	ClaudeCode turnRef `json:"claudeCode"`
+}
+
+func newTurnMeta(turnID int) turnMeta {
+	return turnMeta{ClaudeCode: turnRef{TurnID: turnID}}
+}
+
+// toolCallMeta returns the _meta of a tool call update for the tool.
+func toolCallMeta(toolName string, parentToolCallID *string) map[string]any {
+	return map[string]any{"claudeCode": map[string]any{
+		"version":          claudeCodeMetaVersion,
+		"toolName":         toolName,
+		"parentToolCallId": parentToolCallID,
+	}}
+}
+
+// claudeCodeMetaOf returns the claudeCode object of a tool call or tool
+// call update, adding one if it has none, with the version and parent
+// every tool call's has. It returns nil for other updates.
+func claudeCodeMetaOf(n *acp.SessionNotification) map[string]any {
+	var meta *any
+	switch u := &n.Update; {
+	case u.ToolCall != nil:
+		meta = &u.ToolCall.Meta
+	case u.ToolCallUpdate != nil:
+		meta = &u.ToolCallUpdate.Meta
+	default:
+		return nil
+	}
+	cc := claudeCodeObject(meta)
+	if _, ok := cc["version"]; !ok {
+		cc["version"] = claudeCodeMetaVersion
+	}
+	if _, ok := cc["parentToolCallId"]; !ok {
+		cc["parentToolCallId"] = nil
+	}
+	return cc
+}
+
+// stampToolMeta records the running turn and the CLI message a tool call
+// update came from, with the message's usage if it has any.
+func (s *Session) stampToolMeta(n acp.SessionNotification, messageType string, usage map[string]any) acp.SessionNotification {
+	cc := claudeCodeMetaOf(&n)
+	if cc == nil {
+		return n
+	}
+	cc["turnId"] = s.turnID()
+	cc["messageType"] = messageType
+	if usage != nil {
+		var u tokenUsage
+		u.set(usage)
+		cc["usage"] = u
+	}
+	return n
+}
+
+// withClaudeCodeMeta sets key in the claudeCode object of a _meta value,
+// creating both as needed.
+func withClaudeCodeMeta(meta any, key string, value any) any {
+	claudeCodeObject(&meta)[key] = value
+	return meta
+}
+
+// claudeCodeObject returns the claudeCode object of the _meta value at
+// meta, replacing a value that is not a map, or a claudeCode value that is
+// not an object, with an empty one.
+func claudeCodeObject(meta *any) map[string]any {
+	m, ok := (*meta).(map[string]any)
+	if !ok {
+		m = map[string]any{}
+		*meta = m
+	}
+	cc, ok := m["claudeCode"].(map[string]any)
+	if !ok {
+		cc = map[string]any{}
+		m["claudeCode"] = cc
+	}
+	return cc
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestStampToolMeta(t *testing.T) {
+	session := &Session{turn: 3}
+	parent := "toolu_task"
+	start := acp.StartToolCall("t1", "Bash")
+	start.ToolCall.Meta = toolCallMeta("Bash", &parent)
+	n := session.stampToolMeta(acp.SessionNotification{SessionId: "s1", Update: start}, "assistant", map[string]any{"input_tokens": float64(10), "output_tokens": float64(5)})
+
+	meta, _ := json.Marshal(n.Update.ToolCall.Meta)
+	want := `{"claudeCode":{"messageType":"assistant","parentToolCallId":"toolu_task","toolName":"Bash","turnId":3,"usage":{"inputTokens":10,"cacheCreationInputTokens":0,"cacheReadInputTokens":0,"outputTokens":5},"version":1}}`
+	if string(meta) != want {
+		t.Errorf("meta = %s, want %s", meta, want)
+	}
+
+	// Updates built without meta get a versioned one; others are untouched.
+	n = session.stampToolMeta(acp.SessionNotification{SessionId: "s1", Update: acp.UpdateToolCall("t1")}, "user", nil)
+	meta, _ = json.Marshal(n.Update.ToolCallUpdate.Meta)
+	if want := `{"claudeCode":{"messageType":"user","parentToolCallId":null,"turnId":3,"version":1}}`; string(meta) != want {
+		t.Errorf("meta = %s, want %s", meta, want)
+	}
+	// The helpers add to each other's fields rather than replacing them.
+	update := acp.UpdateToolCall("t1")
+	update.ToolCallUpdate.Meta = withClaudeCodeMeta(nil, "note", "kept")
+	n = session.stampToolMeta(acp.SessionNotification{SessionId: "s1", Update: update}, "user", nil)
+	n.Update.ToolCallUpdate.Meta = withClaudeCodeMeta(n.Update.ToolCallUpdate.Meta, "extra", 1)
+	meta, _ = json.Marshal(n.Update.ToolCallUpdate.Meta)
+	if want := `{"claudeCode":{"extra":1,"messageType":"user","note":"kept","parentToolCallId":null,"turnId":3,"version":1}}`; string(meta) != want {
+		t.Errorf("meta = %s, want %s", meta, want)
+	}
+	n = session.stampToolMeta(acp.SessionNotification{SessionId: "s1", Update: acp.UpdateAgentMessageText("hi")}, "assistant", nil)
+	if n.Update.AgentMessageChunk.Meta != nil {
+		t.Error("expected message chunks to keep no meta")
+	}
+}
+
+func TestPrompt_TurnIDs(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{CoalesceWindow: -1})
+	var updates []acp.SessionNotification
+	session := &Session{
+		toolUseCache: NewToolUseCache(0),
+		updates:      newUpdateQueue(func(n acp.SessionNotification) { updates = append(updates, n) }, 0),
+	}
+	agent.sessions["s1"] = session
+	cliOutput := `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"hi"}]}}` + "\n" +
+		`{"type":"result","subtype":"success"}` + "\n"
+
+	for turn := 1; turn <= 2; turn++ {
+		session.process = &ClaudeCodeProcess{stdin: nopWriteCloser{io.Discard}, decoder: newNDJSONDecoder(strings.NewReader(cliOutput))}
+		resp, err := agent.Prompt(context.Background(), acp.PromptRequest{SessionId: "s1", Prompt: []acp.ContentBlock{acp.TextBlock("hello")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Meta.(map[string]any)["claudeCode"].(map[string]any)["turnId"]; got != turn {
+			t.Errorf("response turnId = %v, want %d", got, turn)
+		}
+		if len(updates) != turn || updates[turn-1].Meta != newTurnMeta(turn) {
+			t.Errorf("expected the update of turn %d to name it, got %+v", turn, updates)
+		}
+	}
+}
@@ -2,25 +2,44 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
 // ClaudeCodeOptions configures the Claude Code subprocess
 type ClaudeCodeOptions struct {
-	Cwd            string
-	SessionID      string
-	PermissionMode string // "default"|"acceptEdits"|"bypassPermissions"|"dontAsk"|"plan"
-	McpServers     map[string]McpServerConfig
-	SystemPrompt   string
-	Resume            string // optional session ID to resume
+	Cwd               string
+	SessionID         string
+	PermissionMode    string // "default"|"acceptEdits"|"bypassPermissions"|"dontAsk"|"plan"
+	McpServers        map[string]McpServerConfig
+	SystemPrompt      string
+	Resume            string // session ID to resume instead of starting SessionID
 	Executable        string // claude CLI path, defaults to "claude"
 	MaxTurns          int
-	MaxThinkingTokens int // 0 means not set
+	MaxThinkingTokens int  // 0 means not set
+	DisableThinking   bool // pass --max-thinking-tokens=0
+	Model             string
+	Env               map[string]string // added to the inherited environment
+	Settings          string            // extra settings JSON, passed in a --settings file
+	MaxMessageSize    int               // 0 means MaxMessageSize
+	Agent             string            // subagent to run the session as
+	AllowedTools      []string          // tools the CLI runs without asking
+	DisallowedTools   []string          // tools the CLI must not use
+	PermissionPrompt  string            // MCP tool the CLI asks for permission decisions
+	EnvFilter         EnvFilter         // applied to the inherited environment
+	Logger            *slog.Logger      // logs skipped stdout lines; nil uses the default
 }
 
 type McpServerConfig struct {
@@ -54,13 +73,39 @@ type SDKResponse struct {
 	Message   json.RawMessage `json:"message,omitempty"`
 	Content   json.RawMessage `json:"content,omitempty"`
 	Error     *SDKError       `json:"error,omitempty"`
-	Errors    []string        `json:"errors,omitempty"`  // For result type error messages
+	Errors    []string        `json:"errors,omitempty"`   // For result type error messages
 	IsError   bool            `json:"is_error,omitempty"` // For result type
-	Result    string          `json:"result,omitempty"`  // For result type success message
+	Result    string          `json:"result,omitempty"`   // For result type success message
 	Tools     json.RawMessage `json:"tools,omitempty"`
 	Model     string          `json:"model,omitempty"`
 	Event     json.RawMessage `json:"event,omitempty"` // For stream_event type
-	RawLine   json.RawMessage `json:"-"`               // Original ndjson line, preserved for lossless field access
+	// ParentToolUseID is set for messages emitted by a subagent (Task) tool call.
+	ParentToolUseID *string         `json:"parent_tool_use_id,omitempty"`
+	RawLine         json.RawMessage `json:"-"` // Original ndjson line, preserved for lossless field access
+	// StopReason is the API stop_reason of the turn's last response, for result type.
+	StopReason string `json:"stop_reason,omitempty"`
+
+	raw map[string]any // lazily decoded RawLine, see Raw
+}
+
+// Raw returns the message decoded as a generic map. The line is decoded at
+// most once and the result cached, so repeated calls are cheap. Callers must
+// not mutate the returned map.
+func (r *SDKResponse) Raw() map[string]any {
+	if r.raw != nil {
+		return r.raw
+	}
+	line := r.RawLine
+	if line == nil {
+		line, _ = json.Marshal(r)
+	}
+	var raw map[string]any
+	_ = json.Unmarshal(line, &raw)
+	if raw == nil {
+		raw = map[string]any{}
+	}
+	r.raw = raw
+	return raw
 }
 
 type SDKError struct {
@@ -68,6 +113,19 @@ type SDKError struct {
 	Code    string `json:"code,omitempty"`
 }
 
+// UnmarshalJSON also accepts a bare string, which auth_status messages use
+// for their error and assistant messages for an error category such as
+// "authentication_failed".
+func (e *SDKError) UnmarshalJSON(data []byte) error {
+	var msg string
+	if err := json.Unmarshal(data, &msg); err == nil {
+		*e = SDKError{Message: msg}
+		return nil
+	}
+	type plain SDKError
+	return json.Unmarshal(data, (*plain)(e))
+}
+
 // SDKContentBlock represents a content block in Claude's response
 type SDKContentBlock struct {
 	Type     string          `json:"type"` // text|tool_use|tool_result|thinking
@@ -88,21 +146,109 @@ type StreamEvent struct {
 	Delta        json.RawMessage  `json:"delta,omitempty"`
 }
 
-// ClaudeCodeProcess manages communication with the Claude Code CLI subprocess
+// MaxMessageSize is the largest single ndjson message accepted from the CLI.
+// Larger messages are read past and discarded so the session can continue.
+const MaxMessageSize = 256 * 1024 * 1024
+
+// MessageTooLargeError is returned by ReadMessage when a CLI message exceeds
+// MaxMessageSize. The stream stays usable; callers may skip the message.
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+	Type  string
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds limit of %d bytes (type=%q)", e.Size, e.Limit, e.Type)
+}
+
+// ClaudeCodeProcess manages communication with an agent CLI subprocess
+// over ndjson. Its converter translates the CLI's messages; without one the
+// process speaks the Claude Code format.
 type ClaudeCodeProcess struct {
-	cmd     *exec.Cmd
-	stdin   io.WriteCloser
-	scanner *bufio.Scanner
-	done    chan struct{}
-	mu      sync.Mutex
+	cmd            *exec.Cmd
+	stdin          io.WriteCloser
+	decoder        *ndjsonDecoder
+	converter      MessageConverter
+	logger         *slog.Logger
+	maxMessageSize int
+	interrupts     int      // request IDs for interrupts
+	tempFiles      []string // --mcp-config and --settings files, removed on Close
+	done           chan struct{}
+	mu             sync.Mutex
 }
 
-// NewClaudeCodeProcess starts a Claude Code subprocess with the given options.
-func NewClaudeCodeProcess(opts ClaudeCodeOptions) (*ClaudeCodeProcess, error) {
-	executable := opts.Executable
+var _ Process = (*ClaudeCodeProcess)(nil)
+
+const (
+	cliInstallHint = "install it with `npm install -g @anthropic-ai/claude-code` or set CLAUDE_CODE_EXECUTABLE"
+	cliUpgradeHint = "upgrade it with `claude update` or `npm install -g @anthropic-ai/claude-code@latest`"
+)
+
+// CLIUnavailableError reports a claude CLI that cannot be used: it was not
+// found, or its version is older than minCLIVersion.
+type CLIUnavailableError struct {
+	Executable string
+	Version    string // empty if the CLI was not found
+	Err        error
+}
+
+func (e *CLIUnavailableError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("claude CLI %q not found; %s", e.Executable, cliInstallHint)
+	}
+	return fmt.Sprintf("claude CLI %q is version %s, but %s or newer is required; %s", e.Executable, e.Version, minCLIVersion, cliUpgradeHint)
+}
+
+func (e *CLIUnavailableError) Unwrap() error { return e.Err }
+
+// Hint returns the instructions for fixing the error.
+func (e *CLIUnavailableError) Hint() string {
+	if e.Version == "" {
+		return cliInstallHint
+	}
+	return cliUpgradeHint
+}
+
+// cliExecutable returns executable, defaulting to "claude" on PATH.
+func cliExecutable(executable string) string {
 	if executable == "" {
-		executable = "claude"
+		return "claude"
 	}
+	return executable
+}
+
+// cliVersion returns the output of executable --version.
+func cliVersion(executable string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, executable, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// verifyCLI checks that executable exists and is not older than
+// minCLIVersion. Versions that cannot be determined are accepted.
+func verifyCLI(executable string) error {
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return &CLIUnavailableError{Executable: executable, Err: err}
+	}
+	version, err := cliVersion(path)
+	if err != nil {
+		return nil
+	}
+	if cmp, ok := compareVersions(version, minCLIVersion); ok && cmp < 0 {
+		return &CLIUnavailableError{Executable: path, Version: version}
+	}
+	return nil
+}
+
+// NewClaudeCodeProcess starts a Claude Code subprocess with the given options.
+func NewClaudeCodeProcess(opts ClaudeCodeOptions) (*ClaudeCodeProcess, error) {
+	executable := cliExecutable(opts.Executable)
 
 	maxTurns := opts.MaxTurns
 	if maxTurns <= 0 {
@@ -115,45 +261,101 @@ func NewClaudeCodeProcess(opts ClaudeCodeOptions) (*ClaudeCodeProcess, error) {
 		"--verbose",
 		"--include-partial-messages",
 		fmt.Sprintf("--max-turns=%d", maxTurns),
-		fmt.Sprintf("--session-id=%s", opts.SessionID),
+	}
+	if opts.Resume != "" {
+		args = append(args, "--resume="+opts.Resume)
+	} else {
+		args = append(args, "--session-id="+opts.SessionID)
 	}
 
 	if opts.PermissionMode != "" {
 		args = append(args, fmt.Sprintf("--permission-mode=%s", opts.PermissionMode))
 	}
 
-	if opts.Resume != "" {
-		args = append(args, "--resume")
-	}
-
 	if opts.SystemPrompt != "" {
 		args = append(args, fmt.Sprintf("--system-prompt=%s", opts.SystemPrompt))
 	}
 
 	if opts.MaxThinkingTokens > 0 {
 		args = append(args, fmt.Sprintf("--max-thinking-tokens=%d", opts.MaxThinkingTokens))
+	} else if opts.DisableThinking {
+		args = append(args, "--max-thinking-tokens=0")
 	}
 
-	if len(opts.McpServers) > 0 {
-		tmpFile, err := os.CreateTemp("", "mcp-config-*.json")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create mcp config temp file: %w", err)
+	if opts.Model != "" {
+		args = append(args, fmt.Sprintf("--model=%s", opts.Model))
+	}
+
+	if opts.Agent != "" {
+		args = append(args, fmt.Sprintf("--agent=%s", opts.Agent))
+	}
+
+	// Rule specifiers may contain commas, so each rule goes in an argument
+	// of its own.
+	for _, rule := range opts.AllowedTools {
+		args = append(args, "--allowedTools="+rule)
+	}
+	for _, rule := range opts.DisallowedTools {
+		args = append(args, "--disallowedTools="+rule)
+	}
+
+	if opts.PermissionPrompt != "" {
+		args = append(args, fmt.Sprintf("--permission-prompt-tool=%s", opts.PermissionPrompt))
+	}
+
+	// Settings may hold env secrets, so they go in a file only the user can
+	// read rather than on the command line, which other users can see.
+	var tempFiles []string
+	removeTempFiles := func() {
+		for _, path := range tempFiles {
+			os.Remove(path)
 		}
-		mcpConfig := map[string]interface{}{
-			"mcpServers": opts.McpServers,
+	}
+	if opts.Settings != "" {
+		path, err := writeTempJSON("settings-*.json", json.RawMessage(opts.Settings))
+		if err != nil {
+			return nil, fmt.Errorf("failed to write settings: %w", err)
 		}
-		if err := json.NewEncoder(tmpFile).Encode(mcpConfig); err != nil {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-			return nil, fmt.Errorf("failed to write mcp config: %w", err)
+		tempFiles = append(tempFiles, path)
+		args = append(args, fmt.Sprintf("--settings=%s", path))
+	}
+
+	if len(opts.McpServers) > 0 {
+		path, err := writeMCPConfig(opts.McpServers)
+		if err != nil {
+			removeTempFiles()
+			return nil, err
 		}
-		tmpFile.Close()
-		args = append(args, fmt.Sprintf("--mcp-config=%s", tmpFile.Name()))
+		tempFiles = append(tempFiles, path)
+		args = append(args, fmt.Sprintf("--mcp-config=%s", path))
 	}
 
+	p, err := startProcess(executable, args, opts, claudeConverter{})
+	if err != nil {
+		removeTempFiles()
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return nil, &CLIUnavailableError{Executable: executable, Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start claude process: %w", err)
+	}
+	p.tempFiles = tempFiles
+	return p, nil
+}
+
+// startProcess runs executable with args in the session's directory and
+// environment, exchanging ndjson messages through converter.
+func startProcess(executable string, args []string, opts ClaudeCodeOptions, converter MessageConverter) (*ClaudeCodeProcess, error) {
 	cmd := exec.Command(executable, args...)
 	cmd.Dir = opts.Cwd
 	cmd.Stderr = os.Stderr
+	if len(opts.Env) > 0 || !opts.EnvFilter.isZero() {
+		cmd.Env = opts.EnvFilter.apply(os.Environ())
+		for k, v := range opts.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
 
 	stdinPipe, err := cmd.StdinPipe()
 	if err != nil {
@@ -166,31 +368,61 @@ func NewClaudeCodeProcess(opts ClaudeCodeOptions) (*ClaudeCodeProcess, error) {
 	}
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start claude process: %w", err)
+		return nil, err
 	}
 
-	scanner := bufio.NewScanner(stdoutPipe)
-	scanner.Buffer(make([]byte, 0, 10*1024*1024), 10*1024*1024) // 10MB buffer
+	maxMessageSize := opts.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = MaxMessageSize
+	}
 
 	p := &ClaudeCodeProcess{
-		cmd:     cmd,
-		stdin:   stdinPipe,
-		scanner: scanner,
-		done:    make(chan struct{}),
+		cmd:            cmd,
+		stdin:          stdinPipe,
+		decoder:        newNDJSONDecoder(stdoutPipe),
+		converter:      converter,
+		logger:         opts.Logger,
+		maxMessageSize: maxMessageSize,
+		done:           make(chan struct{}),
 	}
 
 	return p, nil
 }
 
-// SendMessage sends a user message to the Claude Code subprocess via stdin.
+// conv returns the process's message converter.
+func (p *ClaudeCodeProcess) conv() MessageConverter {
+	if p.converter == nil {
+		return claudeConverter{}
+	}
+	return p.converter
+}
+
+// SendMessage sends a user message to the subprocess via stdin.
 func (p *ClaudeCodeProcess) SendMessage(msg SDKUserMessage) error {
+	data, err := p.conv().EncodeUserMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return p.writeLine(data)
+}
+
+// Interrupt asks the subprocess to stop the current turn.
+func (p *ClaudeCodeProcess) Interrupt() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.interrupts++
+	requestID := fmt.Sprintf("interrupt-%d", p.interrupts)
+	p.mu.Unlock()
 
-	data, err := json.Marshal(msg)
+	data, err := p.conv().EncodeInterrupt(requestID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return fmt.Errorf("failed to marshal interrupt: %w", err)
 	}
+	return p.writeLine(data)
+}
+
+func (p *ClaudeCodeProcess) writeLine(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	data = append(data, '\n')
 	if _, err := p.stdin.Write(data); err != nil {
@@ -200,28 +432,107 @@ func (p *ClaudeCodeProcess) SendMessage(msg SDKUserMessage) error {
 	return nil
 }
 
-// ReadMessage reads the next ndjson line from the subprocess stdout.
-// Returns nil, io.EOF when there are no more lines.
-func (p *ClaudeCodeProcess) ReadMessage() (*SDKResponse, error) {
-	if !p.scanner.Scan() {
-		if err := p.scanner.Err(); err != nil {
-			return nil, fmt.Errorf("scanner error: %w", err)
+// ndjsonDecoder reads the lines of an ndjson stream. Unlike bufio.Scanner
+// it has no fixed line length limit: callers give the limit per line, and
+// the rest of a longer line is read past without being kept.
+type ndjsonDecoder struct {
+	r *bufio.Reader
+}
+
+func newNDJSONDecoder(r io.Reader) *ndjsonDecoder {
+	return &ndjsonDecoder{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// next returns the next line without its newline, and its full size. If
+// the size is over limit, the line holds only its first limit bytes. A
+// limit of zero or less keeps every line whole. It returns io.EOF once the
+// stream ends.
+func (d *ndjsonDecoder) next(limit int) ([]byte, int, error) {
+	var line []byte
+	size := 0
+	for {
+		chunk, err := d.r.ReadSlice('\n')
+		size += len(chunk)
+		if limit <= 0 || len(line) < limit {
+			keep := chunk
+			if limit > 0 {
+				keep = chunk[:min(len(chunk), limit-len(line))]
+			}
+			line = append(line, keep...)
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err == nil:
+			size--
+			if len(line) > 0 && line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			}
+			return line, size, nil
+		case errors.Is(err, io.EOF) && size > 0:
+			return line, size, nil
+		default:
+			return nil, 0, err
 		}
-		return nil, io.EOF
 	}
+}
 
-	line := p.scanner.Bytes()
-	// Make a copy since scanner reuses the buffer
-	rawCopy := make([]byte, len(line))
-	copy(rawCopy, line)
+// messageTypePattern finds the type of a message cut short by the size
+// limit.
+var messageTypePattern = regexp.MustCompile(`^\{\s*"type"\s*:\s*"([^"]*)"`)
 
-	var resp SDKResponse
-	if err := json.Unmarshal(rawCopy, &resp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// ReadMessage reads the next ndjson message from the subprocess stdout.
+// Returns nil, io.EOF when there are no more messages. A message larger than
+// the size limit yields a *MessageTooLargeError and the stream stays usable;
+// no more than the limit is kept in memory. Lines that are not messages,
+// such as warnings the CLI prints to stdout, are logged and skipped, as are
+// lines the converter skips.
+func (p *ClaudeCodeProcess) ReadMessage() (*SDKResponse, error) {
+	for {
+		line, size, err := p.decoder.next(p.maxMessageSize)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		if p.maxMessageSize > 0 && size > p.maxMessageSize {
+			tooLarge := &MessageTooLargeError{Size: size, Limit: p.maxMessageSize}
+			if m := messageTypePattern.FindSubmatch(line); m != nil {
+				tooLarge.Type = string(m[1])
+			}
+			return nil, tooLarge
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		resp, err := p.conv().DecodeMessage(json.RawMessage(line))
+		if err != nil {
+			p.log().Warn("Skipping CLI output that is not a message", "line", truncateForLog(line), "error", err)
+			continue
+		}
+		if resp != nil {
+			return resp, nil
+		}
+	}
+}
+
+func (p *ClaudeCodeProcess) log() *slog.Logger {
+	if p.logger != nil {
+		return p.logger
 	}
-	resp.RawLine = rawCopy
+	return slog.Default()
+}
 
-	return &resp, nil
+// truncateForLog shortens a skipped line for the log.
+func truncateForLog(line []byte) string {
+	const max = 200
+	if len(line) > max {
+		return string(line[:max]) + "…"
+	}
+	return string(line)
 }
 
 // Close shuts down the subprocess by closing stdin and waiting for exit.
@@ -235,9 +546,20 @@ func (p *ClaudeCodeProcess) Close() error {
 
 	err := p.cmd.Wait()
 	close(p.done)
+	for _, path := range p.tempFiles {
+		os.Remove(path)
+	}
 	return err
 }
 
+// Pid returns the subprocess's process ID.
+func (p *ClaudeCodeProcess) Pid() int {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
 // Done returns a channel that is closed when the process exits.
 func (p *ClaudeCodeProcess) Done() <-chan struct{} {
 	return p.done
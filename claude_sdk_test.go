@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage_LongLine(t *testing.T) {
+	// Lines well beyond the old 10MB scanner limit must decode.
+	text := strings.Repeat("x", 11*1024*1024)
+	input := `{"type":"assistant","message":{"role":"assistant","content":"` + text + `"}}` + "\n" +
+		`{"type":"result","subtype":"success"}` + "\n"
+	p := &ClaudeCodeProcess{decoder: newNDJSONDecoder(strings.NewReader(input)), maxMessageSize: MaxMessageSize}
+
+	resp, err := p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Type != "assistant" {
+		t.Errorf("expected assistant, got %q", resp.Type)
+	}
+	if len(resp.RawLine) < len(text) {
+		t.Errorf("RawLine truncated: %d bytes", len(resp.RawLine))
+	}
+
+	resp, err = p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Type != "result" || resp.Subtype != "success" {
+		t.Errorf("unexpected second message: %+v", resp)
+	}
+
+	if _, err := p.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadMessage_TooLarge(t *testing.T) {
+	input := `{"type":"user","message":{"content":"` + strings.Repeat("y", 100) + `"}}` + "\n" +
+		`{"type":"result","subtype":"success"}` + "\n"
+	p := &ClaudeCodeProcess{decoder: newNDJSONDecoder(strings.NewReader(input)), maxMessageSize: 50}
+
+	_, err := p.ReadMessage()
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected MessageTooLargeError, got %v", err)
+	}
+	if tooLarge.Type != "user" || tooLarge.Limit != 50 {
+		t.Errorf("unexpected error details: %+v", tooLarge)
+	}
+
+	// The stream must remain usable after an oversized message.
+	resp, err := p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Type != "result" {
+		t.Errorf("expected result, got %q", resp.Type)
+	}
+}
+
+func TestReadMessage_SkipsNonJSONLines(t *testing.T) {
+	input := "Warning: config file not found\n" +
+		`{"type":"assistant"` + "\n\n" +
+		`{"type":"result","subtype":"success"}` + "\n"
+	p := &ClaudeCodeProcess{decoder: newNDJSONDecoder(strings.NewReader(input)), maxMessageSize: MaxMessageSize}
+
+	resp, err := p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Type != "result" {
+		t.Errorf("expected the lines before the result skipped, got %q", resp.Type)
+	}
+	if _, err := p.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestNDJSONDecoder_Limit(t *testing.T) {
+	d := newNDJSONDecoder(strings.NewReader(strings.Repeat("x", 200_000) + "\nshort"))
+	line, size, err := d.next(10)
+	if err != nil || size != 200_000 || string(line) != strings.Repeat("x", 10) {
+		t.Errorf("got %d bytes of %d, %v; want the first 10 of 200000", len(line), size, err)
+	}
+	// A last line without a newline is still read.
+	if line, size, err := d.next(10); err != nil || string(line) != "short" || size != 5 {
+		t.Errorf("got %q %d %v, want the last line", line, size, err)
+	}
+	if _, _, err := d.next(10); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadMessage_ParentToolUseIDAndRaw(t *testing.T) {
+	input := `{"type":"stream_event","parent_tool_use_id":"toolu_1","event":{"type":"message_stop"}}` + "\n" +
+		`{"type":"assistant","parent_tool_use_id":null}` + "\n"
+	p := &ClaudeCodeProcess{decoder: newNDJSONDecoder(strings.NewReader(input)), maxMessageSize: MaxMessageSize}
+
+	resp, err := p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ParentToolUseID == nil || *resp.ParentToolUseID != "toolu_1" {
+		t.Errorf("expected parent tool use id, got %v", resp.ParentToolUseID)
+	}
+	raw := resp.Raw()
+	event, _ := raw["event"].(map[string]any)
+	if event["type"] != "message_stop" {
+		t.Errorf("unexpected raw event: %v", raw)
+	}
+	// The map is shared, so check it is cached without mutating it.
+	if reflect.ValueOf(resp.Raw()).UnsafePointer() != reflect.ValueOf(raw).UnsafePointer() {
+		t.Error("expected Raw to return the cached map")
+	}
+
+	resp, err = p.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ParentToolUseID != nil {
+		t.Errorf("expected nil parent tool use id for null, got %v", *resp.ParentToolUseID)
+	}
+}
+
+func TestNewClaudeCodeProcess_SettingsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the CLI")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	cli := filepath.Join(dir, "claude")
+	script := "#!/bin/sh\nfor a; do case $a in --settings=*) f=\"${a#--settings=}\"; echo \"$f\" > " + out + "; cat \"$f\" >> " + out + ";; esac; done\ncat > /dev/null\n"
+	if err := os.WriteFile(cli, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewClaudeCodeProcess(ClaudeCodeOptions{Cwd: dir, SessionID: "s1", Executable: cli, Settings: `{"env":{"TOKEN":"s3cret"}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, settings, _ := strings.Cut(string(data), "\n")
+	if !filepath.IsAbs(path) || !strings.Contains(settings, `"TOKEN":"s3cret"`) {
+		t.Fatalf("expected a settings file path on the command line, got %q", data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the settings file to be removed on close, got %v", err)
+	}
+}
+
+func TestNewClaudeCodeProcess_ToolRules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the CLI")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	cli := filepath.Join(dir, "claude")
+	script := "#!/bin/sh\nfor a; do case $a in --allowedTools=*|--disallowedTools=*) echo \"$a\" >> " + out + ";; esac; done\ncat > /dev/null\n"
+	if err := os.WriteFile(cli, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewClaudeCodeProcess(ClaudeCodeOptions{
+		Cwd:             dir,
+		SessionID:       "s1",
+		Executable:      cli,
+		AllowedTools:    []string{"Read", "Bash(sort -t,:*)"},
+		DisallowedTools: []string{"WebFetch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--allowedTools=Read\n--allowedTools=Bash(sort -t,:*)\n--disallowedTools=WebFetch\n"
+	if string(data) != want {
+		t.Errorf("got args %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// settleTime is how long a check waits after a prompt response for
+// updates the agent should not send anymore.
+const settleTime = 500 * time.Millisecond
+
+// conformance runs the checks against one agent connection. Later checks
+// build on the state earlier ones leave behind.
+type conformance struct {
+	conn   *acp.ClientSideConnection
+	client *conformanceClient
+	wire   *wireRecorder
+	dir    string
+	opts   options
+
+	init    *acp.InitializeResponse
+	session *acp.NewSessionResponse
+}
+
+// check is one conformance assertion. It fails with an error and is
+// skipped with a skipError.
+type check struct {
+	name string
+	run  func(ctx context.Context, c *conformance) error
+}
+
+type skipError struct{ reason string }
+
+func (e skipError) Error() string { return e.reason }
+
+func skip(format string, args ...any) error {
+	return skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+var checks = []check{
+	{"initialize", checkInitialize},
+	{"session/new", checkNewSession},
+	{"session/new returns distinct IDs", checkDistinctSessions},
+	{"session/set_mode", checkSetMode},
+	{"session/prompt", checkPrompt},
+	{"session/prompt with an unknown session fails", checkPromptUnknownSession},
+	{"session/cancel", checkCancel},
+	{"session/prompt after cancel", checkPromptAfterCancel},
+	{"session/load", checkLoadSession},
+}
+
+func checkInitialize(ctx context.Context, c *conformance) error {
+	resp, err := c.conn.Initialize(ctx, acp.InitializeRequest{
+		ProtocolVersion: acp.ProtocolVersionNumber,
+		ClientCapabilities: acp.ClientCapabilities{
+			Fs: acp.FileSystemCapability{ReadTextFile: true, WriteTextFile: true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if resp.ProtocolVersion < 1 || resp.ProtocolVersion > acp.ProtocolVersionNumber {
+		return fmt.Errorf("protocol version %d, want 1 to %d", resp.ProtocolVersion, acp.ProtocolVersionNumber)
+	}
+	seen := map[acp.AuthMethodId]bool{}
+	for _, m := range resp.AuthMethods {
+		if m.Id == "" {
+			return errors.New("auth method with an empty ID")
+		}
+		if seen[m.Id] {
+			return fmt.Errorf("auth method %q listed twice", m.Id)
+		}
+		seen[m.Id] = true
+	}
+	c.init = &resp
+	return nil
+}
+
+func (c *conformance) newSession(ctx context.Context) (acp.NewSessionResponse, error) {
+	if c.init == nil {
+		return acp.NewSessionResponse{}, skip("not initialized")
+	}
+	resp, err := c.conn.NewSession(ctx, acp.NewSessionRequest{Cwd: c.dir, McpServers: []acp.McpServer{}})
+	if err != nil {
+		return resp, err
+	}
+	if resp.SessionId == "" {
+		return resp, errors.New("empty session ID")
+	}
+	return resp, nil
+}
+
+func checkNewSession(ctx context.Context, c *conformance) error {
+	resp, err := c.newSession(ctx)
+	if err != nil {
+		return err
+	}
+	if m := resp.Modes; m != nil {
+		if !slices.ContainsFunc(m.AvailableModes, func(mode acp.SessionMode) bool { return mode.Id == m.CurrentModeId }) {
+			return fmt.Errorf("current mode %q is not among the available modes", m.CurrentModeId)
+		}
+	}
+	c.session = &resp
+	return nil
+}
+
+func checkDistinctSessions(ctx context.Context, c *conformance) error {
+	if c.session == nil {
+		return skip("no session")
+	}
+	resp, err := c.newSession(ctx)
+	if err != nil {
+		return err
+	}
+	if resp.SessionId == c.session.SessionId {
+		return fmt.Errorf("both sessions are %q", resp.SessionId)
+	}
+	return nil
+}
+
+func checkSetMode(ctx context.Context, c *conformance) error {
+	if c.session == nil {
+		return skip("no session")
+	}
+	modes := c.session.Modes
+	if modes == nil || len(modes.AvailableModes) < 2 {
+		return skip("the agent offers fewer than two modes")
+	}
+	id := c.session.SessionId
+	original := modes.CurrentModeId
+	other := modes.AvailableModes[0].Id
+	if other == original {
+		other = modes.AvailableModes[1].Id
+	}
+	if _, err := c.conn.SetSessionMode(ctx, acp.SetSessionModeRequest{SessionId: id, ModeId: other}); err != nil {
+		return fmt.Errorf("switching to %q: %w", other, err)
+	}
+	if _, err := c.conn.SetSessionMode(ctx, acp.SetSessionModeRequest{SessionId: id, ModeId: "acp-conformance-no-such-mode"}); err == nil {
+		return errors.New("switching to an unknown mode succeeded")
+	}
+	if _, err := c.conn.SetSessionMode(ctx, acp.SetSessionModeRequest{SessionId: id, ModeId: original}); err != nil {
+		return fmt.Errorf("switching back to %q: %w", original, err)
+	}
+	return nil
+}
+
+// turn is a prompt turn and the wire events it produced.
+type turn struct {
+	resp   acp.PromptResponse
+	events []wireEvent
+}
+
+// prompt runs a turn and checks what every turn must satisfy: a known stop
+// reason, updates for the prompted session only, tool call updates for
+// announced tool calls, and no updates after the response.
+//
+// cancel, if set, runs while the turn does. first is closed when the agent
+// sends the turn's first message and done when the prompt returns.
+func (c *conformance) prompt(ctx context.Context, text string, cancel func(first, done <-chan struct{}) error) (turn, error) {
+	if c.session == nil {
+		return turn{}, skip("no session")
+	}
+	id := c.session.SessionId
+	mark := c.wire.mark()
+	first := c.wire.next()
+
+	type result struct {
+		resp acp.PromptResponse
+		err  error
+	}
+	done := make(chan struct{})
+	results := make(chan result, 1)
+	go func() {
+		resp, err := c.conn.Prompt(ctx, acp.PromptRequest{SessionId: id, Prompt: []acp.ContentBlock{acp.TextBlock(text)}})
+		results <- result{resp, err}
+		close(done)
+	}()
+	var cancelErr error
+	if cancel != nil {
+		cancelErr = cancel(first, done)
+	}
+	r := <-results
+	if r.err != nil {
+		return turn{}, r.err
+	}
+	if cancelErr != nil {
+		return turn{}, cancelErr
+	}
+	switch r.resp.StopReason {
+	case acp.StopReasonEndTurn, acp.StopReasonMaxTokens, acp.StopReasonMaxTurnRequests, acp.StopReasonRefusal, acp.StopReasonCancelled:
+	default:
+		return turn{}, fmt.Errorf("unknown stop reason %q", r.resp.StopReason)
+	}
+
+	select {
+	case <-time.After(settleTime):
+	case <-ctx.Done():
+		return turn{}, ctx.Err()
+	}
+	t := turn{resp: r.resp, events: c.wire.since(mark)}
+	toolCalls := map[string]bool{}
+	responded := false
+	for _, ev := range t.events {
+		if ev.StopReason != "" {
+			responded = true
+			continue
+		}
+		if ev.SessionID != id {
+			return t, fmt.Errorf("%s update for session %q during a turn of %q", ev.Kind, ev.SessionID, id)
+		}
+		if responded {
+			return t, fmt.Errorf("%s update after the prompt response", ev.Kind)
+		}
+		switch ev.Kind {
+		case "tool_call":
+			toolCalls[ev.ToolCallID] = true
+		case "tool_call_update":
+			if !toolCalls[ev.ToolCallID] {
+				return t, fmt.Errorf("tool_call_update for unannounced tool call %q", ev.ToolCallID)
+			}
+		}
+	}
+	return t, nil
+}
+
+func checkPrompt(ctx context.Context, c *conformance) error {
+	_, err := c.prompt(ctx, c.opts.prompt, nil)
+	return err
+}
+
+func checkPromptUnknownSession(ctx context.Context, c *conformance) error {
+	if c.init == nil {
+		return skip("not initialized")
+	}
+	_, err := c.conn.Prompt(ctx, acp.PromptRequest{
+		SessionId: "acp-conformance-no-such-session",
+		Prompt:    []acp.ContentBlock{acp.TextBlock(c.opts.prompt)},
+	})
+	if err == nil {
+		return errors.New("prompt succeeded")
+	}
+	return nil
+}
+
+// checkCancel cancels a turn once the agent sends its first update, or
+// after -cancel-after without one, and expects the turn to stop as
+// cancelled.
+func checkCancel(ctx context.Context, c *conformance) error {
+	if c.session == nil {
+		return skip("no session")
+	}
+	id := c.session.SessionId
+	defer c.client.setCancelled(id, false)
+	ended := false
+	mark := c.wire.mark()
+	t, err := c.prompt(ctx, c.opts.cancelPrompt, func(first, done <-chan struct{}) error {
+		select {
+		case <-first:
+		case <-time.After(c.opts.cancelAfter):
+		case <-done:
+		}
+		select {
+		case <-done:
+			ended = true
+			return nil
+		default:
+		}
+		if slices.ContainsFunc(c.wire.since(mark), func(ev wireEvent) bool { return ev.StopReason != "" }) {
+			ended = true
+			return nil
+		}
+		c.client.setCancelled(id, true)
+		return c.conn.Cancel(ctx, acp.CancelNotification{SessionId: id})
+	})
+	if err != nil {
+		return err
+	}
+	if ended {
+		return skip("the turn ended before it could be cancelled")
+	}
+	if t.resp.StopReason != acp.StopReasonCancelled {
+		return fmt.Errorf("stop reason %q, want cancelled", t.resp.StopReason)
+	}
+	return nil
+}
+
+func checkPromptAfterCancel(ctx context.Context, c *conformance) error {
+	_, err := c.prompt(ctx, c.opts.prompt, nil)
+	return err
+}
+
+func checkLoadSession(ctx context.Context, c *conformance) error {
+	if c.session == nil {
+		return skip("no session")
+	}
+	if !c.init.AgentCapabilities.LoadSession {
+		return skip("the agent does not advertise loadSession")
+	}
+	id := c.session.SessionId
+	mark := c.wire.mark()
+	if _, err := c.conn.LoadSession(ctx, acp.LoadSessionRequest{SessionId: id, Cwd: c.dir, McpServers: []acp.McpServer{}}); err != nil {
+		return err
+	}
+	for _, ev := range c.wire.since(mark) {
+		if ev.StopReason == "" && ev.SessionID != id {
+			return fmt.Errorf("%s update for session %q while loading %q", ev.Kind, ev.SessionID, id)
+		}
+	}
+	return nil
+}
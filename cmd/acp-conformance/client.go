@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// conformanceClient is the client side of a conformance run. It allows
+// every permission request until the session's turn is cancelled, and
+// serves files from the run's directory. Session updates are checked in
+// the order they arrive on the wire, by wireRecorder.
+type conformanceClient struct {
+	dir string
+
+	mu        sync.Mutex
+	cancelled map[acp.SessionId]bool
+}
+
+var _ acp.Client = (*conformanceClient)(nil)
+
+func newConformanceClient(dir string) *conformanceClient {
+	return &conformanceClient{dir: dir, cancelled: map[acp.SessionId]bool{}}
+}
+
+// setCancelled makes permission requests for the session answer cancelled,
+// as the protocol requires once the client has cancelled the turn.
+func (c *conformanceClient) setCancelled(id acp.SessionId, cancelled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelled[id] = cancelled
+}
+
+func (c *conformanceClient) SessionUpdate(context.Context, acp.SessionNotification) error {
+	return nil
+}
+
+func (c *conformanceClient) RequestPermission(_ context.Context, params acp.RequestPermissionRequest) (acp.RequestPermissionResponse, error) {
+	c.mu.Lock()
+	cancelled := c.cancelled[params.SessionId]
+	c.mu.Unlock()
+	if !cancelled {
+		for _, opt := range params.Options {
+			if opt.Kind == acp.PermissionOptionKindAllowOnce || opt.Kind == acp.PermissionOptionKindAllowAlways {
+				return acp.RequestPermissionResponse{
+					Outcome: acp.RequestPermissionOutcome{Selected: &acp.RequestPermissionOutcomeSelected{OptionId: opt.OptionId}},
+				}, nil
+			}
+		}
+	}
+	return acp.RequestPermissionResponse{
+		Outcome: acp.RequestPermissionOutcome{Cancelled: &acp.RequestPermissionOutcomeCancelled{}},
+	}, nil
+}
+
+// path resolves a path the agent asked for, refusing paths outside the
+// run's directory.
+func (c *conformanceClient) path(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q is not absolute", p)
+	}
+	rel, err := filepath.Rel(c.dir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the conformance directory", p)
+	}
+	return p, nil
+}
+
+func (c *conformanceClient) ReadTextFile(_ context.Context, params acp.ReadTextFileRequest) (acp.ReadTextFileResponse, error) {
+	p, err := c.path(params.Path)
+	if err != nil {
+		return acp.ReadTextFileResponse{}, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return acp.ReadTextFileResponse{}, err
+	}
+	return acp.ReadTextFileResponse{Content: string(data)}, nil
+}
+
+func (c *conformanceClient) WriteTextFile(_ context.Context, params acp.WriteTextFileRequest) (acp.WriteTextFileResponse, error) {
+	p, err := c.path(params.Path)
+	if err != nil {
+		return acp.WriteTextFileResponse{}, err
+	}
+	return acp.WriteTextFileResponse{}, os.WriteFile(p, []byte(params.Content), 0o644)
+}
+
+// The client does not advertise terminals, so agents must not use them.
+
+func (c *conformanceClient) CreateTerminal(context.Context, acp.CreateTerminalRequest) (acp.CreateTerminalResponse, error) {
+	return acp.CreateTerminalResponse{}, errNoTerminals
+}
+
+func (c *conformanceClient) KillTerminalCommand(context.Context, acp.KillTerminalCommandRequest) (acp.KillTerminalCommandResponse, error) {
+	return acp.KillTerminalCommandResponse{}, errNoTerminals
+}
+
+func (c *conformanceClient) TerminalOutput(context.Context, acp.TerminalOutputRequest) (acp.TerminalOutputResponse, error) {
+	return acp.TerminalOutputResponse{}, errNoTerminals
+}
+
+func (c *conformanceClient) ReleaseTerminal(context.Context, acp.ReleaseTerminalRequest) (acp.ReleaseTerminalResponse, error) {
+	return acp.ReleaseTerminalResponse{}, errNoTerminals
+}
+
+func (c *conformanceClient) WaitForTerminalExit(context.Context, acp.WaitForTerminalExitRequest) (acp.WaitForTerminalExitResponse, error) {
+	return acp.WaitForTerminalExitResponse{}, errNoTerminals
+}
+
+var errNoTerminals = errors.New("terminals are not supported: the client did not advertise them")
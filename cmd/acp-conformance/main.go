@@ -0,0 +1,125 @@
+// Command acp-conformance checks that an ACP agent handles the core
+// protocol flows correctly: initialize, session/new, session/set_mode,
+// session/prompt, session/cancel and, when advertised, session/load.
+//
+// Usage:
+//
+//	acp-conformance [flags] -- agent [args...]
+//
+// The agent runs in a fresh temporary directory, which is also the working
+// directory of its sessions. The client advertises file system access but
+// no terminals, and allows every permission request. The command exits
+// non-zero if any check fails.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+type options struct {
+	prompt       string
+	cancelPrompt string
+	cancelAfter  time.Duration
+	timeout      time.Duration
+	verbose      bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.prompt, "prompt", "Reply with the single word: pong.", "Prompt for turns expected to complete")
+	flag.StringVar(&opts.cancelPrompt, "cancel-prompt", "Count from 1 to 500, one number per line.", "Prompt for the turn that gets cancelled")
+	flag.DurationVar(&opts.cancelAfter, "cancel-after", 2*time.Second, "Cancel the turn after this long if the agent sends nothing")
+	flag.DurationVar(&opts.timeout, "timeout", 2*time.Minute, "Timeout for each check")
+	flag.BoolVar(&opts.verbose, "v", false, "Show the agent's stderr and the updates it sends")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- agent [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed, err := run(opts, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// run starts the agent and runs every check against it, reporting whether
+// any failed.
+func run(opts options, agent []string) (bool, error) {
+	dir, err := os.MkdirTemp("", "acp-conformance-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command(agent[0], agent[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = io.Discard
+	if opts.verbose {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return false, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("starting agent: %w", err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	client := newConformanceClient(dir)
+	wire := &wireRecorder{r: stdout, verbose: opts.verbose}
+	c := &conformance{
+		conn:   acp.NewClientSideConnection(client, stdin, wire),
+		client: client,
+		wire:   wire,
+		dir:    dir,
+		opts:   opts,
+	}
+
+	var passed, failed, skipped int
+	for _, chk := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+		err := chk.run(ctx, c)
+		cancel()
+		var skipErr skipError
+		switch {
+		case err == nil:
+			passed++
+			fmt.Fprintf(os.Stderr, "✅ %s\n", chk.name)
+		case errors.As(err, &skipErr):
+			skipped++
+			fmt.Fprintf(os.Stderr, "⏭  %s: %s\n", chk.name, skipErr.reason)
+		default:
+			failed++
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", chk.name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return failed > 0, nil
+}
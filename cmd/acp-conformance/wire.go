@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// wireRecorder sits between the agent's stdout and the client connection
+// and records the session updates and prompt responses in the order the
+// agent wrote them. The SDK dispatches notifications concurrently, so the
+// order the client sees them in proves nothing.
+type wireRecorder struct {
+	r       io.Reader
+	verbose bool
+
+	mu       sync.Mutex
+	partial  []byte
+	events   []wireEvent
+	watchers []chan struct{}
+}
+
+// wireEvent is a session update or, with StopReason set, the response to a
+// prompt.
+type wireEvent struct {
+	SessionID  acp.SessionId
+	Kind       string // the update's sessionUpdate discriminator
+	ToolCallID string
+	StopReason acp.StopReason
+}
+
+func (w *wireRecorder) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	if n > 0 {
+		w.record(p[:n])
+	}
+	return n, err
+}
+
+func (w *wireRecorder) record(data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, data...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return
+		}
+		line := w.partial[:i]
+		w.partial = w.partial[i+1:]
+		if ev, ok := parseWireEvent(line); ok {
+			if w.verbose {
+				fmt.Fprintf(os.Stderr, "   ← %s\n", ev)
+			}
+			w.events = append(w.events, ev)
+			for _, ch := range w.watchers {
+				close(ch)
+			}
+			w.watchers = nil
+		}
+	}
+}
+
+func parseWireEvent(line []byte) (wireEvent, bool) {
+	var msg struct {
+		Method string `json:"method"`
+		Params struct {
+			SessionID acp.SessionId `json:"sessionId"`
+			Update    struct {
+				SessionUpdate string `json:"sessionUpdate"`
+				ToolCallID    string `json:"toolCallId"`
+			} `json:"update"`
+		} `json:"params"`
+		Result struct {
+			StopReason acp.StopReason `json:"stopReason"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(line, &msg) != nil {
+		return wireEvent{}, false
+	}
+	switch {
+	case msg.Method == acp.ClientMethodSessionUpdate:
+		u := msg.Params.Update
+		return wireEvent{SessionID: msg.Params.SessionID, Kind: u.SessionUpdate, ToolCallID: u.ToolCallID}, true
+	case msg.Method == "" && msg.Result.StopReason != "":
+		return wireEvent{StopReason: msg.Result.StopReason}, true
+	}
+	return wireEvent{}, false
+}
+
+func (e wireEvent) String() string {
+	switch {
+	case e.StopReason != "":
+		return "prompt response: " + string(e.StopReason)
+	case e.ToolCallID != "":
+		return fmt.Sprintf("%s %s", e.Kind, e.ToolCallID)
+	}
+	return e.Kind
+}
+
+// mark returns the number of events recorded so far, for since.
+func (w *wireRecorder) mark() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.events)
+}
+
+// since returns the events recorded after mark.
+func (w *wireRecorder) since(mark int) []wireEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]wireEvent(nil), w.events[mark:]...)
+}
+
+// next returns a channel closed when the next event is recorded.
+func (w *wireRecorder) next() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan struct{})
+	w.watchers = append(w.watchers, ch)
+	return ch
+}
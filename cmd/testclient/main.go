@@ -3,15 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
 
 	acp "github.com/coder/acp-go-sdk"
 )
 
-type testClient struct{}
+type testClient struct {
+	mu          sync.Mutex
+	agentText   strings.Builder
+	toolTitles  []string
+	permissions []string // queued answers: option kinds or "cancel"
+}
 
 var _ acp.Client = (*testClient)(nil)
 
@@ -21,6 +29,31 @@ func (c *testClient) RequestPermission(_ context.Context, params acp.RequestPerm
 		title = *params.ToolCall.Title
 	}
 	fmt.Fprintf(os.Stderr, "🔐 Permission requested: %s\n", title)
+
+	c.mu.Lock()
+	var answer string
+	if len(c.permissions) > 0 {
+		answer = c.permissions[0]
+		c.permissions = c.permissions[1:]
+	}
+	c.mu.Unlock()
+	if answer == "cancel" {
+		return acp.RequestPermissionResponse{
+			Outcome: acp.RequestPermissionOutcome{Cancelled: &acp.RequestPermissionOutcomeCancelled{}},
+		}, nil
+	}
+	if answer != "" {
+		for _, opt := range params.Options {
+			if string(opt.Kind) == answer {
+				return acp.RequestPermissionResponse{
+					Outcome: acp.RequestPermissionOutcome{
+						Selected: &acp.RequestPermissionOutcomeSelected{OptionId: opt.OptionId},
+					},
+				}, nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  No %q option offered, using the first option\n", answer)
+	}
 	// Auto-allow for testing
 	if len(params.Options) > 0 {
 		return acp.RequestPermissionResponse{
@@ -41,6 +74,9 @@ func (c *testClient) SessionUpdate(_ context.Context, params acp.SessionNotifica
 		cb := u.AgentMessageChunk.Content
 		if cb.Text != nil {
 			fmt.Print(cb.Text.Text)
+			c.mu.Lock()
+			c.agentText.WriteString(cb.Text.Text)
+			c.mu.Unlock()
 		}
 	case u.AgentThoughtChunk != nil:
 		cb := u.AgentThoughtChunk.Content
@@ -49,18 +85,52 @@ func (c *testClient) SessionUpdate(_ context.Context, params acp.SessionNotifica
 		}
 	case u.ToolCall != nil:
 		fmt.Fprintf(os.Stderr, "\n🔧 %s [%s]\n", u.ToolCall.Title, u.ToolCall.Status)
+		c.mu.Lock()
+		c.toolTitles = append(c.toolTitles, u.ToolCall.Title)
+		c.mu.Unlock()
+		renderToolContent(u.ToolCall.Content)
 	case u.ToolCallUpdate != nil:
 		status := ""
 		if u.ToolCallUpdate.Status != nil {
 			status = string(*u.ToolCallUpdate.Status)
 		}
 		fmt.Fprintf(os.Stderr, "🔧 Tool %s → %s\n", u.ToolCallUpdate.ToolCallId, status)
+		renderToolContent(u.ToolCallUpdate.Content)
 	case u.Plan != nil:
 		fmt.Fprintf(os.Stderr, "📋 Plan updated (%d entries)\n", len(u.Plan.Entries))
+		for _, e := range u.Plan.Entries {
+			fmt.Fprintf(os.Stderr, "   [%s] %s\n", e.Status, e.Content)
+		}
 	}
 	return nil
 }
 
+// renderToolContent prints tool call content, showing diffs line by line.
+func renderToolContent(content []acp.ToolCallContent) {
+	for _, c := range content {
+		switch {
+		case c.Diff != nil:
+			fmt.Fprintf(os.Stderr, "   📝 %s\n", c.Diff.Path)
+			if c.Diff.OldText != nil {
+				for _, line := range strings.Split(*c.Diff.OldText, "\n") {
+					fmt.Fprintf(os.Stderr, "   \033[31m- %s\033[0m\n", line)
+				}
+			}
+			for _, line := range strings.Split(c.Diff.NewText, "\n") {
+				fmt.Fprintf(os.Stderr, "   \033[32m+ %s\033[0m\n", line)
+			}
+		case c.Content != nil && c.Content.Content.Text != nil:
+			text := c.Content.Content.Text.Text
+			if len(text) > 500 {
+				text = text[:500] + "…"
+			}
+			fmt.Fprintf(os.Stderr, "   %s\n", strings.ReplaceAll(text, "\n", "\n   "))
+		case c.Terminal != nil:
+			fmt.Fprintf(os.Stderr, "   🖥  terminal %s\n", c.Terminal.TerminalId)
+		}
+	}
+}
+
 func (c *testClient) ReadTextFile(_ context.Context, params acp.ReadTextFileRequest) (acp.ReadTextFileResponse, error) {
 	data, err := os.ReadFile(params.Path)
 	if err != nil {
@@ -97,13 +167,28 @@ func (c *testClient) WaitForTerminalExit(_ context.Context, _ acp.WaitForTermina
 }
 
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	agentBin := flag.String("agent", "./claude-code-acp-go", "Path to the agent binary")
+	repl := flag.Bool("repl", false, "Read prompts from stdin in a loop")
+	script := flag.String("script", "", "Run a YAML scenario file and exit non-zero on mismatch")
+	flag.Parse()
 
-	// Build path to our agent binary
-	agentBin := "./claude-code-acp-go"
+	if *script != "" {
+		if err := runScenarioFile(*script, *agentBin); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Scenario failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "✅ Scenario passed\n")
+		return
+	}
 
-	cmd := exec.CommandContext(ctx, agentBin)
+	ctx := context.Background()
+	if !*repl {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, *agentBin)
 	cmd.Stderr = os.Stderr
 	stdin, _ := cmd.StdinPipe()
 	stdout, _ := cmd.StdoutPipe()
@@ -153,6 +238,11 @@ func main() {
 		fmt.Fprintf(os.Stderr, "   Mode: %s\n", sessResp.Modes.CurrentModeId)
 	}
 
+	if *repl {
+		runREPL(ctx, conn, sessResp, os.Stdin)
+		return
+	}
+
 	// Step 3: Send a simple prompt
 	prompt := "What is 2+2? Reply with just the number."
 	fmt.Fprintf(os.Stderr, "→ Sending prompt: %q\n\n", prompt)
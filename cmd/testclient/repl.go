@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+const replHelp = `Commands:
+  /mode [id]   show available modes or switch to mode id
+  /cancel      cancel the running prompt
+  /quit        exit
+Anything else is sent as a prompt.`
+
+// runREPL reads prompts from in until EOF or /quit. Prompts run in the
+// background so /cancel can interrupt a running turn.
+func runREPL(ctx context.Context, conn *acp.ClientSideConnection, sess acp.NewSessionResponse, in io.Reader) {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+
+	var done chan struct{} // non-nil while a prompt is running
+	cancelRunning := func() {
+		if err := conn.Cancel(ctx, acp.CancelNotification{SessionId: sess.SessionId}); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Cancel error: %v\n", err)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, replHelp)
+	fmt.Fprint(os.Stderr, "\n> ")
+	for {
+		select {
+		case <-done:
+			done = nil
+			fmt.Fprint(os.Stderr, "\n> ")
+			continue
+		case line, ok := <-lines:
+			if !ok {
+				if done != nil {
+					<-done
+				}
+				return
+			}
+			switch {
+			case line == "":
+			case line == "/quit" || line == "/exit":
+				if done != nil {
+					cancelRunning()
+					<-done
+				}
+				return
+			case line == "/help":
+				fmt.Fprintln(os.Stderr, replHelp)
+			case line == "/cancel":
+				if done == nil {
+					fmt.Fprintln(os.Stderr, "Nothing to cancel.")
+				} else {
+					cancelRunning()
+				}
+			case line == "/mode" || strings.HasPrefix(line, "/mode "):
+				replSetMode(ctx, conn, &sess, strings.TrimSpace(strings.TrimPrefix(line, "/mode")))
+			case done != nil:
+				fmt.Fprintln(os.Stderr, "⏳ A prompt is already running; use /cancel to stop it.")
+			default:
+				done = make(chan struct{})
+				go replPrompt(ctx, conn, sess.SessionId, line, done)
+				continue
+			}
+			if done == nil {
+				fmt.Fprint(os.Stderr, "\n> ")
+			}
+		}
+	}
+}
+
+// replPrompt sends a single prompt and closes done when the turn ends.
+func replPrompt(ctx context.Context, conn *acp.ClientSideConnection, sessionID acp.SessionId, prompt string, done chan<- struct{}) {
+	defer close(done)
+	resp, err := conn.Prompt(ctx, acp.PromptRequest{
+		SessionId: sessionID,
+		Prompt:    []acp.ContentBlock{acp.TextBlock(prompt)},
+	})
+	if err != nil {
+		b, _ := json.MarshalIndent(err, "", "  ")
+		fmt.Fprintf(os.Stderr, "\n❌ Prompt error: %s\n", string(b))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n✅ Prompt completed (stopReason=%s)\n", resp.StopReason)
+}
+
+// replSetMode lists the available modes, or switches to modeID if given.
+func replSetMode(ctx context.Context, conn *acp.ClientSideConnection, sess *acp.NewSessionResponse, modeID string) {
+	if modeID == "" {
+		if sess.Modes == nil {
+			fmt.Fprintln(os.Stderr, "Agent did not report any modes.")
+			return
+		}
+		for _, m := range sess.Modes.AvailableModes {
+			marker := " "
+			if m.Id == sess.Modes.CurrentModeId {
+				marker = "*"
+			}
+			fmt.Fprintf(os.Stderr, " %s %s (%s)\n", marker, m.Id, m.Name)
+		}
+		return
+	}
+	if _, err := conn.SetSessionMode(ctx, acp.SetSessionModeRequest{
+		SessionId: sess.SessionId,
+		ModeId:    acp.SessionModeId(modeID),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ SetSessionMode error: %v\n", err)
+		return
+	}
+	if sess.Modes != nil {
+		sess.Modes.CurrentModeId = acp.SessionModeId(modeID)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Mode: %s\n", modeID)
+}
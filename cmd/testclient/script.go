@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+	"gopkg.in/yaml.v3"
+)
+
+// scenario is a scripted end-to-end run against an agent binary.
+//
+// Example:
+//
+//	agent: ./claude-code-acp-go
+//	timeout: 2m
+//	files:
+//	  hello.txt: "hello\n"
+//	steps:
+//	  - initialize: true
+//	  - new_session: {}
+//	    expect: {mode: default}
+//	  - set_mode: acceptEdits
+//	  - prompt: "Replace hello with bye in hello.txt"
+//	    permissions: [allow_once]
+//	    expect:
+//	      stop_reason: end_turn
+//	      tool_calls: ["hello.txt"]
+//	      files: {hello.txt: bye}
+type scenario struct {
+	Agent   string            `yaml:"agent"`
+	Args    []string          `yaml:"args"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Cwd     string            `yaml:"cwd"`
+	Files   map[string]string `yaml:"files"`
+	Steps   []scenarioStep    `yaml:"steps"`
+}
+
+// scenarioStep performs exactly one action and then checks expectations.
+type scenarioStep struct {
+	Name        string          `yaml:"name"`
+	Initialize  bool            `yaml:"initialize"`
+	NewSession  *newSessionStep `yaml:"new_session"`
+	SetMode     string          `yaml:"set_mode"`
+	Prompt      string          `yaml:"prompt"`
+	CancelAfter time.Duration   `yaml:"cancel_after"`
+	Permissions []string        `yaml:"permissions"`
+	Expect      scenarioExpect  `yaml:"expect"`
+}
+
+type newSessionStep struct {
+	Meta map[string]any `yaml:"meta"`
+}
+
+// scenarioExpect lists the assertions checked after a step.
+type scenarioExpect struct {
+	StopReason    string            `yaml:"stop_reason"`
+	Error         bool              `yaml:"error"`
+	ErrorContains string            `yaml:"error_contains"`
+	Mode          string            `yaml:"mode"`
+	TextContains  []string          `yaml:"text_contains"`
+	ToolCalls     []string          `yaml:"tool_calls"`
+	Files         map[string]string `yaml:"files"`
+}
+
+// runScenarioFile loads and executes a scenario file.
+func runScenarioFile(path string, agentBin string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sc, err := parseScenario(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if sc.Agent != "" {
+		agentBin = sc.Agent
+		if !filepath.IsAbs(agentBin) && strings.ContainsRune(agentBin, filepath.Separator) {
+			agentBin = filepath.Join(filepath.Dir(path), agentBin)
+		}
+	}
+	return runScenario(sc, agentBin)
+}
+
+// parseScenario decodes a scenario, rejecting keys it does not know so that
+// a misspelled expectation fails instead of silently passing.
+func parseScenario(data []byte) (scenario, error) {
+	var sc scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return scenario{}, err
+	}
+	return sc, nil
+}
+
+func runScenario(sc scenario, agentBin string) error {
+	timeout := sc.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cwd := sc.Cwd
+	if cwd == "" {
+		dir, err := os.MkdirTemp("", "acp-scenario-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		cwd = dir
+	}
+	cwd, _ = filepath.Abs(cwd)
+	for name, content := range sc.Files {
+		p := filepath.Join(cwd, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("write fixture %s: %w", name, err)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, agentBin, sc.Args...)
+	cmd.Stderr = os.Stderr
+	cmd.Dir = cwd
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start agent %s: %w", agentBin, err)
+	}
+	defer func() {
+		stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	client := &testClient{}
+	conn := acp.NewClientSideConnection(client, stdin, stdout)
+
+	var sessionID acp.SessionId
+	for i, step := range sc.Steps {
+		label := step.Name
+		if label == "" {
+			label = fmt.Sprintf("step %d", i+1)
+		}
+		fmt.Fprintf(os.Stderr, "→ %s\n", label)
+		if err := runScenarioStep(ctx, conn, client, cwd, &sessionID, step); err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+	}
+	return nil
+}
+
+func runScenarioStep(ctx context.Context, conn *acp.ClientSideConnection, client *testClient, cwd string, sessionID *acp.SessionId, step scenarioStep) error {
+	client.mu.Lock()
+	client.agentText.Reset()
+	client.toolTitles = nil
+	client.permissions = append([]string(nil), step.Permissions...)
+	client.mu.Unlock()
+
+	var (
+		stepErr    error
+		stopReason acp.StopReason
+		mode       acp.SessionModeId
+	)
+	switch {
+	case step.Initialize:
+		_, stepErr = conn.Initialize(ctx, acp.InitializeRequest{
+			ProtocolVersion: acp.ProtocolVersionNumber,
+			ClientCapabilities: acp.ClientCapabilities{
+				Fs:       acp.FileSystemCapability{ReadTextFile: true, WriteTextFile: true},
+				Terminal: true,
+			},
+		})
+
+	case step.NewSession != nil:
+		req := acp.NewSessionRequest{Cwd: cwd, McpServers: []acp.McpServer{}}
+		if step.NewSession.Meta != nil {
+			req.Meta = step.NewSession.Meta
+		}
+		var resp acp.NewSessionResponse
+		resp, stepErr = conn.NewSession(ctx, req)
+		if stepErr == nil {
+			*sessionID = resp.SessionId
+			if resp.Modes != nil {
+				mode = resp.Modes.CurrentModeId
+			}
+		}
+
+	case step.SetMode != "":
+		_, stepErr = conn.SetSessionMode(ctx, acp.SetSessionModeRequest{
+			SessionId: *sessionID,
+			ModeId:    acp.SessionModeId(step.SetMode),
+		})
+		if stepErr == nil {
+			mode = acp.SessionModeId(step.SetMode)
+		}
+
+	case step.Prompt != "":
+		if step.CancelAfter > 0 {
+			timer := time.AfterFunc(step.CancelAfter, func() {
+				_ = conn.Cancel(ctx, acp.CancelNotification{SessionId: *sessionID})
+			})
+			defer timer.Stop()
+		}
+		var resp acp.PromptResponse
+		resp, stepErr = conn.Prompt(ctx, acp.PromptRequest{
+			SessionId: *sessionID,
+			Prompt:    []acp.ContentBlock{acp.TextBlock(step.Prompt)},
+		})
+		fmt.Fprintln(os.Stderr)
+		if stepErr == nil {
+			stopReason = resp.StopReason
+		}
+
+	default:
+		return fmt.Errorf("step has no action")
+	}
+
+	return checkScenarioExpect(step.Expect, client, cwd, stepErr, stopReason, mode)
+}
+
+// checkScenarioExpect compares the outcome of a step against its expectations.
+func checkScenarioExpect(exp scenarioExpect, client *testClient, cwd string, stepErr error, stopReason acp.StopReason, mode acp.SessionModeId) error {
+	wantErr := exp.Error || exp.ErrorContains != ""
+	if stepErr != nil && !wantErr {
+		return fmt.Errorf("unexpected error: %w", stepErr)
+	}
+	if stepErr == nil && wantErr {
+		return fmt.Errorf("expected an error, got none")
+	}
+	if exp.ErrorContains != "" && !strings.Contains(stepErr.Error(), exp.ErrorContains) {
+		return fmt.Errorf("error %q does not contain %q", stepErr.Error(), exp.ErrorContains)
+	}
+	if exp.StopReason != "" && string(stopReason) != exp.StopReason {
+		return fmt.Errorf("stop reason: got %q, want %q", stopReason, exp.StopReason)
+	}
+	if exp.Mode != "" && string(mode) != exp.Mode {
+		return fmt.Errorf("mode: got %q, want %q", mode, exp.Mode)
+	}
+
+	client.mu.Lock()
+	text := client.agentText.String()
+	titles := append([]string(nil), client.toolTitles...)
+	client.mu.Unlock()
+
+	for _, want := range exp.TextContains {
+		if !strings.Contains(text, want) {
+			return fmt.Errorf("agent text does not contain %q", want)
+		}
+	}
+	for _, want := range exp.ToolCalls {
+		found := false
+		for _, title := range titles {
+			if strings.Contains(title, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no tool call title contains %q (got %q)", want, titles)
+		}
+	}
+	for name, want := range exp.Files {
+		data, err := os.ReadFile(filepath.Join(cwd, name))
+		if err != nil {
+			return fmt.Errorf("expected file %s: %w", name, err)
+		}
+		if !strings.Contains(string(data), want) {
+			return fmt.Errorf("file %s does not contain %q", name, want)
+		}
+	}
+	return nil
+}
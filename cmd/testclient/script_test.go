@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestParseScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		check   func(t *testing.T, sc scenario)
+	}{
+		{
+			name: "full",
+			yaml: `
+agent: ./agent
+timeout: 30s
+files:
+  hello.txt: "hello\n"
+steps:
+  - initialize: true
+  - new_session: {meta: {debug: true}}
+    expect: {mode: default}
+  - name: edit
+    prompt: "Replace hello"
+    permissions: [allow_once, cancel]
+    cancel_after: 2s
+    expect:
+      stop_reason: end_turn
+      tool_calls: ["hello.txt"]
+      files: {hello.txt: bye}
+`,
+			check: func(t *testing.T, sc scenario) {
+				if sc.Agent != "./agent" || sc.Timeout != 30*time.Second || sc.Files["hello.txt"] != "hello\n" {
+					t.Errorf("unexpected scenario: %+v", sc)
+				}
+				if len(sc.Steps) != 3 || !sc.Steps[0].Initialize || sc.Steps[1].NewSession == nil {
+					t.Fatalf("unexpected steps: %+v", sc.Steps)
+				}
+				if sc.Steps[1].NewSession.Meta["debug"] != true || sc.Steps[1].Expect.Mode != "default" {
+					t.Errorf("unexpected new_session step: %+v", sc.Steps[1])
+				}
+				edit := sc.Steps[2]
+				if edit.Name != "edit" || edit.CancelAfter != 2*time.Second || strings.Join(edit.Permissions, ",") != "allow_once,cancel" {
+					t.Errorf("unexpected prompt step: %+v", edit)
+				}
+				if edit.Expect.StopReason != "end_turn" || edit.Expect.ToolCalls[0] != "hello.txt" || edit.Expect.Files["hello.txt"] != "bye" {
+					t.Errorf("unexpected expectations: %+v", edit.Expect)
+				}
+			},
+		},
+		{
+			name:    "unknown top-level key",
+			yaml:    "agent: ./agent\nstep: []\n",
+			wantErr: "field step not found",
+		},
+		{
+			name:    "misspelled expectation",
+			yaml:    "steps:\n  - prompt: hi\n    expect: {stop_reasn: end_turn}\n",
+			wantErr: "field stop_reasn not found",
+		},
+		{
+			name:    "bad duration",
+			yaml:    "timeout: soon\n",
+			wantErr: "cannot unmarshal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := parseScenario([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, sc)
+		})
+	}
+}
+
+// fakeAgent answers every prompt with a fixed reply and end_turn.
+type fakeAgent struct {
+	conn  *acp.AgentSideConnection
+	reply string
+}
+
+func (a *fakeAgent) Authenticate(context.Context, acp.AuthenticateRequest) (acp.AuthenticateResponse, error) {
+	return acp.AuthenticateResponse{}, nil
+}
+
+func (a *fakeAgent) Initialize(context.Context, acp.InitializeRequest) (acp.InitializeResponse, error) {
+	return acp.InitializeResponse{ProtocolVersion: acp.ProtocolVersionNumber}, nil
+}
+
+func (a *fakeAgent) Cancel(context.Context, acp.CancelNotification) error { return nil }
+
+func (a *fakeAgent) NewSession(context.Context, acp.NewSessionRequest) (acp.NewSessionResponse, error) {
+	return acp.NewSessionResponse{
+		SessionId: "s1",
+		Modes:     &acp.SessionModeState{CurrentModeId: "default", AvailableModes: []acp.SessionMode{{Id: "default", Name: "Default"}}},
+	}, nil
+}
+
+func (a *fakeAgent) Prompt(ctx context.Context, params acp.PromptRequest) (acp.PromptResponse, error) {
+	err := a.conn.SessionUpdate(ctx, acp.SessionNotification{
+		SessionId: params.SessionId,
+		Update:    acp.UpdateAgentMessageText(a.reply),
+	})
+	return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, err
+}
+
+func (a *fakeAgent) SetSessionMode(context.Context, acp.SetSessionModeRequest) (acp.SetSessionModeResponse, error) {
+	return acp.SetSessionModeResponse{}, nil
+}
+
+// fakeConn connects a client-side connection to a fakeAgent over pipes.
+func fakeConn(t *testing.T, client *testClient) *acp.ClientSideConnection {
+	t.Helper()
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	agent := &fakeAgent{reply: "hello from the agent"}
+	agent.conn = acp.NewAgentSideConnection(agent, a2cW, c2aR)
+	t.Cleanup(func() {
+		c2aW.Close()
+		a2cW.Close()
+	})
+	return acp.NewClientSideConnection(client, c2aW, a2cR)
+}
+
+func TestRunScenarioStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    scenarioStep
+		wantErr string
+	}{
+		{
+			name: "passing prompt",
+			step: scenarioStep{Prompt: "hi", Expect: scenarioExpect{StopReason: "end_turn"}},
+		},
+		{
+			name: "passing mode",
+			step: scenarioStep{NewSession: &newSessionStep{}, Expect: scenarioExpect{Mode: "default"}},
+		},
+		{
+			name:    "wrong stop reason",
+			step:    scenarioStep{Prompt: "hi", Expect: scenarioExpect{StopReason: "cancelled"}},
+			wantErr: `stop reason: got "end_turn", want "cancelled"`,
+		},
+		{
+			name:    "missing text",
+			step:    scenarioStep{Prompt: "hi", Expect: scenarioExpect{TextContains: []string{"goodbye"}}},
+			wantErr: `agent text does not contain "goodbye"`,
+		},
+		{
+			name:    "expected error",
+			step:    scenarioStep{Prompt: "hi", Expect: scenarioExpect{Error: true}},
+			wantErr: "expected an error, got none",
+		},
+		{
+			name:    "no action",
+			step:    scenarioStep{},
+			wantErr: "step has no action",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			client := &testClient{}
+			conn := fakeConn(t, client)
+			sessionID := acp.SessionId("s1")
+			err := runScenarioStep(ctx, conn, client, t.TempDir(), &sessionID, tt.step)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// Default coalescing parameters for streamed text deltas.
+const (
+	DefaultCoalesceWindow = 25 * time.Millisecond
+	DefaultCoalesceBytes  = 1024
+)
+
+// notificationCoalescer merges consecutive agent message and thought text
+// chunks into a single SessionUpdate. Buffered text is flushed when the
+// window elapses, when it reaches maxBytes, or when any other notification
+// is pushed, so ordering relative to tool calls and plans is preserved.
+type notificationCoalescer struct {
+	send     func(acp.SessionNotification)
+	window   time.Duration
+	maxBytes int
+
+	mu        sync.Mutex
+	sessionID acp.SessionId
+	thought   bool
+	buf       strings.Builder
+	timer     *time.Timer
+}
+
+// newNotificationCoalescer creates a coalescer that delivers through send.
+// A non-positive window disables coalescing and every push is sent directly.
+func newNotificationCoalescer(send func(acp.SessionNotification), window time.Duration, maxBytes int) *notificationCoalescer {
+	return &notificationCoalescer{send: send, window: window, maxBytes: maxBytes}
+}
+
+// Push queues a notification for delivery.
+func (c *notificationCoalescer) Push(n acp.SessionNotification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	text, thought, ok := coalescableText(n)
+	if !ok || c.window <= 0 {
+		c.flushLocked()
+		c.send(n)
+		return
+	}
+
+	if c.buf.Len() > 0 && (thought != c.thought || n.SessionId != c.sessionID) {
+		c.flushLocked()
+	}
+	if c.buf.Len() == 0 {
+		c.sessionID = n.SessionId
+		c.thought = thought
+		c.timer = time.AfterFunc(c.window, c.Flush)
+	}
+	c.buf.WriteString(text)
+	if c.maxBytes > 0 && c.buf.Len() >= c.maxBytes {
+		c.flushLocked()
+	}
+}
+
+// Flush delivers any buffered text immediately.
+func (c *notificationCoalescer) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *notificationCoalescer) flushLocked() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.buf.Len() == 0 {
+		return
+	}
+	text := c.buf.String()
+	c.buf.Reset()
+
+	update := acp.UpdateAgentMessageText(text)
+	if c.thought {
+		update = acp.UpdateAgentThoughtText(text)
+	}
+	c.send(acp.SessionNotification{SessionId: c.sessionID, Update: update})
+}
+
+// coalescableText reports whether n is a plain text agent message or
+// thought chunk, returning its text. Its only meta may be its turn's.
+func coalescableText(n acp.SessionNotification) (text string, thought bool, ok bool) {
+	if _, isTurn := n.Meta.(turnMeta); n.Meta != nil && !isTurn {
+		return "", false, false
+	}
+	u := n.Update
+	switch {
+	case u.AgentMessageChunk != nil:
+		if u.AgentMessageChunk.Content.Text != nil && u.AgentMessageChunk.Meta == nil {
+			return u.AgentMessageChunk.Content.Text.Text, false, true
+		}
+	case u.AgentThoughtChunk != nil:
+		if u.AgentThoughtChunk.Content.Text != nil && u.AgentThoughtChunk.Meta == nil {
+			return u.AgentThoughtChunk.Content.Text.Text, true, true
+		}
+	}
+	return "", false, false
+}
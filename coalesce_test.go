@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+type notificationRecorder struct {
+	mu   sync.Mutex
+	sent []acp.SessionNotification
+}
+
+func (r *notificationRecorder) send(n acp.SessionNotification) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+}
+
+func (r *notificationRecorder) get() []acp.SessionNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]acp.SessionNotification(nil), r.sent...)
+}
+
+func textChunk(text string) acp.SessionNotification {
+	return acp.SessionNotification{SessionId: "s1", Update: acp.UpdateAgentMessageText(text)}
+}
+
+func TestNotificationCoalescer_MergesText(t *testing.T) {
+	rec := &notificationRecorder{}
+	c := newNotificationCoalescer(rec.send, time.Hour, 1024)
+	c.Push(textChunk("Hel"))
+	c.Push(textChunk("lo"))
+	if len(rec.get()) != 0 {
+		t.Fatal("expected text to be buffered")
+	}
+	c.Flush()
+	sent := rec.get()
+	if len(sent) != 1 || sent[0].Update.AgentMessageChunk.Content.Text.Text != "Hello" {
+		t.Fatalf("expected one merged chunk, got %+v", sent)
+	}
+}
+
+func TestNotificationCoalescer_FlushesOnOtherUpdate(t *testing.T) {
+	rec := &notificationRecorder{}
+	c := newNotificationCoalescer(rec.send, time.Hour, 1024)
+	c.Push(textChunk("a"))
+	c.Push(acp.SessionNotification{SessionId: "s1", Update: acp.UpdateAgentThoughtText("hmm")})
+	c.Push(acp.SessionNotification{SessionId: "s1", Update: acp.StartToolCall("t1", "Read")})
+	sent := rec.get()
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 notifications, got %d", len(sent))
+	}
+	if sent[0].Update.AgentMessageChunk == nil || sent[1].Update.AgentThoughtChunk == nil || sent[2].Update.ToolCall == nil {
+		t.Errorf("unexpected order: %+v", sent)
+	}
+}
+
+func TestNotificationCoalescer_ByteLimit(t *testing.T) {
+	rec := &notificationRecorder{}
+	c := newNotificationCoalescer(rec.send, time.Hour, 4)
+	c.Push(textChunk("ab"))
+	c.Push(textChunk("cd"))
+	c.Push(textChunk("e"))
+	sent := rec.get()
+	if len(sent) != 1 || sent[0].Update.AgentMessageChunk.Content.Text.Text != "abcd" {
+		t.Fatalf("expected flush at byte limit, got %+v", sent)
+	}
+}
+
+func TestNotificationCoalescer_WindowElapses(t *testing.T) {
+	rec := &notificationRecorder{}
+	c := newNotificationCoalescer(rec.send, 10*time.Millisecond, 1024)
+	c.Push(textChunk("x"))
+	deadline := time.Now().Add(time.Second)
+	for len(rec.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(rec.get()) != 1 {
+		t.Fatal("expected buffered text to flush after the window")
+	}
+}
+
+func TestNotificationCoalescer_Disabled(t *testing.T) {
+	rec := &notificationRecorder{}
+	c := newNotificationCoalescer(rec.send, 0, 1024)
+	c.Push(textChunk("a"))
+	c.Push(textChunk("b"))
+	if len(rec.get()) != 2 {
+		t.Fatalf("expected pass-through when disabled, got %d", len(rec.get()))
+	}
+}
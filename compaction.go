@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// compactionProgressBytes is how much summary text accumulates between
+// progress updates of a streamed compaction.
+const compactionProgressBytes = 1024
+
+// compactionTracker reports conversation compaction as a tool call. The CLI
+// signals compaction with a "compacting" status message and a
+// compact_boundary message when done; the API may additionally stream the
+// summary as a compaction content block.
+type compactionTracker struct {
+	mu         sync.Mutex
+	toolCallID string // active compaction, empty when idle
+	blockIndex int    // stream index of the compaction block, -1 if none
+	summary    strings.Builder
+	reported   int      // summary length at the last progress update
+	ids        IDSource // generates tool call IDs; nil is random
+}
+
+// handleSystem processes a CLI system message.
+func (c *compactionTracker) handleSystem(raw map[string]any, sessionID string) []acp.SessionNotification {
+	switch raw["subtype"] {
+	case "status":
+		if raw["status"] == "compacting" {
+			return c.start(sessionID)
+		}
+	case "compact_boundary":
+		meta, _ := raw["compact_metadata"].(map[string]any)
+		trigger, _ := meta["trigger"].(string)
+		preTokens, _ := meta["pre_tokens"].(float64)
+		detail := "Conversation compacted"
+		if trigger != "" && preTokens > 0 {
+			detail = fmt.Sprintf("Conversation compacted (%s, %d tokens before)", trigger, int(preTokens))
+		} else if trigger != "" {
+			detail = fmt.Sprintf("Conversation compacted (%s)", trigger)
+		}
+		return append(c.start(sessionID), c.finish(sessionID, detail)...)
+	}
+	return nil
+}
+
+// handleStreamEvent processes the compaction block events of a stream
+// event. Other events produce no notifications.
+func (c *compactionTracker) handleStreamEvent(event map[string]any, sessionID string) []acp.SessionNotification {
+	index, _ := event["index"].(float64)
+	switch event["type"] {
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		if block["type"] != "compaction" {
+			return nil
+		}
+		out := c.start(sessionID)
+		c.mu.Lock()
+		c.blockIndex = int(index)
+		c.mu.Unlock()
+		if text, _ := block["content"].(string); text != "" {
+			out = append(out, c.appendSummary(sessionID, text)...)
+		}
+		return out
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]any)
+		if delta["type"] != "compaction_delta" {
+			return nil
+		}
+		text, _ := delta["content"].(string)
+		return c.appendSummary(sessionID, text)
+	case "content_block_stop":
+		c.mu.Lock()
+		done := c.toolCallID != "" && c.blockIndex == int(index)
+		c.mu.Unlock()
+		if done {
+			return c.finish(sessionID, "Conversation compacted")
+		}
+	}
+	return nil
+}
+
+// handleBlock processes a compaction block from a complete assistant
+// message, used when the CLI does not stream.
+func (c *compactionTracker) handleBlock(block map[string]any, sessionID string) []acp.SessionNotification {
+	out := c.start(sessionID)
+	if text, _ := block["content"].(string); text != "" {
+		out = append(out, c.appendSummary(sessionID, text)...)
+	}
+	return append(out, c.finish(sessionID, "Conversation compacted")...)
+}
+
+// start opens a compaction tool call unless one is already active.
+func (c *compactionTracker) start(sessionID string) []acp.SessionNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.toolCallID != "" {
+		return nil
+	}
+	c.toolCallID = "compaction-" + idsOr(c.ids).NewID()
+	c.blockIndex = -1
+	c.summary.Reset()
+	c.reported = 0
+	update := acp.StartToolCall(acp.ToolCallId(c.toolCallID), "Compacting conversation",
+		acp.WithStartKind(acp.ToolKindOther),
+		acp.WithStartStatus(acp.ToolCallStatusInProgress),
+	)
+	update.ToolCall.Meta = compactionMeta()
+	return []acp.SessionNotification{{SessionId: acp.SessionId(sessionID), Update: update}}
+}
+
+// appendSummary adds streamed summary text, sending a progress update once
+// enough text has arrived since the last one.
+func (c *compactionTracker) appendSummary(sessionID, text string) []acp.SessionNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.toolCallID == "" {
+		return nil
+	}
+	c.summary.WriteString(text)
+	if c.summary.Len()-c.reported < compactionProgressBytes {
+		return nil
+	}
+	c.reported = c.summary.Len()
+	update := acp.UpdateToolCall(acp.ToolCallId(c.toolCallID),
+		acp.WithUpdateContent([]acp.ToolCallContent{acp.ToolContent(acp.TextBlock(c.summary.String()))}),
+	)
+	update.ToolCallUpdate.Meta = compactionMeta()
+	return []acp.SessionNotification{{SessionId: acp.SessionId(sessionID), Update: update}}
+}
+
+// finish completes the active compaction. The summary becomes the tool
+// call content, or detail if no summary was streamed.
+func (c *compactionTracker) finish(sessionID, detail string) []acp.SessionNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.toolCallID == "" {
+		return nil
+	}
+	text := c.summary.String()
+	if text == "" {
+		text = detail
+	}
+	update := acp.UpdateToolCall(acp.ToolCallId(c.toolCallID),
+		acp.WithUpdateTitle("Compacted conversation"),
+		acp.WithUpdateStatus(acp.ToolCallStatusCompleted),
+		acp.WithUpdateContent([]acp.ToolCallContent{acp.ToolContent(acp.TextBlock(text))}),
+	)
+	update.ToolCallUpdate.Meta = compactionMeta()
+	c.toolCallID = ""
+	c.blockIndex = -1
+	c.summary.Reset()
+	return []acp.SessionNotification{{SessionId: acp.SessionId(sessionID), Update: update}}
+}
+
+// interrupt fails a compaction that was still active when the turn ended.
+func (c *compactionTracker) interrupt(sessionID string) []acp.SessionNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.toolCallID == "" {
+		return nil
+	}
+	update := acp.UpdateToolCall(acp.ToolCallId(c.toolCallID), acp.WithUpdateStatus(acp.ToolCallStatusFailed))
+	update.ToolCallUpdate.Meta = compactionMeta()
+	c.toolCallID = ""
+	c.blockIndex = -1
+	c.summary.Reset()
+	return []acp.SessionNotification{{SessionId: acp.SessionId(sessionID), Update: update}}
+}
+
+func compactionMeta() map[string]any {
+	return toolCallMeta("Compact", nil)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestCompactionTracker_CLIStatus(t *testing.T) {
+	var c compactionTracker
+	start := c.handleSystem(map[string]any{"subtype": "status", "status": "compacting"}, "s1")
+	if len(start) != 1 || start[0].Update.ToolCall == nil || start[0].Update.ToolCall.Status != acp.ToolCallStatusInProgress {
+		t.Fatalf("expected in-progress tool call, got %+v", start)
+	}
+	id := start[0].Update.ToolCall.ToolCallId
+
+	done := c.handleSystem(map[string]any{
+		"subtype":          "compact_boundary",
+		"compact_metadata": map[string]any{"trigger": "manual", "pre_tokens": float64(120000)},
+	}, "s1")
+	if len(done) != 1 || done[0].Update.ToolCallUpdate == nil {
+		t.Fatalf("expected completion update, got %+v", done)
+	}
+	upd := done[0].Update.ToolCallUpdate
+	if upd.ToolCallId != id || upd.Status == nil || *upd.Status != acp.ToolCallStatusCompleted {
+		t.Errorf("unexpected completion: %+v", upd)
+	}
+	if text := upd.Content[0].Content.Content.Text.Text; !strings.Contains(text, "manual, 120000 tokens before") {
+		t.Errorf("summary = %q", text)
+	}
+	if n := c.interrupt("s1"); n != nil {
+		t.Errorf("expected no active compaction, got %+v", n)
+	}
+}
+
+func TestCompactionTracker_StreamedSummary(t *testing.T) {
+	var c compactionTracker
+	out := c.handleStreamEvent(map[string]any{
+		"type": "content_block_start", "index": float64(1),
+		"content_block": map[string]any{"type": "compaction"},
+	}, "s1")
+	if len(out) != 1 || out[0].Update.ToolCall == nil {
+		t.Fatalf("expected tool call start, got %+v", out)
+	}
+
+	chunk := strings.Repeat("x", compactionProgressBytes/2)
+	delta := map[string]any{"type": "content_block_delta", "index": float64(1), "delta": map[string]any{"type": "compaction_delta", "content": chunk}}
+	if out := c.handleStreamEvent(delta, "s1"); len(out) != 0 {
+		t.Errorf("expected no progress update yet, got %d", len(out))
+	}
+	if out := c.handleStreamEvent(delta, "s1"); len(out) != 1 {
+		t.Errorf("expected a progress update, got %d", len(out))
+	}
+	if out := c.handleStreamEvent(map[string]any{"type": "content_block_stop", "index": float64(0)}, "s1"); len(out) != 0 {
+		t.Errorf("stop of another block finished compaction")
+	}
+
+	out = c.handleStreamEvent(map[string]any{"type": "content_block_stop", "index": float64(1)}, "s1")
+	if len(out) != 1 || out[0].Update.ToolCallUpdate == nil {
+		t.Fatalf("expected completion, got %+v", out)
+	}
+	if text := out[0].Update.ToolCallUpdate.Content[0].Content.Content.Text.Text; text != chunk+chunk {
+		t.Errorf("summary has %d bytes, want %d", len(text), 2*len(chunk))
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config holds agent process options read from the config file. Every
+// field mirrors a command-line flag; flags given explicitly take precedence.
+//
+// Example config.toml:
+//
+//	transport = "websocket"
+//	port = 9000
+//	log_level = "debug"
+//	executable = "/opt/claude/bin/claude"
+//	max_turns = 100
+//
+//	[websocket]
+//	auth_token = "s3cret"
+type Config struct {
+	Transport        string         `toml:"transport" json:"transport"`
+	Framing          string         `toml:"framing" json:"framing"`
+	Host             string         `toml:"host" json:"host"`
+	Port             int            `toml:"port" json:"port"`
+	Socket           string         `toml:"socket" json:"socket"`
+	LogLevel         string         `toml:"log_level" json:"log_level"`
+	Backend          string         `toml:"backend" json:"backend"`
+	Executable       string         `toml:"executable" json:"executable"`
+	MaxTurns         int            `toml:"max_turns" json:"max_turns"`
+	MaxMessageSize   int            `toml:"max_message_size" json:"max_message_size"`
+	MaxImageBytes    int            `toml:"max_image_bytes" json:"max_image_bytes"`
+	MaxToolOutput    int            `toml:"max_tool_output_bytes" json:"max_tool_output_bytes"`
+	TurnIdleTimeout  configDuration `toml:"turn_idle_timeout" json:"turn_idle_timeout"`
+	PermissionMode   string         `toml:"permission_mode" json:"permission_mode"`
+	AllowBypass      bool           `toml:"dangerously_allow_bypass" json:"dangerously_allow_bypass"`
+	StrictProtocol   string         `toml:"strict_protocol" json:"strict_protocol"`
+	CoalesceWindow   configDuration `toml:"coalesce_window" json:"coalesce_window"`
+	CoalesceBytes    int            `toml:"coalesce_bytes" json:"coalesce_bytes"`
+	UpdateQueueSize  int            `toml:"update_queue_size" json:"update_queue_size"`
+	SettingsFile     string         `toml:"settings" json:"settings"`
+	SuppressThoughts bool           `toml:"suppress_thoughts" json:"suppress_thoughts"`
+	NoLineNumbers    bool           `toml:"no_read_line_numbers" json:"no_read_line_numbers"`
+	MaxTerminals     int            `toml:"max_terminals" json:"max_terminals"`
+	MaxToolCalls     int            `toml:"max_tool_calls_per_minute" json:"max_tool_calls_per_minute"`
+	MaxBashTime      configDuration `toml:"max_bash_time" json:"max_bash_time"`
+	EnvAllow         string         `toml:"env_allow" json:"env_allow"`
+	EnvDeny          string         `toml:"env_deny" json:"env_deny"`
+	HTTPProxy        string         `toml:"http_proxy" json:"http_proxy"`
+	HTTPSProxy       string         `toml:"https_proxy" json:"https_proxy"`
+	NoProxy          string         `toml:"no_proxy" json:"no_proxy"`
+	ACPTools         bool           `toml:"acp_tools" json:"acp_tools"`
+	MaxParallelTools int            `toml:"max_parallel_tools" json:"max_parallel_tools"`
+	WebSocket        struct {
+		AuthToken string `toml:"auth_token" json:"auth_token"`
+	} `toml:"websocket" json:"websocket"`
+
+	hasCoalesceWindow bool // coalesce_window was present, so zero is meaningful
+}
+
+// configDuration is a time.Duration written as a string such as "25ms".
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+// defaultConfigDir returns $XDG_CONFIG_HOME/claude-code-acp, falling back
+// to ~/.config/claude-code-acp.
+func defaultConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "claude-code-acp")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "claude-code-acp")
+}
+
+// loadConfig reads the config file at path. An empty path looks for
+// config.toml, then config.json, in the default config directory; it is
+// not an error for neither to exist. The format follows the file extension.
+func loadConfig(path string) (Config, error) {
+	if path == "" {
+		dir := defaultConfigDir()
+		if dir == "" {
+			return Config{}, nil
+		}
+		for _, name := range []string{"config.toml", "config.json"} {
+			candidate := filepath.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return Config{}, err
+			}
+		}
+		if path == "" {
+			return Config{}, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return Config{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return Config{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+		_, cfg.hasCoalesceWindow = raw["coalesce_window"]
+	default:
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return Config{}, fmt.Errorf("parse %s: unknown key %q", path, undecoded[0].String())
+		}
+		cfg.hasCoalesceWindow = md.IsDefined("coalesce_window")
+	}
+	// A relative settings path is written relative to the config file, not
+	// to wherever the agent happens to be started.
+	if cfg.SettingsFile != "" && !filepath.IsAbs(cfg.SettingsFile) {
+		cfg.SettingsFile = filepath.Join(filepath.Dir(path), cfg.SettingsFile)
+	}
+	return cfg, nil
+}
+
+// flagValues returns the config entries that are set, keyed by flag name.
+func (c Config) flagValues() map[string]string {
+	values := map[string]string{}
+	setString := func(name, v string) {
+		if v != "" {
+			values[name] = v
+		}
+	}
+	setInt := func(name string, v int) {
+		if v != 0 {
+			values[name] = strconv.Itoa(v)
+		}
+	}
+	setBool := func(name string, v bool) {
+		if v {
+			values[name] = "true"
+		}
+	}
+	setString("transport", c.Transport)
+	setString("framing", c.Framing)
+	setString("host", c.Host)
+	setInt("port", c.Port)
+	setString("socket", c.Socket)
+	setString("log-level", c.LogLevel)
+	setString("backend", c.Backend)
+	setString("executable", c.Executable)
+	setInt("max-turns", c.MaxTurns)
+	setInt("max-message-size", c.MaxMessageSize)
+	setInt("max-image-bytes", c.MaxImageBytes)
+	setInt("max-tool-output-bytes", c.MaxToolOutput)
+	if c.TurnIdleTimeout != 0 {
+		values["turn-idle-timeout"] = time.Duration(c.TurnIdleTimeout).String()
+	}
+	setString("permission-mode", c.PermissionMode)
+	setBool("dangerously-allow-bypass", c.AllowBypass)
+	setString("strict-protocol", c.StrictProtocol)
+	if c.hasCoalesceWindow {
+		values["coalesce-window"] = time.Duration(c.CoalesceWindow).String()
+	}
+	setInt("coalesce-bytes", c.CoalesceBytes)
+	setInt("update-queue-size", c.UpdateQueueSize)
+	setString("settings", c.SettingsFile)
+	setBool("suppress-thoughts", c.SuppressThoughts)
+	setBool("no-read-line-numbers", c.NoLineNumbers)
+	setInt("max-terminals", c.MaxTerminals)
+	setInt("max-tool-calls-per-minute", c.MaxToolCalls)
+	if c.MaxBashTime != 0 {
+		values["max-bash-time"] = time.Duration(c.MaxBashTime).String()
+	}
+	setString("env-allow", c.EnvAllow)
+	setString("env-deny", c.EnvDeny)
+	setString("http-proxy", c.HTTPProxy)
+	setString("https-proxy", c.HTTPSProxy)
+	setString("no-proxy", c.NoProxy)
+	setBool("acp-tools", c.ACPTools)
+	setInt("max-parallel-tools", c.MaxParallelTools)
+	setString("ws-token", c.WebSocket.AuthToken)
+	return values
+}
+
+// applyConfig sets every flag in flags that was not given on the command
+// line to its config file value.
+func applyConfig(flags *flag.FlagSet, cfg Config) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range cfg.flagValues() {
+		if explicit[name] || flags.Lookup(name) == nil {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("config %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "config.toml", `
+transport = "websocket"
+port = 9000
+log_level = "debug"
+max_turns = 50
+coalesce_window = "0s"
+max_terminals = 4
+max_bash_time = "10m"
+
+[websocket]
+auth_token = "s3cret"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Transport != "websocket" || cfg.Port != 9000 || cfg.LogLevel != "debug" || cfg.MaxTurns != 50 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.WebSocket.AuthToken != "s3cret" {
+		t.Errorf("auth token = %q", cfg.WebSocket.AuthToken)
+	}
+	if v, ok := cfg.flagValues()["coalesce-window"]; !ok || v != "0s" {
+		t.Errorf("expected explicit zero coalesce window, got %q (%v)", v, ok)
+	}
+	if v := cfg.flagValues(); v["max-terminals"] != "4" || v["max-bash-time"] != "10m0s" {
+		t.Errorf("unexpected tool limits: %v", v)
+	}
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "config.json", `{"host": "0.0.0.0", "coalesce_window": "50ms", "executable": "/opt/claude"}`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "0.0.0.0" || cfg.Executable != "/opt/claude" || time.Duration(cfg.CoalesceWindow) != 50*time.Millisecond {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadConfig_RelativeSettings(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"config.toml": `settings = "claude/settings.json"`,
+		"config.json": `{"settings": "claude/settings.json"}`,
+	} {
+		cfg, err := loadConfig(writeConfigFile(t, dir, name, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, "claude", "settings.json"); cfg.SettingsFile != want {
+			t.Errorf("%s: settings = %q, want %q", name, cfg.SettingsFile, want)
+		}
+	}
+	cfg, err := loadConfig(writeConfigFile(t, dir, "abs.toml", `settings = "/etc/claude/settings.json"`))
+	if err != nil || cfg.SettingsFile != "/etc/claude/settings.json" {
+		t.Errorf("absolute settings = %q, %v", cfg.SettingsFile, err)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := loadConfig(writeConfigFile(t, dir, "bad.toml", `unknown_key = 1`)); err == nil {
+		t.Error("expected error for unknown TOML key")
+	}
+	if _, err := loadConfig(writeConfigFile(t, dir, "unknown.json", `{"unknown_key": 1}`)); err == nil {
+		t.Error("expected error for unknown JSON key")
+	}
+	if _, err := loadConfig(writeConfigFile(t, dir, "bad.json", `{"port": "x"}`)); err == nil {
+		t.Error("expected error for invalid JSON value")
+	}
+	if _, err := loadConfig(filepath.Join(dir, "missing.toml")); err == nil {
+		t.Error("expected error for missing explicit config file")
+	}
+}
+
+func TestLoadConfig_DefaultLocation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	cfg, err := loadConfig("")
+	if err != nil || cfg.Port != 0 {
+		t.Fatalf("expected empty config without a file, got %+v, %v", cfg, err)
+	}
+
+	writeConfigFile(t, dir, "claude-code-acp/config.json", `{"port": 1}`)
+	writeConfigFile(t, dir, "claude-code-acp/config.toml", `port = 2`)
+	cfg, err = loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 2 {
+		t.Errorf("expected config.toml to be preferred, got port %d", cfg.Port)
+	}
+}
+
+func TestApplyConfig_FlagsOverride(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 8080, "")
+	host := fs.String("host", "127.0.0.1", "")
+	window := fs.Duration("coalesce-window", 25*time.Millisecond, "")
+	if err := fs.Parse([]string{"--port", "7000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Port: 9000, Host: "0.0.0.0", MaxTurns: 10}
+	if err := applyConfig(fs, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 7000 {
+		t.Errorf("explicit flag overridden: port = %d", *port)
+	}
+	if *host != "0.0.0.0" {
+		t.Errorf("config not applied: host = %q", *host)
+	}
+	if *window != 25*time.Millisecond {
+		t.Errorf("unset config value applied: window = %v", *window)
+	}
+}
+
+func TestApplyConfig_SuppressThoughts(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	suppress := fs.Bool("suppress-thoughts", false, "")
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(writeConfigFile(t, t.TempDir(), "config.toml", `suppress_thoughts = true`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !*suppress {
+		t.Error("suppress_thoughts not applied")
+	}
+}
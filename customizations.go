@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// customization is a subagent or output style available to a session.
+type customization struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Source      string `json:"source"` // "project"|"user"|"builtin"
+}
+
+// builtinOutputStyles are the output styles that ship with Claude Code.
+var builtinOutputStyles = []customization{
+	{Name: "default", Description: "Claude completes coding tasks efficiently and provides concise responses", Source: "builtin"},
+	{Name: "Explanatory", Description: "Claude explains its implementation choices and codebase patterns", Source: "builtin"},
+	{Name: "Learning", Description: "Claude pauses and asks you to write small pieces of code for hands-on practice", Source: "builtin"},
+}
+
+// discoverAgents lists the custom subagents defined in .claude/agents of the
+// project and of the user config directory. Project agents shadow user
+// agents of the same name.
+func discoverAgents(cwd string) []customization {
+	return mergeCustomizations(
+		readCustomizations(filepath.Join(cwd, ".claude", "agents"), "project"),
+		readCustomizations(filepath.Join(getClaudeConfigDir(), "agents"), "user"),
+	)
+}
+
+// discoverOutputStyles lists the built-in output styles and those defined
+// in .claude/output-styles of the project and the user config directory.
+func discoverOutputStyles(cwd string) []customization {
+	return mergeCustomizations(
+		readCustomizations(filepath.Join(cwd, ".claude", "output-styles"), "project"),
+		readCustomizations(filepath.Join(getClaudeConfigDir(), "output-styles"), "user"),
+		builtinOutputStyles,
+	)
+}
+
+// mergeCustomizations concatenates lists in order of precedence, dropping
+// entries whose name appeared in an earlier list.
+func mergeCustomizations(lists ...[]customization) []customization {
+	seen := make(map[string]bool)
+	var out []customization
+	for _, list := range lists {
+		for _, c := range list {
+			if seen[c.Name] {
+				continue
+			}
+			seen[c.Name] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// readCustomizations reads the markdown definitions in dir, sorted by file
+// name. The name and description come from the YAML frontmatter; the name
+// defaults to the file name without its extension.
+func readCustomizations(dir, source string) []customization {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+	sort.Strings(paths)
+	var out []customization
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var front struct {
+			Name        string `yaml:"name"`
+			Description string `yaml:"description"`
+		}
+		if fm, ok := frontmatter(data); ok {
+			_ = yaml.Unmarshal(fm, &front)
+		}
+		if front.Name == "" {
+			front.Name = strings.TrimSuffix(filepath.Base(path), ".md")
+		}
+		out = append(out, customization{Name: front.Name, Description: front.Description, Source: source})
+	}
+	return out
+}
+
+// frontmatter returns the YAML between the leading "---" lines of a
+// markdown file.
+func frontmatter(data []byte) ([]byte, bool) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	rest, ok := bytes.CutPrefix(data, []byte("---\n"))
+	if !ok {
+		return nil, false
+	}
+	if bytes.HasPrefix(rest, []byte("---\n")) {
+		return nil, true
+	}
+	end := bytes.Index(rest, []byte("\n---"))
+	if end < 0 {
+		return nil, false
+	}
+	return rest[:end], true
+}
+
+// customizationsMeta advertises the session's subagents and output styles,
+// and the ones selected, in the NewSession response _meta.
+func customizationsMeta(cwd, agent string, settings *SettingsManager) map[string]any {
+	outputStyle := settings.GetSettings().OutputStyle
+	meta := map[string]any{
+		"agents":       discoverAgents(cwd),
+		"outputStyles": discoverOutputStyles(cwd),
+	}
+	if agent != "" {
+		meta["agent"] = agent
+	}
+	if outputStyle != "" {
+		meta["outputStyle"] = outputStyle
+	}
+	return map[string]any{"claudeCode": meta}
+}
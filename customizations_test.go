@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverAgents(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", configDir)
+	cwd := t.TempDir()
+
+	writeTestFile(t, filepath.Join(cwd, ".claude", "agents", "reviewer.md"),
+		"---\nname: code-reviewer\ndescription: Reviews diffs\ntools: Read, Grep\n---\nYou review code.\n")
+	writeTestFile(t, filepath.Join(cwd, ".claude", "agents", "plain.md"), "No frontmatter.\n")
+	writeTestFile(t, filepath.Join(configDir, "agents", "reviewer.md"), "---\nname: code-reviewer\ndescription: User copy\n---\n")
+	writeTestFile(t, filepath.Join(configDir, "agents", "writer.md"), "---\r\ndescription: Writes docs\r\n---\r\n")
+
+	agents := discoverAgents(cwd)
+	want := []customization{
+		{Name: "plain", Source: "project"},
+		{Name: "code-reviewer", Description: "Reviews diffs", Source: "project"},
+		{Name: "writer", Description: "Writes docs", Source: "user"},
+	}
+	if len(agents) != len(want) {
+		t.Fatalf("expected %d agents, got %+v", len(want), agents)
+	}
+	for i := range want {
+		if agents[i] != want[i] {
+			t.Errorf("agent %d = %+v, want %+v", i, agents[i], want[i])
+		}
+	}
+}
+
+func TestDiscoverOutputStyles(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	cwd := t.TempDir()
+	writeTestFile(t, filepath.Join(cwd, ".claude", "output-styles", "terse.md"), "---\nname: Terse\ndescription: Short answers\n---\nBe brief.\n")
+
+	styles := discoverOutputStyles(cwd)
+	if len(styles) != len(builtinOutputStyles)+1 {
+		t.Fatalf("unexpected styles: %+v", styles)
+	}
+	if styles[0].Name != "Terse" || styles[0].Source != "project" {
+		t.Errorf("expected project style first, got %+v", styles[0])
+	}
+	if styles[1].Name != "default" || styles[1].Source != "builtin" {
+		t.Errorf("expected built-in styles after custom ones, got %+v", styles[1])
+	}
+}
+
+func TestAddExtraSettings_OutputStyle(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	cwd := t.TempDir()
+	agent := &ClaudeAcpAgent{}
+	mgr := NewSettingsManager(cwd, nil)
+	err := agent.addExtraSettings(mgr, acp.NewSessionRequest{
+		Cwd: cwd,
+		Meta: map[string]any{
+			"settings":    map[string]any{"outputStyle": "Learning"},
+			"outputStyle": "Explanatory",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, ok := mgr.ExtraSettings()
+	if !ok || extra.OutputStyle != "Explanatory" {
+		t.Errorf("expected _meta.outputStyle to win, got %+v", extra)
+	}
+}
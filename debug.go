@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// debugSessionsMethod is the extension request returning the live state of
+// the connection's sessions, for diagnosing stuck sessions. In websocket
+// mode the same state for every connection is served at GET /debug/sessions.
+const debugSessionsMethod = extMethodPrefix + "debug/sessions"
+
+// debugSessionsResult is the answer to _claude/debug/sessions. Updates
+// totals the update queues of the connection's sessions; GET
+// /debug/sessions, which spans connections, leaves it out.
+type debugSessionsResult struct {
+	Sessions []debugSession    `json:"sessions"`
+	Updates  *updateQueueStats `json:"updates,omitempty"`
+}
+
+// debugSession is a session's live state. Busy is set while a turn runs.
+type debugSession struct {
+	SessionID        string           `json:"sessionId"`
+	Cwd              string           `json:"cwd"`
+	PermissionMode   string           `json:"permissionMode"`
+	Busy             bool             `json:"busy"`
+	Turns            int              `json:"turns"`
+	PID              int              `json:"pid,omitempty"`
+	StartedAt        time.Time        `json:"startedAt"`
+	UptimeSeconds    int64            `json:"uptimeSeconds"`
+	PendingToolCalls []debugToolCall  `json:"pendingToolCalls"`
+	Caches           debugCaches      `json:"caches"`
+	Updates          updateQueueStats `json:"updates"`
+	LastError        *debugError      `json:"lastError,omitempty"`
+}
+
+// debugToolCall is a tool call still waiting for its result.
+type debugToolCall struct {
+	ToolCallID string `json:"toolCallId"`
+	Name       string `json:"name"`
+}
+
+type debugCaches struct {
+	ToolUses  int `json:"toolUses"`
+	Files     int `json:"files"`
+	FileBytes int `json:"fileBytes"`
+}
+
+type debugError struct {
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// extDebugSessions returns the state of the connection's sessions.
+func (a *ClaudeAcpAgent) extDebugSessions(_ context.Context, _ json.RawMessage) (any, error) {
+	sessions := a.debugSessions()
+	var stats updateQueueStats
+	for _, s := range sessions {
+		stats.Queued += s.Updates.Queued
+		stats.Written += s.Updates.Written
+		stats.Merged += s.Updates.Merged
+		stats.Dropped += s.Updates.Dropped
+	}
+	return debugSessionsResult{Sessions: sessions, Updates: &stats}, nil
+}
+
+// debugSessions returns the state of the agent's sessions, by ID.
+func (a *ClaudeAcpAgent) debugSessions() []debugSession {
+	a.mu.RLock()
+	ids := make([]string, 0, len(a.sessions))
+	sessions := make(map[string]*Session, len(a.sessions))
+	for id, s := range a.sessions {
+		ids = append(ids, id)
+		sessions[id] = s
+	}
+	a.mu.RUnlock()
+	slices.Sort(ids)
+
+	out := make([]debugSession, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, sessions[id].debugState(id))
+	}
+	return out
+}
+
+// debugState returns the session's live state.
+func (s *Session) debugState(sessionID string) debugSession {
+	d := debugSession{
+		SessionID:        sessionID,
+		Cwd:              s.cwd,
+		PermissionMode:   s.GetPermissionMode(),
+		PendingToolCalls: []debugToolCall{},
+	}
+	if s.turnMu.TryLock() {
+		s.turnMu.Unlock()
+	} else {
+		d.Busy = true
+	}
+	if p, ok := s.proc().(interface{ Pid() int }); ok {
+		d.PID = p.Pid()
+	}
+	if s.toolUseCache != nil {
+		for _, e := range s.toolUseCache.Entries() {
+			d.PendingToolCalls = append(d.PendingToolCalls, debugToolCall{ToolCallID: e.ID, Name: e.Name})
+		}
+		d.Caches.ToolUses = len(d.PendingToolCalls)
+	}
+	d.Caches.Files, d.Caches.FileBytes = s.toolOptions.files.stats()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.Turns = s.turn
+	d.StartedAt = s.created
+	if s.updates != nil {
+		d.Updates = s.updates.snapshot()
+	}
+	if !s.created.IsZero() {
+		d.UptimeSeconds = int64(time.Since(s.created) / time.Second)
+	}
+	if s.lastError != "" {
+		d.LastError = &debugError{Message: s.lastError, At: s.lastErrorAt}
+	}
+	return d
+}
+
+// isLoopbackRequest reports whether r came from a loopback address.
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// recordError remembers the session's latest error for debugging.
+func (s *Session) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorAt = time.Now()
+}
+
+// debugSessionsHandler serves GET /debug/sessions in websocket mode: the
+// state of the sessions of every connection. Without a token it answers
+// only requests from the local machine.
+func debugSessionsHandler(hub *sessionHub, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !wsAuthorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if token == "" && !isLoopbackRequest(r) {
+			http.Error(w, "forbidden: set a token to allow remote access", http.StatusForbidden)
+			return
+		}
+		sessions := []debugSession{}
+		for _, owner := range hub.owners() {
+			sessions = append(sessions, owner.debugSessions()...)
+		}
+		slices.SortFunc(sessions, func(a, b debugSession) int { return strings.Compare(a.SessionID, b.SessionID) })
+		writeJSON(w, http.StatusOK, debugSessionsResult{Sessions: sessions})
+	}
+}
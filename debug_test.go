@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtDebugSessions(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	session := &Session{cwd: "/work", permissionMode: "plan", created: time.Now().Add(-time.Minute), toolUseCache: NewToolUseCache(0)}
+	session.toolUseCache.Put(ToolUseEntry{Type: "tool_use", ID: "toolu_1", Name: "Bash"})
+	session.toolOptions.files = newFileCache()
+	session.toolOptions.files.put("/work/a.go", "package a\n")
+	session.beginTurn()
+	session.recordError(errors.New("CLI exited"))
+	agent.sessions["s2"] = session
+	agent.sessions["s1"] = &Session{cwd: "/other"}
+	session.turnMu.Lock()
+	defer session.turnMu.Unlock()
+
+	res, err := agent.extMethods[debugSessionsMethod](context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := res.(debugSessionsResult).Sessions
+	if len(sessions) != 2 || sessions[0].SessionID != "s1" || sessions[0].Busy {
+		t.Fatalf("got %+v", sessions)
+	}
+	got := sessions[1]
+	if !got.Busy || got.PermissionMode != "plan" || got.Turns != 1 || got.UptimeSeconds < 60 {
+		t.Errorf("state: got %+v", got)
+	}
+	if len(got.PendingToolCalls) != 1 || got.PendingToolCalls[0] != (debugToolCall{ToolCallID: "toolu_1", Name: "Bash"}) {
+		t.Errorf("pending tool calls: got %+v", got.PendingToolCalls)
+	}
+	if got.Caches != (debugCaches{ToolUses: 1, Files: 1, FileBytes: 10}) {
+		t.Errorf("caches: got %+v", got.Caches)
+	}
+	if got.LastError == nil || got.LastError.Message != "CLI exited" {
+		t.Errorf("last error: got %+v", got.LastError)
+	}
+}
+
+func TestDebugSessionsHandler(t *testing.T) {
+	hub := newSessionHub()
+	for _, id := range []string{"b", "a"} {
+		agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+		agent.sessions[id] = &Session{cwd: "/" + id}
+		hub.add(id, agent)
+	}
+	srv := httptest.NewServer(debugSessionsHandler(hub, "secret"))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res debugSessionsResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sessions) != 2 || res.Sessions[0].SessionID != "a" || res.Sessions[1].Cwd != "/b" {
+		t.Errorf("got %+v", res.Sessions)
+	}
+
+	// Without a token only local requests are answered.
+	for addr, want := range map[string]int{"127.0.0.1:4000": http.StatusOK, "[::1]:4000": http.StatusOK, "203.0.113.5:4000": http.StatusForbidden} {
+		req := httptest.NewRequest("GET", "/debug/sessions", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		debugSessionsHandler(hub, "")(rec, req)
+		if rec.Code != want {
+			t.Errorf("from %s: got %d, want %d", addr, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// maxPendingDiagnostics bounds the diagnostics attached to one prompt.
+const maxPendingDiagnostics = 200
+
+// diagnosticsFollowUpPrompt is sent for a followUp push when no prompt is
+// running.
+const diagnosticsFollowUpPrompt = "Fix the problems reported in the workspace diagnostics."
+
+// diagnosticsFollowUpMethod is the extension notification sent when a
+// turn started by a followUp push ends, since no client request awaits it.
+const diagnosticsFollowUpMethod = extMethodPrefix + "workspace/diagnostics_follow_up"
+
+// diagnosticsFollowUpParams is the payload of
+// _claude/workspace/diagnostics_follow_up: the turn's stop reason, or the
+// error that ended it.
+type diagnosticsFollowUpParams struct {
+	SessionID  string `json:"sessionId"`
+	StopReason string `json:"stopReason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// workspaceDiagnosticsParams is the payload of the
+// _claude/workspace/diagnostics notification, pushed by clients after a
+// build, lint or test run. The diagnostics replace those pending for the
+// files they name; uris lists files whose pending diagnostics are cleared,
+// such as files that now build cleanly. With followUp set, an idle session
+// starts a turn to address them right away.
+type workspaceDiagnosticsParams struct {
+	SessionID   string       `json:"sessionId"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+	URIs        []string     `json:"uris,omitempty"`
+	FollowUp    bool         `json:"followUp,omitempty"`
+}
+
+// diagnostic is a single finding, shaped like an LSP diagnostic with the
+// file it belongs to. Lines and characters are zero-based.
+type diagnostic struct {
+	URI      string           `json:"uri"`
+	Range    *diagnosticRange `json:"range,omitempty"`
+	Severity string           `json:"severity,omitempty"` // error|warning|info|hint
+	Message  string           `json:"message"`
+	Source   string           `json:"source,omitempty"` // e.g. "go vet", "eslint"
+	Code     string           `json:"code,omitempty"`
+}
+
+type diagnosticRange struct {
+	Start struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	} `json:"start"`
+}
+
+// pendingDiagnostics collects pushed diagnostics until the next prompt.
+type pendingDiagnostics struct {
+	mu     sync.Mutex
+	byPath map[string][]diagnostic
+	order  []string
+}
+
+// update replaces the pending diagnostics of the files in diags, and of
+// cleared, which may name files without diagnostics.
+func (p *pendingDiagnostics) update(diags []diagnostic, cleared []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byPath == nil {
+		p.byPath = map[string][]diagnostic{}
+	}
+	var paths []string
+	fresh := map[string][]diagnostic{}
+	for _, uri := range cleared {
+		path := fileURIPath(uri)
+		if _, ok := fresh[path]; !ok {
+			paths = append(paths, path)
+		}
+		fresh[path] = nil
+	}
+	for _, d := range diags {
+		path := fileURIPath(d.URI)
+		if _, ok := fresh[path]; !ok {
+			paths = append(paths, path)
+		}
+		fresh[path] = append(fresh[path], d)
+	}
+	for _, path := range paths {
+		ds := fresh[path]
+		if len(ds) == 0 {
+			delete(p.byPath, path)
+			p.order = slices.DeleteFunc(p.order, func(s string) bool { return s == path })
+			continue
+		}
+		if _, ok := p.byPath[path]; !ok {
+			p.order = append(p.order, path)
+		}
+		p.byPath[path] = ds
+	}
+}
+
+// take returns the pending diagnostics as a prompt context block and
+// forgets them. ok is false if there are none.
+func (p *pendingDiagnostics) take(cwd string) (block acp.ContentBlock, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.order) == 0 {
+		return acp.ContentBlock{}, false
+	}
+	var sb strings.Builder
+	sb.WriteString("<workspace-diagnostics>\n")
+	n := 0
+	for _, path := range p.order {
+		for _, d := range p.byPath[path] {
+			if n == maxPendingDiagnostics {
+				break
+			}
+			n++
+			sb.WriteString(formatDiagnostic(path, cwd, d) + "\n")
+		}
+	}
+	if total := p.countLocked(); total > n {
+		fmt.Fprintf(&sb, "(%d more omitted)\n", total-n)
+	}
+	sb.WriteString("</workspace-diagnostics>")
+	p.byPath, p.order = nil, nil
+	return acp.TextBlock(sb.String()), true
+}
+
+func (p *pendingDiagnostics) countLocked() int {
+	total := 0
+	for _, ds := range p.byPath {
+		total += len(ds)
+	}
+	return total
+}
+
+// formatDiagnostic renders d in the file:line:col: severity: message form
+// compilers use, with the path relative to cwd when inside it.
+func formatDiagnostic(path, cwd string, d diagnostic) string {
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	loc := path
+	if d.Range != nil {
+		loc = fmt.Sprintf("%s:%d:%d", path, d.Range.Start.Line+1, d.Range.Start.Character+1)
+	}
+	severity := d.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	line := fmt.Sprintf("%s: %s: %s", loc, severity, d.Message)
+	switch {
+	case d.Source != "" && d.Code != "":
+		line += fmt.Sprintf(" [%s %s]", d.Source, d.Code)
+	case d.Source != "":
+		line += " [" + d.Source + "]"
+	case d.Code != "":
+		line += " [" + d.Code + "]"
+	}
+	return line
+}
+
+// extWorkspaceDiagnostics queues pushed diagnostics for the session's next
+// prompt and, for followUp pushes to an idle session, starts that prompt.
+func (a *ClaudeAcpAgent) extWorkspaceDiagnostics(ctx context.Context, params json.RawMessage) (any, error) {
+	var p workspaceDiagnosticsParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.diagnostics.update(p.Diagnostics, p.URIs)
+	if !p.FollowUp || len(p.Diagnostics) == 0 {
+		return nil, nil
+	}
+	// A running turn picks the diagnostics up in the next prompt instead.
+	// Otherwise the follow-up turn keeps the lock taken here, so no prompt
+	// can start in between.
+	if !session.turnMu.TryLock() {
+		return nil, nil
+	}
+	go func() {
+		defer session.turnMu.Unlock()
+		resp, err := a.runTurn(context.WithoutCancel(ctx), session, acp.PromptRequest{
+			SessionId: acp.SessionId(p.SessionID),
+			Prompt:    []acp.ContentBlock{acp.TextBlock(diagnosticsFollowUpPrompt)},
+		})
+		result := diagnosticsFollowUpParams{SessionID: p.SessionID, StopReason: string(resp.StopReason)}
+		if err != nil {
+			session.log().Warn("Diagnostics follow-up failed", "error", err)
+			result = diagnosticsFollowUpParams{SessionID: p.SessionID, Error: err.Error()}
+		}
+		if err := a.sendExtNotification(diagnosticsFollowUpMethod, result); err != nil {
+			session.log().Warn("Failed to send diagnostics follow-up result", "error", err)
+		}
+	}()
+	return nil, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestExtWorkspaceDiagnostics(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	session := &Session{cwd: "/w"}
+	agent.sessions["s1"] = session
+	push := func(params string) {
+		t.Helper()
+		if _, err := agent.extWorkspaceDiagnostics(context.Background(), json.RawMessage(params)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	push(`{"sessionId":"s1","diagnostics":[
+		{"uri":"file:///w/a.go","range":{"start":{"line":4,"character":1}},"message":"undefined: x","source":"go vet"},
+		{"uri":"file:///w/b.go","severity":"warning","message":"unused variable","code":"SA4006"}]}`)
+	// A later push replaces a.go's diagnostics and clears b.go's.
+	push(`{"sessionId":"s1","diagnostics":[{"uri":"file:///w/a.go","message":"missing return"}],"uris":["file:///w/b.go"]}`)
+	push(`{"sessionId":"s1","diagnostics":[{"uri":"/elsewhere/c.go","severity":"info","message":"note"}]}`)
+
+	block, ok := session.diagnostics.take(session.cwd)
+	if !ok {
+		t.Fatal("no diagnostics pending")
+	}
+	want := "<workspace-diagnostics>\na.go: error: missing return\n/elsewhere/c.go: info: note\n</workspace-diagnostics>"
+	if block.Text == nil || block.Text.Text != want {
+		t.Errorf("got %+v, want %q", block.Text, want)
+	}
+	if _, ok := session.diagnostics.take(session.cwd); ok {
+		t.Error("diagnostics not cleared after take")
+	}
+
+	if _, err := agent.extWorkspaceDiagnostics(context.Background(), json.RawMessage(`{"sessionId":"nope"}`)); err == nil {
+		t.Error("expected an error for an unknown session")
+	}
+}
+
+func TestFormatDiagnostic(t *testing.T) {
+	d := diagnostic{Range: &diagnosticRange{}, Message: "undefined: x", Source: "go vet", Code: "E1"}
+	d.Range.Start.Line, d.Range.Start.Character = 4, 1
+	if got, want := formatDiagnostic("/w/a.go", "/w", d), "a.go:5:2: error: undefined: x [go vet E1]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
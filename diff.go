@@ -0,0 +1,202 @@
+package main
+
+// diffOp is a single line-level edit produced by the diff algorithm.
+// op is ' ' for context, '-' for deletion, and '+' for insertion.
+// oldN and newN are the 0-based positions in the old and new line slices
+// at which the operation applies.
+type diffOp struct {
+	op   byte
+	line string
+	oldN int
+	newN int
+}
+
+// myersDiff computes a minimal line diff between oldLines and newLines using
+// Myers' O(ND) algorithm with the linear-space "middle snake" refinement.
+// Memory use is O(N+M) regardless of how large the files are, unlike a full
+// LCS table. Deletions are emitted before insertions within a changed region.
+func myersDiff(oldLines, newLines []string) []diffOp {
+	// Intern lines so the inner loops compare ints instead of strings.
+	ids := make(map[string]int, len(oldLines)+len(newLines))
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	d := &myersDiffer{
+		a:        intern(oldLines),
+		b:        intern(newLines),
+		changedA: make([]bool, len(oldLines)),
+		changedB: make([]bool, len(newLines)),
+	}
+	d.compare(0, len(d.a), 0, len(d.b))
+
+	ops := make([]diffOp, 0, len(oldLines)+len(newLines))
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && d.changedA[i]:
+			ops = append(ops, diffOp{'-', oldLines[i], i, j})
+			i++
+		case j < len(newLines) && d.changedB[j]:
+			ops = append(ops, diffOp{'+', newLines[j], i, j})
+			j++
+		default:
+			ops = append(ops, diffOp{' ', oldLines[i], i, j})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// myersDiffer holds the state of a single diff computation.
+type myersDiffer struct {
+	a, b               []int
+	changedA, changedB []bool
+}
+
+// compare marks the changed lines between a[aLo:aHi] and b[bLo:bHi].
+func (d *myersDiffer) compare(aLo, aHi, bLo, bHi int) {
+	// Strip common prefix and suffix; they never contribute to the diff.
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.changedB[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.changedA[i] = true
+		}
+	default:
+		x, y, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok {
+			// No common lines at all: everything is replaced.
+			for i := aLo; i < aHi; i++ {
+				d.changedA[i] = true
+			}
+			for j := bLo; j < bHi; j++ {
+				d.changedB[j] = true
+			}
+			return
+		}
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// middleSnake runs the forward and reverse searches simultaneously until the
+// paths overlap and returns the absolute split point. ok is false when the
+// ranges share no lines or no split point makes progress.
+func (d *myersDiffer) middleSnake(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n := aHi - aLo
+	m := bHi - bLo
+	maxD := (n + m + 1) / 2
+	vOffset := maxD + 1
+	vLength := 2*maxD + 3
+	v1 := make([]int, vLength)
+	v2 := make([]int, vLength)
+	for i := range v1 {
+		v1[i] = -1
+		v2[i] = -1
+	}
+	v1[vOffset+1] = 0
+	v2[vOffset+1] = 0
+
+	delta := n - m
+	// If the total number of lines is odd, the front path collides with the
+	// reverse path; otherwise the reverse path collides with the front.
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	split := func(x1, y1 int) (int, int, bool) {
+		if (x1 == 0 && y1 == 0) || (x1 == n && y1 == m) {
+			return 0, 0, false
+		}
+		return aLo + x1, bLo + y1, true
+	}
+
+	for step := 0; step < maxD; step++ {
+		// Walk the front path one step.
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1Offset := vOffset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[aLo+x1] == d.b[bLo+y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+			if x1 > n {
+				// Ran off the right of the graph.
+				k1end += 2
+			} else if y1 > m {
+				// Ran off the bottom of the graph.
+				k1start += 2
+			} else if front {
+				k2Offset := vOffset + delta - k1
+				if k2Offset >= 0 && k2Offset < vLength && v2[k2Offset] != -1 {
+					// Mirror x2 onto the top-left coordinate system.
+					x2 := n - v2[k2Offset]
+					if x1 >= x2 {
+						return split(x1, y1)
+					}
+				}
+			}
+		}
+
+		// Walk the reverse path one step.
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2Offset := vOffset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[aHi-x2-1] == d.b[bHi-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+			if x2 > n {
+				k2end += 2
+			} else if y2 > m {
+				k2start += 2
+			} else if !front {
+				k1Offset := vOffset + delta - k2
+				if k1Offset >= 0 && k1Offset < vLength && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := x1 - (k1Offset - vOffset)
+					if x1 >= n-x2 {
+						return split(x1, y1)
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minCLIVersion is the oldest claude CLI release known to support the
+// stream-json options this agent relies on.
+const minCLIVersion = "2.0.0"
+
+type doctorStatus string
+
+const (
+	doctorOK   doctorStatus = "ok"
+	doctorWarn doctorStatus = "warn"
+	doctorFail doctorStatus = "FAIL"
+)
+
+// doctorResult is the outcome of one diagnostic check.
+type doctorResult struct {
+	Name   string
+	Status doctorStatus
+	Detail string
+}
+
+// runDoctor implements the "doctor" subcommand. It prints a report to out
+// and returns the process exit code: 1 if any check failed.
+func runDoctor(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configPath := flags.String("config", "", "Config file (default ~/.config/claude-code-acp/config.{toml,json})")
+	executable := flags.String("executable", "", "Path to the claude CLI")
+	cwd := flags.String("cwd", ".", "Project directory whose settings are checked")
+	skipRoundTrip := flags.Bool("skip-roundtrip", false, "Do not send a test prompt to the CLI")
+	timeout := flags.Duration("timeout", 90*time.Second, "Timeout for the test prompt")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var results []doctorResult
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		results = append(results, doctorResult{"config file", doctorFail, err.Error()})
+	} else {
+		results = append(results, doctorResult{"config file", doctorOK, "valid or absent"})
+	}
+	exe := *executable
+	if exe == "" {
+		exe = cfg.Executable
+	}
+	if exe == "" {
+		exe = os.Getenv("CLAUDE_CODE_EXECUTABLE")
+	}
+	if exe == "" {
+		exe = "claude"
+	}
+	dir, _ := filepath.Abs(*cwd)
+
+	cliPath, cliResult := checkCLI(exe)
+	results = append(results, cliResult)
+	results = append(results, checkAuth())
+	results = append(results, checkSettingsFiles(dir)...)
+	switch {
+	case *skipRoundTrip:
+		results = append(results, doctorResult{"round-trip", doctorWarn, "skipped"})
+	case cliPath == "":
+		results = append(results, doctorResult{"round-trip", doctorFail, "skipped: claude CLI not found"})
+	default:
+		results = append(results, checkRoundTrip(cliPath, *timeout))
+	}
+
+	failed := false
+	for _, r := range results {
+		fmt.Fprintf(out, "[%-4s] %-24s %s\n", r.Status, r.Name, r.Detail)
+		failed = failed || r.Status == doctorFail
+	}
+	if failed {
+		fmt.Fprintln(out, "\nSome checks failed.")
+		return 1
+	}
+	fmt.Fprintln(out, "\nAll checks passed.")
+	return 0
+}
+
+// checkCLI locates the claude CLI and checks its version. It returns the
+// resolved path, or "" if the CLI could not be run.
+func checkCLI(exe string) (string, doctorResult) {
+	path, err := exec.LookPath(exe)
+	if err != nil {
+		return "", doctorResult{"claude CLI", doctorFail, fmt.Sprintf("%s not found on PATH; %s", exe, cliInstallHint)}
+	}
+	version, err := cliVersion(path)
+	if err != nil {
+		return "", doctorResult{"claude CLI", doctorFail, fmt.Sprintf("%s --version failed: %v", path, err)}
+	}
+	if cmp, ok := compareVersions(version, minCLIVersion); !ok {
+		return path, doctorResult{"claude CLI", doctorWarn, fmt.Sprintf("%s: unrecognized version %q", path, version)}
+	} else if cmp < 0 {
+		return path, doctorResult{"claude CLI", doctorWarn, fmt.Sprintf("%s: version %s is older than %s; %s", path, version, minCLIVersion, cliUpgradeHint)}
+	}
+	return path, doctorResult{"claude CLI", doctorOK, fmt.Sprintf("%s (%s)", path, version)}
+}
+
+var versionRegexp = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// compareVersions compares the first x.y.z version found in a with b,
+// returning -1, 0 or 1. ok is false if a contains no version.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	ma := versionRegexp.FindStringSubmatch(a)
+	mb := versionRegexp.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return 0, false
+	}
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(ma[i])
+		y, _ := strconv.Atoi(mb[i])
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// checkAuth looks for credentials the CLI can use without prompting.
+func checkAuth() doctorResult {
+	if backupExistsWithoutPrimary() {
+		return doctorResult{"authentication", doctorFail, "~/.claude.json is missing but a backup exists; run `claude /login`"}
+	}
+	for _, env := range []string{"ANTHROPIC_API_KEY", "ANTHROPIC_AUTH_TOKEN", "CLAUDE_CODE_OAUTH_TOKEN"} {
+		if os.Getenv(env) != "" {
+			return doctorResult{"authentication", doctorOK, env + " is set"}
+		}
+	}
+	for _, env := range []string{"CLAUDE_CODE_USE_BEDROCK", "CLAUDE_CODE_USE_VERTEX"} {
+		if os.Getenv(env) != "" {
+			return doctorResult{"authentication", doctorOK, env + " is set; cloud provider credentials are not checked"}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(getClaudeConfigDir(), ".credentials.json")); err == nil {
+		return doctorResult{"authentication", doctorOK, "stored Claude login found"}
+	}
+	return doctorResult{"authentication", doctorWarn, "no API key or stored login found (the system keychain is not checked); run `claude /login` if prompts fail"}
+}
+
+// checkSettingsFiles parses every settings file that applies to cwd and
+// reports errors that session startup silently ignores.
+func checkSettingsFiles(cwd string) []doctorResult {
+	mgr := NewSettingsManager(cwd, nil)
+	files := []struct{ name, path string }{
+		{"user settings", mgr.getUserSettingsPath()},
+		{"project settings", mgr.getProjectSettingsPath()},
+		{"local settings", mgr.getLocalSettingsPath()},
+		{"managed settings", getManagedSettingsPath()},
+	}
+	var results []doctorResult
+	for _, f := range files {
+		_, err := readSettingsFile(f.path)
+		switch {
+		case err == nil:
+			results = append(results, doctorResult{f.name, doctorOK, f.path})
+		case errors.Is(err, fs.ErrNotExist):
+			results = append(results, doctorResult{f.name, doctorOK, f.path + " (not present)"})
+		default:
+			results = append(results, doctorResult{f.name, doctorFail, err.Error()})
+		}
+	}
+	return results
+}
+
+// checkRoundTrip sends a trivial prompt through the CLI in stream-json mode
+// and waits for a successful result.
+func checkRoundTrip(executable string, timeout time.Duration) doctorResult {
+	dir, err := os.MkdirTemp("", "acp-doctor-*")
+	if err != nil {
+		return doctorResult{"round-trip", doctorFail, err.Error()}
+	}
+	defer os.RemoveAll(dir)
+
+	sessionID := randomIDs{}.NewID()
+	proc, err := NewClaudeCodeProcess(ClaudeCodeOptions{
+		Cwd:        dir,
+		SessionID:  sessionID,
+		Executable: executable,
+		MaxTurns:   1,
+	})
+	if err != nil {
+		return doctorResult{"round-trip", doctorFail, err.Error()}
+	}
+	defer func() {
+		_ = proc.cmd.Process.Kill()
+		_ = proc.Close()
+	}()
+
+	start := time.Now()
+	err = proc.SendMessage(SDKUserMessage{
+		Type:      "user",
+		Message:   SDKMessage{Role: "user", Content: "Reply with the single word OK."},
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return doctorResult{"round-trip", doctorFail, err.Error()}
+	}
+
+	done := make(chan doctorResult, 1)
+	go func() {
+		for {
+			resp, err := proc.ReadMessage()
+			if err != nil {
+				done <- doctorResult{"round-trip", doctorFail, fmt.Sprintf("CLI exited before replying: %v", err)}
+				return
+			}
+			if resp.Type != "result" {
+				continue
+			}
+			if resp.IsError || resp.Subtype != "success" {
+				detail := resp.Result
+				if len(resp.Errors) > 0 {
+					detail = strings.Join(resp.Errors, "; ")
+				}
+				done <- doctorResult{"round-trip", doctorFail, fmt.Sprintf("%s: %s", resp.Subtype, detail)}
+				return
+			}
+			done <- doctorResult{"round-trip", doctorOK, fmt.Sprintf("replied in %s", time.Since(start).Round(time.Millisecond))}
+			return
+		}
+	}()
+
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(timeout):
+		return doctorResult{"round-trip", doctorFail, fmt.Sprintf("no reply within %s", timeout)}
+	}
+}
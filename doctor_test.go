@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"2.0.14 (Claude Code)", "2.0.0", 1, true},
+		{"1.0.128 (Claude Code)", "2.0.0", -1, true},
+		{"2.0.0", "2.0.0", 0, true},
+		{"2.1.280-dev.20260921 (Claude Code)", "2.1.3", 1, true},
+		{"unknown", "2.0.0", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := compareVersions(tt.a, tt.b)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %v; want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCheckSettingsFiles_ReportsParseErrors(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	cwd := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(cwd, ".claude"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cwd, ".claude", "settings.json"), []byte(`{"permissions": {"allow": "Read"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := checkSettingsFiles(cwd)
+	statuses := map[string]doctorStatus{}
+	for _, r := range results {
+		statuses[r.Name] = r.Status
+	}
+	if statuses["project settings"] != doctorFail {
+		t.Errorf("expected invalid project settings to fail, got %v", results)
+	}
+	if statuses["user settings"] != doctorOK || statuses["local settings"] != doctorOK {
+		t.Errorf("expected absent files to pass, got %v", results)
+	}
+}
+
+func TestRunDoctor_MissingCLI(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	var out strings.Builder
+	code := runDoctor([]string{"--executable", "definitely-not-a-claude-binary", "--cwd", t.TempDir()}, &out)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	for _, want := range []string{"claude CLI", "not found on PATH", "round-trip", "Some checks failed."} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// documentContentBlock converts a document block from an assistant message
+// to an ACP content block. Text and base64 sources are embedded as
+// resources and URL sources become resource links. ok is false for sources
+// the client cannot resolve, such as Files API references.
+func documentContentBlock(block map[string]any) (acp.ContentBlock, bool) {
+	source, _ := block["source"].(map[string]any)
+	title, _ := block["title"].(string)
+	mediaType, _ := source["media_type"].(string)
+	uri := "document:" + url.PathEscape(title)
+	if title == "" {
+		uri = "document:untitled"
+	}
+
+	switch source["type"] {
+	case "text":
+		data, _ := source["data"].(string)
+		return acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+			Meta:     citedContentMeta(title),
+			Uri:      uri,
+			Text:     data,
+			MimeType: optionalString(mediaType),
+		}}), true
+	case "content":
+		return acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+			Meta:     citedContentMeta(title),
+			Uri:      uri,
+			Text:     joinTextBlocks(source["content"]),
+			MimeType: acp.Ptr("text/plain"),
+		}}), true
+	case "base64":
+		data, _ := source["data"].(string)
+		return acp.ResourceBlock(acp.EmbeddedResourceResource{BlobResourceContents: &acp.BlobResourceContents{
+			Meta:     citedContentMeta(title),
+			Uri:      uri,
+			Blob:     data,
+			MimeType: optionalString(mediaType),
+		}}), true
+	case "url":
+		link, _ := source["url"].(string)
+		if link == "" {
+			return acp.ContentBlock{}, false
+		}
+		name := title
+		if name == "" {
+			name = link
+		}
+		return acp.ResourceLinkBlock(name, link), true
+	}
+	return acp.ContentBlock{}, false
+}
+
+// searchResultContentBlock converts a search_result block to an embedded
+// text resource identified by its source, or to plain text if it has none.
+func searchResultContentBlock(block map[string]any) (acp.ContentBlock, bool) {
+	source, _ := block["source"].(string)
+	title, _ := block["title"].(string)
+	text := joinTextBlocks(block["content"])
+	if source == "" {
+		if title != "" {
+			text = title + "\n\n" + text
+		}
+		return acp.TextBlock(text), text != ""
+	}
+	return acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+		Meta: citedContentMeta(title),
+		Uri:  source,
+		Text: text,
+	}}), true
+}
+
+// joinTextBlocks returns the text of content, which is either a string or
+// a list of text blocks.
+func joinTextBlocks(content any) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+	blocks, _ := content.([]any)
+	var texts []string
+	for _, b := range blocks {
+		m, _ := b.(map[string]any)
+		if text, ok := m["text"].(string); ok && m["type"] == "text" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// citedContentMeta carries the title of a document or search result, which
+// embedded resources have no field for.
+func citedContentMeta(title string) any {
+	if title == "" {
+		return nil
+	}
+	return map[string]any{
+		"claudeCode": map[string]any{
+			"title": title,
+		},
+	}
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lineNormalizers are tried in order when old_string has no exact match:
+// first ignoring trailing whitespace, then all whitespace differences
+// including indentation.
+var lineNormalizers = []func(string) string{
+	func(line string) string { return strings.TrimRight(line, " \t\r") },
+	func(line string) string { return strings.Join(strings.Fields(line), " ") },
+}
+
+// fuzzyMatch is a whitespace-insensitive match of old_string.
+type fuzzyMatch struct {
+	start, end int    // byte range in the content
+	newText    string // replacement, re-indented to the matched lines
+}
+
+// findFuzzyMatch looks for the lines of oldText in content, comparing lines
+// with whitespace normalized. It only matches whole lines and fails if the
+// match is ambiguous; the returned count is the number of candidate
+// locations at the first normalization that found any.
+func findFuzzyMatch(content, oldText, newText string) (fuzzyMatch, int) {
+	oldBody := strings.TrimSuffix(oldText, "\n")
+	oldLines := strings.Split(oldBody, "\n")
+	lines := strings.Split(content, "\n")
+
+	for _, normalize := range lineNormalizers {
+		want := make([]string, len(oldLines))
+		for i, l := range oldLines {
+			want[i] = normalize(l)
+		}
+		var starts []int
+		for i := 0; i+len(want) <= len(lines); i++ {
+			if linesMatch(lines[i:i+len(want)], want, normalize) {
+				starts = append(starts, i)
+			}
+		}
+		if len(starts) == 0 {
+			continue
+		}
+		if len(starts) > 1 {
+			return fuzzyMatch{}, len(starts)
+		}
+
+		first := starts[0]
+		start := lineOffset(lines, first)
+		matched := lines[first : first+len(oldLines)]
+		end := lineOffset(lines, first+len(oldLines)) - 1 // before the last line's newline
+		if oldBody != oldText && end < len(content) {
+			end++ // old_string ended with a newline
+		} else if strings.HasSuffix(matched[len(matched)-1], "\r") {
+			end-- // keep the "\r" of a "\r\n" line ending
+		}
+		return fuzzyMatch{
+			start:   start,
+			end:     end,
+			newText: reindent(newText, oldLines, matched),
+		}, 1
+	}
+	return fuzzyMatch{}, 0
+}
+
+func linesMatch(lines, want []string, normalize func(string) string) bool {
+	for j, w := range want {
+		if normalize(lines[j]) != w {
+			return false
+		}
+	}
+	return true
+}
+
+// lineOffset returns the byte offset of line n (0-based) in the content
+// that was split into lines. n may be len(lines), the end of the content
+// plus one.
+func lineOffset(lines []string, n int) int {
+	offset := 0
+	for _, l := range lines[:n] {
+		offset += len(l) + 1
+	}
+	return offset
+}
+
+// reindent shifts newText from the indentation of oldLines to that of the
+// matched file lines, based on the first non-blank line.
+func reindent(newText string, oldLines, matched []string) string {
+	for i, l := range oldLines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		oldIndent := leadingWhitespace(l)
+		fileIndent := leadingWhitespace(matched[i])
+		if oldIndent == fileIndent {
+			return newText
+		}
+		newLines := strings.Split(newText, "\n")
+		for j, nl := range newLines {
+			if strings.HasPrefix(nl, oldIndent) && strings.TrimSpace(nl) != "" {
+				newLines[j] = fileIndent + nl[len(oldIndent):]
+			}
+		}
+		return strings.Join(newLines, "\n")
+	}
+	return newText
+}
+
+func leadingWhitespace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// nearestMiss describes the lines of content most similar to oldText, to
+// help the model correct a failed edit. It returns "" if nothing is close.
+func nearestMiss(content, oldText string) string {
+	normalize := lineNormalizers[len(lineNormalizers)-1]
+	var want []string
+	for _, l := range strings.Split(strings.TrimSuffix(oldText, "\n"), "\n") {
+		want = append(want, normalize(l))
+	}
+	lines := strings.Split(content, "\n")
+
+	best, bestScore := 0, 0
+	for i := range lines {
+		score := 0
+		for j := 0; j < len(want) && i+j < len(lines); j++ {
+			if want[j] != "" && normalize(lines[i+j]) == want[j] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if bestScore == 0 {
+		return ""
+	}
+	end := min(best+len(want), len(lines))
+	return fmt.Sprintf("The most similar text starts at line %d:\n%s", best+1, strings.Join(lines[best:end], "\n"))
+}
+
+// editNotFoundError reports an old_string that could not be matched.
+func editNotFoundError(content, oldText string, candidates int) error {
+	msg := fmt.Sprintf("The provided `old_string` does not appear in the file: %q.", oldText)
+	if candidates > 1 {
+		msg += fmt.Sprintf("\n\nIgnoring whitespace it matches %d locations; include more surrounding context.", candidates)
+	} else if miss := nearestMiss(content, oldText); miss != "" {
+		msg += "\n\n" + miss
+	}
+	return fmt.Errorf("%s\n\nNo edits were applied.", msg)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// EnvFilter selects which of the agent's own environment variables the CLI
+// inherits, so editor secrets need not leak into the subprocess. Patterns
+// are path.Match globs over variable names, such as "AWS_*", compared
+// case-insensitively. Variables from settings env are always passed.
+type EnvFilter struct {
+	// Allow, if set, limits inherited variables to essentialEnv and those
+	// matching a pattern.
+	Allow []string
+	// Deny drops matching variables. It never drops preservedEnv.
+	Deny []string
+}
+
+// essentialEnv are inherited even when Allow is set: what the CLI and the
+// commands it runs need to work, and its own configuration.
+var essentialEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ",
+	"TMPDIR", "TEMP", "TMP", "XDG_*", "ANTHROPIC_*", "CLAUDE_*",
+	// Windows
+	"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT", "USERPROFILE",
+	"APPDATA", "LOCALAPPDATA", "PROGRAMDATA", "PROGRAMFILES*", "HOMEDRIVE", "HOMEPATH",
+}
+
+// preservedEnv are always inherited.
+var preservedEnv = []string{"CLAUDECODE", "HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY"}
+
+// parseEnvPatterns splits a comma-separated pattern list, as given to
+// --env-allow and --env-deny.
+func parseEnvPatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid env pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// isZero reports whether f passes the environment through unchanged.
+func (f EnvFilter) isZero() bool {
+	return len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+// apply returns the entries of environ, in "NAME=value" form, that f lets
+// through.
+func (f EnvFilter) apply(environ []string) []string {
+	var out []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if f.allows(name) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+func (f EnvFilter) allows(name string) bool {
+	if matchEnvName(preservedEnv, name) {
+		return true
+	}
+	if len(f.Allow) > 0 && !matchEnvName(essentialEnv, name) && !matchEnvName(f.Allow, name) {
+		return false
+	}
+	return !matchEnvName(f.Deny, name)
+}
+
+// matchEnvName reports whether name matches any of patterns.
+func matchEnvName(patterns []string, name string) bool {
+	name = strings.ToUpper(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvFilter_Apply(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin", "HOME=/home/dev", "AWS_PROFILE=dev", "GITHUB_TOKEN=ghp_x",
+		"EDITOR_SECRET=s3cret", "https_proxy=http://proxy:3128", "CLAUDECODE=1", "LC_ALL=C",
+	}
+
+	if got := (EnvFilter{}).apply(environ); !slices.Equal(got, environ) {
+		t.Errorf("zero filter changed the environment: %v", got)
+	}
+
+	got := EnvFilter{Deny: []string{"*_TOKEN", "*_SECRET", "*PROXY"}}.apply(environ)
+	want := []string{"PATH=/usr/bin", "HOME=/home/dev", "AWS_PROFILE=dev", "https_proxy=http://proxy:3128", "CLAUDECODE=1", "LC_ALL=C"}
+	if !slices.Equal(got, want) {
+		t.Errorf("deny: got %v, want %v", got, want)
+	}
+
+	got = EnvFilter{Allow: []string{"aws_*"}, Deny: []string{"HOME"}}.apply(environ)
+	want = []string{"PATH=/usr/bin", "AWS_PROFILE=dev", "https_proxy=http://proxy:3128", "CLAUDECODE=1", "LC_ALL=C"}
+	if !slices.Equal(got, want) {
+		t.Errorf("allow: got %v, want %v", got, want)
+	}
+}
+
+func TestParseEnvPatterns(t *testing.T) {
+	got, err := parseEnvPatterns(" AWS_*, ,GOOGLE_APPLICATION_CREDENTIALS")
+	if err != nil || !slices.Equal(got, []string{"AWS_*", "GOOGLE_APPLICATION_CREDENTIALS"}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := parseEnvPatterns("AWS_[*"); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// errorKind classifies an agent failure so clients can offer a targeted
+// recovery action. It is reported as the "kind" field of the error data.
+type errorKind string
+
+const (
+	errKindSessionNotFound errorKind = "session_not_found"
+	errKindInvalidMode     errorKind = "invalid_mode"
+	errKindSessionBusy     errorKind = "session_busy"
+	errKindSessionReadOnly errorKind = "session_read_only"
+	errKindSettings        errorKind = "settings_error"
+	errKindCLIStart        errorKind = "cli_start_failed"
+	errKindCLISend         errorKind = "cli_send_failed"
+	errKindCLIRead         errorKind = "cli_read_failed"
+	errKindCLIResult       errorKind = "cli_error"
+	errKindCLIProtocol     errorKind = "cli_protocol_mismatch"
+	errKindPanic           errorKind = "internal_panic"
+	errKindCLINotFound     errorKind = "cli_not_found"
+	errKindCLIOutdated     errorKind = "cli_outdated"
+	errKindAuthRequired    errorKind = "auth_required"
+	errKindUnreachable     errorKind = "client_unreachable"
+	errKindTurnTimeout     errorKind = "turn_timeout"
+)
+
+// errorData is the data payload of errors returned by the agent. The
+// "error" field carries the human-readable message.
+type errorData struct {
+	Kind      errorKind `json:"kind"`
+	Error     string    `json:"error"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Retryable bool      `json:"retryable"`
+	// Executable and Hint describe a missing or outdated claude CLI.
+	Executable string `json:"executable,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+	// AuthMethodID is the auth method to authenticate with again.
+	AuthMethodID string `json:"authMethodId,omitempty"`
+}
+
+// resourceNotFoundCode is the ACP error code clients return for a file
+// that does not exist.
+const resourceNotFoundCode = -32002
+
+// isResourceNotFound reports whether err is a client's "resource not
+// found" error.
+func isResourceNotFound(err error) bool {
+	var reqErr *acp.RequestError
+	return errors.As(err, &reqErr) && reqErr.Code == resourceNotFoundCode
+}
+
+// newAgentError builds a RequestError with the given constructor and a
+// structured data payload.
+func newAgentError(newErr func(data any) *acp.RequestError, kind errorKind, sessionID string, retryable bool, msg string) *acp.RequestError {
+	return newErr(errorData{Kind: kind, Error: msg, SessionID: sessionID, Retryable: retryable})
+}
+
+// errSessionNotFound reports a request for an unknown session.
+func errSessionNotFound(sessionID string) *acp.RequestError {
+	return newAgentError(acp.NewInvalidParams, errKindSessionNotFound, sessionID, false, "session not found: "+sessionID)
+}
+
+// errSessionReadOnly reports a request to drive a session the client only
+// watches; the session's creator is its single writer.
+func errSessionReadOnly(sessionID string) *acp.RequestError {
+	return newAgentError(acp.NewInvalidRequest, errKindSessionReadOnly, sessionID, false, "session is read-only for this client: "+sessionID)
+}
+
+// errCLISend reports a failure to write a message to the CLI process. The
+// process has usually exited, so the session must be recreated.
+func errCLISend(sessionID string, err error) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindCLISend, sessionID, false, "failed to send message: "+err.Error())
+}
+
+// errCLIRead reports a failure to read the CLI process output.
+func errCLIRead(sessionID string, err error) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindCLIRead, sessionID, false, "read error: "+err.Error())
+}
+
+// errCLIProtocol reports a CLI message that failed the --strict-protocol
+// check.
+func errCLIProtocol(sessionID, msgType string, problems []string) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindCLIProtocol, sessionID, false,
+		fmt.Sprintf("unexpected %s message from the CLI: %s", msgType, strings.Join(problems, "; ")))
+}
+
+// errClientUnreachable ends a turn whose session updates the client has
+// stopped receiving. The prompt may be retried once it reconnects.
+func errClientUnreachable(sessionID string) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindUnreachable, sessionID, true,
+		fmt.Sprintf("ending the turn: the last %d session updates could not be delivered to the client", maxUpdateFailures))
+}
+
+// errTurnTimeout reports a turn interrupted because the CLI sent nothing
+// for the turn idle timeout.
+func errTurnTimeout(sessionID string, timeout time.Duration) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindTurnTimeout, sessionID, true,
+		fmt.Sprintf("the turn was interrupted after Claude Code produced no output for %s", timeout))
+}
+
+// errCLIResult reports an error result from the CLI. The turn may be
+// retried with the same session.
+func errCLIResult(sessionID, msg string) *acp.RequestError {
+	return newAgentError(acp.NewInternalError, errKindCLIResult, sessionID, true, msg)
+}
+
+// errAuthRequired reports that the CLI's credentials are missing, expired or
+// rejected. It is not retryable: the running CLI keeps the credentials it
+// started with, so the client must authenticate with methodID and then
+// create or load the session again.
+func errAuthRequired(sessionID, msg, methodID string) *acp.RequestError {
+	return acp.NewAuthRequired(errorData{Kind: errKindAuthRequired, Error: msg, SessionID: sessionID, Retryable: false, AuthMethodID: methodID})
+}
+
+// errCLIStart reports a failure to start the CLI process, with install or
+// upgrade instructions when the CLI is missing or outdated.
+func errCLIStart(err error) *acp.RequestError {
+	var unavailable *CLIUnavailableError
+	if !errors.As(err, &unavailable) {
+		return newAgentError(acp.NewInternalError, errKindCLIStart, "", true, "failed to start Claude Code: "+err.Error())
+	}
+	kind := errKindCLINotFound
+	if unavailable.Version != "" {
+		kind = errKindCLIOutdated
+	}
+	return acp.NewInternalError(errorData{
+		Kind:       kind,
+		Error:      unavailable.Error(),
+		Retryable:  true,
+		Executable: unavailable.Executable,
+		Hint:       unavailable.Hint(),
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestAgentErrors_StructuredData(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	_, err := agent.Prompt(context.Background(), acp.PromptRequest{SessionId: "missing"})
+
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("expected *acp.RequestError, got %T", err)
+	}
+	if reqErr.Code != -32602 {
+		t.Errorf("code = %d, want -32602", reqErr.Code)
+	}
+	b, _ := json.Marshal(reqErr.Data)
+	var data map[string]any
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data["kind"] != "session_not_found" || data["sessionId"] != "missing" || data["retryable"] != false {
+		t.Errorf("unexpected data: %s", b)
+	}
+	if data["error"] != "session not found: missing" {
+		t.Errorf("unexpected message: %v", data["error"])
+	}
+}
+
+func TestAgentErrors_CLIResultRetryable(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	_, err := agent.handleResult(&SDKResponse{Subtype: "error_during_execution", IsError: true, Errors: []string{"overloaded"}}, "s1")
+
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("expected *acp.RequestError, got %T", err)
+	}
+	data, ok := reqErr.Data.(errorData)
+	if !ok || data.Kind != errKindCLIResult || !data.Retryable || data.Error != "overloaded" || data.SessionID != "s1" {
+		t.Errorf("unexpected data: %+v", reqErr.Data)
+	}
+}
+
+func TestHandleResult_StopReasons(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	tests := []struct {
+		resp SDKResponse
+		want acp.StopReason
+	}{
+		{SDKResponse{Subtype: "success", StopReason: "end_turn"}, acp.StopReasonEndTurn},
+		{SDKResponse{Subtype: "success", StopReason: "refusal"}, acp.StopReasonRefusal},
+		{SDKResponse{Subtype: "success", StopReason: "max_tokens"}, acp.StopReasonMaxTokens},
+		{SDKResponse{Subtype: "success", StopReason: "model_context_window_exceeded"}, acp.StopReasonMaxTokens},
+		{SDKResponse{Subtype: "success"}, acp.StopReasonEndTurn},
+		{SDKResponse{Subtype: "error_during_execution", StopReason: "refusal"}, acp.StopReasonRefusal},
+		{SDKResponse{Subtype: "error_max_turns"}, acp.StopReasonMaxTurnRequests},
+	}
+	for _, tt := range tests {
+		got, err := agent.handleResult(&tt.resp, "s1")
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error %v", tt.resp.Subtype, tt.resp.StopReason, err)
+			continue
+		}
+		if got.StopReason != tt.want {
+			t.Errorf("%s/%s: stop reason = %s, want %s", tt.resp.Subtype, tt.resp.StopReason, got.StopReason, tt.want)
+		}
+	}
+}
+
+func TestNewSession_CLIUnavailable(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	outdated := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(outdated, []byte("#!/bin/sh\necho '1.0.3 (Claude Code)'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		executable string
+		kind       errorKind
+	}{
+		{"definitely-not-a-claude-binary", errKindCLINotFound},
+		{outdated, errKindCLIOutdated},
+	}
+	for _, tt := range tests {
+		if tt.kind == errKindCLIOutdated && runtime.GOOS == "windows" {
+			continue
+		}
+		agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{Executable: tt.executable})
+		_, err := agent.NewSession(context.Background(), acp.NewSessionRequest{Cwd: t.TempDir(), McpServers: []acp.McpServer{}})
+
+		var reqErr *acp.RequestError
+		if !errors.As(err, &reqErr) {
+			t.Fatalf("%s: expected *acp.RequestError, got %v", tt.executable, err)
+		}
+		data, ok := reqErr.Data.(errorData)
+		if !ok || data.Kind != tt.kind || data.Hint == "" || data.Executable == "" {
+			t.Errorf("%s: unexpected data: %+v", tt.executable, reqErr.Data)
+		}
+	}
+}
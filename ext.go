@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// extMethodPrefix namespaces the ACP extension methods served by this agent.
+// ACP reserves method names starting with "_" for extensions.
+const extMethodPrefix = "_claude/"
+
+// extMethodHandler handles one extension method. A returned *acp.RequestError
+// is sent to the client as-is; any other error becomes an internal error.
+type extMethodHandler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// extRouter sits between the transport and the SDK connection. The SDK
+// answers unknown methods with MethodNotFound, so extension requests are
+// peeled off the inbound stream here and answered on the shared writer.
+// Everything else, including unregistered "_" methods, is passed through.
+// Notifications are looked up in notifications, then in handlers.
+// Responses to the agent's own extension requests are handed to calls.
+type extRouter struct {
+	handlers      map[string]extMethodHandler
+	notifications map[string]extMethodHandler
+	calls         *extCalls
+	out           *lockedWriter
+	logger        *slog.Logger
+	ctx           context.Context
+}
+
+// lockedWriter serializes whole-message writes from the SDK and the router.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// extRequest is the subset of a JSON-RPC message needed for routing.
+// Result and Error are set on responses.
+type extRequest struct {
+	ID     *json.RawMessage  `json:"id,omitempty"`
+	Method string            `json:"method,omitempty"`
+	Params json.RawMessage   `json:"params,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *acp.RequestError `json:"error,omitempty"`
+}
+
+type extResponse struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      *json.RawMessage  `json:"id"`
+	Result  any               `json:"result,omitempty"`
+	Error   *acp.RequestError `json:"error,omitempty"`
+}
+
+// extNotification is an extension notification sent to the client.
+type extNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// newAgentConnection connects agent to a client over the given streams,
+// serving the agent's extension methods alongside the standard ACP ones.
+func newAgentConnection(agent *ClaudeAcpAgent, peerInput io.Writer, peerOutput io.Reader, logger *slog.Logger) *acp.AgentSideConnection {
+	out := &lockedWriter{w: peerInput}
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := &extCalls{}
+	router := &extRouter{handlers: agent.extMethods, notifications: agent.extNotifications, calls: calls, out: out, logger: logger, ctx: ctx}
+	go func() {
+		defer cancel()
+		pw.CloseWithError(router.route(peerOutput, pw))
+	}()
+
+	agent.extOut = out
+	agent.extCalls = calls
+	conn := acp.NewAgentSideConnection(recoveringAgent{agent: agent, logger: logger}, out, pr)
+	conn.SetLogger(logger)
+	agent.SetAgentConnection(conn)
+	return conn
+}
+
+// route copies messages from in to sdk, handling registered extension
+// methods itself. It returns when in is exhausted.
+func (r *extRouter) route(in io.Reader, sdk io.Writer) error {
+	br := bufio.NewReaderSize(in, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && !r.intercept(line) {
+			if _, werr := sdk.Write(line); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// intercept dispatches line if it is a registered extension method and
+// reports whether it was consumed.
+func (r *extRouter) intercept(line []byte) bool {
+	if !bytes.Contains(line, []byte(`"_`)) {
+		return false
+	}
+	var req extRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return false
+	}
+	if req.Method == "" && req.ID != nil {
+		return r.calls.deliver(req)
+	}
+	if !strings.HasPrefix(req.Method, "_") {
+		return false
+	}
+	handler, ok := r.notifications[req.Method]
+	if !ok || req.ID != nil {
+		handler, ok = r.handlers[req.Method]
+	}
+	if !ok {
+		return false
+	}
+	go r.serve(req, handler)
+	return true
+}
+
+// call runs handler, converting a panic into an internal error.
+func (r *extRouter) call(req extRequest, handler extMethodHandler) (_ any, err error) {
+	defer recoverPanic(r.logger, req.Method, "", &err)
+	return handler(r.ctx, req.Params)
+}
+
+func (r *extRouter) serve(req extRequest, handler extMethodHandler) {
+	result, err := r.call(req, handler)
+	if req.ID == nil {
+		if err != nil {
+			r.logger.Error("Extension notification failed", "method", req.Method, "error", err)
+		}
+		return
+	}
+
+	resp := extResponse{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		var reqErr *acp.RequestError
+		if !errors.As(err, &reqErr) {
+			reqErr = acp.NewInternalError(map[string]any{"error": err.Error()})
+		}
+		resp.Error = reqErr
+	} else {
+		if result == nil {
+			result = map[string]any{}
+		}
+		resp.Result = result
+	}
+
+	b, mErr := json.Marshal(resp)
+	if mErr != nil {
+		r.logger.Error("Failed to encode extension response", "method", req.Method, "error", mErr)
+		return
+	}
+	if _, wErr := r.out.Write(append(b, '\n')); wErr != nil {
+		r.logger.Error("Failed to send extension response", "method", req.Method, "error", wErr)
+	}
+}
+
+// sendExtNotification sends an extension notification to the client. It
+// does nothing if the agent has no connection.
+func (a *ClaudeAcpAgent) sendExtNotification(method string, params any) error {
+	if a.extOut == nil {
+		return nil
+	}
+	b, err := json.Marshal(extNotification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	_, err = a.extOut.Write(append(b, '\n'))
+	return err
+}
+
+// extCalls tracks the extension requests the agent sends the client. Their
+// IDs are strings starting with extCallIDPrefix, so their responses can be
+// told apart from those to the SDK's own requests.
+type extCalls struct {
+	mu      sync.Mutex
+	next    int
+	pending map[string]chan extRequest
+}
+
+const extCallIDPrefix = "_claude-"
+
+// deliver hands a response to the call waiting for it and reports whether
+// there was one.
+func (c *extCalls) deliver(resp extRequest) bool {
+	var id string
+	if c == nil || json.Unmarshal(*resp.ID, &id) != nil || !strings.HasPrefix(id, extCallIDPrefix) {
+		return false
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+	return ok
+}
+
+// callClientExt sends the client an extension request and decodes the
+// result into result. Callers should first check clientSupportsExt.
+func (a *ClaudeAcpAgent) callClientExt(ctx context.Context, method string, params, result any) error {
+	if a.extOut == nil || a.extCalls == nil {
+		return errors.New("no client connection")
+	}
+	c := a.extCalls
+	c.mu.Lock()
+	c.next++
+	id := fmt.Sprintf("%s%d", extCallIDPrefix, c.next)
+	ch := make(chan extRequest, 1)
+	if c.pending == nil {
+		c.pending = map[string]chan extRequest{}
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	b, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	if _, err := a.extOut.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clientSupportsExt reports whether the client advertised method in its
+// capabilities' _meta, as {"_meta": {"<method>": true}}.
+func (a *ClaudeAcpAgent) clientSupportsExt(method string) bool {
+	return a.clientCapabilities != nil && supportsExt(*a.clientCapabilities, method)
+}
+
+// supportsExt reports whether capabilities advertise an extension method
+// in their _meta.
+func supportsExt(c acp.ClientCapabilities, method string) bool {
+	meta, _ := c.Meta.(map[string]any)
+	supported, _ := meta[method].(bool)
+	return supported
+}
+
+// sendContextUsage reports the session's context window usage with a
+// _claude/context_usage notification.
+func (a *ClaudeAcpAgent) sendContextUsage(sessionID string, session *Session) {
+	usage := session.usage.snapshot(sessionID)
+	if err := a.sendExtNotification(extMethodPrefix+"context_usage", usage); err != nil {
+		session.log().Warn("Failed to send context usage", "error", err)
+	}
+}
+
+// decodeExtParams unmarshals extension method params, mapping failures to
+// an InvalidParams error.
+func decodeExtParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return acp.NewInvalidParams(map[string]any{"error": err.Error()})
+	}
+	return nil
+}
+
+// registerExtMethods installs the extension methods served by the agent.
+func (a *ClaudeAcpAgent) registerExtMethods() {
+	a.extMethods = map[string]extMethodHandler{
+		extMethodPrefix + "permissions/list":         a.extListPermissions,
+		extMethodPrefix + "permissions/update":       a.extUpdatePermissions,
+		extMethodPrefix + "session/clear":            a.extClearSession,
+		extMethodPrefix + "memory/read":              a.extReadMemory,
+		extMethodPrefix + "memory/update":            a.extUpdateMemory,
+		extMethodPrefix + "session/attach":           a.extAttachSession,
+		extMethodPrefix + "session/detach":           a.extDetachSession,
+		extMethodPrefix + "session/export":           a.extExportSession,
+		extMethodPrefix + "session/revert_last_turn": a.extRevertLastTurn,
+		mcpServersUpdateMethod:                       a.extUpdateMCPServers,
+		debugSessionsMethod:                          a.extDebugSessions,
+	}
+	a.extNotifications = map[string]extMethodHandler{
+		extMethodPrefix + "workspace/diagnostics": a.extWorkspaceDiagnostics,
+		fileChangedMethod:                         a.extFilesChanged,
+	}
+}
+
+// extSession looks up the session named by an extension request.
+func (a *ClaudeAcpAgent) extSession(sessionID string) (*Session, error) {
+	return a.lookupSession(sessionID)
+}
+
+// permissionRulesParams is the payload of _claude/permissions/list.
+type permissionRulesParams struct {
+	SessionID string `json:"sessionId"`
+}
+
+// permissionUpdateParams is the payload of _claude/permissions/update.
+// Added and removed rules apply to the session's local settings layer
+// and are written to .claude/settings.local.json when Persist is set.
+type permissionUpdateParams struct {
+	SessionID string          `json:"sessionId"`
+	Add       PermissionRules `json:"add"`
+	Remove    PermissionRules `json:"remove"`
+	Persist   bool            `json:"persist,omitempty"`
+}
+
+// permissionRulesResult reports the merged rule set after a list or update.
+type permissionRulesResult struct {
+	Permissions PermissionRules `json:"permissions"`
+}
+
+func (a *ClaudeAcpAgent) extListPermissions(_ context.Context, params json.RawMessage) (any, error) {
+	var p permissionRulesParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return permissionRulesResult{Permissions: session.settingsManager.PermissionRules()}, nil
+}
+
+func (a *ClaudeAcpAgent) extUpdatePermissions(_ context.Context, params json.RawMessage) (any, error) {
+	var p permissionUpdateParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	for _, list := range [][]string{p.Add.Allow, p.Add.Deny, p.Add.Ask} {
+		for _, rule := range list {
+			if strings.TrimSpace(rule) == "" {
+				return nil, acp.NewInvalidParams(map[string]any{"error": "empty permission rule"})
+			}
+		}
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.settingsManager.UpdatePermissionRules(p.Add, p.Remove, p.Persist); err != nil {
+		return nil, err
+	}
+	return permissionRulesResult{Permissions: session.settingsManager.PermissionRules()}, nil
+}
+
+// sessionClearParams is the payload of _claude/session/clear.
+type sessionClearParams struct {
+	SessionID string `json:"sessionId"`
+}
+
+// sessionClearTimeout bounds how long _claude/session/clear waits for the
+// CLI to finish /clear.
+const sessionClearTimeout = 30 * time.Second
+
+// extClearSession starts a new conversation in the session's CLI process
+// by sending /clear. The ACP session ID, process, settings and MCP servers
+// are kept. It fails while a prompt is running. A CLI that does not finish
+// /clear in time is stopped, and the next prompt restarts it.
+func (a *ClaudeAcpAgent) extClearSession(ctx context.Context, params json.RawMessage) (any, error) {
+	var p sessionClearParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.turnMu.TryLock() {
+		return nil, newAgentError(acp.NewInvalidRequest, errKindSessionBusy, p.SessionID, true, "cannot clear a session while a prompt is running")
+	}
+	defer session.turnMu.Unlock()
+
+	err = session.sendMessage(SDKUserMessage{
+		Type:    "user",
+		Message: SDKMessage{Role: "user", Content: "/clear"},
+	})
+	if err != nil {
+		return nil, errCLISend(p.SessionID, err)
+	}
+	proc := session.proc()
+	ctx, cancel := context.WithTimeout(ctx, sessionClearTimeout)
+	defer cancel()
+	// The CLI starts a new conversation; restarts must resume that one.
+	var conversationID string
+	done := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := proc.ReadMessage()
+			if err != nil {
+				var tooLarge *MessageTooLargeError
+				if errors.As(err, &tooLarge) {
+					continue
+				}
+				done <- err
+				return
+			}
+			if resp.SessionID != "" {
+				conversationID = resp.SessionID
+			}
+			if resp.Type == "result" {
+				done <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, errCLIRead(p.SessionID, err)
+		}
+	case <-ctx.Done():
+		// Stop the CLI so the reader ends before the lock is released.
+		_ = proc.Close()
+		<-done
+		return nil, errCLIRead(p.SessionID, fmt.Errorf("/clear did not finish: %w", ctx.Err()))
+	}
+	if conversationID != "" {
+		session.mu.Lock()
+		session.conversationID = conversationID
+		session.mu.Unlock()
+	}
+	session.history.reset()
+	session.checkpoints.reset()
+	session.usage.reset()
+	a.sendContextUsage(p.SessionID, session)
+	return map[string]any{}, nil
+}
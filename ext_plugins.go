@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"acp4all/extplugin"
+)
+
+// installExtPlugins routes the plugins' methods and notifications to them.
+// Plugins registered later replace earlier handlers of the same method.
+func (a *ClaudeAcpAgent) installExtPlugins(plugins []extplugin.Plugin) {
+	for _, p := range plugins {
+		if err := p.Validate(); err != nil {
+			a.logger.Error("Skipping extension plugin", "error", err)
+			continue
+		}
+		for method, h := range p.Methods {
+			a.extMethods[method] = a.pluginHandler(method, h)
+		}
+		for method, h := range p.Notifications {
+			a.extNotifications[method] = a.pluginHandler(method, h)
+		}
+	}
+}
+
+// pluginHandler adapts a plugin handler, resolving the session named in
+// the call's params.
+func (a *ClaudeAcpAgent) pluginHandler(method string, h extplugin.Handler) extMethodHandler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		call := extplugin.Call{Method: method, Params: params, Notify: a.sendExtNotification}
+		var p struct {
+			SessionID string `json:"sessionId"`
+		}
+		if json.Unmarshal(params, &p) == nil && p.SessionID != "" {
+			call.SessionID = p.SessionID
+			if session, err := a.extSession(p.SessionID); err == nil {
+				call.Session = session
+			}
+		}
+		return h(ctx, call)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"acp4all/extplugin"
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestExtPlugins_Routing(t *testing.T) {
+	notified := make(chan extplugin.Call, 1)
+	plugin := extplugin.Plugin{
+		Name: "zed",
+		Methods: map[string]extplugin.Handler{
+			"_zed/echo": func(_ context.Context, call extplugin.Call) (any, error) {
+				if call.Session == nil {
+					return nil, acp.NewInvalidParams(map[string]any{"error": "no session"})
+				}
+				if err := call.Notify("_zed/echoed", map[string]any{"sessionId": call.SessionID}); err != nil {
+					return nil, err
+				}
+				return map[string]any{"cwd": call.Session.Cwd()}, nil
+			},
+		},
+		Notifications: map[string]extplugin.Handler{
+			"_zed/ping": func(_ context.Context, call extplugin.Call) (any, error) {
+				notified <- call
+				return nil, nil
+			},
+		},
+	}
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{ExtPlugins: []extplugin.Plugin{plugin}})
+	agent.sessions["s1"] = &Session{cwd: "/work"}
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_zed/echo","params":{"sessionId":"s1"}}`)
+	if msg := recv(); msg["method"] != "_zed/echoed" {
+		t.Fatalf("expected the plugin notification first, got %v", msg)
+	}
+	if msg := recv(); msg["result"].(map[string]any)["cwd"] != "/work" {
+		t.Fatalf("unexpected response %v", msg)
+	}
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_zed/echo","params":{"sessionId":"missing"}}`)
+	if errObj, ok := recv()["error"].(map[string]any); !ok || errObj["code"].(float64) != -32602 {
+		t.Fatalf("expected InvalidParams for an unknown session, got %v", errObj)
+	}
+
+	send(`{"jsonrpc":"2.0","method":"_zed/ping","params":{"sessionId":"s1"}}`)
+	if call := <-notified; call.Method != "_zed/ping" || call.Session == nil {
+		t.Errorf("unexpected notification call %+v", call)
+	}
+
+	// A notification handler does not answer requests of the same name.
+	send(`{"jsonrpc":"2.0","id":3,"method":"_zed/ping","params":{}}`)
+	if errObj, ok := recv()["error"].(map[string]any); !ok || errObj["code"].(float64) != -32601 {
+		t.Errorf("expected MethodNotFound, got %v", errObj)
+	}
+}
+
+func TestExtPlugins_Reserved(t *testing.T) {
+	noop := func(context.Context, extplugin.Call) (any, error) { return nil, nil }
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{ExtPlugins: []extplugin.Plugin{
+		{Name: "bad", Methods: map[string]extplugin.Handler{extMethodPrefix + "session/clear": noop}},
+	}})
+	if _, err := agent.extMethods[extMethodPrefix+"session/clear"](context.Background(), []byte(`{"sessionId":"missing"}`)); err == nil {
+		t.Error("a plugin must not replace the agent's own methods")
+	}
+}
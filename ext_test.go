@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// extTestConn starts an agent connection over pipes and returns a raw line
+// writer and reader for the client side.
+func extTestConn(t *testing.T, agent *ClaudeAcpAgent) (func(string), func() map[string]any) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	newAgentConnection(agent, a2cW, c2aR, logger)
+	t.Cleanup(func() {
+		c2aW.Close()
+		a2cW.Close()
+	})
+
+	send := func(line string) {
+		if _, err := io.WriteString(c2aW, line+"\n"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	lines := make(chan string, 8)
+	go func() {
+		scanner := bufio.NewScanner(a2cR)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	recv := func() map[string]any {
+		t.Helper()
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			var msg map[string]any
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("invalid response %q: %v", line, err)
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for response")
+		}
+		return nil
+	}
+	return send, recv
+}
+
+func TestExtRouter_UnknownMethodFallsThrough(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/nope","params":{}}`)
+	msg := recv()
+	errObj, ok := msg["error"].(map[string]any)
+	if !ok || errObj["code"].(float64) != -32601 {
+		t.Fatalf("expected MethodNotFound, got %v", msg)
+	}
+}
+
+func TestExtRouter_PermissionsUpdate(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	cwd := t.TempDir()
+	localPath := filepath.Join(cwd, ".claude", "settings.local.json")
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte(`{"model":"opus","permissions":{"allow":["Read"],"defaultMode":"plan"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	mgr := NewSettingsManager(cwd, agent.logger)
+	if err := mgr.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	agent.sessions["s1"] = &Session{settingsManager: mgr}
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/permissions/update","params":{"sessionId":"s1","add":{"deny":["Bash(rm:*)"]},"remove":{"allow":["Read"]},"persist":true}}`)
+	msg := recv()
+	result, ok := msg["result"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected result, got %v", msg)
+	}
+	perms := result["permissions"].(map[string]any)
+	if deny := perms["deny"].([]any); len(deny) != 1 || deny[0] != "Bash(rm:*)" {
+		t.Errorf("unexpected deny rules: %v", deny)
+	}
+	if allow := perms["allow"].([]any); len(allow) != 0 {
+		t.Errorf("expected Read to be removed, got %v", allow)
+	}
+
+	if got := mgr.CheckPermission(ACPToolNamePrefix+"Bash", map[string]any{"command": "rm -rf /"}); got.Decision != PermissionDeny {
+		t.Errorf("expected runtime deny rule to apply, got %v", got.Decision)
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"model": "opus"`, `"defaultMode": "plan"`, `"Bash(rm:*)"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("persisted settings missing %s:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), `"allow"`) {
+		t.Errorf("expected allow list to be dropped:\n%s", data)
+	}
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_claude/permissions/list","params":{"sessionId":"missing"}}`)
+	msg = recv()
+	if errObj, ok := msg["error"].(map[string]any); !ok || errObj["code"].(float64) != -32602 {
+		t.Fatalf("expected InvalidParams for unknown session, got %v", msg)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestExtRouter_SessionClear(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	var stdin strings.Builder
+	cliOutput := `{"type":"system","subtype":"init","session_id":"c2"}` + "\n" +
+		`{"type":"result","subtype":"success","session_id":"c2"}` + "\n"
+	session := &Session{process: &ClaudeCodeProcess{
+		stdin:   nopWriteCloser{&stdin},
+		decoder: newNDJSONDecoder(strings.NewReader(cliOutput)),
+	}}
+	session.usage.update(map[string]any{"input_tokens": float64(5000)}, "claude-sonnet-4-5")
+	agent.sessions["s1"] = session
+	send, recv := extTestConn(t, agent)
+
+	session.turnMu.Lock()
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/clear","params":{"sessionId":"s1"}}`)
+	if errObj, ok := recv()["error"].(map[string]any); !ok || errObj["code"].(float64) != -32600 {
+		t.Fatalf("expected InvalidRequest while a prompt is running, got %v", errObj)
+	}
+	session.turnMu.Unlock()
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_claude/session/clear","params":{"sessionId":"s1"}}`)
+	usage := recv()
+	if usage["method"] != "_claude/context_usage" || usage["params"].(map[string]any)["used"].(float64) != 0 {
+		t.Errorf("expected reset context usage, got %v", usage)
+	}
+	if msg := recv(); msg["result"] == nil {
+		t.Fatalf("expected result, got %v", msg)
+	}
+	if !strings.Contains(stdin.String(), `"content":"/clear"`) {
+		t.Errorf("expected /clear to be sent, got %q", stdin.String())
+	}
+}
+
+func TestExtClearSession_ResumesNewConversation(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	backend := &restartBackend{output: `{"type":"system","subtype":"init","session_id":"c2"}` + "\n" +
+		`{"type":"result","subtype":"success","session_id":"c2"}` + "\n"}
+	// The CLI was closed by a cancel, so clearing restarts it.
+	session := &Session{
+		process:             &ClaudeCodeProcess{stdin: closedStdin(t)},
+		backend:             backend,
+		startOptions:        ProcessOptions{SessionID: "s1"},
+		conversationStarted: true,
+	}
+	session.history.add(acp.UpdateAgentMessageText("before"))
+	session.checkpoints.record("/work/a.go", "old", true)
+	agent.sessions["s1"] = session
+
+	if _, err := agent.extClearSession(context.Background(), json.RawMessage(`{"sessionId":"s1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.starts) != 1 || backend.starts[0].Resume != "s1" {
+		t.Fatalf("expected the CLI restarted, got %+v", backend.starts)
+	}
+	if updates, turns := session.history.snapshot(); len(updates) != 0 || len(turns) != 0 {
+		t.Errorf("expected the history cleared, got %v", updates)
+	}
+	if session.checkpoints.popLast() != nil {
+		t.Error("expected the checkpoints cleared")
+	}
+
+	// Later restarts, as after the next cancel, resume the conversation
+	// /clear started.
+	session.process = &ClaudeCodeProcess{stdin: closedStdin(t)}
+	if err := session.restartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	if resume := backend.starts[1].Resume; resume != "c2" {
+		t.Errorf("restart resumed %q, want c2", resume)
+	}
+}
+
+// hungProcess is a CLI that never answers; closing it ends its output.
+type hungProcess struct {
+	idleProcess
+	closeOnce sync.Once
+}
+
+func (p *hungProcess) ReadMessage() (*SDKResponse, error) {
+	<-p.done
+	return nil, io.EOF
+}
+
+func (p *hungProcess) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return nil
+}
+
+func TestExtClearSession_Timeout(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	proc := &hungProcess{}
+	proc.done = make(chan struct{})
+	session := &Session{process: proc}
+	agent.sessions["s1"] = session
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := agent.extClearSession(ctx, json.RawMessage(`{"sessionId":"s1"}`)); err == nil {
+		t.Fatal("expected an error from a CLI that never finishes /clear")
+	}
+	select {
+	case <-proc.Done():
+	default:
+		t.Error("expected the CLI to be stopped")
+	}
+	if !session.turnMu.TryLock() {
+		t.Error("expected the session to be free for the next prompt")
+	}
+}
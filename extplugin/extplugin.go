@@ -0,0 +1,87 @@
+// Package extplugin lets other packages serve ACP extension methods and
+// notifications the agent does not handle itself, such as a client's
+// "_zed/..." extensions. A plugin package calls Register from an init
+// function; a blank import of it in the agent's main package installs it.
+package extplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// reservedPrefix is the agent's own extension namespace, which plugins may
+// not use.
+const reservedPrefix = "_claude/"
+
+// Plugin attaches handlers for extension methods and notifications. Method
+// names must start with "_" and must not use the agent's own "_claude/"
+// namespace.
+type Plugin struct {
+	Name string
+	// Methods handle requests; the result or error is sent as the response.
+	Methods map[string]Handler
+	// Notifications handle notifications; results are discarded and errors
+	// logged.
+	Notifications map[string]Handler
+}
+
+// Handler handles an extension call routed to a plugin. A returned
+// *acp.RequestError is sent to the client as-is; any other error becomes
+// an internal error.
+type Handler func(ctx context.Context, call Call) (any, error)
+
+// Call is an extension method or notification received from the client.
+type Call struct {
+	Method string
+	Params json.RawMessage
+	// SessionID is the params' sessionId, if any, and Session the session it
+	// names; Session is nil when the ID is missing or unknown.
+	SessionID string
+	Session   Session
+	// Notify sends an extension notification to the client.
+	Notify func(method string, params any) error
+}
+
+// Session is what a plugin can see of an agent session.
+type Session interface {
+	// Cwd returns the session's working directory.
+	Cwd() string
+}
+
+// Validate reports a method name the plugin may not handle.
+func (p Plugin) Validate() error {
+	for _, methods := range []map[string]Handler{p.Methods, p.Notifications} {
+		for method := range methods {
+			if !strings.HasPrefix(method, "_") || strings.HasPrefix(method, reservedPrefix) {
+				return fmt.Errorf("ext plugin %q: invalid method %q", p.Name, method)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	mu      sync.Mutex
+	plugins []Plugin
+)
+
+// Register adds plugin to the plugins the agent installs. It panics if the
+// plugin is invalid.
+func Register(plugin Plugin) {
+	if err := plugin.Validate(); err != nil {
+		panic(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	plugins = append(plugins, plugin)
+}
+
+// Registered returns the plugins added with Register, in order.
+func Registered() []Plugin {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Plugin(nil), plugins...)
+}
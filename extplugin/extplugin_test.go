@@ -0,0 +1,19 @@
+package extplugin
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPlugin_Validate(t *testing.T) {
+	noop := func(context.Context, Call) (any, error) { return nil, nil }
+	for _, method := range []string{"zed/echo", "_claude/echo"} {
+		p := Plugin{Name: "bad", Methods: map[string]Handler{method: noop}}
+		if p.Validate() == nil {
+			t.Errorf("expected %q to be rejected", method)
+		}
+	}
+	if err := (Plugin{Name: "zed", Notifications: map[string]Handler{"_zed/ping": noop}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// fileChangedMethod is the client notification that files changed outside
+// the agent's tools, such as edits in the editor. Clients that advertise it
+// in their capabilities' _meta promise to send it, which lets the built-in
+// tools cache file contents between calls.
+const fileChangedMethod = extMethodPrefix + "fs/did_change"
+
+// maxFileCacheBytes bounds the file contents cached per session.
+const maxFileCacheBytes = 32 << 20
+
+// maxChangedFileNotes bounds the changed files listed in one prompt.
+const maxChangedFileNotes = 50
+
+// maxTrackedFiles bounds the files whose outside changes are tracked per
+// session.
+const maxTrackedFiles = 1000
+
+// fileChangedParams is the payload of _claude/fs/did_change. A change
+// carrying the hash of the content the agent has cached, such as the echo
+// of the agent's own write, is ignored. A change carrying the new content
+// replaces the cached content instead of dropping it.
+type fileChangedParams struct {
+	SessionID string       `json:"sessionId"`
+	Changes   []fileChange `json:"changes"`
+}
+
+type fileChange struct {
+	Path    string  `json:"path"`
+	Hash    string  `json:"hash,omitempty"` // hex SHA-256 of the new content
+	Content *string `json:"content,omitempty"`
+}
+
+// fileCache holds the last content the built-in tools read or wrote per
+// path, so Read→Edit→Read sequences on large files do not transfer the
+// whole file each time. A nil cache caches nothing.
+type fileCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedFile
+	order   []string // oldest first, for eviction
+	size    int
+}
+
+type cachedFile struct {
+	content string
+	hash    string
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{entries: map[string]cachedFile{}}
+}
+
+// contentHash is the hash clients send in _claude/fs/did_change.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *fileCache) get(path string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.entries[path]
+	return f.content, ok
+}
+
+// put caches a file's content, evicting the oldest entries past
+// maxFileCacheBytes. Files larger than that are not cached.
+func (c *fileCache) put(path, content string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(path)
+	if len(content) > maxFileCacheBytes {
+		return
+	}
+	for c.size+len(content) > maxFileCacheBytes {
+		c.removeLocked(c.order[0])
+	}
+	c.entries[path] = cachedFile{content: content, hash: contentHash(content)}
+	c.order = append(c.order, path)
+	c.size += len(content)
+}
+
+// current reports whether hash is that of the cached content of path.
+func (c *fileCache) current(path, hash string) bool {
+	if c == nil || hash == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.entries[path]
+	return ok && f.hash == hash
+}
+
+func (c *fileCache) invalidate(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(path)
+}
+
+// clear drops every entry, after commands that may have changed any file.
+func (c *fileCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.order, c.size = nil, 0
+}
+
+// stats returns the number of cached files and their total size.
+func (c *fileCache) stats() (files, bytes int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+func (c *fileCache) removeLocked(path string) {
+	f, ok := c.entries[path]
+	if !ok {
+		return
+	}
+	delete(c.entries, path)
+	c.order = slices.DeleteFunc(c.order, func(p string) bool { return p == path })
+	c.size -= len(f.content)
+}
+
+// changedFiles tracks the files changed outside the agent since the model
+// last read or wrote them, so the next prompt can tell the model to read
+// them again before editing. Only files the model has read or written are
+// tracked, at most maxTrackedFiles of them, the least recently seen
+// forgotten first.
+type changedFiles struct {
+	mu    sync.Mutex
+	known map[string]string // hash of the agent's last write, or "" if only read
+	order []string          // known paths, least recently seen first
+	paths []string
+	noted map[string]bool // listed in a prompt but not read since
+}
+
+// add notes a change to path reported by the client. Changes to files the
+// model has not seen, and echoes of the agent's own last write, are
+// ignored.
+func (c *changedFiles) add(path, hash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written, ok := c.known[path]
+	if !ok || hash != "" && hash == written {
+		return
+	}
+	if !slices.Contains(c.paths, path) {
+		c.paths = append(c.paths, path)
+	}
+	delete(c.noted, path)
+}
+
+// seen forgets the changes to a file the model has read.
+func (c *changedFiles) seen(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackLocked(path, c.known[path])
+}
+
+// wrote forgets the changes to a file the agent has written with content.
+func (c *changedFiles) wrote(path, content string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackLocked(path, contentHash(content))
+}
+
+func (c *changedFiles) trackLocked(path, hash string) {
+	if c.known == nil {
+		c.known = map[string]string{}
+	}
+	if _, ok := c.known[path]; ok {
+		c.order = slices.DeleteFunc(c.order, func(p string) bool { return p == path })
+	}
+	c.known[path] = hash
+	c.order = append(c.order, path)
+	c.forgetLocked(path)
+	for len(c.order) > maxTrackedFiles {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.known, oldest)
+		c.forgetLocked(oldest)
+	}
+}
+
+func (c *changedFiles) forgetLocked(path string) {
+	c.paths = slices.DeleteFunc(c.paths, func(p string) bool { return p == path })
+	delete(c.noted, path)
+}
+
+// stale reports whether a file changed outside the agent since the model
+// last read it.
+func (c *changedFiles) stale(path string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.paths, path) || c.noted[path]
+}
+
+// take returns a note listing the changed files for the next prompt. ok is
+// false if there are none.
+func (c *changedFiles) take(cwd string) (block acp.ContentBlock, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paths) == 0 {
+		return acp.ContentBlock{}, false
+	}
+	if c.noted == nil {
+		c.noted = map[string]bool{}
+	}
+	var sb strings.Builder
+	sb.WriteString("<system-reminder>\nThese files were changed outside of this conversation since you last read them. Read them again before editing them.\n")
+	for _, path := range c.paths {
+		c.noted[path] = true
+	}
+	for i, path := range c.paths {
+		if i == maxChangedFileNotes {
+			fmt.Fprintf(&sb, "(%d more)\n", len(c.paths)-i)
+			break
+		}
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		sb.WriteString("- " + path + "\n")
+	}
+	sb.WriteString("</system-reminder>")
+	c.paths = nil
+	return acp.TextBlock(sb.String()), true
+}
+
+// extFilesChanged updates the cached contents of files the client reports
+// changed, and notes the files for the next prompt.
+func (a *ClaudeAcpAgent) extFilesChanged(_ context.Context, params json.RawMessage) (any, error) {
+	var p fileChangedParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	files := session.toolOptions.files
+	for _, ch := range p.Changes {
+		hash := ch.Hash
+		if ch.Content != nil {
+			hash = contentHash(*ch.Content)
+		}
+		if files.current(ch.Path, hash) {
+			continue
+		}
+		if ch.Content != nil {
+			files.put(ch.Path, *ch.Content)
+		} else {
+			files.invalidate(ch.Path)
+		}
+		session.changedFiles.add(ch.Path, hash)
+	}
+	return nil, nil
+}
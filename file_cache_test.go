@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestFileCache(t *testing.T) {
+	c := newFileCache()
+	c.put("/a", "one")
+	c.put("/b", "two")
+	if got, ok := c.get("/a"); !ok || got != "one" {
+		t.Fatalf("get = %q, %v", got, ok)
+	}
+
+	if !c.current("/a", contentHash("one")) || c.current("/b", contentHash("other")) {
+		t.Error("current compares the wrong hashes")
+	}
+	c.invalidate("/b")
+	if _, ok := c.get("/b"); ok {
+		t.Error("invalidated entry kept")
+	}
+
+	big := strings.Repeat("x", maxFileCacheBytes/2+1)
+	c.put("/big1", big)
+	c.put("/big2", big)
+	if _, ok := c.get("/big1"); ok {
+		t.Error("oldest entry not evicted")
+	}
+	if _, ok := c.get("/big2"); !ok || c.size > maxFileCacheBytes {
+		t.Errorf("size = %d", c.size)
+	}
+	c.clear()
+	if _, ok := c.get("/big2"); ok || c.size != 0 {
+		t.Error("clear kept entries")
+	}
+
+	var nilCache *fileCache
+	nilCache.put("/a", "one")
+	if _, ok := nilCache.get("/a"); ok {
+		t.Error("nil cache cached")
+	}
+}
+
+func TestFileCache_ReadEditRead(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	defer c2aW.Close()
+	defer a2cW.Close()
+	client := newMockClient()
+	client.files["/src/main.go"] = "package main\n\nfunc main() {}\n"
+	acp.NewClientSideConnection(client, c2aW, a2cR)
+	agent := NewClaudeAcpAgent(logger, AgentOptions{})
+	conn := newAgentConnection(agent, a2cW, c2aR, logger)
+	agent.sessions["s1"] = &Session{toolOptions: BuiltinToolOptions{files: newFileCache()}}
+	opts := agent.sessions["s1"].toolOptions
+	ctx := context.Background()
+
+	read := func(input map[string]any) string {
+		t.Helper()
+		out, isErr, _ := handleRead(ctx, conn, "s1", input, opts)
+		if isErr {
+			t.Fatal(out)
+		}
+		return out
+	}
+	read(map[string]any{"file_path": "/src/main.go"})
+	if out, isErr, _ := handleEdit(ctx, conn, "s1", map[string]any{"file_path": "/src/main.go", "old_string": "func main() {}", "new_string": "func main() { run() }"}, opts); isErr {
+		t.Fatal(out)
+	}
+	if out := read(map[string]any{"file_path": "/src/main.go", "offset": float64(3)}); !strings.Contains(out, "run()") {
+		t.Errorf("read after edit: %q", out)
+	}
+	if client.reads != 1 {
+		t.Errorf("client reads = %d, want 1", client.reads)
+	}
+
+	// The client reports an edit in the editor.
+	params, _ := json.Marshal(fileChangedParams{SessionID: "s1", Changes: []fileChange{{Path: "/src/main.go"}}})
+	if _, err := agent.extFilesChanged(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	read(map[string]any{"file_path": "/src/main.go"})
+	if client.reads != 2 {
+		t.Errorf("client reads = %d, want 2", client.reads)
+	}
+}
+
+func TestFilesChanged_Notes(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	session := &Session{cwd: "/work", toolOptions: BuiltinToolOptions{files: newFileCache()}}
+	session.toolOptions.changes = &session.changedFiles
+	agent.sessions["s1"] = session
+	session.toolOptions.files.put("/work/b.go", "old")
+	session.changedFiles.wrote("/work/a.go", "own write")
+	for _, path := range []string{"/work/b.go", "/work/c.go"} {
+		session.changedFiles.seen(path)
+	}
+
+	// a.go is the echo of the agent's own write, which is no longer
+	// cached, and d.go was never read.
+	content := "new"
+	params, _ := json.Marshal(fileChangedParams{SessionID: "s1", Changes: []fileChange{
+		{Path: "/work/a.go", Hash: contentHash("own write")},
+		{Path: "/work/b.go", Content: &content},
+		{Path: "/work/c.go"},
+		{Path: "/work/d.go"},
+	}})
+	if _, err := agent.extFilesChanged(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := session.toolOptions.files.get("/work/b.go"); got != "new" {
+		t.Errorf("cached b.go = %q", got)
+	}
+	block, ok := session.changedFiles.take(session.cwd)
+	if !ok || block.Text == nil || strings.Contains(block.Text.Text, "a.go") ||
+		strings.Contains(block.Text.Text, "d.go") || !strings.Contains(block.Text.Text, "- b.go\n- c.go\n") {
+		t.Fatalf("note = %+v", block.Text)
+	}
+	if _, ok := session.changedFiles.take(session.cwd); ok {
+		t.Error("note repeated")
+	}
+
+	// An edit that fails on a noted file says why it may have.
+	out, isErr, _ := handleEdit(context.Background(), nil, "s1", map[string]any{"file_path": "/work/b.go", "old_string": "old", "new_string": "x"}, session.toolOptions)
+	if !isErr || !strings.Contains(out, "changed outside of this conversation") {
+		t.Errorf("edit: %q", out)
+	}
+}
+
+func TestChangedFiles_Bounded(t *testing.T) {
+	var c changedFiles
+	for i := range maxTrackedFiles + 1 {
+		path := fmt.Sprintf("/work/%d.go", i)
+		c.seen(path)
+		c.add(path, "")
+	}
+	if _, ok := c.take("/work"); !ok {
+		t.Fatal("no note")
+	}
+	if len(c.known) != maxTrackedFiles || len(c.noted) != maxTrackedFiles {
+		t.Errorf("tracked %d, noted %d", len(c.known), len(c.noted))
+	}
+	if c.stale("/work/0.go") || !c.stale("/work/1.go") {
+		t.Error("least recently seen file not forgotten")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// lspReader converts a stream of Content-Length framed messages, as used by
+// the Language Server Protocol, into ndjson. Messages larger than
+// MaxMessageSize are rejected before their body is allocated.
+type lspReader struct {
+	r   *bufio.Reader
+	buf []byte // unread part of the current message
+}
+
+func newLSPReader(r io.Reader) *lspReader {
+	return &lspReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (l *lspReader) Read(p []byte) (int, error) {
+	if len(l.buf) == 0 {
+		msg, err := l.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		// Messages are single JSON values; drop newlines so each occupies
+		// one ndjson line.
+		msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte(" "))
+		msg = bytes.ReplaceAll(msg, []byte("\n"), []byte(" "))
+		l.buf = append(msg, '\n')
+	}
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
+
+// readMessage reads the headers and body of the next message.
+func (l *lspReader) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := l.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("read message header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if length < 0 {
+				// Tolerate blank lines between messages.
+				continue
+			}
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid message header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+			if n > MaxMessageSize {
+				return nil, fmt.Errorf("Content-Length %d exceeds the %d byte message limit", n, MaxMessageSize)
+			}
+			length = n
+		}
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(l.r, msg); err != nil {
+		return nil, fmt.Errorf("read message body: %w", err)
+	}
+	return msg, nil
+}
+
+// lspWriter frames each ndjson line written to it with a Content-Length
+// header. Partial lines are held until their newline arrives.
+type lspWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending []byte
+}
+
+func newLSPWriter(w io.Writer) *lspWriter {
+	return &lspWriter{w: w}
+}
+
+func (l *lspWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		msg := bytes.TrimSuffix(l.pending[:i], []byte("\r"))
+		if len(bytes.TrimSpace(msg)) > 0 {
+			frame := fmt.Appendf(nil, "Content-Length: %d\r\n\r\n", len(msg))
+			if _, err := l.w.Write(append(frame, msg...)); err != nil {
+				return 0, err
+			}
+		}
+		l.pending = l.pending[i+1:]
+	}
+}
+
+// stdioFraming wraps the stdio streams for the given framing: "ndjson"
+// (the default) or "lsp".
+func stdioFraming(framing string, in io.Reader, out io.Writer) (io.Reader, io.Writer, error) {
+	switch framing {
+	case "", "ndjson":
+		return in, out, nil
+	case "lsp":
+		return newLSPReader(in), newLSPWriter(out), nil
+	}
+	return nil, nil, fmt.Errorf("unknown framing %q (want ndjson or lsp)", framing)
+}
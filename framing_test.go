@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLSPReader(t *testing.T) {
+	body1 := "{\n  \"id\": 1\n}"
+	body2 := `{"id":2}`
+	input := "Content-Length: 13\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n" + body1 +
+		"content-length:8\r\n\r\n" + body2
+	got, err := io.ReadAll(newLSPReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{   \"id\": 1 }\n{\"id\":2}\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{
+		"Content-Length: x\r\n\r\n",
+		"Content-Length: 10\r\n\r\n{}",
+		"garbage\r\n\r\n",
+		"Content-Length: 4611686018427387904\r\n\r\n{}",
+		fmt.Sprintf("Content-Length: %d\r\n\r\n{}", MaxMessageSize+1),
+	} {
+		if _, err := io.ReadAll(newLSPReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestLSPWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newLSPWriter(&out)
+	for _, chunk := range []string{`{"id":1}` + "\n" + `{"id"`, `:2}` + "\n"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	if want := "Content-Length: 8\r\n\r\n{\"id\":1}Content-Length: 8\r\n\r\n{\"id\":2}"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestStdioFraming_LSPConnection(t *testing.T) {
+	if _, _, err := stdioFraming("xml", nil, nil); err == nil {
+		t.Error("expected an error for unknown framing")
+	}
+
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	t.Cleanup(func() {
+		c2aW.Close()
+		a2cW.Close()
+	})
+	in, out, err := stdioFraming("lsp", c2aR, a2cW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	newAgentConnection(agent, out, in, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	req := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":1,"clientCapabilities":{}}}`
+	go io.WriteString(c2aW, fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(req), req))
+	msg, err := newLSPReader(a2cR).readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(msg, []byte(`"protocolVersion":1`)) {
+		t.Errorf("unexpected response %s", msg)
+	}
+}
@@ -5,7 +5,17 @@ go 1.25.0
 replace github.com/coder/acp-go-sdk => /tmp/acp-go-sdk
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/coder/acp-go-sdk v0.6.3
 	github.com/gobwas/glob v0.2.3
 	github.com/gorilla/websocket v1.5.3
+	github.com/modelcontextprotocol/go-sdk v1.0.0
+	golang.org/x/net v0.57.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/google/jsonschema-go v0.3.0 // indirect
+	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
+	golang.org/x/text v0.40.0 // indirect
 )
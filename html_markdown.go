@@ -0,0 +1,332 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToMarkdown converts an HTML page to markdown for the model, keeping
+// headings, links, lists, code and tables and dropping scripts, styles and
+// the document head. Relative links are resolved against base.
+func htmlToMarkdown(src string, base *url.URL) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	w := &markdownWriter{base: base, lineStart: true}
+	w.node(doc)
+	return strings.TrimSpace(w.sb.String()) + "\n", nil
+}
+
+// markdownWriter renders HTML nodes as markdown. Whitespace in text is
+// collapsed, and the blank lines between blocks are only written when more
+// content follows, so nesting never piles up empty lines.
+type markdownWriter struct {
+	sb     strings.Builder
+	base   *url.URL
+	prefix string // written at the start of each line: "> " and list indents
+
+	newlines    int    // line breaks owed before the next content
+	blankPrefix string // the prefix when they were owed, for blank lines
+	space       bool   // a space is owed before the next content
+	lineStart   bool   // nothing but the prefix or a list marker on this line
+	listDepth   int
+}
+
+// block ends the current block with a blank line.
+func (w *markdownWriter) block() {
+	if !w.lineStart {
+		w.owe(2)
+	}
+}
+
+// lineBreak ends the current line.
+func (w *markdownWriter) lineBreak() {
+	if !w.lineStart {
+		w.owe(1)
+	}
+}
+
+// owe adds line breaks before the next content, up to n in all.
+func (w *markdownWriter) owe(n int) {
+	if w.newlines == 0 {
+		w.blankPrefix = w.prefix
+	}
+	w.newlines = max(w.newlines, n)
+}
+
+// flush writes the owed line breaks or space before content.
+func (w *markdownWriter) flush() {
+	if w.newlines > 0 {
+		for i := range w.newlines {
+			w.sb.WriteByte('\n')
+			if i < w.newlines-1 {
+				w.sb.WriteString(strings.TrimRightFunc(w.blankPrefix, unicode.IsSpace))
+			}
+		}
+		w.sb.WriteString(w.prefix)
+		w.newlines, w.space = 0, false
+		return
+	}
+	if w.sb.Len() == 0 {
+		w.sb.WriteString(w.prefix)
+		w.space = false
+	}
+	if w.space && !w.lineStart {
+		w.sb.WriteByte(' ')
+	}
+	w.space = false
+}
+
+// word writes s as is.
+func (w *markdownWriter) word(s string) {
+	if s == "" {
+		return
+	}
+	w.flush()
+	w.sb.WriteString(s)
+	w.lineStart = false
+}
+
+// text writes s with its whitespace collapsed.
+func (w *markdownWriter) text(s string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	if unicode.IsSpace(rune(s[0])) {
+		w.space = true
+	}
+	w.word(strings.Join(fields, " "))
+	w.space = unicode.IsSpace(rune(s[len(s)-1]))
+}
+
+// inline renders n's children on their own and returns them trimmed, for
+// wrapping in emphasis or link syntax.
+func (w *markdownWriter) inline(n *html.Node) string {
+	sub := &markdownWriter{base: w.base, lineStart: true}
+	sub.children(n)
+	return strings.TrimSpace(sub.sb.String())
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := range n.ChildNodes() {
+		w.node(c)
+	}
+}
+
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Iframe, atom.Object, atom.Button, atom.Select:
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		if text := w.inline(n); text != "" {
+			w.block()
+			w.word(strings.Repeat("#", level) + " " + strings.Join(strings.Fields(text), " "))
+			w.block()
+		}
+	case atom.Br:
+		w.owe(1)
+	case atom.Hr:
+		w.block()
+		w.word("---")
+		w.block()
+	case atom.Strong, atom.B:
+		w.wrap(n, "**")
+	case atom.Em, atom.I:
+		w.wrap(n, "_")
+	case atom.Code, atom.Kbd, atom.Samp:
+		w.wrap(n, "`")
+	case atom.Pre:
+		w.pre(n)
+	case atom.A:
+		w.link(n)
+	case atom.Img:
+		if src := w.resolve(attr(n, "src")); src != "" {
+			w.word("![" + attr(n, "alt") + "](" + src + ")")
+		}
+	case atom.Ul, atom.Ol:
+		w.list(n)
+	case atom.Blockquote:
+		w.block()
+		saved := w.prefix
+		w.prefix += "> "
+		w.children(n)
+		w.prefix = saved
+		w.blankPrefix = saved // the blank line after the quote is outside it
+		w.block()
+	case atom.Table:
+		w.table(n)
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav,
+		atom.Aside, atom.Form, atom.Figure, atom.Figcaption, atom.Dl, atom.Dt, atom.Dd, atom.Details, atom.Summary:
+		w.block()
+		w.children(n)
+		w.block()
+	default:
+		w.children(n)
+	}
+}
+
+// wrap writes n's content between marker, as in **bold**.
+func (w *markdownWriter) wrap(n *html.Node, marker string) {
+	text := w.inline(n)
+	if text == "" {
+		return
+	}
+	if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.TrimLeftFunc(c.Data, unicode.IsSpace) != c.Data {
+		w.space = true
+	}
+	w.word(marker + text + marker)
+}
+
+func (w *markdownWriter) link(n *html.Node) {
+	text := w.inline(n)
+	href := attr(n, "href")
+	if strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+		href = ""
+	}
+	href = w.resolve(href)
+	switch {
+	case text == "":
+	case href == "":
+		w.word(text)
+	default:
+		w.word("[" + text + "](" + href + ")")
+	}
+}
+
+func (w *markdownWriter) pre(n *html.Node) {
+	lang := ""
+	for c := range n.Descendants() {
+		if c.DataAtom == atom.Code {
+			for class := range strings.FieldsSeq(attr(c, "class")) {
+				if l, ok := strings.CutPrefix(class, "language-"); ok {
+					lang = l
+				}
+			}
+			break
+		}
+	}
+	var code strings.Builder
+	for c := range n.Descendants() {
+		if c.Type == html.TextNode {
+			code.WriteString(c.Data)
+		}
+	}
+	w.block()
+	w.word("```" + lang)
+	for line := range strings.SplitSeq(strings.Trim(code.String(), "\n"), "\n") {
+		w.owe(1)
+		w.flush()
+		w.sb.WriteString(strings.TrimRightFunc(line, unicode.IsSpace))
+	}
+	w.owe(1)
+	w.word("```")
+	w.block()
+}
+
+func (w *markdownWriter) list(n *html.Node) {
+	if w.listDepth == 0 {
+		w.block()
+	} else {
+		w.lineBreak()
+	}
+	w.listDepth++
+	i := 0
+	for li := range n.ChildNodes() {
+		if li.DataAtom != atom.Li {
+			continue
+		}
+		i++
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(i) + ". "
+		}
+		w.owe(1)
+		w.word(marker)
+		w.lineStart = true
+		saved := w.prefix
+		w.prefix += strings.Repeat(" ", len(marker))
+		w.children(li)
+		w.prefix = saved
+	}
+	w.listDepth--
+	if w.listDepth == 0 {
+		w.block()
+	} else {
+		w.lineBreak()
+	}
+}
+
+func (w *markdownWriter) table(n *html.Node) {
+	var rows [][]string
+	for tr := range n.Descendants() {
+		if tr.DataAtom != atom.Tr {
+			continue
+		}
+		var cells []string
+		for td := range tr.ChildNodes() {
+			if td.DataAtom == atom.Td || td.DataAtom == atom.Th {
+				cell := strings.Join(strings.Fields(w.inline(td)), " ")
+				cells = append(cells, strings.ReplaceAll(cell, "|", `\|`))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	w.block()
+	for i, cells := range rows {
+		w.lineBreak()
+		w.word("| " + strings.Join(cells, " | ") + " |")
+		if i == 0 {
+			w.lineBreak()
+			w.word("|" + strings.Repeat(" --- |", len(cells)))
+		}
+	}
+	w.block()
+}
+
+// resolve makes href absolute against the page URL.
+func (w *markdownWriter) resolve(href string) string {
+	if href == "" || w.base == nil {
+		return href
+	}
+	u, err := w.base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// httpBridge serves a REST API over a single ClaudeAcpAgent for scripts and
+// CI bots that do not speak ACP:
+//
+//	POST /sessions              {"cwd": "/repo", "mode": "acceptEdits"}
+//	POST /sessions/{id}/prompt  {"prompt": "Fix the failing test"}
+//	POST /sessions/{id}/cancel
+//	POST /sessions/{id}/respond {"id": 3, "result": {...}} or {"id": 3, "error": {...}}
+//
+// A prompt request that accepts text/event-stream receives the session's
+// notifications as server-sent events named after their method, followed
+// by a "done" event with the stop reason or an "error" event. Otherwise the
+// notifications are collected into the JSON response.
+//
+// Request bodies must be application/json, which browsers cannot send to
+// another origin without a CORS preflight the bridge never allows.
+//
+// Requests the agent makes of its client, such as session/request_permission,
+// reach a streaming prompt as "request" events carrying the JSON-RPC id,
+// method and params; the script answers them with POST .../respond. Requests
+// with no streaming prompt to answer them fail at once.
+//
+// The bridge is the agent's client: it reads the messages the agent writes
+// and calls the agent directly. It advertises no file system or terminal
+// capabilities, so the agent works on local files.
+type httpBridge struct {
+	agent   acp.Agent
+	logger  *slog.Logger
+	replies io.Writer // the agent connection's input, for answers to its requests
+
+	mu      sync.Mutex
+	streams map[string]*httpStream // by session ID, while a prompt runs
+	pending map[string]string      // session ID by unanswered request ID
+}
+
+// httpStream buffers one prompt's events for its HTTP handler, so a slow
+// reader holds up only its own session.
+type httpStream struct {
+	sse bool // whether the handler can pass requests on
+
+	mu     sync.Mutex
+	events []httpEvent
+	ready  chan struct{} // signalled when events are added
+}
+
+func newHTTPStream(sse bool) *httpStream {
+	return &httpStream{sse: sse, ready: make(chan struct{}, 1)}
+}
+
+func (s *httpStream) push(ev httpEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns and forgets the buffered events.
+func (s *httpStream) take() []httpEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// httpEvent is a notification or request the agent sent to the client. ID
+// is set for requests.
+type httpEvent struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// newHTTPBridge connects a new agent to the bridge and initializes it.
+func newHTTPBridge(logger *slog.Logger, opts AgentOptions) (*httpBridge, error) {
+	agent := NewClaudeAcpAgent(logger, opts)
+	// The bridge calls the agent directly, so the connection's input only
+	// carries answers to the agent's requests.
+	input, replies := io.Pipe()
+	b := &httpBridge{
+		agent:   recoveringAgent{agent: agent, logger: logger},
+		logger:  logger,
+		replies: replies,
+		streams: make(map[string]*httpStream),
+		pending: make(map[string]string),
+	}
+	newAgentConnection(agent, b, input, logger)
+	if _, err := b.agent.Initialize(context.Background(), acp.InitializeRequest{ProtocolVersion: acp.ProtocolVersionNumber}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Write receives the messages the agent sends to its client, one per call,
+// and buffers them in the stream of their session. The agent writes a
+// prompt's notifications before its response, so the handler has them all
+// once the prompt returns.
+func (b *httpBridge) Write(p []byte) (int, error) {
+	var msg httpEvent
+	if err := json.Unmarshal(p, &msg); err != nil || msg.Method == "" {
+		return len(p), nil
+	}
+	var target struct {
+		SessionID string `json:"sessionId"`
+	}
+	_ = json.Unmarshal(msg.Params, &target)
+	b.mu.Lock()
+	stream := b.streams[target.SessionID]
+	if msg.ID != nil && stream != nil && stream.sse {
+		b.pending[string(*msg.ID)] = target.SessionID
+	}
+	b.mu.Unlock()
+	switch {
+	case msg.ID != nil && (stream == nil || !stream.sse):
+		b.logger.Warn("HTTP bridge has no client to answer agent request", "method", msg.Method)
+		go b.reply(*msg.ID, nil, acp.NewMethodNotFound(msg.Method))
+	case stream != nil:
+		stream.push(msg)
+	}
+	return len(p), nil
+}
+
+// reply answers an agent request with a result or an error.
+func (b *httpBridge) reply(id json.RawMessage, result json.RawMessage, reqErr *acp.RequestError) {
+	msg := map[string]any{"jsonrpc": "2.0", "id": id}
+	if reqErr != nil {
+		msg["error"] = reqErr
+	} else {
+		msg["result"] = result
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	_, _ = b.replies.Write(append(data, '\n'))
+}
+
+// failPending fails the session's unanswered requests once its prompt's
+// stream ends.
+func (b *httpBridge) failPending(sessionID string) {
+	b.mu.Lock()
+	var ids []string
+	for id, sid := range b.pending {
+		if sid == sessionID {
+			ids = append(ids, id)
+			delete(b.pending, id)
+		}
+	}
+	b.mu.Unlock()
+	for _, id := range ids {
+		go b.reply(json.RawMessage(id), nil, acp.NewInternalError(map[string]any{"error": "the prompt's event stream ended"}))
+	}
+}
+
+func (b *httpBridge) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", b.handleNewSession)
+	mux.HandleFunc("POST /sessions/{id}/prompt", b.handlePrompt)
+	mux.HandleFunc("POST /sessions/{id}/cancel", b.handleCancel)
+	mux.HandleFunc("POST /sessions/{id}/respond", b.handleRespond)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !isJSONRequest(r) {
+			writeJSON(w, http.StatusUnsupportedMediaType, httpErrorBody(acp.NewInvalidRequest(map[string]any{"error": "Content-Type must be application/json"})))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isJSONRequest reports whether r declares an application/json body.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// httpNewSessionRequest is the body of POST /sessions. Cwd defaults to the
+// server's working directory.
+type httpNewSessionRequest struct {
+	acp.NewSessionRequest
+	Mode string `json:"mode,omitempty"`
+}
+
+func (b *httpBridge) handleNewSession(w http.ResponseWriter, r *http.Request) {
+	var req httpNewSessionRequest
+	if err := decodeHTTPBody(r, &req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if req.Cwd == "" {
+		req.Cwd, _ = os.Getwd()
+	}
+	if req.McpServers == nil {
+		req.McpServers = []acp.McpServer{}
+	}
+	resp, err := b.agent.NewSession(r.Context(), req.NewSessionRequest)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if req.Mode != "" {
+		_, err := b.agent.SetSessionMode(r.Context(), acp.SetSessionModeRequest{SessionId: resp.SessionId, ModeId: acp.SessionModeId(req.Mode)})
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		resp.Modes.CurrentModeId = acp.SessionModeId(req.Mode)
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// httpPromptRequest is the body of POST /sessions/{id}/prompt. Prompt is a
+// string or a list of ACP content blocks.
+type httpPromptRequest struct {
+	Prompt json.RawMessage `json:"prompt"`
+}
+
+func (b *httpBridge) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+	var req httpPromptRequest
+	if err := decodeHTTPBody(r, &req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	prompt, err := parseHTTPPrompt(req.Prompt)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	stream := newHTTPStream(sse)
+	b.mu.Lock()
+	if b.streams[sessionID] != nil {
+		b.mu.Unlock()
+		writeHTTPError(w, newAgentError(acp.NewInvalidRequest, errKindSessionBusy, sessionID, true, "a prompt is already running"))
+		return
+	}
+	b.streams[sessionID] = stream
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.streams, sessionID)
+		b.mu.Unlock()
+		b.failPending(sessionID)
+	}()
+
+	type result struct {
+		resp acp.PromptResponse
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := b.agent.Prompt(r.Context(), acp.PromptRequest{SessionId: acp.SessionId(sessionID), Prompt: prompt})
+		results <- result{resp, err}
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+	var events []httpEvent
+	deliver := func() {
+		for _, ev := range stream.take() {
+			switch {
+			case !sse:
+				events = append(events, ev)
+			case ev.ID != nil:
+				writeSSE(w, "request", ev)
+			default:
+				writeSSE(w, ev.Method, ev.Params)
+			}
+		}
+		if sse && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	for {
+		select {
+		case <-stream.ready:
+			deliver()
+		case res := <-results:
+			deliver()
+			switch {
+			case sse && res.err != nil:
+				writeSSE(w, "error", httpErrorBody(res.err))
+			case sse:
+				writeSSE(w, "done", res.resp)
+			case res.err != nil:
+				writeHTTPError(w, res.err)
+			default:
+				writeJSON(w, http.StatusOK, map[string]any{"stopReason": res.resp.StopReason, "updates": events})
+			}
+			return
+		}
+	}
+}
+
+// httpRespondRequest is the body of POST /sessions/{id}/respond: the answer
+// to a "request" event, with either a result or an error.
+type httpRespondRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *acp.RequestError `json:"error,omitempty"`
+}
+
+func (b *httpBridge) handleRespond(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+	var req httpRespondRequest
+	if err := decodeHTTPBody(r, &req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if req.Result == nil && req.Error == nil {
+		writeHTTPError(w, acp.NewInvalidParams(map[string]any{"error": "result or error is required"}))
+		return
+	}
+	b.mu.Lock()
+	owner, ok := b.pending[string(req.ID)]
+	if ok && owner == sessionID {
+		delete(b.pending, string(req.ID))
+	}
+	b.mu.Unlock()
+	if !ok || owner != sessionID {
+		writeHTTPError(w, acp.NewInvalidParams(map[string]any{"error": "no pending request " + string(req.ID)}))
+		return
+	}
+	b.reply(req.ID, req.Result, req.Error)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *httpBridge) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if err := b.agent.Cancel(r.Context(), acp.CancelNotification{SessionId: acp.SessionId(r.PathValue("id"))}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseHTTPPrompt accepts a prompt given as text or as content blocks.
+func parseHTTPPrompt(raw json.RawMessage) ([]acp.ContentBlock, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == "" {
+			return nil, acp.NewInvalidParams(map[string]any{"error": "prompt is empty"})
+		}
+		return []acp.ContentBlock{acp.TextBlock(text)}, nil
+	}
+	var blocks []acp.ContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil || len(blocks) == 0 {
+		return nil, acp.NewInvalidParams(map[string]any{"error": "prompt must be a string or a list of content blocks"})
+	}
+	return blocks, nil
+}
+
+func decodeHTTPBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return acp.NewInvalidParams(map[string]any{"error": "invalid JSON body: " + err.Error()})
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeSSE(w io.Writer, event string, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+// httpErrorBody wraps an agent error for a JSON response.
+func httpErrorBody(err error) map[string]any {
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) {
+		reqErr = acp.NewInternalError(map[string]any{"error": err.Error()})
+	}
+	return map[string]any{"error": reqErr}
+}
+
+// writeHTTPError responds with an agent error and a matching status code.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr *acp.RequestError
+	if errors.As(err, &reqErr) {
+		data, _ := reqErr.Data.(errorData)
+		switch {
+		case data.Kind == errKindSessionNotFound:
+			status = http.StatusNotFound
+		case data.Kind == errKindSessionBusy:
+			status = http.StatusConflict
+		case reqErr.Code == acp.NewAuthRequired(nil).Code:
+			status = http.StatusUnauthorized
+		case reqErr.Code == acp.NewInvalidParams(nil).Code, reqErr.Code == acp.NewInvalidRequest(nil).Code:
+			status = http.StatusBadRequest
+		}
+	}
+	writeJSON(w, status, httpErrorBody(err))
+}
+
+// RunHTTPServer serves the REST API on host:port. A non-empty token is
+// required from every client.
+func RunHTTPServer(host string, port int, token string, logger *slog.Logger, opts AgentOptions) error {
+	bridge, err := newHTTPBridge(logger, opts)
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("%s:%d", host, port)
+	logger.Info("HTTP API listening", "address", addr)
+	return http.ListenAndServe(addr, guardHTTPAPI(bridge.handler(), token, logger))
+}
+
+// guardHTTPAPI requires token from every request to api. Without a token,
+// only requests addressed to a loopback host name from no origin or a
+// loopback one are served, so web pages, including DNS-rebinding ones,
+// cannot drive the agent.
+func guardHTTPAPI(api http.Handler, token string, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !wsAuthorized(r, token) {
+			logger.Warn("Rejected unauthorized HTTP request", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if token == "" && (!isLoopbackHost(r.Host) || !isLoopbackOrigin(r.Header.Get("Origin"))) {
+			logger.Warn("Rejected non-local HTTP request", "remote", r.RemoteAddr, "host", r.Host, "origin", r.Header.Get("Origin"))
+			http.Error(w, "forbidden: set --ws-token to serve other hosts and origins", http.StatusForbidden)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackHost reports whether host, with or without a port, is
+// localhost or a loopback IP address.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackOrigin reports whether a request's Origin header is absent, as
+// from scripts, or names a loopback host.
+func isLoopbackOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && isLoopbackHost(u.Host)
+}
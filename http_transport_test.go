@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestHTTPBridge(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	bridge, err := newHTTPBridge(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(bridge.handler())
+	t.Cleanup(srv.Close)
+	agent := bridge.agent.(recoveringAgent).agent.(*ClaudeAcpAgent)
+	agent.sessions["s1"] = &Session{cwd: t.TempDir()}
+	return srv
+}
+
+func postJSON(t *testing.T, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHTTPBridge_PromptJSON(t *testing.T) {
+	srv := newTestHTTPBridge(t)
+	resp := postJSON(t, srv.URL+"/sessions/s1/prompt", `{"prompt":"# Run go vet before committing"}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var body struct {
+		StopReason string      `json:"stopReason"`
+		Updates    []httpEvent `json:"updates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.StopReason != "end_turn" || len(body.Updates) != 1 || body.Updates[0].Method != "session/update" {
+		t.Errorf("unexpected response %+v", body)
+	}
+}
+
+func TestHTTPBridge_PromptSSE(t *testing.T) {
+	srv := newTestHTTPBridge(t)
+	resp := postJSON(t, srv.URL+"/sessions/s1/prompt", `{"prompt":[{"type":"text","text":"# Prefer small commits"}]}`,
+		map[string]string{"Accept": "text/event-stream"})
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	events := strings.Split(strings.TrimSpace(string(data)), "\n\n")
+	if len(events) != 2 || !strings.HasPrefix(events[0], "event: session/update\n") ||
+		!strings.HasPrefix(events[1], "event: done\n") || !strings.Contains(events[1], `"stopReason":"end_turn"`) {
+		t.Errorf("unexpected events %q", data)
+	}
+}
+
+func TestHTTPBridge_Errors(t *testing.T) {
+	srv := newTestHTTPBridge(t)
+	if resp := postJSON(t, srv.URL+"/sessions/missing/prompt", `{"prompt":"hi"}`, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: status = %d", resp.StatusCode)
+	}
+	if resp := postJSON(t, srv.URL+"/sessions/s1/prompt", `{"prompt":42}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid prompt: status = %d", resp.StatusCode)
+	}
+	if resp := postJSON(t, srv.URL+"/sessions/missing/cancel", ``, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel unknown session: status = %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPBridge_RequiresJSON(t *testing.T) {
+	srv := newTestHTTPBridge(t)
+	// A text/plain POST is one a web page can send to any origin.
+	resp, err := http.Post(srv.URL+"/sessions", "text/plain", strings.NewReader(`{"cwd":"/tmp"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+}
+
+func TestGuardHTTPAPI(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name, token, host, origin, auth string
+		want                            int
+	}{
+		{"local script", "", "127.0.0.1:8080", "", "", http.StatusNoContent},
+		{"localhost page", "", "localhost:8080", "http://localhost:3000", "", http.StatusNoContent},
+		{"IPv6 loopback", "", "[::1]:8080", "", "", http.StatusNoContent},
+		{"rebound host name", "", "evil.example:8080", "", "", http.StatusForbidden},
+		{"other origin", "", "127.0.0.1:8080", "https://evil.example", "", http.StatusForbidden},
+		{"opaque origin", "", "127.0.0.1:8080", "null", "", http.StatusForbidden},
+		{"token", "s3cret", "agent.example:8080", "https://app.example", "Bearer s3cret", http.StatusNoContent},
+		{"wrong token", "s3cret", "127.0.0.1:8080", "", "Bearer nope", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/sessions", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		guardHTTPAPI(api, tt.token, logger).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+// replyRecorder collects the answers the bridge writes to the agent.
+type replyRecorder chan string
+
+func (r replyRecorder) Write(p []byte) (int, error) {
+	r <- string(p)
+	return len(p), nil
+}
+
+func TestHTTPBridge_AgentRequests(t *testing.T) {
+	replies := make(replyRecorder, 4)
+	bridge := &httpBridge{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		replies: replies,
+		streams: map[string]*httpStream{},
+		pending: map[string]string{},
+	}
+	request := `{"jsonrpc":"2.0","id":7,"method":"session/request_permission","params":{"sessionId":"s1"}}`
+
+	// With no streaming prompt to pass it to, a request fails at once.
+	bridge.Write([]byte(request))
+	if reply := <-replies; !strings.Contains(reply, `"id":7`) || !strings.Contains(reply, `"error"`) {
+		t.Errorf("unexpected reply %s", reply)
+	}
+
+	// A streaming prompt receives it as an event and answers it.
+	stream := newHTTPStream(true)
+	bridge.streams["s1"] = stream
+	bridge.Write([]byte(request))
+	if events := stream.take(); len(events) != 1 || events[0].ID == nil || string(*events[0].ID) != "7" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	srv := httptest.NewServer(bridge.handler())
+	defer srv.Close()
+	if resp := postJSON(t, srv.URL+"/sessions/s1/respond", `{"id":7,"result":{"outcome":{"outcome":"cancelled"}}}`, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if reply := <-replies; !strings.Contains(reply, `"id":7`) || !strings.Contains(reply, `"result":{"outcome"`) {
+		t.Errorf("unexpected reply %s", reply)
+	}
+	if resp := postJSON(t, srv.URL+"/sessions/s1/respond", `{"id":7,"result":{}}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("answering twice: status = %d", resp.StatusCode)
+	}
+}
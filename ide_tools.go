@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// Tools of the "ide" MCP server that editors register with Claude Code.
+const (
+	ideGetDiagnostics = "mcp__ide__getDiagnostics"
+	ideExecuteCode    = "mcp__ide__executeCode"
+)
+
+// ideDiagnostics is one file's entry in the getDiagnostics result. Ranges
+// follow LSP and are 0-based.
+type ideDiagnostics struct {
+	URI         string `json:"uri"`
+	Diagnostics []struct {
+		Message  string `json:"message"`
+		Severity string `json:"severity"`
+		Source   string `json:"source"`
+		Range    struct {
+			Start struct {
+				Line      int `json:"line"`
+				Character int `json:"character"`
+			} `json:"start"`
+		} `json:"range"`
+	} `json:"diagnostics"`
+}
+
+// ideToolInfo describes calls to the IDE MCP server's tools.
+func ideToolInfo(name string, input map[string]any) ToolInfo {
+	switch name {
+	case ideGetDiagnostics:
+		info := ToolInfo{Title: "Get Diagnostics", Kind: acp.ToolKindRead}
+		if uri := inputStr(input, "uri"); uri != "" {
+			path := fileURIPath(uri)
+			info.Title = "Get Diagnostics " + path
+			info.Locations = []acp.ToolCallLocation{{Path: path}}
+		}
+		return info
+	default: // ideExecuteCode
+		info := ToolInfo{Title: "Run Code in Kernel", Kind: acp.ToolKindExecute}
+		if code := inputStr(input, "code"); code != "" {
+			info.Content = []acp.ToolCallContent{acp.ToolContent(acp.TextBlock("```python\n" + code + "\n```"))}
+		}
+		return info
+	}
+}
+
+// diagnosticsToolUpdate renders a getDiagnostics result as a list of
+// findings, with a location for each so editors can jump to it. Results
+// that are not the expected JSON fall back to the generic rendering.
+func diagnosticsToolUpdate(content any) (ToolUpdate, bool) {
+	var text string
+	if s, ok := content.(string); ok {
+		text = s
+	} else if arr, ok := content.([]any); ok {
+		for _, item := range arr {
+			if m, ok := item.(map[string]any); ok && m["type"] == "text" {
+				t, _ := m["text"].(string)
+				text += t
+			}
+		}
+	}
+	var files []ideDiagnostics
+	if err := json.Unmarshal([]byte(text), &files); err != nil {
+		return ToolUpdate{}, false
+	}
+
+	var b strings.Builder
+	var locations []acp.ToolCallLocation
+	for _, f := range files {
+		path := fileURIPath(f.URI)
+		for _, d := range f.Diagnostics {
+			line := d.Range.Start.Line + 1
+			locations = append(locations, acp.ToolCallLocation{Path: path, Line: acp.Ptr(line)})
+			severity := d.Severity
+			if severity == "" {
+				severity = "Diagnostic"
+			}
+			fmt.Fprintf(&b, "- **%s** %s:%d:%d: %s", severity, path, line, d.Range.Start.Character+1, d.Message)
+			if d.Source != "" {
+				fmt.Fprintf(&b, " (%s)", d.Source)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(locations) == 0 {
+		return ToolUpdate{Content: []acp.ToolCallContent{acp.ToolContent(acp.TextBlock("No diagnostics."))}}, true
+	}
+	return ToolUpdate{
+		Content:   []acp.ToolCallContent{acp.ToolContent(acp.TextBlock(strings.TrimSuffix(b.String(), "\n")))},
+		Locations: locations,
+	}, true
+}
+
+// fileURIPath returns the path of a file:// URI, or uri unchanged if it is
+// not one.
+func fileURIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
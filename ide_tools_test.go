@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestToolInfoFromToolUse_IDE(t *testing.T) {
+	info := toolInfoFromToolUse(ideGetDiagnostics, map[string]any{"uri": "file:///src/main.go"})
+	if info.Title != "Get Diagnostics /src/main.go" || info.Kind != acp.ToolKindRead {
+		t.Errorf("unexpected diagnostics info: %+v", info)
+	}
+	if len(info.Locations) != 1 || info.Locations[0].Path != "/src/main.go" {
+		t.Errorf("expected location for the file, got %+v", info.Locations)
+	}
+
+	info = toolInfoFromToolUse(ideExecuteCode, map[string]any{"code": "print(1)"})
+	if info.Kind != acp.ToolKindExecute || len(info.Content) != 1 {
+		t.Errorf("unexpected executeCode info: %+v", info)
+	}
+}
+
+func TestToolUpdateFromToolResult_Diagnostics(t *testing.T) {
+	toolUse := &ToolUseEntry{Name: ideGetDiagnostics, ID: "d1"}
+	result := `[{"uri":"file:///src/main.go","diagnostics":[
+		{"message":"undefined: foo","severity":"Error","source":"compiler","range":{"start":{"line":9,"character":2},"end":{"line":9,"character":5}}},
+		{"message":"unused variable","severity":"Warning","range":{"start":{"line":20,"character":0},"end":{"line":20,"character":1}}}
+	]},{"uri":"file:///src/util.go","diagnostics":[]}]`
+
+	update := toolUpdateFromToolResult(map[string]any{
+		"content": []any{map[string]any{"type": "text", "text": result}},
+	}, toolUse)
+	if len(update.Locations) != 2 {
+		t.Fatalf("expected 2 locations, got %+v", update.Locations)
+	}
+	if loc := update.Locations[0]; loc.Path != "/src/main.go" || loc.Line == nil || *loc.Line != 10 {
+		t.Errorf("unexpected first location: %+v", loc)
+	}
+	text := update.Content[0].Content.Content.Text.Text
+	for _, want := range []string{
+		"- **Error** /src/main.go:10:3: undefined: foo (compiler)",
+		"- **Warning** /src/main.go:21:1: unused variable",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("content missing %q:\n%s", want, text)
+		}
+	}
+
+	update = toolUpdateFromToolResult(map[string]any{"content": "[]"}, toolUse)
+	if len(update.Content) != 1 || update.Content[0].Content.Content.Text.Text != "No diagnostics." {
+		t.Errorf("unexpected empty result rendering: %+v", update.Content)
+	}
+
+	update = toolUpdateFromToolResult(map[string]any{"content": "not json"}, toolUse)
+	if len(update.Content) != 1 || len(update.Locations) != 0 {
+		t.Errorf("expected generic rendering for non-JSON output, got %+v", update)
+	}
+}
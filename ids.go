@@ -0,0 +1,83 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// IDSource generates the IDs the agent hands out: session IDs, synthetic
+// tool call IDs, notebook cell IDs and edit markers. The default is random;
+// tests and recordings inject a seeded source so their output is stable.
+type IDSource interface {
+	// NewID returns a UUID.
+	NewID() string
+	// RandomString returns n random lowercase letters and digits.
+	RandomString(n int) string
+}
+
+// randomIDs is the default IDSource.
+type randomIDs struct{}
+
+func (randomIDs) NewID() string {
+	b := make([]byte, 16)
+	_, _ = crand.Read(b)
+	return formatUUID(b)
+}
+
+func (randomIDs) RandomString(n int) string {
+	return randomStringFrom(rand.Intn, n)
+}
+
+// seededIDs is a deterministic IDSource; see NewSeededIDSource.
+type seededIDs struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewSeededIDSource returns an IDSource producing the same sequence of IDs
+// for the same seed.
+func NewSeededIDSource(seed int64) IDSource {
+	return &seededIDs{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (s *seededIDs) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := make([]byte, 16)
+	_, _ = s.rng.Read(b)
+	return formatUUID(b)
+}
+
+func (s *seededIDs) RandomString(n int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return randomStringFrom(s.rng.Intn, n)
+}
+
+// idsOr returns ids, or the random source if it is nil.
+func idsOr(ids IDSource) IDSource {
+	if ids == nil {
+		return randomIDs{}
+	}
+	return ids
+}
+
+// formatUUID formats 16 random bytes as a version 4 UUID:
+// xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx.
+func formatUUID(b []byte) string {
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func randomStringFrom(intn func(int) int, n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[intn(len(letters))]
+	}
+	return string(b)
+}
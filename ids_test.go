@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestSeededIDSource(t *testing.T) {
+	a, b := NewSeededIDSource(42), NewSeededIDSource(42)
+	for range 3 {
+		id := a.NewID()
+		if !uuidPattern.MatchString(id) {
+			t.Errorf("%q is not a v4 UUID", id)
+		}
+		if other := b.NewID(); other != id {
+			t.Errorf("same seed: got %q and %q", id, other)
+		}
+		if s, other := a.RandomString(8), b.RandomString(8); s != other || len(s) != 8 {
+			t.Errorf("same seed: got %q and %q", s, other)
+		}
+	}
+	if NewSeededIDSource(1).NewID() == NewSeededIDSource(2).NewID() {
+		t.Error("different seeds gave the same ID")
+	}
+	if id := (randomIDs{}).NewID(); !uuidPattern.MatchString(id) {
+		t.Errorf("%q is not a v4 UUID", id)
+	}
+}
+
+func TestCompactionUsesIDSource(t *testing.T) {
+	c := compactionTracker{ids: NewSeededIDSource(7)}
+	n := c.start("s1")
+	want := "compaction-" + NewSeededIDSource(7).NewID()
+	if len(n) != 1 || string(n[0].Update.ToolCall.ToolCallId) != want {
+		t.Errorf("got %+v, want tool call %s", n, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -18,6 +19,7 @@ import (
 type mockClient struct {
 	mu             sync.Mutex
 	files          map[string]string
+	reads          int // ReadTextFile calls
 	sessionUpdates []acp.SessionNotification
 	permissionAuto bool // auto-allow permissions
 	terminals      map[string]*mockTerminal
@@ -43,9 +45,10 @@ func newMockClient() *mockClient {
 func (c *mockClient) ReadTextFile(_ context.Context, req acp.ReadTextFileRequest) (acp.ReadTextFileResponse, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.reads++
 	content, ok := c.files[req.Path]
 	if !ok {
-		return acp.ReadTextFileResponse{}, &acp.RequestError{Code: -32603, Message: "File not found: " + req.Path}
+		return acp.ReadTextFileResponse{}, &acp.RequestError{Code: resourceNotFoundCode, Message: "File not found: " + req.Path}
 	}
 	return acp.ReadTextFileResponse{Content: content}, nil
 }
@@ -143,10 +146,8 @@ func setupTestConnection(t *testing.T) (*acp.ClientSideConnection, *mockClient,
 	client := newMockClient()
 	clientConn := acp.NewClientSideConnection(client, c2aW, a2cR)
 	clientConn.SetLogger(logger)
-	agent := NewClaudeAcpAgent(logger)
-	agentConn := acp.NewAgentSideConnection(agent, a2cW, c2aR)
-	agentConn.SetLogger(logger)
-	agent.SetAgentConnection(agentConn)
+	agent := NewClaudeAcpAgent(logger, AgentOptions{})
+	newAgentConnection(agent, a2cW, c2aR, logger)
 	cleanup := func() {
 		c2aW.Close()
 		a2cW.Close()
@@ -222,6 +223,26 @@ func TestIntegration_AgentCapabilities(t *testing.T) {
 	}
 }
 
+func TestInitialize_SessionModesMeta(t *testing.T) {
+	for _, allowBypass := range []bool{true, false} {
+		agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+		agent.allowBypass = allowBypass
+		resp, err := agent.Initialize(context.Background(), acp.InitializeRequest{ProtocolVersion: acp.ProtocolVersionNumber})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modes := resp.Meta.(map[string]any)["claudeCode"].(map[string]any)["sessionModes"].(map[string]any)
+		available := modes["availableModes"].([]acp.SessionMode)
+		hasBypass := slices.ContainsFunc(available, func(m acp.SessionMode) bool { return m.Id == "bypassPermissions" })
+		if hasBypass != allowBypass || len(available) < 4 {
+			t.Errorf("allowBypass=%v: unexpected modes %v", allowBypass, available)
+		}
+		if _, ok := modes["bypassUnavailableReason"]; ok == allowBypass {
+			t.Errorf("allowBypass=%v: unexpected bypassUnavailableReason %v", allowBypass, modes["bypassUnavailableReason"])
+		}
+	}
+}
+
 // --- Tests requiring CLI ---
 
 func TestIntegration_NewSession(t *testing.T) {
@@ -0,0 +1,50 @@
+package main
+
+import "strings"
+
+// textFormat is the line ending style of an existing file, preserved when
+// Edit or Write rewrites it.
+type textFormat struct {
+	eol          string // dominant line ending, "\n" or "\r\n"
+	finalNewline bool   // whether the content ends with a line ending
+}
+
+// detectTextFormat returns the format of content. The line ending is the
+// one used by most lines; ties go to "\n".
+func detectTextFormat(content string) textFormat {
+	crlf := strings.Count(content, "\r\n")
+	lf := strings.Count(content, "\n") - crlf
+	f := textFormat{eol: "\n", finalNewline: strings.HasSuffix(content, "\n")}
+	if crlf > lf {
+		f.eol = "\r\n"
+	}
+	return f
+}
+
+// convert rewrites the bare "\n" line endings of text to f's line ending.
+// Text that already contains "\r\n" is returned unchanged.
+func (f textFormat) convert(text string) string {
+	if f.eol == "\n" || strings.Contains(text, "\r\n") {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", f.eol)
+}
+
+// fixFinalNewline adds or removes the final line ending of content so its
+// presence matches f.
+func (f textFormat) fixFinalNewline(content string) string {
+	hasNewline := strings.HasSuffix(content, "\n")
+	switch {
+	case f.finalNewline && !hasNewline && content != "":
+		return content + f.eol
+	case !f.finalNewline && hasNewline:
+		return strings.TrimSuffix(strings.TrimSuffix(content, "\n"), "\r")
+	}
+	return content
+}
+
+// normalizeLineEndings converts "\r\n" line endings to "\n", so diffs show
+// content changes rather than line ending noise.
+func normalizeLineEndings(content string) string {
+	return strings.ReplaceAll(content, "\r\n", "\n")
+}
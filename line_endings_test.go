@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectTextFormat(t *testing.T) {
+	tests := []struct {
+		content      string
+		eol          string
+		finalNewline bool
+	}{
+		{"a\nb\n", "\n", true},
+		{"a\r\nb\r\n", "\r\n", true},
+		{"a\r\nb\r\nc", "\r\n", false},
+		{"a\r\nb\nc\n", "\n", true},
+		{"", "\n", false},
+	}
+	for _, tt := range tests {
+		f := detectTextFormat(tt.content)
+		if f.eol != tt.eol || f.finalNewline != tt.finalNewline {
+			t.Errorf("detectTextFormat(%q) = %+v, want eol %q finalNewline %v", tt.content, f, tt.eol, tt.finalNewline)
+		}
+	}
+}
+
+func TestTextFormat_Apply(t *testing.T) {
+	crlf := textFormat{eol: "\r\n", finalNewline: true}
+	if got := crlf.convert("a\nb\n"); got != "a\r\nb\r\n" {
+		t.Errorf("convert = %q", got)
+	}
+	if got := crlf.convert("a\r\nb\n"); got != "a\r\nb\n" {
+		t.Errorf("convert should leave mixed text alone, got %q", got)
+	}
+	if got := crlf.fixFinalNewline("a\r\nb"); got != "a\r\nb\r\n" {
+		t.Errorf("fixFinalNewline = %q", got)
+	}
+	noFinal := textFormat{eol: "\r\n"}
+	if got := noFinal.fixFinalNewline("a\r\nb\r\n"); got != "a\r\nb" {
+		t.Errorf("fixFinalNewline = %q", got)
+	}
+}
+
+func TestHandleEdit_PreservesLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+
+	tests := []struct {
+		name    string
+		content string
+		input   map[string]any
+		want    string
+	}{
+		{
+			name:    "crlf",
+			content: "one\r\ntwo\r\nthree\r\n",
+			input:   map[string]any{"old_string": "one\ntwo", "new_string": "1\n2"},
+			want:    "1\r\n2\r\nthree\r\n",
+		},
+		{
+			name:    "crlf fuzzy",
+			content: "one  \r\ntwo\r\nthree\r\n",
+			input:   map[string]any{"old_string": "one\ntwo", "new_string": "1\n2"},
+			want:    "1\r\n2\r\nthree\r\n",
+		},
+		{
+			name:    "keeps final newline",
+			content: "one\ntwo\n",
+			input:   map[string]any{"old_string": "two\n", "new_string": "2"},
+			want:    "one\n2\n",
+		},
+		{
+			name:    "keeps missing final newline",
+			content: "one\r\ntwo",
+			input:   map[string]any{"old_string": "two", "new_string": "2\n"},
+			want:    "one\r\n2",
+		},
+		{
+			name:    "multi edit",
+			content: "one\r\ntwo\r\n",
+			input: map[string]any{"edits": []any{
+				map[string]any{"old_string": "one", "new_string": "1"},
+				map[string]any{"old_string": "1\ntwo", "new_string": "1\n2"},
+			}},
+			want: "1\r\n2\r\n",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "file"+string(rune('a'+i))+".txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			tt.input["file_path"] = path
+			out, isErr, err := handleEdit(context.Background(), nil, "s1", tt.input, BuiltinToolOptions{})
+			if err != nil || isErr {
+				t.Fatalf("handleEdit failed: %q, %v", out, err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWrite_PreservesLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("old\r\nfile\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, isErr, err := handleWrite(context.Background(), nil, "s1", map[string]any{"file_path": path, "content": "new\ncontent"}, BuiltinToolOptions{}); err != nil || isErr {
+		t.Fatalf("handleWrite failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "new\r\ncontent\r\n" {
+		t.Errorf("content = %q", data)
+	}
+
+	fresh := filepath.Join(dir, "new.txt")
+	if _, _, err := handleWrite(context.Background(), nil, "s1", map[string]any{"file_path": fresh, "content": "a\nb"}, BuiltinToolOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(fresh); string(data) != "a\nb" {
+		t.Errorf("new file content = %q", data)
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultSocketPath is a per-user socket in the temporary directory.
+func defaultSocketPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("claude-code-acp-%d.sock", os.Getuid()))
+}
+
+type unixListener struct{ *net.UnixListener }
+
+func (l unixListener) Accept() (io.ReadWriteCloser, error) {
+	return l.UnixListener.Accept()
+}
+
+// listenLocal listens on a unix domain socket readable and writable only by
+// the current user, replacing a stale socket file left by a previous run.
+func listenLocal(path string) (localListener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another agent", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// Create the socket with no group or other access from the start.
+	old := syscall.Umask(0o177)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return unixListener{ln}, nil
+}
@@ -0,0 +1,199 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// defaultSocketPath is the named pipe editors attach to.
+func defaultSocketPath() string {
+	return `\\.\pipe\claude-code-acp`
+}
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	advapi32             = syscall.NewLazyDLL("advapi32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW     = kernel32.NewProc("CreateEventW")
+	procGetOverlapped    = kernel32.NewProc("GetOverlappedResult")
+	procConvertStringSD  = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+const (
+	pipeAccessDuplex          = 0x00000003
+	fileFlagOverlapped        = 0x40000000
+	fileFlagFirstPipeInstance = 0x00080000
+	pipeTypeByte              = 0x00000000
+	pipeRejectRemoteClients   = 0x00000008
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 * 1024
+	errorPipeConnected        = syscall.Errno(535)
+	errorIOPending            = syscall.Errno(997)
+	sddlRevision1             = 1
+)
+
+// pipeListener accepts connections on a named pipe, creating a new pipe
+// instance for each client. Instances use overlapped I/O, which os.NewFile
+// hands to the runtime poller: with synchronous I/O a pending read on a
+// pipe handle blocks writes to it.
+type pipeListener struct {
+	path  string
+	attrs *syscall.SecurityAttributes
+
+	mu     sync.Mutex
+	next   syscall.Handle // instance waiting for the next client
+	closed bool
+}
+
+// listenLocal creates a named pipe whose DACL grants access to the current
+// user only. Remote clients are rejected.
+func listenLocal(path string) (localListener, error) {
+	attrs, err := currentUserSecurityAttributes()
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeListener{path: path, attrs: attrs}
+	if l.next, err = l.createInstance(true); err != nil {
+		syscall.LocalFree(syscall.Handle(attrs.SecurityDescriptor))
+		return nil, err
+	}
+	return l, nil
+}
+
+// currentUserSecurityAttributes returns security attributes with a
+// protected DACL allowing only the current user.
+func currentUserSecurityAttributes() (*syscall.SecurityAttributes, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil, err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return nil, err
+	}
+	sddl, err := syscall.UTF16PtrFromString("D:P(A;;GA;;;" + sid + ")")
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := procConvertStringSD.Call(uintptr(unsafe.Pointer(sddl)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("build pipe security descriptor: %w", err)
+	}
+	// The descriptor lives as long as the listener, whose Close frees it.
+	return &syscall.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(syscall.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+func (l *pipeListener) createInstance(first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	openMode := uint32(pipeAccessDuplex | fileFlagOverlapped)
+	if first {
+		// Fail if another process already owns the pipe name.
+		openMode |= fileFlagFirstPipeInstance
+	}
+	h, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(openMode),
+		pipeTypeByte|pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		uintptr(unsafe.Pointer(l.attrs)),
+	)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, fmt.Errorf("create named pipe %s: %w", l.path, err)
+	}
+	return syscall.Handle(h), nil
+}
+
+// Accept waits for a client on the current pipe instance and prepares the
+// next one.
+func (l *pipeListener) Accept() (io.ReadWriteCloser, error) {
+	l.mu.Lock()
+	h := l.next
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		return nil, net.ErrClosed
+	}
+
+	if err := connectNamedPipe(h); err != nil {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("connect named pipe: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	next, err := l.createInstance(false)
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	l.next = next
+	return os.NewFile(uintptr(h), l.path), nil
+}
+
+// connectNamedPipe waits for a client to open the overlapped pipe instance.
+func connectNamedPipe(h syscall.Handle) error {
+	event, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if event == 0 {
+		return err
+	}
+	defer syscall.CloseHandle(syscall.Handle(event))
+	var ov syscall.Overlapped
+	ov.HEvent = syscall.Handle(event)
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(&ov)))
+	switch {
+	case r != 0, err == errorPipeConnected:
+		return nil
+	case err != errorIOPending:
+		return err
+	}
+	var n uint32
+	if r, _, err := procGetOverlapped.Call(uintptr(h), uintptr(unsafe.Pointer(&ov)), uintptr(unsafe.Pointer(&n)), 1); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Close stops accepting clients. A pending Accept is woken by connecting to
+// the waiting instance.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	if f, err := os.OpenFile(l.path, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	// No instance is created once closed is set.
+	syscall.LocalFree(syscall.Handle(l.attrs.SecurityDescriptor))
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// localListener accepts connections on a unix domain socket or a Windows
+// named pipe that only the current user can open.
+type localListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// RunLocalServer accepts ACP connections on a local socket, an empty path
+// meaning defaultSocketPath. Like the WebSocket server, each connection
+// gets its own agent, and the agents share a session registry. Access is
+// limited by file or pipe permissions, so no token is needed.
+func RunLocalServer(path string, logger *slog.Logger, opts AgentOptions) error {
+	if path == "" {
+		path = defaultSocketPath()
+	}
+	ln, err := listenLocal(path)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	logger.Info("Local socket listening", "path", path)
+
+	hub := newSessionHub()
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			logger.Info("New local connection from client")
+			agent := NewClaudeAcpAgent(logger, opts)
+			agent.hub = hub
+			acpConn := newAgentConnection(agent, conn, conn, logger)
+			<-acpConn.Done()
+			agent.closeSessions()
+			logger.Info("Local connection closed")
+		}()
+	}
+}
@@ -0,0 +1,77 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenLocal_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acp.sock")
+	ln, err := listenLocal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+	if _, err := listenLocal(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected an in-use error, got %v", err)
+	}
+
+	// A socket left behind by a stopped agent is replaced.
+	ln.(unixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenLocal(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+
+	regular := filepath.Join(t.TempDir(), "file")
+	writeTestFile(t, regular, "x")
+	if _, err := listenLocal(regular); err == nil {
+		t.Error("expected an error for a path that is not a socket")
+	}
+}
+
+func TestRunLocalServer_Initialize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acp.sock")
+	go RunLocalServer(path, slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":1,"clientCapabilities":{}}}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if !strings.Contains(line, `"protocolVersion":1`) {
+		t.Errorf("unexpected response %s", line)
+	}
+}
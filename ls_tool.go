@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listDirectoryMethod is the client extension request listing a directory.
+// Clients advertise it in their capabilities' _meta; without it, the CLI's
+// own LS is used instead.
+const listDirectoryMethod = extMethodPrefix + "fs/list_directory"
+
+// LS limits.
+const (
+	defaultLSDepth = 1
+	maxLSDepth     = 5
+	maxLSEntries   = 1000
+)
+
+// listDirectoryParams is the payload of _claude/fs/list_directory.
+type listDirectoryParams struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+}
+
+// listDirectoryResult is the client's answer to _claude/fs/list_directory.
+type listDirectoryResult struct {
+	Entries []dirEntry `json:"entries"`
+}
+
+// dirEntry is one directory entry.
+type dirEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "file"|"directory"|"symlink"
+	Size int64  `json:"size,omitempty"`
+}
+
+// dirLister lists the entries of a directory.
+type dirLister func(ctx context.Context, dir string) ([]dirEntry, error)
+
+// clientDirLister returns a lister for the session that asks the client,
+// or nil if the client cannot list directories.
+func (a *ClaudeAcpAgent) clientDirLister(sessionID string) dirLister {
+	if !a.clientSupportsExt(listDirectoryMethod) {
+		return nil
+	}
+	return func(ctx context.Context, dir string) ([]dirEntry, error) {
+		var result listDirectoryResult
+		err := a.callClientExt(ctx, listDirectoryMethod, listDirectoryParams{SessionID: sessionID, Path: dir}, &result)
+		return result.Entries, err
+	}
+}
+
+// localDirLister lists directories on the agent's file system, for the
+// agent's internal paths.
+func localDirLister(_ context.Context, dir string) ([]dirEntry, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(des))
+	for _, de := range des {
+		e := dirEntry{Name: de.Name(), Type: "file"}
+		switch {
+		case de.Type()&os.ModeSymlink != 0:
+			e.Type = "symlink"
+		case de.IsDir():
+			e.Type = "directory"
+		default:
+			if info, err := de.Info(); err == nil {
+				e.Size = info.Size()
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// handleLS lists a directory tree through the client, down to depth
+// levels, skipping entries whose names match an ignore glob. Without a
+// client lister only internal paths can be listed.
+func handleLS(ctx context.Context, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
+	dir := inputStr(input, "path")
+	if dir == "" {
+		return "path is required", true, nil
+	}
+	if !filepath.IsAbs(dir) {
+		return "path must be absolute", true, nil
+	}
+	depth, ok := inputInt(input, "depth")
+	if !ok || depth <= 0 {
+		depth = defaultLSDepth
+	}
+	depth = min(depth, maxLSDepth)
+	ignore := inputStrSlice(input, "ignore")
+	for _, p := range ignore {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Sprintf("invalid ignore pattern %q: %v", p, err), true, nil
+		}
+	}
+	list := opts.listDir
+	if isInternalPath(dir) {
+		list = localDirLister
+	}
+	if list == nil {
+		return "LS is not available: the client cannot list directories", true, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("- " + filepath.Clean(dir) + string(filepath.Separator) + "\n")
+	count := 0
+	var walk func(dir string, level int) error
+	walk = func(dir string, level int) error {
+		entries, err := list(ctx, dir)
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		for _, e := range entries {
+			if ignoredName(e.Name, ignore) {
+				continue
+			}
+			if count == maxLSEntries {
+				return nil
+			}
+			count++
+			indent := strings.Repeat("  ", level+1)
+			switch e.Type {
+			case "directory":
+				sb.WriteString(indent + "- " + e.Name + "/\n")
+				if level+1 < depth {
+					if err := walk(filepath.Join(dir, e.Name), level+1); err != nil {
+						sb.WriteString(indent + "  (" + err.Error() + ")\n")
+					}
+				}
+			case "symlink":
+				sb.WriteString(indent + "- " + e.Name + "@\n")
+			default:
+				fmt.Fprintf(&sb, "%s- %s (%s)\n", indent, e.Name, formatByteSize(int(e.Size)))
+			}
+		}
+		return nil
+	}
+	if err := walk(dir, 0); err != nil {
+		return "Listing directory failed: " + err.Error(), true, nil
+	}
+	if count == maxLSEntries {
+		fmt.Fprintf(&sb, "\nThe listing stopped at %d entries. Use a smaller depth, ignore patterns or a more specific path.\n", maxLSEntries)
+	}
+	return sb.String(), false, nil
+}
+
+// ignoredName reports whether name matches any of the ignore globs.
+func ignoredName(name string, ignore []string) bool {
+	for _, p := range ignore {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestHandleLS_Local(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(dir, "pkg", "util.go"), "package pkg\n")
+	writeTestFile(t, filepath.Join(dir, "pkg", "deep", "x.go"), "package deep\n")
+	if err := os.MkdirAll(filepath.Join(dir, "node_modules", "left-pad"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := BuiltinToolOptions{listDir: localDirLister}
+	out, isErr, _ := handleLS(context.Background(), map[string]any{"path": dir, "depth": float64(2), "ignore": []any{"node_*"}}, opts)
+	if isErr {
+		t.Fatal(out)
+	}
+	want := "- " + dir + string(filepath.Separator) + "\n" +
+		"  - main.go (13 bytes)\n" +
+		"  - pkg/\n" +
+		"    - deep/\n" +
+		"    - util.go (12 bytes)\n"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	if out, isErr, _ := handleLS(context.Background(), map[string]any{"path": "relative"}, opts); !isErr {
+		t.Errorf("expected an error for a relative path, got %q", out)
+	}
+	// Without a client lister, the agent's own files are not listed.
+	if out, isErr, _ := handleLS(context.Background(), map[string]any{"path": dir}, BuiltinToolOptions{}); !isErr || !strings.Contains(out, "not available") {
+		t.Errorf("expected a tool error without a lister, got %q", out)
+	}
+}
+
+func TestHandleLS_Client(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	send, recv := extTestConn(t, agent)
+	if agent.clientDirLister("s1") != nil {
+		t.Fatal("lister without client support")
+	}
+	agent.clientCapabilities = &acp.ClientCapabilities{Meta: map[string]any{listDirectoryMethod: true}}
+	opts := BuiltinToolOptions{listDir: agent.clientDirLister("s1")}
+
+	done := make(chan string)
+	go func() {
+		out, _, _ := handleLS(context.Background(), map[string]any{"path": "/remote/src"}, opts)
+		done <- out
+	}()
+	req := recv()
+	if req["method"] != listDirectoryMethod {
+		t.Fatalf("unexpected request %v", req)
+	}
+	if params := req["params"].(map[string]any); params["path"] != "/remote/src" || params["sessionId"] != "s1" {
+		t.Errorf("params = %v", params)
+	}
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"entries":[{"name":"b.go","type":"file","size":10},{"name":"a","type":"directory"}]}}`, req["id"]))
+	out := <-done
+	if !strings.Contains(out, "  - a/\n  - b.go (10 bytes)\n") {
+		t.Errorf("got:\n%s", out)
+	}
+}
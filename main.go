@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 
-	acp "github.com/coder/acp-go-sdk"
+	"acp4all/extplugin"
 )
 
 func main() {
@@ -17,34 +18,192 @@ func main() {
 		}
 	}()
 
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:], os.Stdout))
+	}
+
 	// Load managed settings and apply environment variables
 	if settings := loadManagedSettings(); settings != nil {
 		applyEnvironmentSettings(settings)
 	}
 
-	transport := flag.String("transport", "stdio", "Transport mode: stdio or websocket")
-	port := flag.Int("port", 8080, "Port for WebSocket server")
-	host := flag.String("host", "127.0.0.1", "Host for WebSocket server")
+	configPath := flag.String("config", "", "Config file (default ~/.config/claude-code-acp/config.{toml,json})")
+	transport := flag.String("transport", "stdio", "Transport mode: stdio, websocket, http or socket")
+	port := flag.Int("port", 8080, "Port for the WebSocket or HTTP server")
+	host := flag.String("host", "127.0.0.1", "Host for the WebSocket or HTTP server")
+	framing := flag.String("framing", "ndjson", "Message framing for the stdio transport: ndjson or lsp (Content-Length headers)")
+	socketPath := flag.String("socket", "", "Unix socket or Windows named pipe for the socket transport (default per-user socket, or \\\\.\\pipe\\claude-code-acp on Windows)")
+	wsToken := flag.String("ws-token", "", "Require this bearer token from WebSocket and HTTP clients")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	backendName := flag.String("backend", "claude", "Agent CLI to bridge: claude")
+	executable := flag.String("executable", "", "Path to the claude CLI (default $CLAUDE_CODE_EXECUTABLE or claude)")
+	maxTurns := flag.Int("max-turns", 200, "Maximum agentic turns per prompt")
+	turnIdleTimeout := flag.Duration("turn-idle-timeout", 0, "Interrupt a turn, which then fails, once the CLI has sent nothing for this long (0 waits forever)")
+	maxMessageSize := flag.Int("max-message-size", MaxMessageSize, "Largest CLI message in bytes; larger messages are skipped")
+	maxToolOutput := flag.Int("max-tool-output-bytes", DefaultMaxToolOutputBytes, "Largest tool output text in bytes sent to the client; longer output keeps its head and tail")
+	maxImageBytes := flag.Int("max-image-bytes", DefaultMaxImageBytes, "Largest image in bytes sent to the client; larger tool images are downscaled or dropped")
+	permissionMode := flag.String("permission-mode", "", "Mode new sessions start in, overriding the settings' defaultMode: default, acceptEdits, plan, dontAsk or bypassPermissions")
+	allowBypass := flag.Bool("dangerously-allow-bypass", false, "Offer bypassPermissions mode, in which every tool runs without asking (never for root outside a sandbox)")
+	strictProtocol := flag.String("strict-protocol", "", "Check CLI messages for unknown types and missing or unknown fields: warn logs them, fail also ends the turn")
+	coalesceWindow := flag.Duration("coalesce-window", DefaultCoalesceWindow, "Buffer streamed text deltas for this long before sending (0 disables)")
+	coalesceBytes := flag.Int("coalesce-bytes", DefaultCoalesceBytes, "Flush buffered text deltas once they reach this many bytes")
+	updateQueueSize := flag.Int("update-queue-size", DefaultUpdateQueueSize, "Maximum session updates waiting for a slow client; text is merged and thoughts dropped beyond it")
+	settingsFile := flag.String("settings", "", "Additional settings JSON file merged above project settings")
+	suppressThoughts := flag.Bool("suppress-thoughts", false, "Drop thinking output instead of sending agent thought updates")
+	noLineNumbers := flag.Bool("no-read-line-numbers", false, "Return Read tool output without line numbers")
+	maxTerminals := flag.Int("max-terminals", 0, "Maximum terminals a session may have open at once (0 is unlimited)")
+	maxToolCalls := flag.Int("max-tool-calls-per-minute", 0, "Maximum tool calls per session per minute (0 is unlimited)")
+	maxBashTime := flag.Duration("max-bash-time", 0, "Maximum total Bash run time per turn (0 is unlimited)")
+	envAllow := flag.String("env-allow", "", "Comma-separated patterns (e.g. AWS_*) of agent env vars the CLI inherits; default all")
+	envDeny := flag.String("env-deny", "", "Comma-separated patterns of agent env vars withheld from the CLI")
+	httpProxy := flag.String("http-proxy", "", "Proxy for HTTP requests by the agent and CLI")
+	httpsProxy := flag.String("https-proxy", "", "Proxy for HTTPS requests by the agent and CLI")
+	noProxy := flag.String("no-proxy", "", "Comma-separated hosts that bypass the proxy")
+	acpTools := flag.Bool("acp-tools", false, "Give the CLI file and shell tools that run through the client (mcp__acp__*) in place of its own, and ask the client for its permission prompts")
+	maxParallelTools := flag.Int("max-parallel-tools", DefaultMaxParallelTools, "Maximum read-only built-in tool calls run at once (1 runs them one by one)")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Printf("claude-code-acp %s\n", versionString())
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err == nil {
+		err = applyConfig(flag.CommandLine, cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config file: %v\n", err)
+		os.Exit(2)
+	}
+
+	stdin, stdout, err := stdioFraming(*framing, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --framing: %v\n", err)
+		os.Exit(2)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-level: %v\n", err)
+		os.Exit(2)
+	}
+
+	backend, err := lookupBackend(*backendName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --backend: %v\n", err)
+		os.Exit(2)
+	}
+
+	if !validPermissionMode(*permissionMode) {
+		fmt.Fprintf(os.Stderr, "Invalid --permission-mode %q: want default, acceptEdits, plan, dontAsk or bypassPermissions\n", *permissionMode)
+		os.Exit(2)
+	}
+
+	if *permissionMode == "bypassPermissions" && !*allowBypass {
+		fmt.Fprintln(os.Stderr, "Invalid --permission-mode: bypassPermissions requires --dangerously-allow-bypass")
+		os.Exit(2)
+	}
+
+	if !validStrictProtocol(*strictProtocol) {
+		fmt.Fprintf(os.Stderr, "Invalid --strict-protocol %q: want warn or fail\n", *strictProtocol)
+		os.Exit(2)
+	}
+
+	var envFilter EnvFilter
+	if envFilter.Allow, err = parseEnvPatterns(*envAllow); err == nil {
+		envFilter.Deny, err = parseEnvPatterns(*envDeny)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid env filter: %v\n", err)
+		os.Exit(2)
+	}
+
+	proxy := ProxySettings{HTTPProxy: *httpProxy, HTTPSProxy: *httpsProxy, NoProxy: *noProxy}
+	if err := proxy.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid proxy: %v\n", err)
+		os.Exit(2)
+	}
+	proxy.setProcessEnv()
+
+	tools := BuiltinToolOptions{
+		DisableLineNumbers: *noLineNumbers,
+		Serve:              *acpTools,
+		MaxParallelTools:   *maxParallelTools,
+		Limits: ToolLimits{
+			MaxTerminals:      *maxTerminals,
+			MaxCallsPerMinute: *maxToolCalls,
+			MaxBashTime:       *maxBashTime,
+		},
+	}
+	opts := AgentOptions{
+		CoalesceWindow:   *coalesceWindow,
+		CoalesceBytes:    *coalesceBytes,
+		UpdateQueueSize:  *updateQueueSize,
+		Executable:       *executable,
+		MaxTurns:         *maxTurns,
+		MaxMessageSize:   *maxMessageSize,
+		MaxImageBytes:    *maxImageBytes,
+		MaxToolOutput:    *maxToolOutput,
+		TurnIdleTimeout:  *turnIdleTimeout,
+		PermissionMode:   *permissionMode,
+		AllowBypass:      *allowBypass,
+		StrictProtocol:   *strictProtocol,
+		SuppressThoughts: *suppressThoughts,
+		Tools:            tools,
+		Backend:          backend,
+		ExtPlugins:       extplugin.Registered(),
+		EnvFilter:        envFilter,
+		Proxy:            proxy,
+	}
+	if *settingsFile != "" {
+		path, err := filepath.Abs(*settingsFile)
+		if err == nil {
+			_, err = readSettingsFile(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --settings file: %v\n", err)
+			os.Exit(2)
+		}
+		opts.SettingsFile = path
+	}
+
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	}))
+	if opts.AllowBypass {
+		if reason := bypassUnavailableReason(opts); reason != "" {
+			logger.Warn("--dangerously-allow-bypass has no effect", "reason", reason)
+		} else {
+			logger.Warn("DANGER: bypassPermissions mode is enabled; sessions switched to it run every tool, including shell commands, without asking", "audit", "permission")
+		}
+	}
 
 	switch *transport {
 	case "websocket":
-		if err := RunWebSocketServer(*host, *port, logger); err != nil {
+		if err := RunWebSocketServer(*host, *port, *wsToken, logger, opts); err != nil {
 			logger.Error("WebSocket server error", "error", err)
 			os.Exit(1)
 		}
+	case "http":
+		if err := RunHTTPServer(*host, *port, *wsToken, logger, opts); err != nil {
+			logger.Error("HTTP server error", "error", err)
+			os.Exit(1)
+		}
+	case "socket":
+		if err := RunLocalServer(*socketPath, logger, opts); err != nil {
+			logger.Error("Local socket server error", "error", err)
+			os.Exit(1)
+		}
 	default:
 		// stdio mode: use stdin/stdout for ACP communication
-		agent := NewClaudeAcpAgent(logger)
-		conn := acp.NewAgentSideConnection(agent, os.Stdout, os.Stdin)
-		conn.SetLogger(logger)
-		agent.SetAgentConnection(conn)
+		agent := NewClaudeAcpAgent(logger, opts)
+		conn := newAgentConnection(agent, stdout, stdin, logger)
 
-		// Block until the connection is closed
+		// Block until the connection is closed, then stop the CLI processes
+		// so none outlive the agent.
 		<-conn.Done()
+		agent.closeSessions()
 	}
 }
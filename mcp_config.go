@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+)
+
+// writeMCPConfig writes servers to a temporary --mcp-config file readable
+// only by the current user, since env values and headers often hold
+// credentials. Each stdio server keeps its env in its own block, so it
+// reaches only that server rather than the CLI and every server it starts.
+// The caller removes the file.
+func writeMCPConfig(servers map[string]McpServerConfig) (string, error) {
+	path, err := writeTempJSON("mcp-config-*.json", map[string]any{"mcpServers": servers})
+	if err != nil {
+		return "", fmt.Errorf("failed to write mcp config: %w", err)
+	}
+	return path, nil
+}
+
+// writeTempJSON writes v to a new temporary file readable only by the
+// current user, named after pattern as by os.CreateTemp. The caller removes
+// the file.
+func writeTempJSON(pattern string, v any) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	err = f.Chmod(0o600)
+	if err == nil {
+		err = json.NewEncoder(f).Encode(v)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// LogValue logs the server without its env and header values.
+func (c McpServerConfig) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("type", c.Type)}
+	if c.Command != "" {
+		attrs = append(attrs, slog.String("command", c.Command), slog.Any("args", c.Args))
+	}
+	if c.URL != "" {
+		attrs = append(attrs, slog.String("url", c.URL))
+	}
+	if len(c.Env) > 0 {
+		attrs = append(attrs, slog.Any("env", slices.Sorted(maps.Keys(c.Env))))
+	}
+	if len(c.Headers) > 0 {
+		attrs = append(attrs, slog.Any("headers", slices.Sorted(maps.Keys(c.Headers))))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// mcpServersLog logs MCP servers by name, redacted as by
+// McpServerConfig.LogValue.
+type mcpServersLog map[string]McpServerConfig
+
+func (m mcpServersLog) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(m))
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		attrs = append(attrs, slog.Any(name, m[name]))
+	}
+	return slog.GroupValue(attrs...)
+}
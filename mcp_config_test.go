@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteMCPConfig(t *testing.T) {
+	path, err := writeMCPConfig(map[string]McpServerConfig{
+		"db":   {Type: "stdio", Command: "db-server", Env: map[string]string{"TOKEN": "s3cret", "HOST": "localhost"}},
+		"docs": {Type: "http", URL: "https://docs.example/mcp", Headers: map[string]string{"Authorization": "Bearer abc"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		McpServers map[string]McpServerConfig `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if env := config.McpServers["db"].Env; len(env) != 2 || env["TOKEN"] != "s3cret" || env["HOST"] != "localhost" {
+		t.Errorf("env not written to the server's block: %v", env)
+	}
+	if config.McpServers["docs"].Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("headers not written: %+v", config.McpServers["docs"])
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o600 {
+			t.Errorf("config mode = %o, want 600", mode)
+		}
+	}
+}
+
+func TestMcpServerConfig_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("servers", "mcpServers", mcpServersLog{
+		"db":   {Type: "stdio", Command: "db-server", Env: map[string]string{"TOKEN": "s3cret"}},
+		"docs": {Type: "http", URL: "https://docs.example/mcp", Headers: map[string]string{"Authorization": "Bearer abc"}},
+	})
+	out := buf.String()
+	for _, secret := range []string{"s3cret", "Bearer abc"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q: %s", secret, out)
+		}
+	}
+	for _, want := range []string{`"TOKEN"`, `"Authorization"`, `"command":"db-server"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %s: %s", want, out)
+		}
+	}
+}
+
+func TestNewClaudeCodeProcess_MCPConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the CLI")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	cli := filepath.Join(dir, "claude")
+	script := "#!/bin/sh\nfor a; do case $a in --mcp-config=*) f=\"${a#--mcp-config=}\"; echo \"$f\" > " + out + "; cat \"$f\" >> " + out + ";; esac; done\n" +
+		"echo \"TOKEN=$TOKEN\" >> " + out + "\ncat > /dev/null\n"
+	if err := os.WriteFile(cli, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewClaudeCodeProcess(ClaudeCodeOptions{
+		Cwd:        dir,
+		SessionID:  "s1",
+		Executable: cli,
+		McpServers: map[string]McpServerConfig{"db": {Type: "stdio", Command: "db-server", Env: map[string]string{"TOKEN": "s3cret"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The env value is in the server's block, not the CLI's environment.
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], `"env":{"TOKEN":"s3cret"}`) || lines[2] != "TOKEN=" {
+		t.Fatalf("expected the config path, the config and no TOKEN in the CLI env, got %q", data)
+	}
+	if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+		t.Errorf("expected the config to be removed on close, got %v", err)
+	}
+}
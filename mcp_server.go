@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,11 +18,171 @@ import (
 const MaxFileSize = 50000
 const LinesToRead = 2000
 
+// MaxOutputBytes is the default limit on command output kept by a terminal.
+const MaxOutputBytes = 32000
+
+// Bash timeouts used when settings do not set BASH_DEFAULT_TIMEOUT_MS or
+// BASH_MAX_TIMEOUT_MS.
+const (
+	DefaultBashTimeoutMs = 2 * 60 * 1000
+	MaxBashTimeoutMs     = 10 * 60 * 1000
+)
+
 // EditOperation represents a single text replacement operation.
 type EditOperation struct {
 	OldText    string
 	NewText    string
 	ReplaceAll bool
+	// OccurrenceIndex selects which occurrence of OldText to replace,
+	// starting at 1. Zero requires OldText to be unique.
+	OccurrenceIndex int
+	// ExpectedReplacements, if set, is the exact number of occurrences
+	// of OldText; all of them are replaced.
+	ExpectedReplacements int
+}
+
+// BuiltinToolOptions configures the built-in tool handlers.
+type BuiltinToolOptions struct {
+	// Serve runs an MCP server per session offering the built-in tools to
+	// the CLI as mcp__acp__ tools, in place of the CLI's own file and shell
+	// tools, so file access and commands go through the client. The server
+	// also answers the CLI's permission prompts by asking the client.
+	Serve bool
+	// DisableLineNumbers returns Read output as plain text instead of
+	// prefixing each line with its number.
+	DisableLineNumbers bool
+	// MaxReadBytes limits the text returned by one Read call; zero uses
+	// MaxFileSize.
+	MaxReadBytes int
+	// MaxOutputBytes limits the command output kept by Bash; zero uses
+	// MaxOutputBytes.
+	MaxOutputBytes int
+	// DefaultBashTimeoutMs is the Bash timeout when the model gives none;
+	// zero uses DefaultBashTimeoutMs.
+	DefaultBashTimeoutMs int
+	// MaxBashTimeoutMs caps the timeout the model may request; zero uses
+	// MaxBashTimeoutMs.
+	MaxBashTimeoutMs int
+	// ReadOnly rejects the tools in readOnlyDeniedTools.
+	ReadOnly bool
+	// Limits caps tool calls, terminals and Bash time per session.
+	Limits ToolLimits
+	// MaxParallelTools caps the read-only tools that run at once when the
+	// model calls several together; zero uses DefaultMaxParallelTools.
+	MaxParallelTools int
+
+	cwd         string           // the session's working directory
+	limiter     *toolLimiter     // the session's Limits state
+	checkpoints *fileCheckpoints // the session's undo history for Edit and Write
+	listDir     dirLister        // lists directories through the client; nil if it cannot
+	readFile    fileReader       // reads images through the client; nil if it cannot
+	fetch       webFetcher       // fetches WebFetch URLs; nil fetches from the agent
+	settings    *SettingsManager // the session's WebFetch rules and Bash terminal env
+	files       *fileCache       // the session's cached file contents; nil caches nothing
+	changes     *changedFiles    // files changed outside the agent since last read
+	scheduler   *toolScheduler   // runs the session's tool calls, in parallel where safe
+	terminals   *TerminalManager // the session's Bash terminals
+	ids         IDSource         // generates notebook cell IDs and edit markers; nil is random
+}
+
+// readOnlyDeniedTools are the tools that modify the workspace or run
+// commands, which a read-only session may not use.
+var readOnlyDeniedTools = []string{"Write", "Edit", "MultiEdit", "NotebookEdit", "Bash"}
+
+// readOnlyDisallowedTools returns the CLI tool names denied to a read-only
+// session: the CLI's own tools and their ACP equivalents.
+func readOnlyDisallowedTools() []string {
+	tools := slices.Clone(readOnlyDeniedTools)
+	for _, name := range readOnlyDeniedTools {
+		tools = append(tools, ACPToolNamePrefix+name)
+	}
+	return tools
+}
+
+func (o BuiltinToolOptions) readLimit() int {
+	if o.MaxReadBytes > 0 {
+		return o.MaxReadBytes
+	}
+	return MaxFileSize
+}
+
+func (o BuiltinToolOptions) outputLimit() int {
+	if o.MaxOutputBytes > 0 {
+		return o.MaxOutputBytes
+	}
+	return MaxOutputBytes
+}
+
+// terminalManager returns the session's terminals, or terminals on conn
+// that nothing owns if the session has none.
+func (o BuiltinToolOptions) terminalManager(conn *acp.AgentSideConnection, sessionID string) *TerminalManager {
+	if o.terminals != nil {
+		return o.terminals
+	}
+	return newTerminalManager(conn, sessionID, o)
+}
+
+// bashTimeout returns the timeout for a Bash command or blocking
+// BashOutput call: the model's timeout input, clamped to the maximum, or
+// the default if none was given.
+func (o BuiltinToolOptions) bashTimeout(input map[string]any) time.Duration {
+	maxMs := o.MaxBashTimeoutMs
+	if maxMs <= 0 {
+		maxMs = MaxBashTimeoutMs
+	}
+	ms := o.DefaultBashTimeoutMs
+	if ms <= 0 {
+		ms = DefaultBashTimeoutMs
+	}
+	if t, ok := inputInt(input, "timeout"); ok && t > 0 {
+		ms = t
+	}
+	return time.Duration(min(ms, maxMs)) * time.Millisecond
+}
+
+// withLimits returns o with limits overridden by the session. Each size
+// limit is taken from the first of meta (maxReadBytes, maxOutputBytes), the
+// session env and the process env (ACP_MAX_READ_BYTES, ACP_MAX_OUTPUT_BYTES)
+// that sets a positive value. Bash timeouts come from the env only
+// (BASH_DEFAULT_TIMEOUT_MS, BASH_MAX_TIMEOUT_MS), so settings can cap them
+// but clients cannot.
+func (o BuiltinToolOptions) withLimits(meta map[string]any, env map[string]string) BuiltinToolOptions {
+	resolve := func(metaKey, envKey string, dst *int) {
+		if v, ok := meta[metaKey].(float64); ok && v > 0 && metaKey != "" {
+			*dst = int(v)
+			return
+		}
+		for _, s := range []string{env[envKey], os.Getenv(envKey)} {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				*dst = n
+				return
+			}
+		}
+	}
+	resolve("maxReadBytes", "ACP_MAX_READ_BYTES", &o.MaxReadBytes)
+	resolve("maxOutputBytes", "ACP_MAX_OUTPUT_BYTES", &o.MaxOutputBytes)
+	resolve("", "BASH_DEFAULT_TIMEOUT_MS", &o.DefaultBashTimeoutMs)
+	resolve("", "BASH_MAX_TIMEOUT_MS", &o.MaxBashTimeoutMs)
+	return o
+}
+
+// BuiltinToolResult is the outcome of a built-in tool call: the text
+// returned to the model and any images read by the tool.
+type BuiltinToolResult struct {
+	Text    string
+	IsError bool
+	Images  []ImageContent
+}
+
+// ImageContent is a base64-encoded image.
+type ImageContent struct {
+	Data     string
+	MimeType string
+}
+
+// textResult adapts a text-only tool handler's return values.
+func textResult(text string, isError bool, err error) (BuiltinToolResult, error) {
+	return BuiltinToolResult{Text: text, IsError: isError}, err
 }
 
 // handleBuiltinTool handles a built-in tool call.
@@ -31,26 +193,45 @@ func handleBuiltinTool(
 	sessionID string,
 	toolName string,
 	input map[string]any,
-) (string, bool, error) {
+	opts BuiltinToolOptions,
+) (BuiltinToolResult, error) {
+	if opts.ReadOnly && slices.Contains(readOnlyDeniedTools, toolName) {
+		return textResult(fmt.Sprintf("%s is not allowed in a read-only session", toolName), true, nil)
+	}
+	if msg, ok := opts.limiter.allowCall(); !ok {
+		return textResult(msg, true, nil)
+	}
 	switch toolName {
 	case "Read":
-		return handleRead(ctx, conn, sessionID, input)
+		if result, ok := readImageFile(ctx, inputStr(input, "file_path"), opts); ok {
+			return result, nil
+		}
+		return textResult(handleRead(ctx, conn, sessionID, input, opts))
 	case "Write":
-		return handleWrite(ctx, conn, sessionID, input)
-	case "Edit":
-		return handleEdit(ctx, conn, sessionID, input)
+		return textResult(handleWrite(ctx, conn, sessionID, input, opts))
+	case "Edit", "MultiEdit":
+		return textResult(handleEdit(ctx, conn, sessionID, input, opts))
 	case "Bash":
-		return handleBash(ctx, conn, sessionID, input)
+		// Commands may change any file; background ones until they exit.
+		defer opts.files.clear()
+		return textResult(handleBash(ctx, conn, sessionID, input, opts))
 	case "BashOutput":
-		return handleBashOutput(ctx, conn, sessionID, input)
+		defer opts.files.clear()
+		return textResult(handleBashOutput(ctx, conn, sessionID, input, opts))
 	case "KillShell":
-		return handleKillShell(ctx, conn, sessionID, input)
+		return textResult(handleKillShell(ctx, conn, sessionID, input, opts))
+	case "LS":
+		return textResult(handleLS(ctx, input, opts))
+	case "NotebookEdit":
+		return textResult(handleNotebookEdit(ctx, conn, sessionID, input, opts))
+	case "WebFetch":
+		return textResult(handleWebFetch(ctx, input, opts))
 	default:
-		return fmt.Sprintf("Unknown tool: %s", toolName), true, nil
+		return textResult(fmt.Sprintf("Unknown tool: %s", toolName), true, nil)
 	}
 }
 
-func handleRead(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+func handleRead(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	filePath := inputStr(input, "file_path")
 	if filePath == "" {
 		return "file_path is required", true, nil
@@ -62,271 +243,322 @@ func handleRead(ctx context.Context, conn *acp.AgentSideConnection, sessionID st
 		if err != nil {
 			return "Reading file failed: " + err.Error(), true, nil
 		}
-		content := string(data)
-		offset, hasOffset := inputInt(input, "offset")
-		limit, hasLimit := inputInt(input, "limit")
-		if hasOffset || hasLimit {
-			lines := strings.Split(content, "\n")
-			start := 0
-			if hasOffset {
-				start = offset - 1
-			}
-			if start < 0 {
-				start = 0
-			}
-			end := len(lines)
-			if hasLimit {
-				end = start + limit
-			}
-			if end > len(lines) {
-				end = len(lines)
-			}
-			content = strings.Join(lines[start:end], "\n")
-		}
-		rawContent = content
+		rawContent = sliceReadLines(string(data), input)
+	} else if cached, ok := opts.files.get(filePath); ok {
+		rawContent = sliceReadLines(cached, input)
 	} else {
-		resp, err := conn.ReadTextFile(ctx, acp.ReadTextFileRequest{
+		req := acp.ReadTextFileRequest{
 			SessionId: acp.SessionId(sessionID),
 			Path:      filePath,
-		})
+		}
+		if offset, ok := inputInt(input, "offset"); ok && offset > 0 {
+			req.Line = &offset
+		}
+		if limit, ok := inputInt(input, "limit"); ok && limit > 0 {
+			req.Limit = &limit
+		}
+		resp, err := conn.ReadTextFile(ctx, req)
 		if err != nil {
 			return "Reading file failed: " + err.Error(), true, nil
 		}
 		rawContent = resp.Content
+		if req.Line == nil && req.Limit == nil {
+			opts.files.put(filePath, rawContent)
+		}
+	}
+	if looksBinary(rawContent) {
+		return fmt.Sprintf("%s appears to be a binary file and cannot be displayed as text.", filePath), true, nil
 	}
+	opts.changes.seen(filePath)
 
 	offset, hasOffset := inputInt(input, "offset")
-	result := extractLinesWithByteLimit(rawContent, MaxFileSize)
+	result := extractLinesWithByteLimit(rawContent, opts.readLimit())
 	var readInfo string
 	if (hasOffset && offset > 1) || result.WasLimited {
 		readInfo = "\n\n<file-read-info>"
 		if result.WasLimited {
-			readInfo += fmt.Sprintf("Read %d lines (hit 50KB limit). ", result.LinesRead)
+			readInfo += fmt.Sprintf("Read %d lines (hit %s limit). ", result.LinesRead, formatByteSize(opts.readLimit()))
 			readInfo += fmt.Sprintf("Continue with offset=%d.", result.LinesRead)
 		} else if hasOffset && offset > 1 {
 			readInfo += fmt.Sprintf("Read lines %d-%d.", offset, offset+result.LinesRead)
 		}
 		readInfo += "</file-read-info>"
 	}
-	return result.Content + readInfo + SystemReminder, false, nil
+	content := result.Content
+	if !opts.DisableLineNumbers {
+		firstLine := 1
+		if hasOffset && offset > 1 {
+			firstLine = offset
+		}
+		content = numberLines(content, firstLine)
+	}
+	return content + readInfo + SystemReminder, false, nil
+}
+
+// formatByteSize formats n as a size for the model, e.g. "50KB".
+func formatByteSize(n int) string {
+	if n >= 1000 && n%1000 == 0 {
+		return fmt.Sprintf("%dKB", n/1000)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// numberLines prefixes each line of content with its line number in the
+// "     1→text" format of the Claude Code Read tool, starting at firstLine.
+func numberLines(content string, firstLine int) string {
+	if content == "" {
+		return ""
+	}
+	trailingNewline := strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%6d→%s", firstLine+i, line)
+	}
+	if trailingNewline {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// sliceReadLines applies Read's offset and limit to a whole file's content.
+func sliceReadLines(content string, input map[string]any) string {
+	offset, hasOffset := inputInt(input, "offset")
+	limit, hasLimit := inputInt(input, "limit")
+	if !hasOffset && !hasLimit {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	start := 0
+	if hasOffset {
+		start = offset - 1
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := len(lines)
+	if hasLimit {
+		end = start + limit
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return strings.Join(lines[start:end], "\n")
 }
 
-func handleWrite(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+func handleWrite(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	filePath := inputStr(input, "file_path")
 	if filePath == "" {
 		return "file_path is required", true, nil
 	}
 	content := inputStr(input, "content")
-	if isInternalPath(filePath) {
-		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
-			return "Writing file failed: " + err.Error(), true, nil
-		}
-		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
-			return "Writing file failed: " + err.Error(), true, nil
-		}
-		return fmt.Sprintf("The file %s has been updated successfully.", filePath), false, nil
-	}
-	_, err := conn.WriteTextFile(ctx, acp.WriteTextFileRequest{
-		SessionId: acp.SessionId(sessionID),
-		Path:      filePath,
-		Content:   content,
-	})
-	if err != nil {
+	// Keep the line endings of a file being overwritten. Files without any
+	// line break carry no style to preserve.
+	existing, readErr := readFileContent(ctx, conn, sessionID, filePath, opts.files)
+	if readErr == nil && strings.Contains(existing, "\n") {
+		f := detectTextFormat(existing)
+		content = f.fixFinalNewline(f.convert(content))
+	}
+	opts.checkpoints.record(filePath, existing, readErr == nil)
+	if err := writeFileContent(ctx, conn, sessionID, filePath, content, opts.files); err != nil {
 		return "Writing file failed: " + err.Error(), true, nil
 	}
+	opts.changes.wrote(filePath, content)
 	return fmt.Sprintf("The file %s has been updated successfully.", filePath), false, nil
 }
 
-func handleEdit(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+func handleEdit(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	filePath := inputStr(input, "file_path")
 	if filePath == "" {
 		return "file_path is required", true, nil
 	}
-	oldString := inputStr(input, "old_string")
-	newString := inputStr(input, "new_string")
-	replaceAll := inputBool(input, "replace_all")
-
-	var fileContent string
-	if isInternalPath(filePath) {
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return "Editing file failed: " + err.Error(), true, nil
+	// MultiEdit sends its edits as a list; Edit sends one at the top level.
+	edits := []map[string]any{input}
+	if list, ok := input["edits"].([]any); ok {
+		edits = edits[:0]
+		for _, item := range list {
+			e, ok := item.(map[string]any)
+			if !ok {
+				return "edits must be a list of objects", true, nil
+			}
+			edits = append(edits, e)
 		}
-		fileContent = string(data)
-	} else {
-		resp, err := conn.ReadTextFile(ctx, acp.ReadTextFileRequest{
-			SessionId: acp.SessionId(sessionID),
-			Path:      filePath,
-		})
-		if err != nil {
-			return "Editing file failed: " + err.Error(), true, nil
+		if len(edits) == 0 {
+			return "edits must not be empty", true, nil
 		}
-		fileContent = resp.Content
 	}
-	newContent, _, err := replaceAndCalculateLocation(fileContent, []EditOperation{
-		{OldText: oldString, NewText: newString, ReplaceAll: replaceAll},
-	})
+
+	fileContent, err := readFileContent(ctx, conn, sessionID, filePath, opts.files)
 	if err != nil {
 		return "Editing file failed: " + err.Error(), true, nil
 	}
-	patch := createUnifiedDiff(filePath, fileContent, newContent)
-	if isInternalPath(filePath) {
-		if err := os.WriteFile(filePath, []byte(newContent), 0o644); err != nil {
-			return "Editing file failed: " + err.Error(), true, nil
+	// The model usually sends "\n" line endings; match and write back the
+	// file's own.
+	format := detectTextFormat(fileContent)
+	ops := make([]EditOperation, 0, len(edits))
+	for _, e := range edits {
+		oldString := inputStr(e, "old_string")
+		if !strings.Contains(fileContent, oldString) {
+			oldString = format.convert(oldString)
 		}
-	} else {
-		_, err := conn.WriteTextFile(ctx, acp.WriteTextFileRequest{
-			SessionId: acp.SessionId(sessionID),
-			Path:      filePath,
-			Content:   newContent,
+		occurrenceIndex, _ := inputInt(e, "occurrence_index")
+		expectedReplacements, _ := inputInt(e, "expected_replacements")
+		ops = append(ops, EditOperation{
+			OldText:              oldString,
+			NewText:              format.convert(inputStr(e, "new_string")),
+			ReplaceAll:           inputBool(e, "replace_all"),
+			OccurrenceIndex:      occurrenceIndex,
+			ExpectedReplacements: expectedReplacements,
 		})
-		if err != nil {
-			return "Editing file failed: " + err.Error(), true, nil
+	}
+	newContent, _, err := replaceAndCalculateLocation(opts.ids, fileContent, ops)
+	if err != nil {
+		msg := "Editing file failed: " + err.Error()
+		if opts.changes.stale(filePath) {
+			msg += "\nThe file was changed outside of this conversation since you last read it. Read it again before editing."
 		}
+		return msg, true, nil
+	}
+	newContent = format.fixFinalNewline(newContent)
+	patch := createUnifiedDiff(filePath, normalizeLineEndings(fileContent), normalizeLineEndings(newContent))
+	opts.checkpoints.record(filePath, fileContent, true)
+	if err := writeFileContent(ctx, conn, sessionID, filePath, newContent, opts.files); err != nil {
+		return "Editing file failed: " + err.Error(), true, nil
 	}
+	opts.changes.wrote(filePath, newContent)
 	return patch, false, nil
 }
 
-func handleBash(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+// writeFileContent writes a file for Edit, Write or NotebookEdit, to disk
+// for internal paths and through the client otherwise, where the written
+// content is cached in files.
+func writeFileContent(ctx context.Context, conn *acp.AgentSideConnection, sessionID, filePath, content string, files *fileCache) error {
+	if isInternalPath(filePath) {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(filePath, []byte(content), 0o644)
+	}
+	_, err := conn.WriteTextFile(ctx, acp.WriteTextFileRequest{
+		SessionId: acp.SessionId(sessionID),
+		Path:      filePath,
+		Content:   content,
+	})
+	if err != nil {
+		files.invalidate(filePath)
+		return err
+	}
+	files.put(filePath, content)
+	return nil
+}
+
+// readFileContent reads a file for Edit or Write, from disk for internal
+// paths and through the client, or from files, otherwise.
+func readFileContent(ctx context.Context, conn *acp.AgentSideConnection, sessionID, filePath string, files *fileCache) (string, error) {
+	if isInternalPath(filePath) {
+		data, err := os.ReadFile(filePath)
+		return string(data), err
+	}
+	if content, ok := files.get(filePath); ok {
+		return content, nil
+	}
+	resp, err := conn.ReadTextFile(ctx, acp.ReadTextFileRequest{
+		SessionId: acp.SessionId(sessionID),
+		Path:      filePath,
+	})
+	if err != nil {
+		return "", err
+	}
+	files.put(filePath, resp.Content)
+	return resp.Content, nil
+}
+
+// resolveBashCwd resolves a Bash cwd relative to the session's working
+// directory. Relative paths may not escape it. msg explains a rejected cwd.
+func resolveBashCwd(cwd, sessionCwd string) (resolved, msg string) {
+	if cwd == "" || filepath.IsAbs(cwd) {
+		return cwd, ""
+	}
+	if sessionCwd == "" {
+		return "", "cwd must be an absolute path"
+	}
+	resolved = filepath.Join(sessionCwd, cwd)
+	if rel, err := filepath.Rel(sessionCwd, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Sprintf("cwd %q is outside the session's working directory; use an absolute path", cwd)
+	}
+	return resolved, ""
+}
+
+func handleBash(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	command := inputStr(input, "command")
 	if command == "" {
 		return "command is required", true, nil
 	}
-	timeoutMs := 2 * 60 * 1000
-	if t, ok := inputInt(input, "timeout"); ok {
-		timeoutMs = t
+	cwd, msg := resolveBashCwd(inputStr(input, "cwd"), opts.cwd)
+	if msg != "" {
+		return msg, true, nil
 	}
 	runInBackground := inputBool(input, "run_in_background")
-	outputByteLimit := 32000
-	resp, err := conn.CreateTerminal(ctx, acp.CreateTerminalRequest{
-		Command:         command,
-		Env:             []acp.EnvVariable{{Name: "CLAUDECODE", Value: "1"}},
-		SessionId:       acp.SessionId(sessionID),
-		OutputByteLimit: &outputByteLimit,
-	})
+	timeout, budgetLimited := opts.limiter.bashTimeout(opts.bashTimeout(input))
+	// Background commands are charged too, once released, so they cannot
+	// start once the budget is spent.
+	if timeout <= 0 {
+		return opts.limiter.bashTimeExhausted(), true, nil
+	}
+	terminals := opts.terminalManager(conn, sessionID)
+	terminalID, err := terminals.create(ctx, command, cwd)
+	var limitErr terminalLimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.Error(), true, nil
+	}
 	if err != nil {
 		return "Running bash command failed: " + err.Error(), true, nil
 	}
-	terminalID := resp.TerminalId
 	if runInBackground {
 		return fmt.Sprintf("Command started in background with id: %s", terminalID), false, nil
 	}
-	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
-	defer cancel()
-	exitResp, err := conn.WaitForTerminalExit(waitCtx, acp.WaitForTerminalExitRequest{
-		SessionId:  acp.SessionId(sessionID),
-		TerminalId: terminalID,
-	})
-	var status string
-	var exitCode *int
-	var signal string
-	if err != nil {
-		if waitCtx.Err() != nil {
-			_, _ = conn.KillTerminalCommand(ctx, acp.KillTerminalCommandRequest{
-				SessionId:  acp.SessionId(sessionID),
-				TerminalId: terminalID,
-			})
-			status = "timedOut"
-		} else {
-			status = "exited"
-		}
-	} else {
-		status = "exited"
-		exitCode = exitResp.ExitCode
-		if exitResp.Signal != nil {
-			signal = *exitResp.Signal
-		}
+	out, status := terminals.wait(ctx, terminalID, timeout)
+	result := formatToolCommandOutput(status, out.Output, out.ExitCode, out.Signal, out.Truncated)
+	if status == "timedOut" && budgetLimited {
+		result += "\n" + opts.limiter.bashTimeExhausted()
 	}
-	outputResp, outputErr := conn.TerminalOutput(ctx, acp.TerminalOutputRequest{
-		SessionId:  acp.SessionId(sessionID),
-		TerminalId: terminalID,
-	})
-	var output string
-	var truncated bool
-	if outputErr == nil {
-		output = outputResp.Output
-		truncated = outputResp.Truncated
-	}
-	_, _ = conn.ReleaseTerminal(ctx, acp.ReleaseTerminalRequest{
-		SessionId:  acp.SessionId(sessionID),
-		TerminalId: terminalID,
-	})
-	return formatToolCommandOutput(status, output, exitCode, signal, truncated), false, nil
+	return result, false, nil
 }
 
-func handleBashOutput(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+func handleBashOutput(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	taskID := inputStr(input, "task_id")
 	if taskID == "" {
 		return "task_id is required", true, nil
 	}
-	block := inputBool(input, "block")
-	timeoutMs := 2 * 60 * 1000
-	if t, ok := inputInt(input, "timeout"); ok {
-		timeoutMs = t
-	}
-	if block {
-		waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
-		defer cancel()
-		exitResp, err := conn.WaitForTerminalExit(waitCtx, acp.WaitForTerminalExitRequest{
-			SessionId:  acp.SessionId(sessionID),
-			TerminalId: taskID,
-		})
-		var status string
-		var exitCode *int
-		var signal string
-		if err != nil {
-			if waitCtx.Err() != nil {
-				_, _ = conn.KillTerminalCommand(ctx, acp.KillTerminalCommandRequest{
-					SessionId:  acp.SessionId(sessionID),
-					TerminalId: taskID,
-				})
-				status = "timedOut"
-			} else {
-				status = "exited"
-			}
-		} else {
-			status = "exited"
-			exitCode = exitResp.ExitCode
-			if exitResp.Signal != nil {
-				signal = *exitResp.Signal
-			}
+	terminals := opts.terminalManager(conn, sessionID)
+	if inputBool(input, "block") {
+		timeout, budgetLimited := opts.limiter.bashTimeout(opts.bashTimeout(input))
+		if timeout <= 0 {
+			return opts.limiter.bashTimeExhausted(), true, nil
 		}
-		outputResp, outputErr := conn.TerminalOutput(ctx, acp.TerminalOutputRequest{
-			SessionId:  acp.SessionId(sessionID),
-			TerminalId: taskID,
-		})
-		var output string
-		var truncated bool
-		if outputErr == nil {
-			output = outputResp.Output
-			truncated = outputResp.Truncated
+		out, status := terminals.wait(ctx, taskID, timeout)
+		result := formatToolCommandOutput(status, out.Output, out.ExitCode, out.Signal, out.Truncated)
+		if status == "timedOut" && budgetLimited {
+			result += "\n" + opts.limiter.bashTimeExhausted()
 		}
-		_, _ = conn.ReleaseTerminal(ctx, acp.ReleaseTerminalRequest{
-			SessionId:  acp.SessionId(sessionID),
-			TerminalId: taskID,
-		})
-		return formatToolCommandOutput(status, output, exitCode, signal, truncated), false, nil
+		return result, false, nil
 	}
-	outputResp, err := conn.TerminalOutput(ctx, acp.TerminalOutputRequest{
-		SessionId:  acp.SessionId(sessionID),
-		TerminalId: taskID,
-	})
+	out, status, err := terminals.output(ctx, taskID)
 	if err != nil {
 		return "Retrieving bash output failed: " + err.Error(), true, nil
 	}
-	return formatToolCommandOutput("started", outputResp.Output, nil, "", outputResp.Truncated), false, nil
+	return formatToolCommandOutput(status, out.Output, out.ExitCode, out.Signal, out.Truncated), false, nil
 }
 
-func handleKillShell(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any) (string, bool, error) {
+func handleKillShell(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
 	shellID := inputStr(input, "shell_id")
 	if shellID == "" {
 		return "shell_id is required", true, nil
 	}
-	_, err := conn.KillTerminalCommand(ctx, acp.KillTerminalCommandRequest{
-		SessionId:  acp.SessionId(sessionID),
-		TerminalId: shellID,
-	})
-	if err != nil {
+	if err := opts.terminalManager(conn, sessionID).kill(ctx, shellID); err != nil {
 		return "Killing shell failed: " + err.Error(), true, nil
 	}
 	return "Command killed successfully.", false, nil
@@ -334,16 +566,25 @@ func handleKillShell(ctx context.Context, conn *acp.AgentSideConnection, session
 
 // replaceAndCalculateLocation performs text replacements and tracks line numbers
 // where replacements occur. Returns the new content and sorted unique line numbers.
-func replaceAndCalculateLocation(fileContent string, edits []EditOperation) (string, []int, error) {
+// ids generates the markers; nil uses random ones.
+func replaceAndCalculateLocation(ids IDSource, fileContent string, edits []EditOperation) (string, []int, error) {
 	currentContent := fileContent
-	markerPrefix := fmt.Sprintf("__REPLACE_MARKER_%s_", randomString(9))
+	markerPrefix := fmt.Sprintf("__REPLACE_MARKER_%s_", idsOr(ids).RandomString(9))
 	markerCounter := 0
-	var markers []string
 
 	for _, edit := range edits {
 		if edit.OldText == "" {
 			return "", nil, fmt.Errorf("The provided `old_string` is empty.\n\nNo edits were applied.")
 		}
+		if edit.ExpectedReplacements > 0 {
+			if n := strings.Count(currentContent, edit.OldText); n != edit.ExpectedReplacements {
+				return "", nil, fmt.Errorf(
+					"Expected %d occurrences of `old_string` but found %d: %q.\n\nNo edits were applied.",
+					edit.ExpectedReplacements, n, edit.OldText,
+				)
+			}
+			edit.ReplaceAll = edit.ExpectedReplacements > 1
+		}
 		if edit.ReplaceAll {
 			var parts []string
 			lastIndex := 0
@@ -353,10 +594,7 @@ func replaceAndCalculateLocation(fileContent string, edits []EditOperation) (str
 				idx := strings.Index(currentContent[searchIndex:], edit.OldText)
 				if idx == -1 {
 					if !found {
-						return "", nil, fmt.Errorf(
-							"The provided `old_string` does not appear in the file: %q.\n\nNo edits were applied.",
-							edit.OldText,
-						)
+						return "", nil, editNotFoundError(currentContent, edit.OldText, 0)
 					}
 					break
 				}
@@ -365,7 +603,6 @@ func replaceAndCalculateLocation(fileContent string, edits []EditOperation) (str
 				parts = append(parts, currentContent[lastIndex:idx])
 				marker := fmt.Sprintf("%s%d__", markerPrefix, markerCounter)
 				markerCounter++
-				markers = append(markers, marker)
 				parts = append(parts, marker+edit.NewText)
 				lastIndex = idx + len(edit.OldText)
 				searchIndex = lastIndex
@@ -373,36 +610,68 @@ func replaceAndCalculateLocation(fileContent string, edits []EditOperation) (str
 			parts = append(parts, currentContent[lastIndex:])
 			currentContent = strings.Join(parts, "")
 		} else {
-			idx := strings.Index(currentContent, edit.OldText)
-			if idx == -1 {
+			var start, end int
+			newText := edit.NewText
+			count := strings.Count(currentContent, edit.OldText)
+			switch {
+			case edit.OccurrenceIndex > 0:
+				if edit.OccurrenceIndex > count {
+					return "", nil, fmt.Errorf(
+						"occurrence_index is %d but `old_string` appears %d times: %q.\n\nNo edits were applied.",
+						edit.OccurrenceIndex, count, edit.OldText,
+					)
+				}
+				start = nthIndex(currentContent, edit.OldText, edit.OccurrenceIndex)
+				end = start + len(edit.OldText)
+			case count > 1:
 				return "", nil, fmt.Errorf(
-					"The provided `old_string` does not appear in the file: %q.\n\nNo edits were applied.",
-					edit.OldText,
+					"Found %d matches of `old_string`, but replace_all is false: %q. Set replace_all to replace every match, "+
+						"or provide more context or an occurrence_index to select one.\n\nNo edits were applied.",
+					count, edit.OldText,
 				)
+			case count == 1:
+				start = strings.Index(currentContent, edit.OldText)
+				end = start + len(edit.OldText)
+			default:
+				// Retry ignoring whitespace differences, a common cause of
+				// failed edits.
+				match, candidates := findFuzzyMatch(currentContent, edit.OldText, edit.NewText)
+				if candidates != 1 {
+					return "", nil, editNotFoundError(currentContent, edit.OldText, candidates)
+				}
+				start, end, newText = match.start, match.end, match.newText
 			}
 
 			marker := fmt.Sprintf("%s%d__", markerPrefix, markerCounter)
 			markerCounter++
-			markers = append(markers, marker)
-			currentContent = currentContent[:idx] + marker + edit.NewText + currentContent[idx+len(edit.OldText):]
+			currentContent = currentContent[:start] + marker + newText + currentContent[end:]
 		}
 	}
 
-	// Find line numbers where markers appear
+	// Remove the markers in one pass, noting the line each appears on. Edits
+	// may have removed earlier markers.
 	var lineNumbers []int
-	for _, marker := range markers {
-		idx := strings.Index(currentContent, marker)
-		if idx != -1 {
-			lineNum := countLines(currentContent[:idx])
-			lineNumbers = append(lineNumbers, lineNum)
+	var final strings.Builder
+	final.Grow(len(currentContent))
+	line := 0
+	for rest := currentContent; ; {
+		idx := strings.Index(rest, markerPrefix)
+		if idx == -1 {
+			final.WriteString(rest)
+			break
+		}
+		line += countLines(rest[:idx])
+		final.WriteString(rest[:idx])
+		rest = rest[idx+len(markerPrefix):]
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		if digits > 0 && strings.HasPrefix(rest[digits:], "__") {
+			lineNumbers = append(lineNumbers, line)
+			rest = rest[digits+2:]
+		} else {
+			final.WriteString(markerPrefix)
 		}
 	}
-
-	// Remove all markers from the final content
-	finalContent := currentContent
-	for _, marker := range markers {
-		finalContent = strings.Replace(finalContent, marker, "", 1)
-	}
+	finalContent := final.String()
 
 	// Dedupe and sort line numbers
 	seen := make(map[int]bool)
@@ -418,6 +687,22 @@ func replaceAndCalculateLocation(fileContent string, edits []EditOperation) (str
 	return finalContent, unique, nil
 }
 
+// nthIndex returns the byte index of the n-th (1-based) non-overlapping
+// occurrence of substr in s, or -1.
+func nthIndex(s, substr string, n int) int {
+	offset := 0
+	for i := 1; ; i++ {
+		idx := strings.Index(s[offset:], substr)
+		if idx == -1 {
+			return -1
+		}
+		if i == n {
+			return offset + idx
+		}
+		offset += idx + len(substr)
+	}
+}
+
 type diffHunk struct {
 	oldStart int
 	oldCount int
@@ -458,56 +743,7 @@ func splitLines(content string) []string {
 
 // computeDiffHunks computes unified diff hunks between old and new line slices.
 func computeDiffHunks(oldLines, newLines []string) []diffHunk {
-	m := len(oldLines)
-	n := len(newLines)
-
-	// Build LCS table
-	lcs := make([][]int, m+1)
-	for i := range lcs {
-		lcs[i] = make([]int, n+1)
-	}
-	for i := m - 1; i >= 0; i-- {
-		for j := n - 1; j >= 0; j-- {
-			if oldLines[i] == newLines[j] {
-				lcs[i][j] = lcs[i+1][j+1] + 1
-			} else if lcs[i+1][j] > lcs[i][j+1] {
-				lcs[i][j] = lcs[i+1][j]
-			} else {
-				lcs[i][j] = lcs[i][j+1]
-			}
-		}
-	}
-
-	type diffOp struct {
-		op   byte
-		line string
-		oldN int
-		newN int
-	}
-
-	var ops []diffOp
-	i, j := 0, 0
-	for i < m && j < n {
-		if oldLines[i] == newLines[j] {
-			ops = append(ops, diffOp{' ', oldLines[i], i, j})
-			i++
-			j++
-		} else if lcs[i+1][j] >= lcs[i][j+1] {
-			ops = append(ops, diffOp{'-', oldLines[i], i, j})
-			i++
-		} else {
-			ops = append(ops, diffOp{'+', newLines[j], i, j})
-			j++
-		}
-	}
-	for i < m {
-		ops = append(ops, diffOp{'-', oldLines[i], i, j})
-		i++
-	}
-	for j < n {
-		ops = append(ops, diffOp{'+', newLines[j], i, j})
-		j++
-	}
+	ops := myersDiff(oldLines, newLines)
 
 	const contextLines = 3
 	var hunks []diffHunk
@@ -604,6 +840,8 @@ func formatToolCommandOutput(status string, output string, exitCode *int, signal
 		sb.WriteString("Killed. ")
 	case "timedOut":
 		sb.WriteString("Timed out. ")
+	case "interrupted":
+		sb.WriteString("Interrupted. ")
 	}
 	if exitCode != nil {
 		sb.WriteString(fmt.Sprintf("Exited with code %d.", *exitCode))
@@ -638,16 +876,6 @@ func isInternalPath(filePath string) bool {
 	return true
 }
 
-// randomString generates a random alphanumeric string of the given length.
-func randomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
-}
-
 // countLines counts the number of line breaks in text,
 // handling \r\n, \r, and \n line endings (matching TS split(/\r\n|\r|\n/) behavior).
 func countLines(text string) int {
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestMcpServer_ReplaceAndCalculateLocation tests the edit replacement logic
@@ -50,6 +56,74 @@ func TestMcpServer_ReplaceAndCalculateLocation(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name:    "ambiguous old_string should error",
+			content: "x = 1\nx = 1\n",
+			edits: []EditOperation{
+				{OldText: "x = 1", NewText: "x = 2"},
+			},
+			expectErr: true,
+		},
+		{
+			name:    "occurrence_index selects a match",
+			content: "x = 1\ny\nx = 1\n",
+			edits: []EditOperation{
+				{OldText: "x = 1", NewText: "x = 2", OccurrenceIndex: 2},
+			},
+			expected:    "x = 1\ny\nx = 2\n",
+			expectLines: 1,
+		},
+		{
+			name:    "occurrence_index out of range should error",
+			content: "x = 1\nx = 1\n",
+			edits: []EditOperation{
+				{OldText: "x = 1", NewText: "x = 2", OccurrenceIndex: 3},
+			},
+			expectErr: true,
+		},
+		{
+			name:    "expected_replacements replaces every match",
+			content: "a b a",
+			edits: []EditOperation{
+				{OldText: "a", NewText: "c", ExpectedReplacements: 2},
+			},
+			expected:    "c b c",
+			expectLines: 1,
+		},
+		{
+			name:    "expected_replacements mismatch should error",
+			content: "a b a a",
+			edits: []EditOperation{
+				{OldText: "a", NewText: "c", ExpectedReplacements: 2},
+			},
+			expectErr: true,
+		},
+		{
+			name:    "trailing whitespace in file is ignored",
+			content: "func a() {  \n\treturn 1\t\n}\n",
+			edits: []EditOperation{
+				{OldText: "func a() {\n\treturn 1\n}", NewText: "func a() {\n\treturn 2\n}"},
+			},
+			expected:    "func a() {\n\treturn 2\n}\n",
+			expectLines: 1,
+		},
+		{
+			name:    "indentation drift is re-indented",
+			content: "if x {\n        call()\n        done()\n}",
+			edits: []EditOperation{
+				{OldText: "    call()\n    done()\n", NewText: "    call()\n      nested()\n"},
+			},
+			expected:    "if x {\n        call()\n          nested()\n}",
+			expectLines: 1,
+		},
+		{
+			name:    "ambiguous fuzzy match should error",
+			content: "a  \nb\na\t\n",
+			edits: []EditOperation{
+				{OldText: "a\n", NewText: "c\n"},
+			},
+			expectErr: true,
+		},
 		{
 			name:    "old_string not found should error",
 			content: "hello world",
@@ -62,7 +136,7 @@ func TestMcpServer_ReplaceAndCalculateLocation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, lines, err := replaceAndCalculateLocation(tt.content, tt.edits)
+			result, lines, err := replaceAndCalculateLocation(nil, tt.content, tt.edits)
 			if tt.expectErr {
 				if err == nil {
 					t.Error("expected error, got nil")
@@ -278,3 +352,288 @@ func TestMcpServer_SplitLines(t *testing.T) {
 		}
 	}
 }
+
+// TestMcpServer_MyersDiff verifies that diff ops reconstruct both inputs
+// and that the edit script is minimal.
+func TestMcpServer_MyersDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		old, new  []string
+		wantEdits int
+	}{
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 0},
+		{"empty old", nil, []string{"a", "b"}, 2},
+		{"empty new", []string{"a", "b"}, nil, 2},
+		{"disjoint", []string{"a", "b"}, []string{"c", "d"}, 4},
+		{"classic", strings.Split("ABCABBA", ""), strings.Split("CBABAC", ""), 5},
+		{"insert middle", []string{"a", "c"}, []string{"a", "b", "c"}, 1},
+		{"repeated lines", []string{"x", "x", "y", "x"}, []string{"x", "y", "x", "x"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := myersDiff(tt.old, tt.new)
+			var gotOld, gotNew []string
+			edits := 0
+			for _, op := range ops {
+				switch op.op {
+				case ' ':
+					gotOld = append(gotOld, op.line)
+					gotNew = append(gotNew, op.line)
+				case '-':
+					gotOld = append(gotOld, op.line)
+					edits++
+				case '+':
+					gotNew = append(gotNew, op.line)
+					edits++
+				}
+			}
+			if strings.Join(gotOld, "\n") != strings.Join(tt.old, "\n") {
+				t.Errorf("old side mismatch: got %v, want %v", gotOld, tt.old)
+			}
+			if strings.Join(gotNew, "\n") != strings.Join(tt.new, "\n") {
+				t.Errorf("new side mismatch: got %v, want %v", gotNew, tt.new)
+			}
+			if edits != tt.wantEdits {
+				t.Errorf("expected %d edits, got %d", tt.wantEdits, edits)
+			}
+		})
+	}
+}
+
+// TestMcpServer_CreateUnifiedDiffLargeFile checks a single-line edit in a
+// large file produces one small hunk.
+func TestMcpServer_CreateUnifiedDiffLargeFile(t *testing.T) {
+	oldContent, newContent := largeEditFixture(20000)
+	diff := createUnifiedDiff("big.go", oldContent, newContent)
+	if strings.Count(diff, "@@ -") != 1 {
+		t.Fatalf("expected exactly one hunk, got:\n%s", diff)
+	}
+	if !strings.Contains(diff, "@@ -9997,8 +9997,8 @@") {
+		t.Errorf("unexpected hunk header:\n%s", diff)
+	}
+	if !strings.Contains(diff, "-line 10000\n+changed 10000\n") {
+		t.Errorf("diff missing change:\n%s", diff)
+	}
+}
+
+// largeEditFixture returns a file of n lines and a copy with the middle line changed.
+func largeEditFixture(n int) (string, string) {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	oldContent := strings.Join(lines, "\n")
+	lines[n/2-1] = fmt.Sprintf("changed %d", n/2)
+	return oldContent, strings.Join(lines, "\n")
+}
+
+func BenchmarkCreateUnifiedDiff_LargeFileSingleEdit(b *testing.B) {
+	oldContent, newContent := largeEditFixture(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		createUnifiedDiff("big.go", oldContent, newContent)
+	}
+}
+
+func BenchmarkCreateUnifiedDiff_LargeFileScatteredEdits(b *testing.B) {
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i)
+	}
+	oldContent := strings.Join(lines, "\n")
+	for i := 0; i < len(lines); i += 50 {
+		lines[i] = fmt.Sprintf("edited %d", i)
+	}
+	newContent := strings.Join(lines, "\n")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		createUnifiedDiff("big.go", oldContent, newContent)
+	}
+}
+
+// benchmarkSizes are the file sizes, in lines, of the size-parameterized
+// benchmarks.
+var benchmarkSizes = []int{100, 1000, 10000, 100000}
+
+func BenchmarkComputeDiffHunks(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		oldContent, newContent := largeEditFixture(n)
+		oldLines, newLines := splitLines(oldContent), splitLines(newContent)
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				computeDiffHunks(oldLines, newLines)
+			}
+		})
+	}
+}
+
+func BenchmarkReplaceAndCalculateLocation(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		content, _ := largeEditFixture(n)
+		b.Run(fmt.Sprintf("lines=%d/single", n), func(b *testing.B) {
+			edits := []EditOperation{{OldText: fmt.Sprintf("line %d\n", n/2), NewText: "changed\n"}}
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := replaceAndCalculateLocation(nil, content, edits); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("lines=%d/all", n), func(b *testing.B) {
+			edits := []EditOperation{{OldText: "line", NewText: "row", ReplaceAll: true}}
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := replaceAndCalculateLocation(nil, content, edits); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMcpServer_NumberLines(t *testing.T) {
+	tests := []struct {
+		content   string
+		firstLine int
+		want      string
+	}{
+		{"", 1, ""},
+		{"a\nb", 1, "     1→a\n     2→b"},
+		{"a\n\nc\n", 9, "     9→a\n    10→\n    11→c\n"},
+	}
+	for _, tt := range tests {
+		if got := numberLines(tt.content, tt.firstLine); got != tt.want {
+			t.Errorf("numberLines(%q, %d) = %q, want %q", tt.content, tt.firstLine, got, tt.want)
+		}
+	}
+}
+
+func TestMcpServer_HandleReadLineNumbers(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+	path := filepath.Join(dir, "plans", "plan.md")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := map[string]any{"file_path": path, "offset": float64(2), "limit": float64(2)}
+
+	got, isErr, err := handleRead(context.Background(), nil, "s1", input, BuiltinToolOptions{})
+	if err != nil || isErr {
+		t.Fatalf("handleRead failed: %q, %v", got, err)
+	}
+	if !strings.HasPrefix(got, "     2→two\n     3→three") {
+		t.Errorf("expected numbering from the offset, got %q", got)
+	}
+
+	got, _, _ = handleRead(context.Background(), nil, "s1", input, BuiltinToolOptions{DisableLineNumbers: true})
+	if !strings.HasPrefix(got, "two\nthree") {
+		t.Errorf("expected plain output, got %q", got)
+	}
+}
+
+func TestMcpServer_EditNotFoundNearestMiss(t *testing.T) {
+	content := "package main\n\nfunc main() {\n\tfmt.Println(\"hi\")\n\tos.Exit(0)\n}\n"
+	_, _, err := replaceAndCalculateLocation(nil, content, []EditOperation{
+		{OldText: "\tfmt.Println(\"hi\")\n\tos.Exit(1)", NewText: "x"},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"does not appear", "starts at line 4", "os.Exit(0)", "No edits were applied."} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%s", want, err)
+		}
+	}
+
+	_, _, err = replaceAndCalculateLocation(nil, "a  \nb\na\t\n", []EditOperation{{OldText: "a\n", NewText: "c"}})
+	if err == nil || !strings.Contains(err.Error(), "matches 2 locations") {
+		t.Errorf("expected ambiguity error, got %v", err)
+	}
+}
+
+func TestBuiltinToolOptions_WithLimits(t *testing.T) {
+	t.Setenv("ACP_MAX_READ_BYTES", "1000")
+	t.Setenv("ACP_MAX_OUTPUT_BYTES", "2000")
+
+	opts := BuiltinToolOptions{}.withLimits(nil, nil)
+	if opts.readLimit() != 1000 || opts.outputLimit() != 2000 {
+		t.Errorf("process env not applied: %+v", opts)
+	}
+	opts = BuiltinToolOptions{}.withLimits(nil, map[string]string{"ACP_MAX_READ_BYTES": "3000"})
+	if opts.readLimit() != 3000 {
+		t.Errorf("session env should override process env: %+v", opts)
+	}
+	opts = BuiltinToolOptions{}.withLimits(map[string]any{"maxReadBytes": float64(4000), "maxOutputBytes": float64(-1)}, map[string]string{"ACP_MAX_READ_BYTES": "3000"})
+	if opts.readLimit() != 4000 || opts.outputLimit() != 2000 {
+		t.Errorf("meta should override env and ignore invalid values: %+v", opts)
+	}
+
+	t.Setenv("ACP_MAX_READ_BYTES", "")
+	t.Setenv("ACP_MAX_OUTPUT_BYTES", "")
+	opts = BuiltinToolOptions{MaxReadBytes: 7}.withLimits(nil, nil)
+	if opts.readLimit() != 7 || opts.outputLimit() != MaxOutputBytes {
+		t.Errorf("unexpected defaults: %+v", opts)
+	}
+}
+
+func TestBuiltinToolOptions_BashTimeout(t *testing.T) {
+	t.Setenv("BASH_DEFAULT_TIMEOUT_MS", "")
+	t.Setenv("BASH_MAX_TIMEOUT_MS", "")
+
+	opts := BuiltinToolOptions{}.withLimits(map[string]any{"": float64(1)}, nil)
+	if got := opts.bashTimeout(nil); got != 2*time.Minute {
+		t.Errorf("default timeout = %v", got)
+	}
+	if got := opts.bashTimeout(map[string]any{"timeout": float64(24 * 60 * 60 * 1000)}); got != 10*time.Minute {
+		t.Errorf("requested timeout not clamped: %v", got)
+	}
+
+	opts = BuiltinToolOptions{}.withLimits(nil, map[string]string{"BASH_DEFAULT_TIMEOUT_MS": "5000", "BASH_MAX_TIMEOUT_MS": "30000"})
+	if got := opts.bashTimeout(map[string]any{"timeout": float64(-1)}); got != 5*time.Second {
+		t.Errorf("settings default timeout = %v", got)
+	}
+	if got := opts.bashTimeout(map[string]any{"timeout": float64(60000)}); got != 30*time.Second {
+		t.Errorf("settings max timeout = %v", got)
+	}
+	if got := opts.bashTimeout(map[string]any{"timeout": float64(1000)}); got != time.Second {
+		t.Errorf("timeout under the max = %v", got)
+	}
+}
+
+func TestMcpServer_HandleReadLimitHint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", dir)
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte(strings.Repeat("0123456789\n", 300)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _ := handleRead(context.Background(), nil, "s1", map[string]any{"file_path": path}, BuiltinToolOptions{MaxReadBytes: 2000})
+	if !strings.Contains(got, "Read 181 lines (hit 2KB limit). Continue with offset=181.") {
+		t.Errorf("unexpected read info:\n%s", got[strings.Index(got, "<file-read-info>"):])
+	}
+}
+
+func TestHandleBuiltinTool_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	opts := BuiltinToolOptions{ReadOnly: true}
+	for _, tool := range []string{"Write", "Edit", "Bash"} {
+		result, err := handleBuiltinTool(context.Background(), nil, "s1", tool, map[string]any{"file_path": path, "command": "true"}, opts)
+		if err != nil || !result.IsError || !strings.Contains(result.Text, "read-only session") {
+			t.Errorf("%s: unexpected result %+v, %v", tool, result, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("read-only Write created the file")
+	}
+
+	tools := readOnlyDisallowedTools()
+	for _, want := range []string{"Bash", "MultiEdit", ACPToolNamePrefix + "Write", ACPToolNamePrefix + "Edit"} {
+		if !slices.Contains(tools, want) {
+			t.Errorf("disallowed tools %v missing %s", tools, want)
+		}
+	}
+}
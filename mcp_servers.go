@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// mcpServersUpdateMethod adds MCP servers to or removes them from a
+// session.
+const mcpServersUpdateMethod = extMethodPrefix + "mcp_servers/update"
+
+// mcpServersUpdateParams is the payload of _claude/mcp_servers/update. Add
+// takes servers as in session/new, replacing any of the same name; Remove
+// names servers to drop.
+type mcpServersUpdateParams struct {
+	SessionID string          `json:"sessionId"`
+	Add       []acp.McpServer `json:"add"`
+	Remove    []string        `json:"remove"`
+}
+
+// mcpServersResult lists the session's MCP servers after an update.
+type mcpServersResult struct {
+	Servers []string `json:"servers"`
+}
+
+// extUpdateMCPServers changes the MCP servers of a session. The CLI reads
+// its MCP config only at startup, so it is restarted with the new config,
+// resuming the conversation. The running CLI is kept until the new one has
+// started; if it cannot start, the session keeps its CLI and servers. The
+// agent's own tool server cannot be replaced or removed. It fails while a
+// prompt is running.
+func (a *ClaudeAcpAgent) extUpdateMCPServers(_ context.Context, params json.RawMessage) (any, error) {
+	var p mcpServersUpdateParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	added := mapMcpServers(p.Add)
+	if len(added) != len(p.Add) {
+		return nil, acp.NewInvalidParams(map[string]any{"error": "each server must be an http, sse or stdio server with a unique name"})
+	}
+	for name := range added {
+		if name == "" || name == acpMcpServerName {
+			return nil, acp.NewInvalidParams(map[string]any{"error": "invalid MCP server name: " + name})
+		}
+	}
+	if slices.Contains(p.Remove, acpMcpServerName) {
+		return nil, acp.NewInvalidParams(map[string]any{"error": "invalid MCP server name: " + acpMcpServerName})
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.turnMu.TryLock() {
+		return nil, newAgentError(acp.NewInvalidRequest, errKindSessionBusy, p.SessionID, true, "cannot change MCP servers while a prompt is running")
+	}
+	defer session.turnMu.Unlock()
+
+	previous := session.startOptions.McpServers
+	servers := maps.Clone(previous)
+	if servers == nil {
+		servers = map[string]McpServerConfig{}
+	}
+	for _, name := range p.Remove {
+		delete(servers, name)
+	}
+	maps.Copy(servers, added)
+
+	session.startOptions.McpServers = servers
+	if session.backend != nil {
+		if err := session.restartProcess(); err != nil {
+			session.startOptions.McpServers = previous
+			return nil, errCLIStart(err)
+		}
+	}
+	session.log().Info("Updated MCP servers", "added", slices.Sorted(maps.Keys(added)), "removed", p.Remove)
+	return mcpServersResult{Servers: slices.Sorted(maps.Keys(servers))}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestExtUpdateMCPServers(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	_, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	backend := &restartBackend{}
+	session := &Session{
+		process: &ClaudeCodeProcess{stdin: w},
+		backend: backend,
+		startOptions: ProcessOptions{SessionID: "s1", McpServers: map[string]McpServerConfig{
+			acpMcpServerName: {Type: "http", URL: "http://127.0.0.1:1/mcp"},
+			"old":            {Type: "stdio", Command: "old-server"},
+		}},
+	}
+	agent.sessions["s1"] = session
+	update := func(params string) (any, error) {
+		return agent.extUpdateMCPServers(context.Background(), json.RawMessage(params))
+	}
+
+	res, err := update(`{"sessionId":"s1","add":[{"name":"docs","command":"docs-server","args":["--stdio"],"env":[]}],"remove":["old"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.(mcpServersResult).Servers; !slices.Equal(got, []string{acpMcpServerName, "docs"}) {
+		t.Errorf("servers = %v", got)
+	}
+	if len(backend.starts) != 1 {
+		t.Fatalf("expected the CLI to be restarted once, got %d", len(backend.starts))
+	}
+	opts := backend.starts[0]
+	if opts.Resume != "" {
+		t.Errorf("expected a session without turns to start afresh, got resume %q", opts.Resume)
+	}
+	if cfg := opts.McpServers["docs"]; cfg.Command != "docs-server" || len(opts.McpServers) != 2 {
+		t.Errorf("unexpected MCP config %+v", opts.McpServers)
+	}
+
+	for _, params := range []string{
+		`{"sessionId":"s1","remove":["acp"]}`,
+		`{"sessionId":"s1","add":[{"name":"acp","command":"x","args":[],"env":[]}]}`,
+		`{"sessionId":"s1","add":[{"name":"a","command":"x","args":[],"env":[]},{"name":"a","command":"y","args":[],"env":[]}]}`,
+	} {
+		var reqErr *acp.RequestError
+		if _, err := update(params); !errors.As(err, &reqErr) || reqErr.Code != -32602 {
+			t.Errorf("%s: expected InvalidParams, got %v", params, err)
+		}
+	}
+
+	session.turnMu.Lock()
+	var reqErr *acp.RequestError
+	if _, err := update(`{"sessionId":"s1","remove":["docs"]}`); !errors.As(err, &reqErr) || reqErr.Code != -32600 {
+		t.Errorf("expected InvalidRequest while a prompt is running, got %v", err)
+	}
+	session.turnMu.Unlock()
+	if len(backend.starts) != 1 {
+		t.Errorf("expected rejected updates not to restart the CLI, got %d starts", len(backend.starts))
+	}
+
+	// A CLI that fails to start leaves the running one and its servers.
+	running := session.proc()
+	backend.err = errors.New("no CLI")
+	if _, err := update(`{"sessionId":"s1","remove":["docs"]}`); err == nil {
+		t.Fatal("expected the failed restart to be reported")
+	}
+	if session.proc() != running {
+		t.Error("expected the running CLI kept")
+	}
+	if _, ok := session.startOptions.McpServers["docs"]; !ok {
+		t.Errorf("expected the MCP servers rolled back, got %v", session.startOptions.McpServers)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// mcpStatusMethod is the extension notification reporting the state of a
+// session's MCP servers whenever it changes.
+const mcpStatusMethod = extMethodPrefix + "mcp_status"
+
+// mcpServerStatus is the state of an MCP server as the CLI's system init
+// message reports it: connected, pending, failed or needs-auth.
+type mcpServerStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// available reports whether the server's tools can be used.
+func (s mcpServerStatus) available() bool {
+	return s.Status == "connected" || s.Status == "pending"
+}
+
+// mcpStatusParams is the payload of _claude/mcp_status. Warnings describe
+// the servers that became unavailable since the last notification, for the
+// client to show.
+type mcpStatusParams struct {
+	SessionID string            `json:"sessionId"`
+	Servers   []mcpServerStatus `json:"servers"`
+	Warnings  []string          `json:"warnings,omitempty"`
+}
+
+// parseMCPServerStatus returns the MCP servers of a system init message,
+// sorted by name. It reports false for other messages.
+func parseMCPServerStatus(raw map[string]any) ([]mcpServerStatus, bool) {
+	if raw["subtype"] != "init" {
+		return nil, false
+	}
+	list, ok := raw["mcp_servers"].([]any)
+	if !ok {
+		return nil, false
+	}
+	servers := []mcpServerStatus{}
+	for _, item := range list {
+		m, _ := item.(map[string]any)
+		name, _ := m["name"].(string)
+		status, _ := m["status"].(string)
+		if name != "" {
+			servers = append(servers, mcpServerStatus{Name: name, Status: status})
+		}
+	}
+	slices.SortFunc(servers, func(a, b mcpServerStatus) int { return strings.Compare(a.Name, b.Name) })
+	return servers, true
+}
+
+// updateMCPStatus records the session's MCP server states. It reports
+// whether they changed and returns the servers that became unavailable.
+func (s *Session) updateMCPStatus(servers []mcpServerStatus) (changed bool, lost []mcpServerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Equal(s.mcpServers, servers) {
+		return false, nil
+	}
+	for _, server := range servers {
+		if server.available() {
+			continue
+		}
+		i := slices.IndexFunc(s.mcpServers, func(old mcpServerStatus) bool { return old.Name == server.Name })
+		if i < 0 || s.mcpServers[i].available() {
+			lost = append(lost, server)
+		}
+	}
+	s.mcpServers = servers
+	return true, lost
+}
+
+// reportMCPStatus handles the MCP server states of a system message. When
+// they change it sends _claude/mcp_status, warning about each server that
+// became unavailable, since the model silently goes without its tools.
+func (a *ClaudeAcpAgent) reportMCPStatus(sessionID string, session *Session, raw map[string]any) {
+	servers, ok := parseMCPServerStatus(raw)
+	if !ok {
+		return
+	}
+	changed, lost := session.updateMCPStatus(servers)
+	if !changed {
+		return
+	}
+	params := mcpStatusParams{SessionID: sessionID, Servers: servers}
+	for _, server := range lost {
+		session.log().Warn("MCP server unavailable", "server", server.Name, "status", server.Status)
+		params.Warnings = append(params.Warnings, mcpStatusWarning(server))
+	}
+	if err := a.sendExtNotification(mcpStatusMethod, params); err != nil {
+		session.log().Warn("Failed to send MCP server status", "error", err)
+	}
+}
+
+// mcpStatusWarning is the message shown for an unavailable server.
+func mcpStatusWarning(server mcpServerStatus) string {
+	reason := "failed to connect"
+	if server.Status == "needs-auth" {
+		reason = "needs authentication"
+	}
+	return fmt.Sprintf("MCP server %q %s; its tools are unavailable.", server.Name, reason)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseMCPServerStatus(t *testing.T) {
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(`{"type":"system","subtype":"init","mcp_servers":[
+		{"name":"search","status":"failed"},{"name":"acp","status":"connected"},{"status":"failed"}]}`), &raw); err != nil {
+		t.Fatal(err)
+	}
+	servers, ok := parseMCPServerStatus(raw)
+	want := []mcpServerStatus{{"acp", "connected"}, {"search", "failed"}}
+	if !ok || len(servers) != 2 || servers[0] != want[0] || servers[1] != want[1] {
+		t.Errorf("got %v, %v; want %v", servers, ok, want)
+	}
+
+	if _, ok := parseMCPServerStatus(map[string]any{"subtype": "compact_boundary"}); ok {
+		t.Error("expected non-init messages to be ignored")
+	}
+}
+
+func TestReportMCPStatus(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	var ext strings.Builder
+	agent.extOut = &ext
+	session := &Session{}
+
+	init := func(servers ...any) map[string]any {
+		return map[string]any{"type": "system", "subtype": "init", "mcp_servers": servers}
+	}
+	server := func(name, status string) any { return map[string]any{"name": name, "status": status} }
+
+	agent.reportMCPStatus("s1", session, init(server("acp", "connected"), server("search", "failed")))
+	if !strings.Contains(ext.String(), `"method":"_claude/mcp_status"`) || !strings.Contains(ext.String(), `{"name":"search","status":"failed"}`) {
+		t.Errorf("expected an mcp_status notification, got %s", ext.String())
+	}
+	if !strings.Contains(ext.String(), `"warnings":["MCP server \"search\" failed to connect`) {
+		t.Errorf("expected a warning about search, got %s", ext.String())
+	}
+
+	// The same state again is not reported; a server already failed is not
+	// warned about twice.
+	ext.Reset()
+	agent.reportMCPStatus("s1", session, init(server("search", "failed"), server("acp", "connected")))
+	if ext.Len() != 0 {
+		t.Errorf("expected no report for an unchanged state, got %s", ext.String())
+	}
+	agent.reportMCPStatus("s1", session, init(server("acp", "needs-auth"), server("search", "failed")))
+	if got := ext.String(); !strings.Contains(got, `"warnings":["MCP server \"acp\" needs authentication; its tools are unavailable."]`) {
+		t.Errorf("expected a warning about acp only, got %s", got)
+	}
+}
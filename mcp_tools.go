@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// MCPToolAnnotations are the behavior hints an MCP server declares for one
+// of its tools.
+type MCPToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    *bool  `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool  `json:"destructiveHint,omitempty"`
+	OpenWorldHint   *bool  `json:"openWorldHint,omitempty"`
+}
+
+// parseMCPToolAnnotations reads the mcpToolAnnotations entry of NewSession
+// _meta, which maps server names to tool names to annotations. It returns
+// annotations keyed by the tool name Claude uses, mcp__<server>__<tool>.
+func parseMCPToolAnnotations(meta map[string]any) map[string]MCPToolAnnotations {
+	raw, ok := meta["mcpToolAnnotations"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var servers map[string]map[string]MCPToolAnnotations
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil
+	}
+	out := make(map[string]MCPToolAnnotations)
+	for server, tools := range servers {
+		for tool, annotations := range tools {
+			out["mcp__"+server+"__"+tool] = annotations
+		}
+	}
+	return out
+}
+
+// splitMCPToolName splits a tool name of the form mcp__<server>__<tool>.
+func splitMCPToolName(name string) (server, tool string, ok bool) {
+	rest, found := strings.CutPrefix(name, "mcp__")
+	if !found {
+		return "", "", false
+	}
+	server, tool, found = strings.Cut(rest, "__")
+	if !found || server == "" || tool == "" {
+		return "", "", false
+	}
+	return server, tool, true
+}
+
+// mcpToolInfo describes a call to a tool of a client-configured MCP server:
+// a readable title naming the server and tool, a kind derived from the
+// tool's annotations when they are known, and the input as a list.
+func mcpToolInfo(name string, input map[string]any, annotations *MCPToolAnnotations) (ToolInfo, bool) {
+	server, tool, ok := splitMCPToolName(name)
+	if !ok || strings.HasPrefix(name, ACPToolNamePrefix) {
+		return ToolInfo{}, false
+	}
+	title := humanizeToolName(tool)
+	kind := acp.ToolKindOther
+	if annotations != nil {
+		if annotations.Title != "" {
+			title = annotations.Title
+		}
+		kind = mcpToolKind(*annotations)
+	}
+	info := ToolInfo{Title: fmt.Sprintf("%s (%s)", title, server), Kind: kind}
+	if text := formatMCPToolInput(input); text != "" {
+		info.Content = []acp.ToolCallContent{acp.ToolContent(acp.TextBlock(text))}
+	}
+	return info, true
+}
+
+// mcpToolKind maps MCP tool annotations to an ACP tool kind. Read-only
+// tools that reach outside the local environment are fetches.
+func mcpToolKind(a MCPToolAnnotations) acp.ToolKind {
+	switch {
+	case a.ReadOnlyHint != nil && *a.ReadOnlyHint:
+		if a.OpenWorldHint != nil && *a.OpenWorldHint {
+			return acp.ToolKindFetch
+		}
+		return acp.ToolKindRead
+	case a.DestructiveHint != nil && *a.DestructiveHint:
+		return acp.ToolKindDelete
+	case a.ReadOnlyHint != nil:
+		return acp.ToolKindEdit
+	}
+	return acp.ToolKindOther
+}
+
+// humanizeToolName turns a tool name such as "create_issue" into
+// "Create issue".
+func humanizeToolName(tool string) string {
+	s := strings.Join(strings.FieldsFunc(tool, func(r rune) bool { return r == '_' || r == '-' }), " ")
+	if s == "" {
+		return tool
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// formatMCPToolInput renders tool input as a markdown list, one argument
+// per line in key order. Nested values are shown as JSON.
+func formatMCPToolInput(input map[string]any) string {
+	keys := make([]string, 0, len(input))
+	for k := range input {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		var value string
+		switch v := input[k].(type) {
+		case string:
+			value = v
+			if strings.Contains(v, "\n") {
+				value = "\n" + markdownEscape(v)
+			}
+		case map[string]any, []any:
+			data, _ := json.MarshalIndent(v, "", "  ")
+			value = "\n```json\n" + string(data) + "\n```"
+		default:
+			data, _ := json.Marshal(v)
+			value = string(data)
+		}
+		fmt.Fprintf(&b, "- **%s**: %s\n", k, value)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
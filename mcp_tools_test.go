@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestMcpToolInfo_Kind(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name        string
+		annotations *MCPToolAnnotations
+		want        acp.ToolKind
+	}{
+		{"unknown", nil, acp.ToolKindOther},
+		{"read only", &MCPToolAnnotations{ReadOnlyHint: &yes}, acp.ToolKindRead},
+		{"read only open world", &MCPToolAnnotations{ReadOnlyHint: &yes, OpenWorldHint: &yes}, acp.ToolKindFetch},
+		{"destructive", &MCPToolAnnotations{ReadOnlyHint: &no, DestructiveHint: &yes}, acp.ToolKindDelete},
+		{"writes", &MCPToolAnnotations{ReadOnlyHint: &no, DestructiveHint: &no}, acp.ToolKindEdit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := mcpToolInfo("mcp__github__create_issue", nil, tt.annotations)
+			if !ok {
+				t.Fatal("expected MCP tool info")
+			}
+			if info.Kind != tt.want {
+				t.Errorf("kind = %v, want %v", info.Kind, tt.want)
+			}
+		})
+	}
+}
+
+func TestMcpToolInfo_TitleAndInput(t *testing.T) {
+	info := toolInfoFromToolUse("mcp__github__create_issue", map[string]any{
+		"title":  "Crash on start",
+		"labels": []any{"bug"},
+		"number": float64(3),
+	})
+	if info.Title != "Create issue (github)" {
+		t.Errorf("title = %q", info.Title)
+	}
+	if len(info.Content) != 1 {
+		t.Fatalf("expected input content, got %d blocks", len(info.Content))
+	}
+	text := info.Content[0].Content.Content.Text.Text
+	for _, want := range []string{"- **labels**: \n```json", "- **number**: 3", "- **title**: Crash on start"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("input missing %q:\n%s", want, text)
+		}
+	}
+
+	info, _ = mcpToolInfo("mcp__github__create_issue", nil, &MCPToolAnnotations{Title: "Open an issue"})
+	if info.Title != "Open an issue (github)" {
+		t.Errorf("annotated title = %q", info.Title)
+	}
+
+	if _, ok := mcpToolInfo(ACPToolNamePrefix+"Read", nil, nil); ok {
+		t.Error("built-in ACP tools should not be treated as MCP tools")
+	}
+}
+
+func TestToAcpNotifications_MCPToolAnnotations(t *testing.T) {
+	cache := NewToolUseCache(0)
+	cache.SetToolAnnotations(parseMCPToolAnnotations(map[string]any{
+		"mcpToolAnnotations": map[string]any{
+			"db": map[string]any{"drop_table": map[string]any{"destructiveHint": true}},
+		},
+	}))
+	notifications := toAcpNotifications([]any{
+		map[string]any{"type": "tool_use", "id": "t1", "name": "mcp__db__drop_table", "input": map[string]any{"table": "users"}},
+	}, "assistant", "session-1", cache, nil)
+	if len(notifications) != 1 || notifications[0].Update.ToolCall == nil {
+		t.Fatalf("expected a tool call, got %+v", notifications)
+	}
+	if call := notifications[0].Update.ToolCall; call.Kind != acp.ToolKindDelete || call.Title != "Drop table (db)" {
+		t.Errorf("unexpected tool call: kind %v, title %q", call.Kind, call.Title)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// memoryScope names one of the CLAUDE.md memory files Claude Code loads.
+type memoryScope string
+
+const (
+	memoryProject memoryScope = "project" // <cwd>/CLAUDE.md, shared with the team
+	memoryLocal   memoryScope = "local"   // <cwd>/CLAUDE.local.md, personal to the project
+	memoryUser    memoryScope = "user"    // ~/.claude/CLAUDE.md, all projects
+)
+
+var memoryScopes = []memoryScope{memoryProject, memoryLocal, memoryUser}
+
+// memoryPath returns the file of a memory scope for a session in cwd.
+func memoryPath(scope memoryScope, cwd string) (string, error) {
+	switch scope {
+	case memoryProject:
+		return filepath.Join(cwd, "CLAUDE.md"), nil
+	case memoryLocal:
+		return filepath.Join(cwd, "CLAUDE.local.md"), nil
+	case memoryUser:
+		return filepath.Join(getClaudeConfigDir(), "CLAUDE.md"), nil
+	}
+	return "", acp.NewInvalidParams(map[string]any{"error": fmt.Sprintf("unknown memory scope %q", scope)})
+}
+
+// memoryFile is one memory file in the _claude/memory/read result.
+type memoryFile struct {
+	Scope   memoryScope `json:"scope"`
+	Path    string      `json:"path"`
+	Exists  bool        `json:"exists"`
+	Content string      `json:"content"`
+}
+
+// readMemory reads a memory file through the client when it can read
+// files, and from disk otherwise. A missing file is not an error.
+func (a *ClaudeAcpAgent) readMemory(ctx context.Context, sessionID, path string) (content string, exists bool, err error) {
+	if a.conn != nil && a.clientCapabilities != nil && a.clientCapabilities.Fs.ReadTextFile && !isInternalPath(path) {
+		resp, err := a.conn.ReadTextFile(ctx, acp.ReadTextFileRequest{SessionId: acp.SessionId(sessionID), Path: path})
+		if err == nil {
+			return resp.Content, true, nil
+		}
+		if isResourceNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// writeMemory writes a memory file through the client when it can write
+// files, so editors see the change, and to disk otherwise.
+func (a *ClaudeAcpAgent) writeMemory(ctx context.Context, sessionID, path, content string) error {
+	if session, err := a.lookupSession(sessionID); err == nil {
+		session.toolOptions.files.invalidate(path)
+	}
+	if a.conn != nil && a.clientCapabilities != nil && a.clientCapabilities.Fs.WriteTextFile && !isInternalPath(path) {
+		_, err := a.conn.WriteTextFile(ctx, acp.WriteTextFileRequest{SessionId: acp.SessionId(sessionID), Path: path, Content: content})
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// addMemory appends text as a list item to a memory file, creating it if
+// needed, and returns the file's path.
+func (a *ClaudeAcpAgent) addMemory(ctx context.Context, sessionID string, session *Session, scope memoryScope, text string) (string, error) {
+	path, err := memoryPath(scope, session.cwd)
+	if err != nil {
+		return "", err
+	}
+	content, _, err := a.readMemory(ctx, sessionID, path)
+	if err != nil {
+		return "", err
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "- " + text + "\n"
+	return path, a.writeMemory(ctx, sessionID, path, content)
+}
+
+// memoryReadParams is the payload of _claude/memory/read. An empty Scope
+// reads all memory files.
+type memoryReadParams struct {
+	SessionID string      `json:"sessionId"`
+	Scope     memoryScope `json:"scope,omitempty"`
+}
+
+// memoryUpdateParams is the payload of _claude/memory/update. Content
+// replaces the file; otherwise Add is appended to it as a list item.
+type memoryUpdateParams struct {
+	SessionID string      `json:"sessionId"`
+	Scope     memoryScope `json:"scope"`
+	Content   *string     `json:"content,omitempty"`
+	Add       string      `json:"add,omitempty"`
+}
+
+func (a *ClaudeAcpAgent) extReadMemory(ctx context.Context, params json.RawMessage) (any, error) {
+	var p memoryReadParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := memoryScopes
+	if p.Scope != "" {
+		scopes = []memoryScope{p.Scope}
+	}
+	files := make([]memoryFile, 0, len(scopes))
+	for _, scope := range scopes {
+		path, err := memoryPath(scope, session.cwd)
+		if err != nil {
+			return nil, err
+		}
+		content, exists, err := a.readMemory(ctx, p.SessionID, path)
+		if err != nil {
+			return nil, acp.NewInternalError(map[string]any{"error": err.Error(), "path": path})
+		}
+		files = append(files, memoryFile{Scope: scope, Path: path, Exists: exists, Content: content})
+	}
+	return map[string]any{"files": files}, nil
+}
+
+func (a *ClaudeAcpAgent) extUpdateMemory(ctx context.Context, params json.RawMessage) (any, error) {
+	var p memoryUpdateParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Content == nil && strings.TrimSpace(p.Add) == "" {
+		return nil, acp.NewInvalidParams(map[string]any{"error": "content or add is required"})
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	path, err := memoryPath(p.Scope, session.cwd)
+	if err != nil {
+		return nil, err
+	}
+	if p.Content != nil {
+		err = a.writeMemory(ctx, p.SessionID, path, *p.Content)
+	} else {
+		_, err = a.addMemory(ctx, p.SessionID, session, p.Scope, strings.TrimSpace(p.Add))
+	}
+	if err != nil {
+		return nil, acp.NewInternalError(map[string]any{"error": err.Error(), "path": path})
+	}
+	return map[string]any{"path": path}, nil
+}
+
+// memoryShortcut returns the text of a prompt using the CLI's "#" shortcut
+// for adding to memory: a single line of text starting with "# ". Other
+// prompts, including multi-line ones that start with a heading, are sent
+// to Claude as usual.
+func memoryShortcut(prompt []acp.ContentBlock) (string, bool) {
+	if len(prompt) != 1 || prompt[0].Text == nil {
+		return "", false
+	}
+	text := strings.TrimSpace(prompt[0].Text.Text)
+	rest, ok := strings.CutPrefix(text, "# ")
+	if !ok || strings.Contains(rest, "\n") {
+		return "", false
+	}
+	rest = strings.TrimSpace(rest)
+	return rest, rest != ""
+}
+
+// handleMemoryShortcut adds a "#" prompt to the project memory and reports
+// the result as an agent message. The running CLI process loaded memory at
+// startup, so the addition applies to new sessions.
+func (a *ClaudeAcpAgent) handleMemoryShortcut(ctx context.Context, sessionID string, session *Session, text string) (acp.PromptResponse, error) {
+	path, err := a.addMemory(ctx, sessionID, session, memoryProject, text)
+	message := "Added to memory: " + path
+	if err != nil {
+		message = "Failed to add to memory: " + err.Error()
+	}
+	updates := a.sessionUpdates(session)
+	updates.push(ctx, acp.SessionNotification{
+		Meta:      newTurnMeta(session.turnID()),
+		SessionId: acp.SessionId(sessionID),
+		Update:    acp.UpdateAgentMessageText(message),
+	})
+	updates.wait(ctx)
+	return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestMemoryShortcut(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   string
+		ok     bool
+	}{
+		{"# Always run gofmt", "Always run gofmt", true},
+		{"  # Use tabs  ", "Use tabs", true},
+		{"#123 is flaky", "", false},
+		{"## Heading", "", false},
+		{"# Plan\nStep one", "", false},
+		{"#", "", false},
+		{"Fix # handling", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := memoryShortcut([]acp.ContentBlock{acp.TextBlock(tt.prompt)})
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("memoryShortcut(%q) = %q, %v; want %q, %v", tt.prompt, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := memoryShortcut([]acp.ContentBlock{acp.TextBlock("# note"), acp.TextBlock("more")}); ok {
+		t.Error("expected multi-block prompts to be sent to Claude")
+	}
+}
+
+func TestExtRouter_Memory(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", configDir)
+	cwd := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cwd, "CLAUDE.md"), []byte("# Project\n- Use Go 1.22"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.sessions["s1"] = &Session{cwd: cwd}
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/memory/update","params":{"sessionId":"s1","scope":"project","add":"Run go vet"}}`)
+	if msg := recv(); msg["result"] == nil {
+		t.Fatalf("expected result, got %v", msg)
+	}
+	data, _ := os.ReadFile(filepath.Join(cwd, "CLAUDE.md"))
+	if string(data) != "# Project\n- Use Go 1.22\n- Run go vet\n" {
+		t.Errorf("unexpected project memory %q", data)
+	}
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_claude/memory/update","params":{"sessionId":"s1","scope":"user","content":"Be terse.\n"}}`)
+	recv()
+	send(`{"jsonrpc":"2.0","id":3,"method":"_claude/memory/read","params":{"sessionId":"s1"}}`)
+	msg := recv()
+	result, ok := msg["result"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected result, got %v", msg)
+	}
+	files := result["files"].([]any)
+	if len(files) != 3 {
+		t.Fatalf("expected 3 memory files, got %v", files)
+	}
+	local := files[1].(map[string]any)
+	if local["scope"] != "local" || local["exists"] != false {
+		t.Errorf("expected missing local memory, got %v", local)
+	}
+	user := files[2].(map[string]any)
+	if user["path"] != filepath.Join(configDir, "CLAUDE.md") || user["content"] != "Be terse.\n" {
+		t.Errorf("unexpected user memory %v", user)
+	}
+
+	send(`{"jsonrpc":"2.0","id":4,"method":"_claude/memory/read","params":{"sessionId":"s1","scope":"team"}}`)
+	if msg := recv(); msg["error"] == nil {
+		t.Errorf("expected error for unknown scope, got %v", msg)
+	}
+}
+
+func TestReadMemory_Client(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.clientCapabilities = &acp.ClientCapabilities{Fs: acp.FileSystemCapability{ReadTextFile: true}}
+	send, recv := extTestConn(t, agent)
+
+	// A missing file is only reported as such when the client says so;
+	// other failures are errors, whether or not the file exists locally.
+	for _, tt := range []struct {
+		reply   string
+		wantErr bool
+	}{
+		{`"error":{"code":-32002,"message":"not found"}`, false},
+		{`"error":{"code":-32603,"message":"permission denied"}`, true},
+	} {
+		type result struct {
+			exists bool
+			err    error
+		}
+		done := make(chan result)
+		go func() {
+			_, exists, err := agent.readMemory(context.Background(), "s1", "/missing/CLAUDE.md")
+			done <- result{exists, err}
+		}()
+		req := recv()
+		id, _ := json.Marshal(req["id"])
+		send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,%s}`, id, tt.reply))
+		got := <-done
+		if got.exists || (got.err != nil) != tt.wantErr {
+			t.Errorf("reply %s: exists %v, err %v", tt.reply, got.exists, got.err)
+		}
+	}
+}
+
+func TestPrompt_MemoryShortcut(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	cwd := t.TempDir()
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.sessions["s1"] = &Session{cwd: cwd}
+	_, recv := extTestConn(t, agent)
+
+	resp, err := agent.Prompt(context.Background(), acp.PromptRequest{
+		SessionId: "s1",
+		Prompt:    []acp.ContentBlock{acp.TextBlock("# Prefer table-driven tests")},
+	})
+	if err != nil || resp.StopReason != acp.StopReasonEndTurn {
+		t.Fatalf("unexpected prompt result: %+v, %v", resp, err)
+	}
+	if msg := recv(); msg["method"] != "session/update" {
+		t.Errorf("expected a session update, got %v", msg)
+	}
+	data, _ := os.ReadFile(filepath.Join(cwd, "CLAUDE.md"))
+	if string(data) != "- Prefer table-driven tests\n" {
+		t.Errorf("unexpected project memory %q", data)
+	}
+}
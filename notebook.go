@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// notebookCellSeparator joins a notebook path and a cell ID in the file
+// names of the per-cell diffs NotebookEdit returns.
+const notebookCellSeparator = "#"
+
+// handleNotebookEdit replaces, inserts or deletes a cell of a Jupyter
+// notebook through the client. It returns a unified diff of the cell's
+// source, so edits preview as code rather than as notebook JSON.
+func handleNotebookEdit(ctx context.Context, conn *acp.AgentSideConnection, sessionID string, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
+	nbPath := inputStr(input, "notebook_path")
+	if nbPath == "" {
+		return "notebook_path is required", true, nil
+	}
+	if !strings.HasSuffix(nbPath, ".ipynb") {
+		return "File must be a Jupyter notebook (.ipynb file)", true, nil
+	}
+	cellID := inputStr(input, "cell_id")
+	newSource := inputStr(input, "new_source")
+	cellType := inputStr(input, "cell_type")
+	editMode := inputStr(input, "edit_mode")
+	if editMode == "" {
+		editMode = "replace"
+	}
+	switch editMode {
+	case "replace", "delete":
+		if cellID == "" {
+			return fmt.Sprintf("cell_id is required to %s a cell", editMode), true, nil
+		}
+	case "insert":
+		if cellType == "" {
+			return "cell_type is required to insert a cell", true, nil
+		}
+	default:
+		return fmt.Sprintf("Unknown edit_mode %q; use replace, insert or delete", editMode), true, nil
+	}
+	if cellType != "" && cellType != "code" && cellType != "markdown" {
+		return fmt.Sprintf("Unknown cell_type %q; use code or markdown", cellType), true, nil
+	}
+
+	original, err := readFileContent(ctx, conn, sessionID, nbPath, opts.files)
+	if err != nil {
+		return "Editing notebook failed: " + err.Error(), true, nil
+	}
+	nb, err := parseNotebook(original)
+	if err != nil {
+		return "Editing notebook failed: " + err.Error(), true, nil
+	}
+	index := -1
+	if cellID != "" {
+		if index = nb.cellIndex(cellID); index < 0 {
+			return fmt.Sprintf("Cell %q not found in notebook", cellID), true, nil
+		}
+	}
+
+	var oldSource, summary string
+	switch editMode {
+	case "replace":
+		cell := nb.cells[index]
+		oldSource = notebookCellSource(cell)
+		setNotebookCellSource(cell, newSource)
+		if cellType != "" {
+			cell["cell_type"] = cellType
+		}
+		if cell["cell_type"] == "code" {
+			// Outputs of the old source no longer apply.
+			cell["outputs"] = []any{}
+			cell["execution_count"] = nil
+		} else {
+			delete(cell, "outputs")
+			delete(cell, "execution_count")
+		}
+		summary = fmt.Sprintf("Updated cell %s of %s.", cellID, nbPath)
+	case "insert":
+		cell := map[string]any{"cell_type": cellType, "metadata": map[string]any{}}
+		setNotebookCellSource(cell, newSource)
+		if cellType == "code" {
+			cell["outputs"] = []any{}
+			cell["execution_count"] = nil
+		}
+		if nb.hasCellIDs() {
+			cell["id"] = idsOr(opts.ids).RandomString(8)
+		}
+		index++ // after cell_id, or first
+		nb.cells = slices.Insert(nb.cells, index, cell)
+		cellID = nb.cellID(index)
+		summary = fmt.Sprintf("Inserted %s cell %s into %s.", cellType, cellID, nbPath)
+	case "delete":
+		oldSource = notebookCellSource(nb.cells[index])
+		nb.cells = slices.Delete(nb.cells, index, index+1)
+		newSource = ""
+		summary = fmt.Sprintf("Deleted cell %s from %s.", cellID, nbPath)
+	}
+
+	content, err := nb.marshal()
+	if err != nil {
+		return "Editing notebook failed: " + err.Error(), true, nil
+	}
+	opts.checkpoints.record(nbPath, original, true)
+	if err := writeFileContent(ctx, conn, sessionID, nbPath, content, opts.files); err != nil {
+		return "Editing notebook failed: " + err.Error(), true, nil
+	}
+	opts.changes.wrote(nbPath, content)
+	return summary + "\n" + createUnifiedDiff(nbPath+notebookCellSeparator+cellID, oldSource, newSource), false, nil
+}
+
+// notebook is a parsed .ipynb file. Fields other than the cells are kept
+// as they are.
+type notebook struct {
+	doc   map[string]any
+	cells []map[string]any
+}
+
+func parseNotebook(content string) (*notebook, error) {
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid notebook JSON: %w", err)
+	}
+	raw, ok := doc["cells"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid notebook: no cells")
+	}
+	nb := &notebook{doc: doc}
+	for _, c := range raw {
+		cell, ok := c.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid notebook: malformed cell")
+		}
+		nb.cells = append(nb.cells, cell)
+	}
+	return nb, nil
+}
+
+// hasCellIDs reports whether the notebook format (4.5 and later) gives
+// cells IDs.
+func (nb *notebook) hasCellIDs() bool {
+	version := func(key string) int64 {
+		n, _ := nb.doc[key].(json.Number)
+		v, _ := n.Int64()
+		return v
+	}
+	major, minor := version("nbformat"), version("nbformat_minor")
+	return major > 4 || major == 4 && minor >= 5
+}
+
+// cellID returns the ID of the cell at index i: its id field, or "cell-N"
+// for notebooks without IDs.
+func (nb *notebook) cellID(i int) string {
+	if id, ok := nb.cells[i]["id"].(string); ok && id != "" {
+		return id
+	}
+	return "cell-" + strconv.Itoa(i)
+}
+
+// cellIndex returns the index of the cell with the given ID, or -1.
+func (nb *notebook) cellIndex(id string) int {
+	for i := range nb.cells {
+		if nb.cellID(i) == id {
+			return i
+		}
+	}
+	if n, ok := strings.CutPrefix(id, "cell-"); ok {
+		if i, err := strconv.Atoi(n); err == nil && i >= 0 && i < len(nb.cells) {
+			return i
+		}
+	}
+	return -1
+}
+
+// marshal formats the notebook the way Jupyter writes it: sorted keys,
+// one-space indentation and a final newline.
+func (nb *notebook) marshal() (string, error) {
+	cells := make([]any, len(nb.cells))
+	for i, c := range nb.cells {
+		cells[i] = c
+	}
+	nb.doc["cells"] = cells
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(nb.doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// notebookCellSource returns a cell's source, which notebooks store as a
+// string or as a list of lines.
+func notebookCellSource(cell map[string]any) string {
+	switch src := cell["source"].(type) {
+	case string:
+		return src
+	case []any:
+		var sb strings.Builder
+		for _, line := range src {
+			if s, ok := line.(string); ok {
+				sb.WriteString(s)
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// setNotebookCellSource stores source as a list of lines, each keeping its
+// line break, as Jupyter does.
+func setNotebookCellSource(cell map[string]any, source string) {
+	lines := []any{}
+	for line := range strings.Lines(source) {
+		lines = append(lines, line)
+	}
+	cell["source"] = lines
+}
+
+// notebookCellDiffs turns the per-cell diffs NotebookEdit returns into
+// diff contents on the notebook, one per changed cell region, each tagged
+// with its cell ID.
+func notebookCellDiffs(text string) ToolUpdate {
+	var update ToolUpdate
+	for _, p := range parseUnifiedDiff(text) {
+		name := p.newFileName
+		if name == "" {
+			name = p.oldFileName
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "b/"), "a/")
+		nbPath, cellID, ok := cutLast(name, notebookCellSeparator)
+		if !ok {
+			continue
+		}
+		for _, h := range p.hunks {
+			var oldLines, newLines []string
+			for _, line := range h.lines {
+				switch line[0] {
+				case '-':
+					oldLines = append(oldLines, line[1:])
+				case '+':
+					newLines = append(newLines, line[1:])
+				default:
+					oldLines = append(oldLines, line[1:])
+					newLines = append(newLines, line[1:])
+				}
+			}
+			diff := &acp.ToolCallContentDiff{
+				Type:    "diff",
+				Path:    nbPath,
+				NewText: strings.Join(newLines, "\n"),
+				Meta:    map[string]any{"cellId": cellID},
+			}
+			if len(oldLines) > 0 {
+				diff.OldText = acp.Ptr(strings.Join(oldLines, "\n"))
+			}
+			update.Content = append(update.Content, acp.ToolCallContent{Diff: diff})
+		}
+		update.Locations = append(update.Locations, acp.ToolCallLocation{Path: nbPath})
+	}
+	return update
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
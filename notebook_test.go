@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNotebook = `{
+ "cells": [
+  {
+   "cell_type": "markdown",
+   "id": "intro",
+   "metadata": {},
+   "source": ["# Title\n", "Some <b>text</b>"]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 3,
+   "id": "load",
+   "metadata": {},
+   "outputs": [{"output_type": "stream", "name": "stdout", "text": ["1\n"]}],
+   "source": "import pandas as pd\ndf = pd.read_csv('a.csv')\nprint(len(df))"
+  }
+ ],
+ "metadata": {"kernelspec": {"name": "python3"}},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}
+`
+
+func editTestNotebook(t *testing.T, input map[string]any) (string, string) {
+	t.Helper()
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	path := filepath.Join(getClaudeConfigDir(), "nb.ipynb")
+	writeTestFile(t, path, testNotebook)
+	input["notebook_path"] = path
+	out, isErr, _ := handleNotebookEdit(context.Background(), nil, "s1", input, BuiltinToolOptions{})
+	if isErr {
+		t.Fatal(out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, string(data)
+}
+
+func TestHandleNotebookEdit_Replace(t *testing.T) {
+	out, data := editTestNotebook(t, map[string]any{"cell_id": "load", "new_source": "import pandas as pd\ndf = pd.read_csv('b.csv')\nprint(len(df))"})
+	nb, err := parseNotebook(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell := nb.cells[1]
+	if got := notebookCellSource(cell); got != "import pandas as pd\ndf = pd.read_csv('b.csv')\nprint(len(df))" {
+		t.Errorf("source = %q", got)
+	}
+	if len(cell["outputs"].([]any)) != 0 || cell["execution_count"] != nil {
+		t.Errorf("outputs not cleared: %v", cell)
+	}
+	if !strings.Contains(data, "Some <b>text</b>") || !strings.Contains(data, "\n \"nbformat_minor\": 5\n}\n") {
+		t.Errorf("notebook not written in Jupyter's format:\n%s", data)
+	}
+
+	update := notebookCellDiffs(out)
+	if len(update.Content) != 1 {
+		t.Fatalf("got %d contents", len(update.Content))
+	}
+	diff := update.Content[0].Diff
+	if diff == nil || !strings.HasSuffix(diff.Path, "nb.ipynb") || diff.Meta.(map[string]any)["cellId"] != "load" {
+		t.Fatalf("diff = %+v", diff)
+	}
+	if diff.OldText == nil || !strings.Contains(*diff.OldText, "a.csv") || strings.Contains(diff.NewText, "a.csv") || strings.Contains(diff.NewText, "cell_type") {
+		t.Errorf("old = %v, new = %q", diff.OldText, diff.NewText)
+	}
+}
+
+func TestHandleNotebookEdit_InsertAndDelete(t *testing.T) {
+	out, data := editTestNotebook(t, map[string]any{"cell_id": "intro", "edit_mode": "insert", "cell_type": "code", "new_source": "x = 1\n"})
+	nb, err := parseNotebook(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nb.cells) != 3 || notebookCellSource(nb.cells[1]) != "x = 1\n" || nb.cells[1]["id"] == nil {
+		t.Fatalf("cells = %v", nb.cells)
+	}
+	update := notebookCellDiffs(out)
+	if len(update.Content) != 1 || update.Content[0].Diff.OldText != nil || update.Content[0].Diff.NewText != "x = 1\n" {
+		t.Errorf("insert diff = %+v", update.Content[0].Diff)
+	}
+
+	_, data = editTestNotebook(t, map[string]any{"cell_id": "cell-0", "edit_mode": "delete"})
+	if nb, _ := parseNotebook(data); len(nb.cells) != 1 || nb.cellID(0) != "load" {
+		t.Errorf("cells after delete = %v", nb.cells)
+	}
+}
+
+func TestHandleNotebookEdit_Errors(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	path := filepath.Join(getClaudeConfigDir(), "nb.ipynb")
+	writeTestFile(t, path, testNotebook)
+	for _, input := range []map[string]any{
+		{"notebook_path": filepath.Join(getClaudeConfigDir(), "nb.py"), "cell_id": "load"},
+		{"notebook_path": path, "new_source": "x"},
+		{"notebook_path": path, "cell_id": "missing", "new_source": "x"},
+		{"notebook_path": path, "edit_mode": "insert", "new_source": "x"},
+		{"notebook_path": path, "cell_id": "load", "edit_mode": "move"},
+	} {
+		if out, isErr, _ := handleNotebookEdit(context.Background(), nil, "s1", input, BuiltinToolOptions{}); !isErr {
+			t.Errorf("%v: expected an error, got %q", input, out)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	acp "github.com/coder/acp-go-sdk"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// permissionPromptToolName is the tool on the ACP tool server that the CLI
+// calls, via --permission-prompt-tool, for each permission decision its own
+// rules leave open.
+const permissionPromptToolName = "permission_prompt"
+
+// permissionPromptInput is what the CLI passes the permission prompt tool.
+type permissionPromptInput struct {
+	ToolName  string         `json:"tool_name" jsonschema:"The tool requesting permission"`
+	Input     map[string]any `json:"input" jsonschema:"The input of the tool call"`
+	ToolUseID string         `json:"tool_use_id,omitempty" jsonschema:"The ID of the tool call"`
+}
+
+// permissionPromptResult is the decision returned to the CLI, as JSON text.
+type permissionPromptResult struct {
+	Behavior           string             `json:"behavior"` // "allow"|"deny"
+	UpdatedInput       map[string]any     `json:"updatedInput,omitempty"`
+	UpdatedPermissions []permissionUpdate `json:"updatedPermissions,omitempty"`
+	Message            string             `json:"message,omitempty"`
+}
+
+// permissionUpdate asks the CLI to remember a decision for the session.
+type permissionUpdate struct {
+	Type        string           `json:"type"` // "addRules"
+	Rules       []permissionRule `json:"rules"`
+	Behavior    string           `json:"behavior"`
+	Destination string           `json:"destination"`
+}
+
+type permissionRule struct {
+	ToolName    string `json:"toolName"`
+	RuleContent string `json:"ruleContent,omitempty"`
+}
+
+// Permission options offered to the client.
+const (
+	permissionAllowAlways = "allow_always"
+	permissionAllowOnce   = "allow"
+	permissionReject      = "reject"
+)
+
+// acceptEditsTools are allowed without asking in acceptEdits mode. The CLI
+// does this for its own tools but not for MCP ones.
+var acceptEditsTools = []string{"Edit", "Write", "MultiEdit", "NotebookEdit", ACPToolNames.Edit, ACPToolNames.MultiEdit, ACPToolNames.Write, ACPToolNames.NotebookEdit}
+
+func addPermissionPromptTool(s *mcp.Server, h mcp.ToolHandler) {
+	mcp.AddTool(s, &mcp.Tool{
+		Name:        permissionPromptToolName,
+		Description: "Asks the user for permission to run a tool call. Used by Claude Code's permission system; do not call it directly.",
+		Meta:        mcp.Meta{"version": acpToolsVersion},
+	}, func(ctx context.Context, req *mcp.CallToolRequest, _ permissionPromptInput) (*mcp.CallToolResult, any, error) {
+		result, err := h(ctx, req)
+		return result, nil, err
+	})
+}
+
+// permissionPromptHandler returns the handler deciding a session's
+// permission prompts: it applies the permission mode and the session's
+// rules, and asks the client about the rest.
+func (a *ClaudeAcpAgent) permissionPromptHandler(sessionID string) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var in permissionPromptInput
+		if err := json.Unmarshal(req.Params.Arguments, &in); err != nil {
+			return toolErrorResult(err.Error()), nil
+		}
+		result := a.decidePermission(ctx, sessionID, in)
+		text, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(text)}}}, nil
+	}
+}
+
+func (a *ClaudeAcpAgent) decidePermission(ctx context.Context, sessionID string, in permissionPromptInput) permissionPromptResult {
+	allow := permissionPromptResult{Behavior: "allow", UpdatedInput: in.Input}
+	if allow.UpdatedInput == nil {
+		allow.UpdatedInput = map[string]any{}
+	}
+	session, err := a.lookupSession(sessionID)
+	if err != nil {
+		return permissionPromptResult{Behavior: "deny", Message: err.Error()}
+	}
+	switch mode := session.GetPermissionMode(); {
+	case mode == "bypassPermissions":
+		auditPermission(session.log(), "Tool call allowed by bypassPermissions mode", "tool", in.ToolName, "toolCallId", in.ToolUseID)
+		return allow
+	case mode == "acceptEdits" && slices.Contains(acceptEditsTools, in.ToolName):
+		return allow
+	}
+	if session.settingsManager != nil {
+		check := session.settingsManager.CheckPermission(in.ToolName, in.Input)
+		switch check.Decision {
+		case PermissionAllow:
+			return allow
+		case PermissionDeny:
+			return permissionPromptResult{Behavior: "deny", Message: fmt.Sprintf("Permission to use %s was denied by the rule %q", in.ToolName, check.Rule)}
+		}
+	}
+	if a.conn == nil {
+		return permissionPromptResult{Behavior: "deny", Message: "No client to ask for permission"}
+	}
+
+	info := toolInfoFromToolUse(in.ToolName, in.Input)
+	toolCallID := in.ToolUseID
+	if toolCallID == "" {
+		toolCallID = "permission-" + a.ids.RandomString(8)
+	}
+	// The user may take longer to answer than the turn idle timeout.
+	watchdog := session.runningWatchdog()
+	watchdog.pause()
+	defer watchdog.resume()
+	resp, err := a.conn.RequestPermission(ctx, acp.RequestPermissionRequest{
+		SessionId: acp.SessionId(sessionID),
+		ToolCall: acp.RequestPermissionToolCall{
+			ToolCallId: acp.ToolCallId(toolCallID),
+			Title:      acp.Ptr(info.Title),
+			Kind:       acp.Ptr(info.Kind),
+			Content:    info.Content,
+			Locations:  info.Locations,
+			RawInput:   in.Input,
+		},
+		Options: []acp.PermissionOption{
+			{OptionId: permissionAllowAlways, Name: "Always Allow", Kind: acp.PermissionOptionKindAllowAlways},
+			{OptionId: permissionAllowOnce, Name: "Allow", Kind: acp.PermissionOptionKindAllowOnce},
+			{OptionId: permissionReject, Name: "Reject", Kind: acp.PermissionOptionKindRejectOnce},
+		},
+	})
+	if err != nil {
+		session.log().Warn("Permission request failed", "tool", in.ToolName, "toolCallId", toolCallID, "error", err)
+		return permissionPromptResult{Behavior: "deny", Message: "Permission request failed: " + err.Error()}
+	}
+	if resp.Outcome.Selected == nil {
+		return permissionPromptResult{Behavior: "deny", Message: "The user cancelled the permission request"}
+	}
+	switch resp.Outcome.Selected.OptionId {
+	case permissionAllowAlways:
+		rule := permissionRuleFor(in.ToolName, in.Input)
+		if session.settingsManager != nil {
+			if err := session.settingsManager.UpdatePermissionRules(PermissionRules{Allow: []string{rule.String()}}, PermissionRules{}, false); err != nil {
+				session.log().Warn("Failed to record permission rule", "toolCallId", toolCallID, "rule", rule.String(), "error", err)
+			}
+		}
+		allow.UpdatedPermissions = []permissionUpdate{{Type: "addRules", Rules: []permissionRule{rule}, Behavior: "allow", Destination: "session"}}
+		return allow
+	case permissionAllowOnce:
+		return allow
+	default:
+		return permissionPromptResult{Behavior: "deny", Message: "The user rejected the tool call"}
+	}
+}
+
+// auditPermission logs a permission event that skips the user's approval,
+// such as entering bypassPermissions mode. The entries are tagged
+// audit=permission so they can be filtered from the rest of the log.
+func auditPermission(log *slog.Logger, msg string, args ...any) {
+	log.Warn(msg, append([]any{"audit", "permission"}, args...)...)
+}
+
+// permissionRuleFor returns the rule an "Always Allow" answer adds: the
+// exact command for Bash, the whole tool otherwise.
+func permissionRuleFor(toolName string, input map[string]any) permissionRule {
+	rule := permissionRule{ToolName: toolName}
+	if toolName == "Bash" || toolName == ACPToolNames.Bash {
+		rule.RuleContent = inputStr(input, "command")
+	}
+	return rule
+}
+
+// String formats r as a settings rule, such as "Bash(npm test)".
+func (r permissionRule) String() string {
+	if r.RuleContent == "" {
+		return r.ToolName
+	}
+	return r.ToolName + "(" + r.RuleContent + ")"
+}
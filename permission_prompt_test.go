@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestDecidePermission(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	defer c2aW.Close()
+	defer a2cW.Close()
+	client := newMockClient()
+	acp.NewClientSideConnection(client, c2aW, a2cR)
+	agent := NewClaudeAcpAgent(logger, AgentOptions{})
+	newAgentConnection(agent, a2cW, c2aR, logger)
+
+	mgr := NewSettingsManager(t.TempDir(), logger)
+	mgr.Initialize()
+	if err := mgr.UpdatePermissionRules(PermissionRules{Deny: []string{"mcp__db__drop"}}, PermissionRules{}, false); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{permissionMode: "default", settingsManager: mgr}
+	agent.sessions["s1"] = session
+	ctx := context.Background()
+	decide := func(tool string, input map[string]any) permissionPromptResult {
+		return agent.decidePermission(ctx, "s1", permissionPromptInput{ToolName: tool, Input: input, ToolUseID: "toolu_1"})
+	}
+
+	if got := decide("mcp__db__drop", nil); got.Behavior != "deny" {
+		t.Errorf("denied rule: got %+v", got)
+	}
+
+	// The mock client picks the first option, Always Allow.
+	got := decide("Bash", map[string]any{"command": "ls"})
+	if got.Behavior != "allow" || got.UpdatedInput["command"] != "ls" || len(got.UpdatedPermissions) != 1 {
+		t.Errorf("allowed prompt: got %+v", got)
+	}
+	if !slices.Contains(mgr.PermissionRules().Allow, "Bash(ls)") {
+		t.Errorf("rule not recorded: %v", mgr.PermissionRules().Allow)
+	}
+
+	client.permissionAuto = false
+	if got := decide("Bash", map[string]any{"command": "rm -rf build"}); got.Behavior != "deny" {
+		t.Errorf("cancelled prompt: got %+v", got)
+	}
+
+	session.SetPermissionMode("acceptEdits")
+	if got := decide(ACPToolNames.Edit, map[string]any{"file_path": "/a"}); got.Behavior != "allow" {
+		t.Errorf("acceptEdits: got %+v", got)
+	}
+	session.SetPermissionMode("bypassPermissions")
+	if got := decide("Bash", map[string]any{"command": "rm -rf build"}); got.Behavior != "allow" {
+		t.Errorf("bypassPermissions: got %+v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// sendMessage sends a prompt to the session's CLI. If the write fails
+// because the CLI went away, as it does after a cancel or a crash, the CLI
+// is restarted resuming the conversation and the prompt is sent once more.
+func (s *Session) sendMessage(msg SDKUserMessage) error {
+	proc := s.proc()
+	err := proc.SendMessage(msg)
+	if err != nil && s.backend != nil && processGone(proc, err) {
+		log := s.log()
+		log.Warn("Sending to the CLI failed, restarting it", "error", err)
+		if rerr := s.restartProcess(); rerr != nil {
+			log.Error("Restarting the CLI failed", "error", rerr)
+			return err
+		}
+		err = s.proc().SendMessage(msg)
+	}
+	if err == nil {
+		s.mu.Lock()
+		s.conversationStarted = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// proc returns the session's current CLI process.
+func (s *Session) proc() Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.process
+}
+
+// processGone reports whether a failed write means the process has exited
+// or closed its input, rather than that the message could not be encoded.
+func processGone(p Process, err error) bool {
+	select {
+	case <-p.Done():
+		return true
+	default:
+	}
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// restartProcess replaces the session's CLI with a new one resuming the
+// same conversation, or the one /clear started, in the session's current
+// permission mode. Until the CLI has accepted a prompt there is no
+// conversation to resume and the session starts afresh. The old CLI is only closed once the new one has
+// started, so a failed restart leaves the session as it was.
+func (s *Session) restartProcess() error {
+	opts := s.startOptions
+	s.mu.Lock()
+	if s.conversationStarted {
+		opts.Resume = cmp.Or(s.conversationID, opts.SessionID)
+	}
+	s.mu.Unlock()
+	opts.PermissionMode = s.GetPermissionMode()
+	proc, err := s.backend.Start(opts)
+	if err != nil {
+		return fmt.Errorf("failed to restart the CLI: %w", err)
+	}
+	s.mu.Lock()
+	old := s.process
+	s.process = proc
+	s.mu.Unlock()
+	if err := old.Close(); err != nil {
+		s.log().Debug("Closing session process failed", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// restartBackend starts processes writing to stdin and reading output,
+// recording their options.
+type restartBackend struct {
+	stdin  strings.Builder
+	output string // what each process prints
+	starts []ProcessOptions
+	err    error // fails every start if set
+}
+
+func (b *restartBackend) Name() string        { return "restart" }
+func (b *restartBackend) Verify(string) error { return nil }
+func (b *restartBackend) Start(opts ProcessOptions) (Process, error) {
+	b.starts = append(b.starts, opts)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &ClaudeCodeProcess{stdin: nopWriteCloser{&b.stdin}, decoder: newNDJSONDecoder(strings.NewReader(b.output))}, nil
+}
+
+// closedStdin returns a CLI input that fails every write.
+func closedStdin(t *testing.T) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	w.Close()
+	return w
+}
+
+func TestSession_SendMessageRestartsCLI(t *testing.T) {
+	backend := &restartBackend{}
+	session := &Session{
+		process:             &ClaudeCodeProcess{stdin: closedStdin(t)},
+		backend:             backend,
+		startOptions:        ProcessOptions{SessionID: "s1", PermissionMode: "default", Cwd: "/work"},
+		permissionMode:      "acceptEdits",
+		turn:                2,
+		conversationStarted: true,
+	}
+
+	msg := SDKUserMessage{Type: "user", Message: SDKMessage{Role: "user", Content: "hello"}}
+	if err := session.sendMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.starts) != 1 {
+		t.Fatalf("expected one restart, got %d", len(backend.starts))
+	}
+	if opts := backend.starts[0]; opts.Resume != "s1" || opts.PermissionMode != "acceptEdits" || opts.Cwd != "/work" {
+		t.Errorf("unexpected restart options %+v", opts)
+	}
+	if !strings.Contains(backend.stdin.String(), `"hello"`) {
+		t.Errorf("message not resent, stdin = %q", backend.stdin.String())
+	}
+}
+
+func TestSession_RestartBeforeConversation(t *testing.T) {
+	backend := &restartBackend{}
+	// The first prompt's send fails: there is nothing to resume yet.
+	session := &Session{
+		process:      &ClaudeCodeProcess{stdin: closedStdin(t)},
+		backend:      backend,
+		startOptions: ProcessOptions{SessionID: "s1"},
+		turn:         1,
+	}
+	if err := session.sendMessage(SDKUserMessage{Type: "user", Message: SDKMessage{Role: "user", Content: "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.starts) != 1 || backend.starts[0].Resume != "" {
+		t.Errorf("expected a fresh start, got %+v", backend.starts)
+	}
+	if !session.conversationStarted {
+		t.Error("expected the conversation to count as started once sent")
+	}
+}
+
+func TestSession_RestartFailureKeepsProcess(t *testing.T) {
+	old := &ClaudeCodeProcess{stdin: nopWriteCloser{io.Discard}}
+	session := &Session{process: old, backend: &restartBackend{err: errors.New("no CLI")}}
+	if err := session.restartProcess(); err == nil {
+		t.Fatal("expected the restart to fail")
+	}
+	if session.proc() != old {
+		t.Error("expected the old process kept after a failed restart")
+	}
+}
+
+func TestSession_SendMessageWithoutBackend(t *testing.T) {
+	_, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	session := &Session{process: &ClaudeCodeProcess{stdin: w}}
+	err = session.sendMessage(SDKUserMessage{Type: "user", Message: SDKMessage{Role: "user", Content: "hello"}})
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
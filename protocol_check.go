@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Values of AgentOptions.StrictProtocol.
+const (
+	// strictProtocolWarn logs CLI messages that do not match the schemas below.
+	strictProtocolWarn = "warn"
+	// strictProtocolFail also ends the turn with an error.
+	strictProtocolFail = "fail"
+)
+
+// validStrictProtocol reports whether mode is a --strict-protocol value;
+// empty turns checking off.
+func validStrictProtocol(mode string) bool {
+	return mode == "" || mode == strictProtocolWarn || mode == strictProtocolFail
+}
+
+// objectSchema describes a JSON object in the CLI's stream-json output.
+// known lists the fields besides the required ones; nil allows any field,
+// for objects the CLI freely adds metadata to.
+type objectSchema struct {
+	required []string
+	known    []string
+}
+
+// cliMessageSchemas are the CLI message types, by type. Top-level messages
+// carry metadata such as uuid that changes between CLI versions, so only
+// their required fields are checked.
+var cliMessageSchemas = map[string]objectSchema{
+	"system":           {required: []string{"subtype"}},
+	"result":           {required: []string{"subtype"}},
+	"stream_event":     {required: []string{"event"}},
+	"assistant":        {required: []string{"message"}},
+	"user":             {required: []string{"message"}},
+	"auth_status":      {},
+	"tool_progress":    {},
+	"tool_use_summary": {},
+	"control_request":  {},
+	"control_response": {},
+	"keep_alive":       {},
+}
+
+// streamEventSchemas are the stream event types, by type.
+var streamEventSchemas = map[string]objectSchema{
+	"message_start":       {required: []string{"message"}},
+	"message_delta":       {required: []string{"delta"}},
+	"message_stop":        {},
+	"content_block_start": {required: []string{"index", "content_block"}},
+	"content_block_delta": {required: []string{"index", "delta"}},
+	"content_block_stop":  {required: []string{"index"}},
+}
+
+// contentBlockSchemas are the content block and delta types toAcpNotifications
+// handles, by type. The blocks it converts list their fields, so a new
+// field, which may carry content the agent would drop, is reported.
+var contentBlockSchemas = map[string]objectSchema{
+	"text":              {required: []string{"text"}, known: []string{"citations", "cache_control"}},
+	"text_delta":        {required: []string{"text"}, known: []string{}},
+	"image":             {required: []string{"source"}, known: []string{"cache_control"}},
+	"thinking":          {required: []string{"thinking"}, known: []string{"signature"}},
+	"thinking_delta":    {required: []string{"thinking"}, known: []string{}},
+	"tool_use":          {required: []string{"id", "name", "input"}, known: []string{"caller", "cache_control"}},
+	"server_tool_use":   {required: []string{"id", "name", "input"}},
+	"mcp_tool_use":      {required: []string{"id", "name", "input"}},
+	"tool_result":       {required: []string{"tool_use_id"}, known: []string{"content", "is_error", "cache_control"}},
+	"document":          {required: []string{"source"}},
+	"search_result":     {},
+	"redacted_thinking": {required: []string{"data"}},
+	"input_json_delta":  {required: []string{"partial_json"}},
+	"citations_delta":   {},
+	"signature_delta":   {required: []string{"signature"}},
+	"container_upload":  {},
+	"compaction":        {},
+	"compaction_delta":  {},
+
+	"tool_search_tool_result":                {required: []string{"tool_use_id"}},
+	"web_fetch_tool_result":                  {required: []string{"tool_use_id"}},
+	"web_search_tool_result":                 {required: []string{"tool_use_id"}},
+	"code_execution_tool_result":             {required: []string{"tool_use_id"}},
+	"bash_code_execution_tool_result":        {required: []string{"tool_use_id"}},
+	"text_editor_code_execution_tool_result": {required: []string{"tool_use_id"}},
+	"mcp_tool_result":                        {required: []string{"tool_use_id"}},
+}
+
+// checkCLIMessage checks a decoded CLI message against the schemas above
+// and returns the problems found, such as an unknown message or content
+// block type or a missing field.
+func checkCLIMessage(msg map[string]any) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	msgType, _ := msg["type"].(string)
+	schema, ok := cliMessageSchemas[msgType]
+	if !ok {
+		report("unknown message type %q", msgType)
+		return problems
+	}
+	checkObject(msg, schema, msgType, report)
+
+	switch msgType {
+	case "assistant", "user":
+		message, ok := msg["message"].(map[string]any)
+		if !ok {
+			break
+		}
+		switch content := message["content"].(type) {
+		case string:
+		case []any:
+			for i, block := range content {
+				checkContentBlock(block, fmt.Sprintf("message.content[%d]", i), report)
+			}
+		default:
+			report("message.content is neither a string nor an array")
+		}
+
+	case "stream_event":
+		event, ok := msg["event"].(map[string]any)
+		if !ok {
+			report("event is not an object")
+			break
+		}
+		eventType, _ := event["type"].(string)
+		schema, ok := streamEventSchemas[eventType]
+		if !ok {
+			report("unknown stream event type %q", eventType)
+			break
+		}
+		checkObject(event, schema, "event "+eventType, report)
+		switch eventType {
+		case "content_block_start":
+			checkContentBlock(event["content_block"], "event.content_block", report)
+		case "content_block_delta":
+			checkContentBlock(event["delta"], "event.delta", report)
+		}
+	}
+	return problems
+}
+
+func checkContentBlock(v any, where string, report func(string, ...any)) {
+	block, ok := v.(map[string]any)
+	if !ok {
+		report("%s is not an object", where)
+		return
+	}
+	blockType, _ := block["type"].(string)
+	schema, ok := contentBlockSchemas[blockType]
+	if !ok {
+		report("%s has unknown type %q", where, blockType)
+		return
+	}
+	checkObject(block, schema, where+" "+blockType, report)
+}
+
+// checkObject reports the required fields obj lacks and, unless the schema
+// allows any field, the fields it does not know.
+func checkObject(obj map[string]any, schema objectSchema, what string, report func(string, ...any)) {
+	for _, f := range schema.required {
+		if _, ok := obj[f]; !ok {
+			report("%s lacks field %q", what, f)
+		}
+	}
+	if schema.known == nil {
+		return
+	}
+	var unknown []string
+	for f := range obj {
+		if f != "type" && !slices.Contains(schema.required, f) && !slices.Contains(schema.known, f) {
+			unknown = append(unknown, f)
+		}
+	}
+	sort.Strings(unknown)
+	for _, f := range unknown {
+		report("%s has unknown field %q", what, f)
+	}
+}
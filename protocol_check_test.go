@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckCLIMessage_Transcripts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			var msg map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				t.Fatalf("%s:%d: %v", path, line, err)
+			}
+			if problems := checkCLIMessage(msg); len(problems) > 0 {
+				t.Errorf("%s:%d: %v", path, line, problems)
+			}
+		}
+	}
+}
+
+func TestCheckCLIMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want []string
+	}{
+		{"unknown type", `{"type":"telemetry"}`, []string{`unknown message type "telemetry"`}},
+		{"missing message", `{"type":"assistant","session_id":"s1"}`, []string{`assistant lacks field "message"`}},
+		{"metadata allowed", `{"type":"result","subtype":"success","uuid":"u1"}`, nil},
+		{
+			"unknown block",
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"hi"},{"type":"hologram"}]}}`,
+			[]string{`message.content[1] has unknown type "hologram"`},
+		},
+		{
+			"block fields",
+			`{"type":"user","message":{"content":[{"type":"tool_result","content":"ok","attachments":[]}]}}`,
+			[]string{`message.content[0] tool_result lacks field "tool_use_id"`, `message.content[0] tool_result has unknown field "attachments"`},
+		},
+		{
+			"stream delta",
+			`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"a","lang":"en"}}}`,
+			[]string{`event.delta text_delta has unknown field "lang"`},
+		},
+		{
+			"unknown event",
+			`{"type":"stream_event","event":{"type":"message_pause"}}`,
+			[]string{`unknown stream event type "message_pause"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg map[string]any
+			if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if got := checkCLIMessage(msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
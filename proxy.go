@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxySettings configures the outbound HTTP proxies used by the CLI and
+// by the agent itself, for users behind a corporate proxy.
+type ProxySettings struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string // comma-separated hosts that bypass the proxy
+}
+
+// validate checks that the proxies are absolute URLs.
+func (p ProxySettings) validate() error {
+	for _, v := range []string{p.HTTPProxy, p.HTTPSProxy} {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", v)
+		}
+	}
+	return nil
+}
+
+// or returns p with its unset fields taken from fallback.
+func (p ProxySettings) or(fallback ProxySettings) ProxySettings {
+	if p.HTTPProxy == "" {
+		p.HTTPProxy = fallback.HTTPProxy
+	}
+	if p.HTTPSProxy == "" {
+		p.HTTPSProxy = fallback.HTTPSProxy
+	}
+	if p.NoProxy == "" {
+		p.NoProxy = fallback.NoProxy
+	}
+	return p
+}
+
+// vars returns the environment variables for the set proxies, in both the
+// upper- and lower-case spellings tools look for.
+func (p ProxySettings) vars() map[string]string {
+	vars := map[string]string{}
+	for name, v := range map[string]string{"HTTP_PROXY": p.HTTPProxy, "HTTPS_PROXY": p.HTTPSProxy, "NO_PROXY": p.NoProxy} {
+		if v != "" {
+			vars[name] = v
+			vars[strings.ToLower(name)] = v
+		}
+	}
+	return vars
+}
+
+// withEnv returns env with the proxy variables added, overriding any set
+// there.
+func (p ProxySettings) withEnv(env map[string]string) map[string]string {
+	vars := p.vars()
+	if len(vars) == 0 {
+		return env
+	}
+	env = maps.Clone(env)
+	if env == nil {
+		env = map[string]string{}
+	}
+	maps.Copy(env, vars)
+	return env
+}
+
+// setProcessEnv exports the proxies to the agent's own environment, where
+// net/http and every subprocess pick them up. It must run before the first
+// HTTP request, since net/http reads the environment once.
+func (p ProxySettings) setProcessEnv() {
+	for name, v := range p.vars() {
+		os.Setenv(name, v)
+	}
+}
+
+// sessionProxy returns the proxies for a session: NewSession meta
+// (httpProxy, httpsProxy, noProxy) over settings.
+func sessionProxy(meta map[string]any, settings ClaudeCodeSettings) ProxySettings {
+	var p ProxySettings
+	p.HTTPProxy, _ = meta["httpProxy"].(string)
+	p.HTTPSProxy, _ = meta["httpsProxy"].(string)
+	p.NoProxy, _ = meta["noProxy"].(string)
+	return p.or(ProxySettings{HTTPProxy: settings.HTTPProxy, HTTPSProxy: settings.HTTPSProxy, NoProxy: settings.NoProxy})
+}
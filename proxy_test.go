@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestSessionProxy(t *testing.T) {
+	settings := ClaudeCodeSettings{HTTPSProxy: "http://settings:3128", NoProxy: "localhost,.corp"}
+	meta := map[string]any{"httpsProxy": "http://meta:8080"}
+
+	p := sessionProxy(meta, settings).or(ProxySettings{HTTPProxy: "http://flag:3128", HTTPSProxy: "http://flag:3128"})
+	want := ProxySettings{HTTPProxy: "http://flag:3128", HTTPSProxy: "http://meta:8080", NoProxy: "localhost,.corp"}
+	if p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+
+	env := p.withEnv(map[string]string{"HTTPS_PROXY": "http://env:1", "FOO": "bar"})
+	for name, v := range map[string]string{
+		"HTTPS_PROXY": "http://meta:8080", "https_proxy": "http://meta:8080",
+		"HTTP_PROXY": "http://flag:3128", "no_proxy": "localhost,.corp", "FOO": "bar",
+	} {
+		if env[name] != v {
+			t.Errorf("%s = %q, want %q", name, env[name], v)
+		}
+	}
+	if got := (ProxySettings{}).withEnv(nil); got != nil {
+		t.Errorf("expected no env without proxies, got %v", got)
+	}
+}
+
+func TestProxySettings_Validate(t *testing.T) {
+	if err := (ProxySettings{HTTPSProxy: "http://proxy.corp:3128", NoProxy: "*"}).validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (ProxySettings{HTTPProxy: "proxy.corp:3128"}).validate(); err == nil {
+		t.Error("expected an error for a proxy without a scheme")
+	}
+}
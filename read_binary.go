@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxImageFileSize is the largest image the Read tool returns. Its base64
+// encoding stays under the API's 5MB image limit.
+const MaxImageFileSize = 3_750_000
+
+// binarySniffSize is how much of a file is inspected for NUL bytes.
+const binarySniffSize = 8000
+
+// readFileMethod is the client extension request reading the bytes of a
+// file, which fs/read_text_file cannot return. Clients advertise it in
+// their capabilities' _meta; without it, Read returns images only from the
+// agent's internal paths.
+const readFileMethod = extMethodPrefix + "fs/read_file"
+
+// imageMimeTypes maps the image extensions the model accepts to their MIME
+// types.
+var imageMimeTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// readFileParams is the payload of _claude/fs/read_file.
+type readFileParams struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Limit     int    `json:"limit"` // bytes to return at most
+}
+
+// readFileResult is the client's answer to _claude/fs/read_file.
+type readFileResult struct {
+	Data string `json:"data"` // base64
+	Size int64  `json:"size"` // of the whole file
+}
+
+// fileReader reads up to limit bytes of a file and returns them with the
+// file's size.
+type fileReader func(ctx context.Context, path string, limit int) (data []byte, size int64, err error)
+
+// clientFileReader returns a reader for the session that asks the client,
+// or nil if the client cannot read binary files.
+func (a *ClaudeAcpAgent) clientFileReader(sessionID string) fileReader {
+	if !a.clientSupportsExt(readFileMethod) {
+		return nil
+	}
+	return func(ctx context.Context, path string, limit int) ([]byte, int64, error) {
+		var result readFileResult
+		if err := a.callClientExt(ctx, readFileMethod, readFileParams{SessionID: sessionID, Path: path, Limit: limit}, &result); err != nil {
+			return nil, 0, err
+		}
+		data, err := base64.StdEncoding.DecodeString(result.Data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid file data: %w", err)
+		}
+		return data, result.Size, nil
+	}
+}
+
+// localFileReader reads files on the agent's file system, for the agent's
+// internal paths.
+func localFileReader(_ context.Context, path string, limit int) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		return nil, 0, fmt.Errorf("%s is a directory", path)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)))
+	return data, info.Size(), err
+}
+
+// readImageFile handles Read calls for images, which fs/read_text_file
+// cannot return, through the client's _claude/fs/read_file or, for
+// internal paths, from the local disk. ok is false for other files and
+// images that cannot be read this way, which go through the text path.
+func readImageFile(ctx context.Context, filePath string, opts BuiltinToolOptions) (result BuiltinToolResult, ok bool) {
+	mimeType, isImage := imageMimeTypes[strings.ToLower(filepath.Ext(filePath))]
+	if !isImage {
+		return BuiltinToolResult{}, false
+	}
+	read := opts.readFile
+	if isInternalPath(filePath) {
+		read = localFileReader
+	}
+	if read == nil {
+		return BuiltinToolResult{}, false
+	}
+	data, size, err := read(ctx, filePath, MaxImageFileSize)
+	if err != nil {
+		return BuiltinToolResult{}, false
+	}
+	if size > MaxImageFileSize {
+		return BuiltinToolResult{
+			Text:    fmt.Sprintf("Image %s is %d bytes, larger than the %d byte limit.", filePath, size, MaxImageFileSize),
+			IsError: true,
+		}, true
+	}
+	return BuiltinToolResult{
+		Text:   fmt.Sprintf("Read image %s (%s, %d bytes).", filePath, mimeType, len(data)),
+		Images: []ImageContent{{Data: base64.StdEncoding.EncodeToString(data), MimeType: mimeType}},
+	}, true
+}
+
+// looksBinary reports whether file content has a NUL byte near its start.
+func looksBinary(content string) bool {
+	return strings.IndexByte(content[:min(len(content), binarySniffSize)], 0) >= 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestReadImageFile(t *testing.T) {
+	dir := t.TempDir()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	imagePath := write("shot.PNG", png)
+	textPath := write("main.go", []byte("package main\n"))
+	opts := BuiltinToolOptions{readFile: localFileReader}
+
+	result, ok := readImageFile(context.Background(), imagePath, opts)
+	if !ok || result.IsError || len(result.Images) != 1 {
+		t.Fatalf("expected an image result, got %+v, %v", result, ok)
+	}
+	if img := result.Images[0]; img.MimeType != "image/png" || img.Data != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("unexpected image: %+v", img)
+	}
+
+	for _, path := range []string{textPath, filepath.Join(dir, "missing.png")} {
+		if _, ok := readImageFile(context.Background(), path, opts); ok {
+			t.Errorf("expected %s to use the text path", path)
+		}
+	}
+	// Without a reader, images outside the internal paths use the text path.
+	if _, ok := readImageFile(context.Background(), imagePath, BuiltinToolOptions{}); ok {
+		t.Error("expected the image to be left to the client")
+	}
+}
+
+func TestHandleRead_Binary(t *testing.T) {
+	files := newFileCache()
+	files.put("/src/app.bin", "ELF\x00\x01\x02")
+	out, isErr, _ := handleRead(context.Background(), nil, "s1", map[string]any{"file_path": "/src/app.bin"}, BuiltinToolOptions{files: files})
+	if !isErr || !strings.Contains(out, "binary file") {
+		t.Errorf("expected binary file to be rejected, got %q", out)
+	}
+}
+
+func TestHandleBuiltinTool_ReadImage(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	send, recv := extTestConn(t, agent)
+	if agent.clientFileReader("s1") != nil {
+		t.Fatal("reader without client support")
+	}
+	agent.clientCapabilities = &acp.ClientCapabilities{Meta: map[string]any{readFileMethod: true}}
+	opts := BuiltinToolOptions{readFile: agent.clientFileReader("s1")}
+
+	type result struct {
+		res BuiltinToolResult
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := handleBuiltinTool(context.Background(), nil, "s1", "Read", map[string]any{"file_path": "/remote/diagram.gif"}, opts)
+		done <- result{res, err}
+	}()
+	req := recv()
+	if req["method"] != readFileMethod {
+		t.Fatalf("unexpected request %v", req)
+	}
+	if params := req["params"].(map[string]any); params["path"] != "/remote/diagram.gif" || params["sessionId"] != "s1" {
+		t.Errorf("params = %v", params)
+	}
+	data := base64.StdEncoding.EncodeToString([]byte("GIF89a"))
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"data":%q,"size":6}}`, req["id"], data))
+	got := <-done
+	if got.err != nil || got.res.IsError || len(got.res.Images) != 1 || got.res.Images[0].MimeType != "image/gif" || got.res.Images[0].Data != data {
+		t.Errorf("unexpected result: %+v, %v", got.res, got.err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// recoveringAgent wraps an acp.Agent so that a panic in one request handler
+// is logged and returned as an internal error for that request instead of
+// terminating the process and every other session with it.
+type recoveringAgent struct {
+	agent  acp.Agent
+	logger *slog.Logger
+}
+
+var _ acp.Agent = recoveringAgent{}
+
+// recoverPanic turns a panic in the handler for method into *err. It must
+// be deferred directly by the handler.
+func recoverPanic(logger *slog.Logger, method, sessionID string, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	logger.Error("Panic in request handler", "method", method, "session", sessionID, "panic", p, "stack", string(debug.Stack()))
+	*err = newAgentError(acp.NewInternalError, errKindPanic, sessionID, false, fmt.Sprintf("internal error in %s: %v", method, p))
+}
+
+func (r recoveringAgent) Authenticate(ctx context.Context, params acp.AuthenticateRequest) (_ acp.AuthenticateResponse, err error) {
+	defer recoverPanic(r.logger, "authenticate", "", &err)
+	return r.agent.Authenticate(ctx, params)
+}
+
+func (r recoveringAgent) Initialize(ctx context.Context, params acp.InitializeRequest) (_ acp.InitializeResponse, err error) {
+	defer recoverPanic(r.logger, "initialize", "", &err)
+	return r.agent.Initialize(ctx, params)
+}
+
+func (r recoveringAgent) Cancel(ctx context.Context, params acp.CancelNotification) (err error) {
+	defer recoverPanic(r.logger, "session/cancel", string(params.SessionId), &err)
+	return r.agent.Cancel(ctx, params)
+}
+
+func (r recoveringAgent) NewSession(ctx context.Context, params acp.NewSessionRequest) (_ acp.NewSessionResponse, err error) {
+	defer recoverPanic(r.logger, "session/new", "", &err)
+	return r.agent.NewSession(ctx, params)
+}
+
+func (r recoveringAgent) Prompt(ctx context.Context, params acp.PromptRequest) (_ acp.PromptResponse, err error) {
+	defer recoverPanic(r.logger, "session/prompt", string(params.SessionId), &err)
+	return r.agent.Prompt(ctx, params)
+}
+
+func (r recoveringAgent) SetSessionMode(ctx context.Context, params acp.SetSessionModeRequest) (_ acp.SetSessionModeResponse, err error) {
+	defer recoverPanic(r.logger, "session/set_mode", string(params.SessionId), &err)
+	return r.agent.SetSessionMode(ctx, params)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+type panickingAgent struct{ acp.Agent }
+
+func (panickingAgent) Prompt(context.Context, acp.PromptRequest) (acp.PromptResponse, error) {
+	panic("boom")
+}
+
+func TestRecoveringAgent_Panic(t *testing.T) {
+	r := recoveringAgent{agent: panickingAgent{}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	_, err := r.Prompt(context.Background(), acp.PromptRequest{SessionId: "s1"})
+
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("expected *acp.RequestError, got %v", err)
+	}
+	data, ok := reqErr.Data.(errorData)
+	if reqErr.Code != -32603 || !ok || data.Kind != errKindPanic || data.SessionID != "s1" {
+		t.Errorf("unexpected error: %+v", reqErr)
+	}
+}
+
+func TestExtRouter_HandlerPanic(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.extMethods[extMethodPrefix+"panic"] = func(context.Context, json.RawMessage) (any, error) { panic("boom") }
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/panic","params":{}}`)
+	if errObj, ok := recv()["error"].(map[string]any); !ok || errObj["code"].(float64) != -32603 {
+		t.Fatalf("expected InternalError, got %v", errObj)
+	}
+}
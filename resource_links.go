@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// resolveResourceLinks replaces links to local text files in a prompt with
+// the files' contents, read through the client, so they reach Claude as
+// embedded context like Resource blocks. Files larger than the session's
+// read limit are truncated. Links that are not file:// URIs, that point to
+// binary files, or that cannot be read are left as links. The prompt is
+// returned unchanged if the client cannot read files.
+func (a *ClaudeAcpAgent) resolveResourceLinks(ctx context.Context, sessionID string, session *Session, prompt []acp.ContentBlock) []acp.ContentBlock {
+	if a.conn == nil || a.clientCapabilities == nil || !a.clientCapabilities.Fs.ReadTextFile {
+		return prompt
+	}
+	out := make([]acp.ContentBlock, len(prompt))
+	for i, block := range prompt {
+		out[i] = block
+		link := block.ResourceLink
+		if link == nil || !strings.HasPrefix(link.Uri, "file://") || !isTextResourceLink(link) {
+			continue
+		}
+		resp, err := a.conn.ReadTextFile(ctx, acp.ReadTextFileRequest{
+			SessionId: acp.SessionId(sessionID),
+			Path:      fileURIPath(link.Uri),
+		})
+		if err != nil {
+			a.logger.Debug("Failed to read linked file", "uri", link.Uri, "error", err)
+			continue
+		}
+		if strings.ContainsRune(resp.Content, 0) {
+			continue
+		}
+		out[i] = acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+			Uri:      link.Uri,
+			Text:     truncateText(resp.Content, session.toolOptions.readLimit()),
+			MimeType: link.MimeType,
+		}})
+	}
+	return out
+}
+
+// isTextResourceLink reports whether a link may refer to a text file,
+// judging by its MIME type or, without one, its extension.
+func isTextResourceLink(link *acp.ContentBlockResourceLink) bool {
+	if link.MimeType != nil && *link.MimeType != "" {
+		mimeType := *link.MimeType
+		return strings.HasPrefix(mimeType, "text/") || strings.HasSuffix(mimeType, "json") ||
+			strings.HasSuffix(mimeType, "xml") || strings.HasSuffix(mimeType, "yaml") ||
+			strings.HasSuffix(mimeType, "javascript")
+	}
+	ext := strings.ToLower(filepath.Ext(link.Uri))
+	if _, isImage := imageMimeTypes[ext]; isImage {
+		return false
+	}
+	return ext != ".pdf"
+}
+
+// truncateText cuts text to at most limit bytes, at a line boundary when
+// possible, and notes how much was left out.
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if nl := strings.LastIndexByte(text[:cut], '\n'); nl > 0 {
+		cut = nl + 1
+	}
+	return text[:cut] + fmt.Sprintf("\n[Truncated: showing %s of %s]", formatByteSize(cut), formatByteSize(len(text)))
+}
+
+// maxMentionedFiles caps how many mentioned files are included in a prompt.
+const maxMentionedFiles = 20
+
+var (
+	// mentionRe matches "@path" mentions at the start of the text or after
+	// whitespace, so e-mail addresses are not mistaken for mentions.
+	mentionRe = regexp.MustCompile(`(?:^|\s)@([^\s@]+)`)
+	fileURIRe = regexp.MustCompile(`file://[^\s)\]>"'<]+`)
+)
+
+// includeMentionedFiles appends the files mentioned in a prompt's text, as
+// "@path" (relative to the session cwd) or file:// links, as embedded
+// resources. Files already embedded in the prompt or mentioned twice are
+// included once, each up to the session's read limit.
+func (a *ClaudeAcpAgent) includeMentionedFiles(ctx context.Context, sessionID string, session *Session, prompt []acp.ContentBlock) []acp.ContentBlock {
+	if a.conn == nil || a.clientCapabilities == nil || !a.clientCapabilities.Fs.ReadTextFile {
+		return prompt
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	for _, block := range prompt {
+		if block.Resource != nil && block.Resource.Resource.TextResourceContents != nil {
+			seen[fileURIPath(block.Resource.Resource.TextResourceContents.Uri)] = true
+		}
+	}
+	for _, block := range prompt {
+		if block.Text == nil {
+			continue
+		}
+		for _, path := range mentionedPaths(block.Text.Text, session.cwd) {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) > maxMentionedFiles {
+		paths = paths[:maxMentionedFiles]
+	}
+
+	for _, path := range paths {
+		resp, err := a.conn.ReadTextFile(ctx, acp.ReadTextFileRequest{
+			SessionId: acp.SessionId(sessionID),
+			Path:      path,
+		})
+		if err != nil || strings.ContainsRune(resp.Content, 0) {
+			continue
+		}
+		prompt = append(prompt, acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+			Uri:  (&url.URL{Scheme: "file", Path: path}).String(),
+			Text: truncateText(resp.Content, session.toolOptions.readLimit()),
+		}}))
+	}
+	return prompt
+}
+
+// mentionedPaths returns the absolute paths of the files mentioned in text:
+// "@path" mentions first, then file:// links.
+func mentionedPaths(text, cwd string) []string {
+	var paths []string
+	for _, m := range mentionRe.FindAllStringSubmatch(text, -1) {
+		path := strings.TrimRight(m[1], ".,;:!?)]}>\"'")
+		if path == "" || strings.Contains(path, "://") {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	for _, uri := range fileURIRe.FindAllString(text, -1) {
+		if path := fileURIPath(strings.TrimRight(uri, ".,;:!?")); path != "" {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+	return paths
+}
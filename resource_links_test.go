@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("short", 10); got != "short" {
+		t.Errorf("expected text under the limit unchanged, got %q", got)
+	}
+	got := truncateText("line one\nline two\nline three\n", 20)
+	if !strings.HasPrefix(got, "line one\nline two\n\n[Truncated: showing 18 bytes of 29 bytes]") {
+		t.Errorf("expected cut at a line boundary, got %q", got)
+	}
+}
+
+func TestResolveResourceLinks(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.clientCapabilities = &acp.ClientCapabilities{Fs: acp.FileSystemCapability{ReadTextFile: true}}
+	send, recv := extTestConn(t, agent)
+	session := &Session{}
+
+	prompt := []acp.ContentBlock{
+		acp.TextBlock("explain"),
+		acp.ResourceLinkBlock("main.go", "file:///src/main.go"),
+		acp.ResourceLinkBlock("logo.png", "file:///src/logo.png"),
+		acp.ResourceLinkBlock("site", "https://example.com"),
+		acp.ResourceLinkBlock("gone.go", "file:///src/gone.go"),
+	}
+	done := make(chan []acp.ContentBlock)
+	go func() { done <- agent.resolveResourceLinks(context.Background(), "s1", session, prompt) }()
+
+	// The agent reads main.go, then gone.go, which fails.
+	for _, reply := range []string{`"result":{"content":"package main\n"}`, `"error":{"code":-32603,"message":"not found"}`} {
+		req := recv()
+		if req["method"] != "fs/read_text_file" {
+			t.Fatalf("expected a read request, got %v", req)
+		}
+		id, _ := json.Marshal(req["id"])
+		send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,%s}`, id, reply))
+	}
+	got := <-done
+
+	res := got[1].Resource
+	if res == nil || res.Resource.TextResourceContents == nil || res.Resource.TextResourceContents.Text != "package main\n" {
+		t.Fatalf("expected main.go to be embedded, got %+v", got[1])
+	}
+	for _, i := range []int{2, 3, 4} {
+		if got[i].ResourceLink == nil {
+			t.Errorf("block %d: expected link to be kept, got %+v", i, got[i])
+		}
+	}
+}
+
+func TestMentionedPaths(t *testing.T) {
+	got := mentionedPaths("look at @main.go, and @/etc/hosts; mail bob@example.com or see file:///tmp/a.txt.", "/src")
+	want := []string{"/src/main.go", "/etc/hosts", "/tmp/a.txt"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("mentionedPaths = %v, want %v", got, want)
+	}
+}
+
+func TestIncludeMentionedFiles(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.clientCapabilities = &acp.ClientCapabilities{Fs: acp.FileSystemCapability{ReadTextFile: true}}
+	send, recv := extTestConn(t, agent)
+	session := &Session{cwd: "/src", toolOptions: BuiltinToolOptions{MaxReadBytes: 1000}}
+
+	prompt := []acp.ContentBlock{
+		acp.TextBlock("compare @a.go with @b.go and @a.go again"),
+		acp.ResourceBlock(acp.EmbeddedResourceResource{TextResourceContents: &acp.TextResourceContents{
+			Uri: "file:///src/b.go", Text: "package b",
+		}}),
+	}
+	done := make(chan []acp.ContentBlock)
+	go func() { done <- agent.includeMentionedFiles(context.Background(), "s1", session, prompt) }()
+
+	req := recv()
+	params, _ := req["params"].(map[string]any)
+	if req["method"] != "fs/read_text_file" || params["path"] != "/src/a.go" {
+		t.Fatalf("expected a single read of a.go, got %v", req)
+	}
+	id, _ := json.Marshal(req["id"])
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"content":"package a"}}`, id))
+	got := <-done
+
+	if len(got) != 3 {
+		t.Fatalf("expected one appended block, got %d", len(got))
+	}
+	res := got[2].Resource.Resource.TextResourceContents
+	if res.Uri != "file:///src/a.go" || res.Text != "package a" {
+		t.Errorf("unexpected appended resource %+v", res)
+	}
+}
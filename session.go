@@ -1,19 +1,88 @@
 package main
 
 import (
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Session represents an active Claude Code session
 type Session struct {
-	process              *ClaudeCodeProcess
+	process              Process           // guarded by mu, since restarts replace it; see proc
+	backend              Backend           // started process; nil if it cannot be restarted
+	startOptions         ProcessOptions    // how process was started
+	conversationStarted  bool              // the CLI has accepted a prompt, so it can be resumed
+	conversationID       string            // the CLI's conversation since /clear started a new one, if it did
+	cwd                  string
 	cancelled            bool
 	streamEventsReceived bool
 	permissionMode       string // "default"|"acceptEdits"|"bypassPermissions"|"dontAsk"|"plan"
 	settingsManager      *SettingsManager
+	allowBypass          bool // bypassPermissions mode may be selected
+	suppressThoughts     bool // drop agent thought updates
+	includeMentions      bool // attach files mentioned in prompts as context
+	usage                usageTracker
+	compaction           compactionTracker
+	history              sessionHistory
+	checkpoints          fileCheckpoints
+	autoCommit           bool // commit the files each successful turn changes
+	diagnostics          pendingDiagnostics
+	changedFiles         changedFiles
+	toolOutputs          toolOutputStore   // full text of truncated tool output
+	toolServer           *acpToolServer    // serves the built-in tools to the CLI, if enabled
+	mcpServers           []mcpServerStatus // as of the latest system init message
+	updates              *updateQueue      // see ClaudeAcpAgent.sessionUpdates
+	updateFailures       atomic.Int32      // updates in a row that could not be delivered
+	watchdog             *turnWatchdog     // of the running turn, if any
+	editedFiles          map[string]bool   // files the current turn's edit tools changed
+	editToolUses         map[string]string // file by ID of edit tool uses awaiting their result
+	dirtyBeforeTurn      map[string]bool   // files with uncommitted changes when the turn began
+	toolUseCache         *ToolUseCache
+	toolOptions          BuiltinToolOptions
+	logger               *slog.Logger // tagged with the session ID
+	turn                 int          // number of the latest turn
+	turnLogger           *slog.Logger // logger tagged with the latest turn
+	created              time.Time
+	lastError            string // latest failed turn or tool call, for debugging
+	lastErrorAt          time.Time
+	turnMu               sync.Mutex   // held while a turn reads from process
 	mu                   sync.Mutex
 }
 
+// log returns the logger for the session's log lines, tagged with the
+// session ID and, once a turn has started, the turn number.
+func (s *Session) log() *slog.Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.turnLogger != nil:
+		return s.turnLogger
+	case s.logger != nil:
+		return s.logger
+	}
+	return slog.Default()
+}
+
+// beginTurn numbers a new turn and returns its logger.
+func (s *Session) beginTurn() *slog.Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	s.turn++
+	s.turnLogger = s.logger.With("turn", s.turn)
+	return s.turnLogger
+}
+
+// turnID returns the number of the latest turn.
+func (s *Session) turnID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.turn
+}
+
 // Cancel marks the session as cancelled
 func (s *Session) Cancel() {
 	s.mu.Lock()
@@ -64,18 +133,8 @@ func (s *Session) GetPermissionMode() string {
 	return s.permissionMode
 }
 
-// BackgroundTerminal represents a terminal running in the background
-type BackgroundTerminal struct {
-	ID            string
-	Status        string // "started"|"aborted"|"exited"|"killed"|"timedOut"
-	LastOutput    string
-	PendingOutput *TerminalOutput
-}
 
-// TerminalOutput holds terminal command output
-type TerminalOutput struct {
-	Output     string
-	ExitCode   *int
-	Signal     string
-	Truncated  bool
+// Cwd returns the session's working directory, for extension plugins.
+func (s *Session) Cwd() string {
+	return s.cwd
 }
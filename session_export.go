@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// maxHistoryUpdates bounds the session updates kept for export; the oldest
+// are dropped first.
+const maxHistoryUpdates = 10000
+
+// sessionHistory records the prompts and updates of a session, and the
+// outcome of each turn, so the session can be exported.
+type sessionHistory struct {
+	mu      sync.Mutex
+	updates []acp.SessionUpdate
+	turns   []turnSummary
+}
+
+// turnSummary is the outcome of one prompt turn, from the CLI's result
+// message. CostUSD is cumulative: the CLI reports the cost of its process
+// so far.
+type turnSummary struct {
+	StopReason string  `json:"stopReason,omitempty"`
+	CostUSD    float64 `json:"costUsd,omitempty"`
+	DurationMs int     `json:"durationMs,omitempty"`
+	NumTurns   int     `json:"numTurns,omitempty"`
+	IsError    bool    `json:"isError,omitempty"`
+}
+
+func (h *sessionHistory) add(u acp.SessionUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.updates) >= maxHistoryUpdates {
+		h.updates = h.updates[1:]
+	}
+	h.updates = append(h.updates, u)
+}
+
+// addPrompt records the text of a user prompt.
+func (h *sessionHistory) addPrompt(prompt []acp.ContentBlock) {
+	if text := promptText(prompt); text != "" {
+		h.add(acp.UpdateUserMessageText(text))
+	}
+}
+
+// promptText returns the text of a prompt, with resource links as
+// @-mentions.
+func promptText(prompt []acp.ContentBlock) string {
+	var parts []string
+	for _, b := range prompt {
+		switch {
+		case b.Text != nil:
+			parts = append(parts, b.Text.Text)
+		case b.ResourceLink != nil:
+			parts = append(parts, "@"+b.ResourceLink.Uri)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// addTurn records the result message that ended a turn.
+func (h *sessionHistory) addTurn(resp *SDKResponse) {
+	raw := resp.Raw()
+	turn := turnSummary{StopReason: resp.StopReason, IsError: resp.IsError}
+	turn.CostUSD, _ = raw["total_cost_usd"].(float64)
+	if v, ok := raw["duration_ms"].(float64); ok {
+		turn.DurationMs = int(v)
+	}
+	if v, ok := raw["num_turns"].(float64); ok {
+		turn.NumTurns = int(v)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.turns = append(h.turns, turn)
+}
+
+// reset forgets the recorded history, as when the conversation is cleared.
+func (h *sessionHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.updates, h.turns = nil, nil
+}
+
+func (h *sessionHistory) snapshot() ([]acp.SessionUpdate, []turnSummary) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]acp.SessionUpdate(nil), h.updates...), append([]turnSummary{}, h.turns...)
+}
+
+// recordUpdate adds an update sent to the client to its session's history.
+func (a *ClaudeAcpAgent) recordUpdate(n acp.SessionNotification) {
+	a.mu.RLock()
+	session := a.sessions[string(n.SessionId)]
+	a.mu.RUnlock()
+	if session != nil {
+		session.history.add(n.Update)
+	}
+}
+
+// sessionExportParams is the payload of _claude/session/export.
+type sessionExportParams struct {
+	SessionID string `json:"sessionId"`
+	Format    string `json:"format,omitempty"` // "markdown" (default) or "json"
+}
+
+// sessionExportResult is the result of _claude/session/export: Markdown
+// text or the structured export, depending on the requested format.
+type sessionExportResult struct {
+	Format   string         `json:"format"`
+	Markdown string         `json:"markdown,omitempty"`
+	Session  *sessionExport `json:"session,omitempty"`
+}
+
+// sessionExport is a session's conversation, assembled from the CLI's
+// transcript and the updates the agent sent.
+type sessionExport struct {
+	SessionID string            `json:"sessionId"`
+	Cwd       string            `json:"cwd"`
+	Messages  []exportMessage   `json:"messages"`
+	ToolCalls []*exportToolCall `json:"toolCalls"`
+	Turns     []turnSummary     `json:"turns"`
+	Usage     contextUsage      `json:"usage"`
+}
+
+// exportMessage is one user or assistant message. ToolCallIDs lists the
+// tool calls the assistant made in it.
+type exportMessage struct {
+	Role        string   `json:"role"` // "user"|"assistant"
+	Text        string   `json:"text"`
+	Timestamp   string   `json:"timestamp,omitempty"`
+	ToolCallIDs []string `json:"toolCallIds,omitempty"`
+}
+
+type exportToolCall struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name,omitempty"`
+	Title   string       `json:"title,omitempty"`
+	Kind    string       `json:"kind,omitempty"`
+	Status  string       `json:"status,omitempty"`
+	Input   any          `json:"input,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	IsError bool         `json:"isError,omitempty"`
+	Diffs   []exportDiff `json:"diffs,omitempty"`
+}
+
+type exportDiff struct {
+	Path    string  `json:"path"`
+	OldText *string `json:"oldText,omitempty"`
+	NewText string  `json:"newText"`
+}
+
+// extExportSession returns the session's conversation as Markdown or JSON.
+func (a *ClaudeAcpAgent) extExportSession(_ context.Context, params json.RawMessage) (any, error) {
+	var p sessionExportParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Format == "" {
+		p.Format = "markdown"
+	}
+	if p.Format != "markdown" && p.Format != "json" {
+		return nil, acp.NewInvalidParams(map[string]any{"error": fmt.Sprintf("unknown format %q (want markdown or json)", p.Format)})
+	}
+	session, err := a.extSession(p.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	exp := buildSessionExport(p.SessionID, session, transcriptPath(session.cwd, p.SessionID))
+	if p.Format == "json" {
+		return sessionExportResult{Format: p.Format, Session: exp}, nil
+	}
+	return sessionExportResult{Format: p.Format, Markdown: exp.markdown()}, nil
+}
+
+var nonAlphanumericRe = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// transcriptPath returns where the CLI keeps a session's transcript:
+// projects/<cwd with non-alphanumerics replaced by "-">/<session>.jsonl in
+// the Claude config dir.
+func transcriptPath(cwd, sessionID string) string {
+	return filepath.Join(getClaudeConfigDir(), "projects", nonAlphanumericRe.ReplaceAllString(cwd, "-"), sessionID+".jsonl")
+}
+
+// buildSessionExport assembles the export. Messages and tool inputs and
+// results come from the transcript when it can be read, otherwise from the
+// recorded updates; titles, statuses and diffs always come from the updates.
+func buildSessionExport(sessionID string, session *Session, transcript string) *sessionExport {
+	updates, turns := session.history.snapshot()
+	exp := &sessionExport{
+		SessionID: sessionID,
+		Cwd:       session.cwd,
+		Messages:  []exportMessage{},
+		ToolCalls: []*exportToolCall{},
+		Turns:     turns,
+		Usage:     session.usage.snapshot(sessionID),
+	}
+	calls := map[string]*exportToolCall{}
+	if !exp.readTranscript(transcript, calls) {
+		exp.messagesFromUpdates(updates, calls)
+	}
+	exp.applyToolUpdates(updates, calls)
+	return exp
+}
+
+// toolCall returns the tool call with id, adding it if it is new.
+func (e *sessionExport) toolCall(calls map[string]*exportToolCall, id string) *exportToolCall {
+	if c, ok := calls[id]; ok {
+		return c
+	}
+	c := &exportToolCall{ID: id}
+	calls[id] = c
+	e.ToolCalls = append(e.ToolCalls, c)
+	return c
+}
+
+// transcriptEntry is the subset of a CLI transcript line used for export.
+type transcriptEntry struct {
+	Type        string `json:"type"`
+	Timestamp   string `json:"timestamp"`
+	IsSidechain bool   `json:"isSidechain"`
+	IsMeta      bool   `json:"isMeta"`
+	Message     struct {
+		Content json.RawMessage `json:"content"`
+	} `json:"message"`
+}
+
+type transcriptBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     any             `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	IsError   bool            `json:"is_error"`
+}
+
+// readTranscript adds the messages and tool calls of the main conversation
+// in the transcript at path. It reports whether the transcript was read.
+func (e *sessionExport) readTranscript(path string, calls map[string]*exportToolCall) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), MaxMessageSize)
+	for scanner.Scan() {
+		var entry transcriptEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.IsSidechain || entry.IsMeta ||
+			(entry.Type != "user" && entry.Type != "assistant") {
+			continue
+		}
+		var text string
+		var blocks []transcriptBlock
+		if json.Unmarshal(entry.Message.Content, &text) != nil {
+			_ = json.Unmarshal(entry.Message.Content, &blocks)
+		}
+		msg := exportMessage{Role: entry.Type, Text: text, Timestamp: entry.Timestamp}
+		var texts []string
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				texts = append(texts, b.Text)
+			case "tool_use":
+				c := e.toolCall(calls, b.ID)
+				c.Name, c.Input = b.Name, b.Input
+				msg.ToolCallIDs = append(msg.ToolCallIDs, b.ID)
+			case "tool_result":
+				c := e.toolCall(calls, b.ToolUseID)
+				c.Output, c.IsError = toolResultText(b.Content), b.IsError
+			}
+		}
+		if len(texts) > 0 {
+			msg.Text = strings.Join(texts, "\n\n")
+		}
+		if msg.Text != "" || len(msg.ToolCallIDs) > 0 {
+			e.Messages = append(e.Messages, msg)
+		}
+	}
+	return scanner.Err() == nil
+}
+
+// toolResultText flattens a tool_result content, a string or text blocks.
+func toolResultText(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var blocks []transcriptBlock
+	_ = json.Unmarshal(content, &blocks)
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// messagesFromUpdates rebuilds the messages from the recorded updates,
+// joining consecutive chunks of the same role.
+func (e *sessionExport) messagesFromUpdates(updates []acp.SessionUpdate, calls map[string]*exportToolCall) {
+	appendText := func(role, text string) {
+		if n := len(e.Messages); n > 0 && e.Messages[n-1].Role == role && len(e.Messages[n-1].ToolCallIDs) == 0 {
+			e.Messages[n-1].Text += text
+			return
+		}
+		e.Messages = append(e.Messages, exportMessage{Role: role, Text: text})
+	}
+	for _, u := range updates {
+		switch {
+		case u.UserMessageChunk != nil && u.UserMessageChunk.Content.Text != nil:
+			appendText("user", u.UserMessageChunk.Content.Text.Text)
+		case u.AgentMessageChunk != nil && u.AgentMessageChunk.Content.Text != nil:
+			appendText("assistant", u.AgentMessageChunk.Content.Text.Text)
+		case u.ToolCall != nil:
+			id := string(u.ToolCall.ToolCallId)
+			if _, seen := calls[id]; seen {
+				continue
+			}
+			e.toolCall(calls, id)
+			if n := len(e.Messages); n == 0 || e.Messages[n-1].Role != "assistant" {
+				e.Messages = append(e.Messages, exportMessage{Role: "assistant"})
+			}
+			last := &e.Messages[len(e.Messages)-1]
+			last.ToolCallIDs = append(last.ToolCallIDs, id)
+		}
+	}
+}
+
+// applyToolUpdates fills in titles, kinds, statuses and diffs from the
+// recorded tool call updates, and inputs and outputs the transcript lacked.
+func (e *sessionExport) applyToolUpdates(updates []acp.SessionUpdate, calls map[string]*exportToolCall) {
+	for _, u := range updates {
+		switch {
+		case u.ToolCall != nil:
+			c := e.toolCall(calls, string(u.ToolCall.ToolCallId))
+			c.Title, c.Kind, c.Status = u.ToolCall.Title, string(u.ToolCall.Kind), string(u.ToolCall.Status)
+			if c.Input == nil {
+				c.Input = u.ToolCall.RawInput
+			}
+			c.applyContent(u.ToolCall.Content)
+		case u.ToolCallUpdate != nil:
+			c := e.toolCall(calls, string(u.ToolCallUpdate.ToolCallId))
+			if u.ToolCallUpdate.Title != nil {
+				c.Title = *u.ToolCallUpdate.Title
+			}
+			if u.ToolCallUpdate.Kind != nil {
+				c.Kind = string(*u.ToolCallUpdate.Kind)
+			}
+			if u.ToolCallUpdate.Status != nil {
+				c.Status = string(*u.ToolCallUpdate.Status)
+			}
+			if c.Input == nil {
+				c.Input = u.ToolCallUpdate.RawInput
+			}
+			c.applyContent(u.ToolCallUpdate.Content)
+		}
+	}
+}
+
+// applyContent records the diffs in a tool call's content, and its text as
+// the output if none is known.
+func (c *exportToolCall) applyContent(content []acp.ToolCallContent) {
+	var diffs []exportDiff
+	var texts []string
+	for _, item := range content {
+		switch {
+		case item.Diff != nil:
+			diffs = append(diffs, exportDiff{Path: item.Diff.Path, OldText: item.Diff.OldText, NewText: item.Diff.NewText})
+		case item.Content != nil && item.Content.Content.Text != nil:
+			texts = append(texts, item.Content.Content.Text.Text)
+		}
+	}
+	if len(diffs) > 0 {
+		c.Diffs = diffs
+	}
+	if c.Output == "" && len(texts) > 0 {
+		c.Output = strings.Join(texts, "\n")
+	}
+}
+
+// markdown renders the export for reading or sharing.
+func (e *sessionExport) markdown() string {
+	calls := map[string]*exportToolCall{}
+	for _, c := range e.ToolCalls {
+		calls[c.ID] = c
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Session %s\n\nWorking directory: `%s`\n", e.SessionID, e.Cwd)
+	for _, m := range e.Messages {
+		heading := "User"
+		if m.Role == "assistant" {
+			heading = "Assistant"
+		}
+		sb.WriteString("\n## " + heading + "\n")
+		if m.Text != "" {
+			sb.WriteString("\n" + strings.TrimRight(m.Text, "\n") + "\n")
+		}
+		for _, id := range m.ToolCallIDs {
+			if c := calls[id]; c != nil {
+				c.writeMarkdown(&sb)
+			}
+		}
+	}
+
+	if len(e.Turns) > 0 || e.Usage.Used > 0 {
+		sb.WriteString("\n## Usage\n\n")
+		var cost float64
+		var duration time.Duration
+		for _, t := range e.Turns {
+			cost = max(cost, t.CostUSD)
+			duration += time.Duration(t.DurationMs) * time.Millisecond
+		}
+		fmt.Fprintf(&sb, "- Turns: %d\n- Cost: $%.4f\n- Duration: %s\n", len(e.Turns), cost, duration.Round(time.Millisecond))
+		fmt.Fprintf(&sb, "- Context: %d of %d tokens\n", e.Usage.Used, e.Usage.Size)
+	}
+	return sb.String()
+}
+
+func (c *exportToolCall) writeMarkdown(sb *strings.Builder) {
+	title := c.Title
+	if title == "" {
+		title = c.Name
+	}
+	if c.Status != "" {
+		title += " (" + c.Status + ")"
+	}
+	sb.WriteString("\n### Tool: " + title + "\n")
+	if c.Input != nil {
+		if b, err := json.MarshalIndent(c.Input, "", "  "); err == nil && string(b) != "{}" {
+			sb.WriteString("\n" + markdownEscape(string(b)) + "\n")
+		}
+	}
+	for _, d := range c.Diffs {
+		var oldText string
+		if d.OldText != nil {
+			oldText = *d.OldText
+		}
+		if diff := createUnifiedDiff(d.Path, oldText, d.NewText); diff != "" {
+			sb.WriteString("\n" + markdownEscape(diff) + "\n")
+		}
+	}
+	if c.Output != "" {
+		label := "Output"
+		if c.IsError {
+			label = "Error"
+		}
+		sb.WriteString("\n" + label + ":\n\n" + markdownEscape(c.Output) + "\n")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestBuildSessionExport_FromTranscript(t *testing.T) {
+	session := &Session{cwd: "/src/app"}
+	oldText := "a := 1\n"
+	session.history.add(acp.StartToolCall("toolu_1", "Edit main.go", acp.WithStartKind(acp.ToolKindEdit), acp.WithStartStatus(acp.ToolCallStatusPending)))
+	session.history.add(acp.UpdateToolCall("toolu_1", acp.WithUpdateStatus(acp.ToolCallStatusCompleted),
+		acp.WithUpdateContent([]acp.ToolCallContent{acp.ToolDiffContent("main.go", "a := 2\n", oldText)})))
+	session.history.addTurn(&SDKResponse{Type: "result", RawLine: json.RawMessage(`{"total_cost_usd":0.0123,"duration_ms":1500,"num_turns":2}`)})
+
+	transcript := filepath.Join(t.TempDir(), "s1.jsonl")
+	writeTestFile(t, transcript, strings.Join([]string{
+		`{"type":"summary","summary":"Edit"}`,
+		`{"type":"user","timestamp":"2026-01-02T03:04:05Z","message":{"role":"user","content":"Set a to 2"}}`,
+		`{"type":"assistant","isSidechain":true,"message":{"content":[{"type":"text","text":"subagent"}]}}`,
+		`{"type":"assistant","message":{"content":[{"type":"text","text":"Editing."},{"type":"tool_use","id":"toolu_1","name":"Edit","input":{"file_path":"main.go"}}]}}`,
+		`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"ok"}]}]}}`,
+		`{"type":"assistant","message":{"content":[{"type":"text","text":"Done."}]}}`,
+	}, "\n"))
+
+	exp := buildSessionExport("s1", session, transcript)
+	if len(exp.Messages) != 3 || exp.Messages[0].Text != "Set a to 2" || exp.Messages[0].Timestamp == "" ||
+		exp.Messages[1].ToolCallIDs[0] != "toolu_1" || exp.Messages[2].Text != "Done." {
+		t.Fatalf("unexpected messages %+v", exp.Messages)
+	}
+	call := exp.ToolCalls[0]
+	if len(exp.ToolCalls) != 1 || call.Name != "Edit" || call.Title != "Edit main.go" || call.Status != "completed" ||
+		call.Output != "ok" || len(call.Diffs) != 1 {
+		t.Fatalf("unexpected tool call %+v", call)
+	}
+
+	md := exp.markdown()
+	for _, want := range []string{"# Session s1", "## User\n\nSet a to 2", "### Tool: Edit main.go (completed)", "-a := 1\n+a := 2", "Output:\n\n```\nok\n```", "- Cost: $0.0123"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "subagent") {
+		t.Error("sidechain messages should be left out")
+	}
+}
+
+func TestExtExportSession_FromUpdates(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	session := &Session{cwd: t.TempDir()}
+	agent.sessions["s1"] = session
+	session.history.addPrompt([]acp.ContentBlock{acp.TextBlock("List files")})
+	session.history.add(acp.UpdateAgentMessageText("Here "))
+	session.history.add(acp.UpdateAgentMessageText("they are."))
+	session.history.add(acp.StartToolCall("t1", "ls", acp.WithStartRawInput(map[string]any{"command": "ls"})))
+	send, recv := extTestConn(t, agent)
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/export","params":{"sessionId":"s1","format":"json"}}`)
+	result, _ := recv()["result"].(map[string]any)
+	exported, _ := result["session"].(map[string]any)
+	messages, _ := exported["messages"].([]any)
+	if len(messages) != 2 || messages[1].(map[string]any)["text"] != "Here they are." ||
+		messages[1].(map[string]any)["toolCallIds"].([]any)[0] != "t1" {
+		t.Errorf("unexpected export %v", result)
+	}
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"_claude/session/export","params":{"sessionId":"s1","format":"pdf"}}`)
+	if msg := recv(); msg["error"] == nil {
+		t.Errorf("expected an error for an unknown format, got %v", msg)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// sessionHub shares sessions between the connections of a websocket server.
+// The connection that created a session is its only writer; others may
+// attach with _claude/session/attach to receive its session updates.
+type sessionHub struct {
+	mu       sync.Mutex
+	sessions map[string]*hubSession
+}
+
+// hubSession is a session's owner and the agents watching it.
+type hubSession struct {
+	owner   *ClaudeAcpAgent
+	viewers map[*ClaudeAcpAgent]*hubViewer
+}
+
+// viewerQueueSize is how many mirrored updates may wait for a viewer.
+const viewerQueueSize = DefaultUpdateQueueSize
+
+// hubViewer mirrors a session's updates to one viewer from its own
+// goroutine, so a slow viewer holds up neither the owner nor the other
+// viewers. Updates that find its queue full are dropped.
+type hubViewer struct {
+	updates chan acp.SessionNotification
+}
+
+func newHubViewer(viewer *ClaudeAcpAgent) *hubViewer {
+	v := &hubViewer{updates: make(chan acp.SessionNotification, viewerQueueSize)}
+	go func() {
+		for n := range v.updates {
+			if err := viewer.conn.SessionUpdate(context.Background(), n); err != nil {
+				viewer.logger.Debug("Failed to mirror session update", "session", n.SessionId, "error", err)
+			}
+		}
+	}()
+	return v
+}
+
+func newSessionHub() *sessionHub {
+	return &sessionHub{sessions: make(map[string]*hubSession)}
+}
+
+// add registers a session created by owner.
+func (h *sessionHub) add(sessionID string, owner *ClaudeAcpAgent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessions[sessionID] = &hubSession{owner: owner, viewers: make(map[*ClaudeAcpAgent]*hubViewer)}
+}
+
+// attach makes viewer receive a session's updates.
+func (h *sessionHub) attach(sessionID string, viewer *ClaudeAcpAgent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return errSessionNotFound(sessionID)
+	}
+	if s.owner == viewer {
+		return acp.NewInvalidParams(map[string]any{"error": "the session belongs to this client", "sessionId": sessionID})
+	}
+	if s.viewers[viewer] == nil {
+		s.viewers[viewer] = newHubViewer(viewer)
+	}
+	return nil
+}
+
+// detach stops sending a session's updates to viewer.
+func (h *sessionHub) detach(sessionID string, viewer *ClaudeAcpAgent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.sessions[sessionID]; ok {
+		s.removeViewer(viewer)
+	}
+}
+
+// removeViewer stops mirroring to viewer. The caller holds the hub's mu.
+func (s *hubSession) removeViewer(viewer *ClaudeAcpAgent) {
+	if v := s.viewers[viewer]; v != nil {
+		close(v.updates)
+		delete(s.viewers, viewer)
+	}
+}
+
+// isViewer reports whether agent watches the session without owning it.
+func (h *sessionHub) isViewer(sessionID string, agent *ClaudeAcpAgent) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[sessionID]
+	return ok && s.viewers[agent] != nil
+}
+
+// mirror queues a session update for the agents watching the session,
+// without waiting for them. It returns how many viewers' queues were full,
+// and so missed it.
+func (h *sessionHub) mirror(n acp.SessionNotification) (dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[string(n.SessionId)]
+	if !ok {
+		return 0
+	}
+	for _, v := range s.viewers {
+		select {
+		case v.updates <- n:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// owners returns the agents owning the shared sessions.
+func (h *sessionHub) owners() []*ClaudeAcpAgent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []*ClaudeAcpAgent
+	for _, s := range h.sessions {
+		if !slices.Contains(out, s.owner) {
+			out = append(out, s.owner)
+		}
+	}
+	return out
+}
+
+// removeAgent forgets the sessions agent owns and detaches it from those it
+// watches, once its connection has closed.
+func (h *sessionHub) removeAgent(agent *ClaudeAcpAgent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		if s.owner == agent {
+			for viewer := range s.viewers {
+				s.removeViewer(viewer)
+			}
+			delete(h.sessions, id)
+			continue
+		}
+		s.removeViewer(agent)
+	}
+}
+
+// mirrorUpdate queues a session update for the clients watching the
+// session.
+func (a *ClaudeAcpAgent) mirrorUpdate(n acp.SessionNotification) {
+	if a.hub == nil {
+		return
+	}
+	if dropped := a.hub.mirror(n); dropped > 0 {
+		a.logger.Debug("Dropped mirrored session update", "session", n.SessionId, "viewers", dropped)
+	}
+}
+
+// lookupSession returns the session a request drives. Sessions this client
+// only watches are read-only.
+func (a *ClaudeAcpAgent) lookupSession(sessionID string) (*Session, error) {
+	a.mu.RLock()
+	session, ok := a.sessions[sessionID]
+	a.mu.RUnlock()
+	if ok {
+		return session, nil
+	}
+	if a.hub != nil && a.hub.isViewer(sessionID, a) {
+		return nil, errSessionReadOnly(sessionID)
+	}
+	return nil, errSessionNotFound(sessionID)
+}
+
+// sessionAttachParams is the payload of _claude/session/attach and
+// _claude/session/detach.
+type sessionAttachParams struct {
+	SessionID string `json:"sessionId"`
+}
+
+func (a *ClaudeAcpAgent) extAttachSession(_ context.Context, params json.RawMessage) (any, error) {
+	var p sessionAttachParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	if a.hub == nil {
+		return nil, acp.NewInvalidRequest(map[string]any{"error": "session sharing is only available in the websocket and socket transports"})
+	}
+	if err := a.hub.attach(p.SessionID, a); err != nil {
+		return nil, err
+	}
+	return map[string]any{"sessionId": p.SessionID, "readOnly": true}, nil
+}
+
+func (a *ClaudeAcpAgent) extDetachSession(_ context.Context, params json.RawMessage) (any, error) {
+	var p sessionAttachParams
+	if err := decodeExtParams(params, &p); err != nil {
+		return nil, err
+	}
+	if a.hub != nil {
+		a.hub.detach(p.SessionID, a)
+	}
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestSessionHub_MirrorsUpdatesToViewers(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := newSessionHub()
+	owner := NewClaudeAcpAgent(logger, AgentOptions{})
+	viewer := NewClaudeAcpAgent(logger, AgentOptions{})
+	owner.hub, viewer.hub = hub, hub
+	owner.sessions["s1"] = &Session{cwd: t.TempDir()}
+	hub.add("s1", owner)
+	ownerSend, ownerRecv := extTestConn(t, owner)
+	viewerSend, viewerRecv := extTestConn(t, viewer)
+
+	ownerSend(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/attach","params":{"sessionId":"s1"}}`)
+	if msg := ownerRecv(); msg["error"] == nil {
+		t.Errorf("the owner must not attach to its own session, got %v", msg)
+	}
+	viewerSend(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/attach","params":{"sessionId":"s1"}}`)
+	if msg := viewerRecv(); msg["result"].(map[string]any)["readOnly"] != true {
+		t.Fatalf("unexpected attach result %v", msg)
+	}
+
+	if _, err := owner.Prompt(context.Background(), acp.PromptRequest{
+		SessionId: "s1",
+		Prompt:    []acp.ContentBlock{acp.TextBlock("# Use tabs")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for name, recv := range map[string]func() map[string]any{"owner": ownerRecv, "viewer": viewerRecv} {
+		if msg := recv(); msg["method"] != "session/update" {
+			t.Errorf("%s: expected a session update, got %v", name, msg)
+		}
+	}
+
+	_, err := viewer.Prompt(context.Background(), acp.PromptRequest{SessionId: "s1", Prompt: []acp.ContentBlock{acp.TextBlock("hi")}})
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Data.(errorData).Kind != errKindSessionReadOnly {
+		t.Errorf("expected a read-only error, got %v", err)
+	}
+
+	hub.removeAgent(owner)
+	if _, err := viewer.lookupSession("s1"); !errors.As(err, &reqErr) || reqErr.Data.(errorData).Kind != errKindSessionNotFound {
+		t.Errorf("expected session not found once the owner is gone, got %v", err)
+	}
+}
+
+func TestSessionHub_AttachOutsideWebSocket(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	send, recv := extTestConn(t, agent)
+	send(`{"jsonrpc":"2.0","id":1,"method":"_claude/session/attach","params":{"sessionId":"s1"}}`)
+	if errObj, ok := recv()["error"].(map[string]any); !ok || errObj["code"].(float64) != -32600 {
+		t.Errorf("expected InvalidRequest, got %v", errObj)
+	}
+}
+
+func TestCloseSessions_StopsProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses cat as the CLI")
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := newSessionHub()
+	agent := NewClaudeAcpAgent(logger, AgentOptions{})
+	agent.hub = hub
+	proc, err := startProcess("cat", nil, ClaudeCodeOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{process: proc}
+	agent.sessions["s1"] = session
+	hub.add("s1", agent)
+
+	agent.closeSessions()
+	select {
+	case <-proc.Done():
+	default:
+		t.Error("process still running after closeSessions")
+	}
+	if !session.IsCancelled() || len(agent.sessions) != 0 {
+		t.Error("session not cancelled and removed")
+	}
+	if err := hub.attach("s1", NewClaudeAcpAgent(logger, AgentOptions{})); err == nil {
+		t.Error("session still registered with the hub")
+	}
+}
+
+// blockedWriter never completes a write until released.
+type blockedWriter struct{ release chan struct{} }
+
+func (w blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// A viewer that stops reading misses updates rather than holding up the
+// session.
+func TestSessionHub_SlowViewer(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := newSessionHub()
+	owner := NewClaudeAcpAgent(logger, AgentOptions{})
+	viewer := NewClaudeAcpAgent(logger, AgentOptions{})
+	w := blockedWriter{release: make(chan struct{})}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	viewer.SetAgentConnection(acp.NewAgentSideConnection(viewer, w, pr))
+	hub.add("s1", owner)
+	if err := hub.attach("s1", viewer); err != nil {
+		t.Fatal(err)
+	}
+
+	n := acp.SessionNotification{SessionId: "s1", Update: acp.UpdateAgentMessageText("hi")}
+	dropped := 0
+	for range viewerQueueSize + 10 {
+		dropped += hub.mirror(n)
+	}
+	if dropped == 0 {
+		t.Error("expected updates dropped for the stalled viewer")
+	}
+	hub.detach("s1", viewer)
+	close(w.release)
+	if hub.isViewer("s1", viewer) {
+		t.Error("viewer still attached after detach")
+	}
+}
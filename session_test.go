@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestSessionLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil)).With("session", "s1")
+	session := &Session{logger: logger}
+
+	session.log().Info("before")
+	session.beginTurn()
+	session.beginTurn().Info("during")
+	session.log().Info("after")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"msg=before session=s1", "msg=during session=s1 turn=2", "msg=after session=s1 turn=2"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines: %q", len(lines), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d: got %q, want suffix %q", i, line, want[i])
+		}
+	}
+}
+
+func TestNewSession_PermissionModeOption(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	tests := []struct {
+		option      string
+		allowBypass bool
+		want        string
+	}{
+		{"", true, "default"},
+		{"acceptEdits", true, "acceptEdits"},
+		{"bypassPermissions", true, "bypassPermissions"},
+		{"bypassPermissions", false, "default"},
+	}
+	for _, tt := range tests {
+		backend := &restartBackend{}
+		agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{Backend: backend, PermissionMode: tt.option})
+		agent.allowBypass = tt.allowBypass
+		resp, err := agent.NewSession(context.Background(), acp.NewSessionRequest{Cwd: t.TempDir(), McpServers: []acp.McpServer{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(resp.Modes.CurrentModeId); got != tt.want || backend.starts[0].PermissionMode != tt.want {
+			t.Errorf("%q (allowBypass=%v): mode = %s, CLI mode = %s, want %s", tt.option, tt.allowBypass, got, backend.starts[0].PermissionMode, tt.want)
+		}
+	}
+}
+
+func TestAllowBypassOption(t *testing.T) {
+	t.Setenv("IS_SANDBOX", "1")
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	if agent.allowBypass || !strings.Contains(bypassUnavailableReason(agent.opts), "--dangerously-allow-bypass") {
+		t.Errorf("expected bypass to need opting in, got allowBypass=%v", agent.allowBypass)
+	}
+
+	var buf bytes.Buffer
+	agent = NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{AllowBypass: true})
+	if !agent.allowBypass {
+		t.Fatal("expected bypass to be allowed in a sandbox")
+	}
+	agent.sessions["s1"] = &Session{allowBypass: true, permissionMode: "default", logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	if _, err := agent.SetSessionMode(context.Background(), acp.SetSessionModeRequest{SessionId: "s1", ModeId: "bypassPermissions"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "audit=permission") {
+		t.Errorf("expected the switch to bypass to be audited, got %q", buf.String())
+	}
+}
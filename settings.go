@@ -1,15 +1,22 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"maps"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gobwas/glob"
 )
@@ -21,13 +28,75 @@ type PermissionSettings struct {
 	Ask                   []string `json:"ask,omitempty"`
 	AdditionalDirectories []string `json:"additionalDirectories,omitempty"`
 	DefaultMode           string   `json:"defaultMode,omitempty"`
+	// DisableBypassPermissionsMode set to "disable" prevents sessions from
+	// entering bypassPermissions mode.
+	DisableBypassPermissionsMode string `json:"disableBypassPermissionsMode,omitempty"`
+}
+
+// PermissionRules is a set of allow, deny and ask rule strings.
+type PermissionRules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	Ask   []string `json:"ask"`
+}
+
+// HookCommand is a single hook action.
+type HookCommand struct {
+	Type    string `json:"type"` // "command"
+	Command string `json:"command"`
+	Timeout int    `json:"timeout,omitempty"` // seconds
+}
+
+// HookMatcher runs Hooks for tool names matching Matcher.
+type HookMatcher struct {
+	Matcher string        `json:"matcher,omitempty"`
+	Hooks   []HookCommand `json:"hooks"`
+}
+
+// StatusLineSettings configures a custom status line.
+type StatusLineSettings struct {
+	Type    string `json:"type"` // "command"
+	Command string `json:"command"`
+	Padding int    `json:"padding,omitempty"`
 }
 
 // ClaudeCodeSettings represents the structure of a Claude Code settings file.
+//
+// The agent itself honors Permissions, Env, Model, ReadOnly, AutoCommit,
+// IncludeCoAuthoredBy (for auto-commits) and the proxy fields, and runs
+// APIKeyHelper when user or managed settings set it. Hooks and the
+// remaining fields are parsed so extra settings keep them when they are
+// passed on to the CLI, which applies them and loads the settings files
+// itself.
 type ClaudeCodeSettings struct {
-	Permissions *PermissionSettings `json:"permissions,omitempty"`
-	Env         map[string]string   `json:"env,omitempty"`
-	Model       string              `json:"model,omitempty"`
+	Permissions                *PermissionSettings      `json:"permissions,omitempty"`
+	Env                        map[string]string        `json:"env,omitempty"`
+	Model                      string                   `json:"model,omitempty"`
+	APIKeyHelper               string                   `json:"apiKeyHelper,omitempty"`
+	Hooks                      map[string][]HookMatcher `json:"hooks,omitempty"`
+	DisableAllHooks            *bool                    `json:"disableAllHooks,omitempty"`
+	CleanupPeriodDays          *int                     `json:"cleanupPeriodDays,omitempty"`
+	IncludeCoAuthoredBy        *bool                    `json:"includeCoAuthoredBy,omitempty"`
+	StatusLine                 *StatusLineSettings      `json:"statusLine,omitempty"`
+	OutputStyle                string                   `json:"outputStyle,omitempty"`
+	ForceLoginMethod           string                   `json:"forceLoginMethod,omitempty"` // "claudeai"|"console"
+	ForceLoginOrgUUID          string                   `json:"forceLoginOrgUUID,omitempty"`
+	EnableAllProjectMcpServers *bool                    `json:"enableAllProjectMcpServers,omitempty"`
+	EnabledMcpjsonServers      []string                 `json:"enabledMcpjsonServers,omitempty"`
+	DisabledMcpjsonServers     []string                 `json:"disabledMcpjsonServers,omitempty"`
+	AWSAuthRefresh             string                   `json:"awsAuthRefresh,omitempty"`
+	AWSCredentialExport        string                   `json:"awsCredentialExport,omitempty"`
+	ReadOnly                   *bool                    `json:"readOnly,omitempty"` // reject Write, Edit and Bash
+	HTTPProxy                  string                   `json:"httpProxy,omitempty"`
+	HTTPSProxy                 string                   `json:"httpsProxy,omitempty"`
+	NoProxy                    string                   `json:"noProxy,omitempty"`
+	AutoCommit                 *bool                    `json:"autoCommit,omitempty"` // commit each turn's edits
+}
+
+// BypassPermissionsDisabled reports whether the settings forbid the
+// bypassPermissions mode.
+func (c ClaudeCodeSettings) BypassPermissionsDisabled() bool {
+	return c.Permissions != nil && c.Permissions.DisableBypassPermissionsMode == "disable"
 }
 
 // PermissionDecision represents the outcome of a permission check.
@@ -63,6 +132,7 @@ var shellOperators = []string{"&&", "||", ";", "|", "$(", "`", "\n"}
 // Per Claude Code docs: "Edit rules apply to all built-in tools that edit files."
 var fileEditingTools = []string{
 	ACPToolNamePrefix + "Edit",
+	ACPToolNamePrefix + "MultiEdit",
 	ACPToolNamePrefix + "Write",
 }
 
@@ -72,13 +142,24 @@ var fileReadingTools = []string{
 	ACPToolNamePrefix + "Read",
 }
 
+// webFetchTools and webSearchTools list the tool names governed by
+// "WebFetch" and "WebSearch" rules: the CLI built-ins and their ACP variants.
+var webFetchTools = []string{"WebFetch", ACPToolNamePrefix + "WebFetch"}
+var webSearchTools = []string{"WebSearch", ACPToolNamePrefix + "WebSearch"}
+
 // toolArgAccessors maps tool names to functions that extract the relevant
 // argument from tool input for permission matching.
 var toolArgAccessors = map[string]func(input map[string]any) string{
-	ACPToolNamePrefix + "Read":  func(input map[string]any) string { return getStringArg(input, "file_path") },
-	ACPToolNamePrefix + "Edit":  func(input map[string]any) string { return getStringArg(input, "file_path") },
-	ACPToolNamePrefix + "Write": func(input map[string]any) string { return getStringArg(input, "file_path") },
-	ACPToolNamePrefix + "Bash":  func(input map[string]any) string { return getStringArg(input, "command") },
+	ACPToolNamePrefix + "Read":      func(input map[string]any) string { return getStringArg(input, "file_path") },
+	ACPToolNamePrefix + "Edit":      func(input map[string]any) string { return getStringArg(input, "file_path") },
+	ACPToolNamePrefix + "MultiEdit": func(input map[string]any) string { return getStringArg(input, "file_path") },
+	ACPToolNamePrefix + "Write":     func(input map[string]any) string { return getStringArg(input, "file_path") },
+	ACPToolNamePrefix + "Bash":      func(input map[string]any) string { return getStringArg(input, "command") },
+
+	"WebFetch":                      func(input map[string]any) string { return getStringArg(input, "url") },
+	ACPToolNamePrefix + "WebFetch":  func(input map[string]any) string { return getStringArg(input, "url") },
+	"WebSearch":                     func(input map[string]any) string { return getStringArg(input, "query") },
+	ACPToolNamePrefix + "WebSearch": func(input map[string]any) string { return getStringArg(input, "query") },
 }
 
 // getStringArg safely extracts a string value from a map.
@@ -91,8 +172,12 @@ func getStringArg(input map[string]any, key string) string {
 	return ""
 }
 
-// ruleRegexp matches rule strings like "Read", "Read(./.env)", "Bash(npm run:*)".
-var ruleRegexp = regexp.MustCompile(`^(\w+)(?:\((.+)\))?$`)
+// ruleRegexp matches rule strings like "Read", "Read(./.env)", "Bash(npm run:*)"
+// and MCP tool names like "mcp__github__create_issue" or "mcp__github__*".
+var ruleRegexp = regexp.MustCompile(`^([\w-]+\*?)(?:\((.+)\))?$`)
+
+// mcpToolPrefix is the prefix shared by all MCP tool names (mcp__<server>__<tool>).
+const mcpToolPrefix = "mcp__"
 
 // parseRule parses a permission rule string into its components.
 // Examples:
@@ -100,6 +185,7 @@ var ruleRegexp = regexp.MustCompile(`^(\w+)(?:\((.+)\))?$`)
 //	"Read"            -> { toolName: "Read" }
 //	"Read(./.env)"    -> { toolName: "Read", argument: "./.env" }
 //	"Bash(npm run:*)" -> { toolName: "Bash", argument: "npm run", isWildcard: true }
+//	"mcp__github__*"  -> { toolName: "mcp__github__*" }
 func parseRule(rule string) parsedRule {
 	matches := ruleRegexp.FindStringSubmatch(rule)
 	if matches == nil {
@@ -166,8 +252,45 @@ func matchesGlob(pattern string, filePath string, cwd string) bool {
 	return g.Match(normalizedPath)
 }
 
+// matchesMcpToolRule checks if an MCP tool name matches an MCP rule name.
+// Supported forms are the exact tool name ("mcp__github__create_issue"),
+// a bare server name ("mcp__github") and a server wildcard ("mcp__github__*").
+func matchesMcpToolRule(ruleName string, toolName string) bool {
+	if ruleName == toolName {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(ruleName, "__*"); ok {
+		return strings.HasPrefix(toolName, prefix+"__")
+	}
+	if !strings.Contains(ruleName[len(mcpToolPrefix):], "__") {
+		return strings.HasPrefix(toolName, ruleName+"__")
+	}
+	return false
+}
+
+// matchesDomain checks if the host of rawURL matches a domain pattern.
+// "example.com" matches only that host; "*.example.com" matches its
+// subdomains and the domain itself.
+func matchesDomain(pattern string, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if base, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == base || strings.HasSuffix(host, "."+base)
+	}
+	return host == pattern
+}
+
 // matchesRule checks if a tool invocation matches a parsed permission rule.
 func matchesRule(rule parsedRule, toolName string, toolInput map[string]any, cwd string) bool {
+	// MCP rules name the tool directly and do not take arguments.
+	if strings.HasPrefix(rule.toolName, mcpToolPrefix) {
+		return rule.argument == "" && matchesMcpToolRule(rule.toolName, toolName)
+	}
+
 	// Determine if the rule applies to this tool.
 	// - "Bash" rules match the Bash tool
 	// - "Edit" rules match all file editing tools
@@ -180,6 +303,10 @@ func matchesRule(rule parsedRule, toolName string, toolInput map[string]any, cwd
 		ruleAppliesToTool = slices.Contains(fileEditingTools, toolName)
 	case "Read":
 		ruleAppliesToTool = slices.Contains(fileReadingTools, toolName)
+	case "WebFetch":
+		ruleAppliesToTool = slices.Contains(webFetchTools, toolName)
+	case "WebSearch":
+		ruleAppliesToTool = slices.Contains(webSearchTools, toolName)
 	}
 
 	if !ruleAppliesToTool {
@@ -217,6 +344,21 @@ func matchesRule(rule parsedRule, toolName string, toolInput map[string]any, cwd
 		return actualArg == rule.argument
 	}
 
+	// WebFetch: "domain:<host>" matches the URL host or, for "*.<host>",
+	// any of its subdomains.
+	if slices.Contains(webFetchTools, toolName) {
+		domain, ok := strings.CutPrefix(rule.argument, "domain:")
+		if !ok {
+			return false
+		}
+		return matchesDomain(domain, actualArg)
+	}
+
+	// WebSearch: the argument is compared against the query.
+	if slices.Contains(webSearchTools, toolName) {
+		return strings.EqualFold(strings.TrimSpace(actualArg), strings.TrimSpace(rule.argument))
+	}
+
 	// File-based tools: use glob matching.
 	return matchesGlob(rule.argument, actualArg, cwd)
 }
@@ -227,15 +369,25 @@ func loadSettingsFile(filePath string) ClaudeCodeSettings {
 	if filePath == "" {
 		return ClaudeCodeSettings{}
 	}
-	data, err := os.ReadFile(filePath)
+	settings, err := readSettingsFile(filePath)
 	if err != nil {
 		return ClaudeCodeSettings{}
 	}
+	return settings
+}
+
+// readSettingsFile reads and parses a JSON settings file, reporting
+// missing files and parse errors.
+func readSettingsFile(filePath string) (ClaudeCodeSettings, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return ClaudeCodeSettings{}, err
+	}
 	var settings ClaudeCodeSettings
 	if err := json.Unmarshal(data, &settings); err != nil {
-		return ClaudeCodeSettings{}
+		return ClaudeCodeSettings{}, fmt.Errorf("parse %s: %w", filePath, err)
 	}
-	return settings
+	return settings, nil
 }
 
 // SettingsManager manages Claude Code settings from multiple sources
@@ -245,7 +397,8 @@ func loadSettingsFile(filePath string) ClaudeCodeSettings {
 //  1. User settings (~/.claude/settings.json)
 //  2. Project settings (<cwd>/.claude/settings.json)
 //  3. Local project settings (<cwd>/.claude/settings.local.json)
-//  4. Enterprise managed settings (platform-specific path)
+//  4. Extra settings added with AddSettingsFile or AddSettings
+//  5. Enterprise managed settings (platform-specific path)
 //
 // The manager combines permission rules from all sources.
 // Deny rules always take precedence during permission checks.
@@ -255,11 +408,14 @@ type SettingsManager struct {
 	projectSettings    ClaudeCodeSettings
 	localSettings      ClaudeCodeSettings
 	enterpriseSettings ClaudeCodeSettings
+	extraSettings      []ClaudeCodeSettings // in increasing precedence
+	extraSources       []string             // path per extraSettings entry, "" if inline
 	mergedSettings     ClaudeCodeSettings
 	mu                 sync.RWMutex
 	onChange           func()
 	logger             *slog.Logger
 	initialized        bool
+	helperWarned       map[string]bool // sources whose apiKeyHelper was reported dropped
 }
 
 // NewSettingsManager creates a new SettingsManager for the given working directory.
@@ -285,6 +441,62 @@ func (s *SettingsManager) Initialize() error {
 	return nil
 }
 
+// AddSettingsFile adds a settings file merged above local settings and
+// below enterprise settings. Files and inline settings added later take
+// precedence over earlier ones. The file must exist and parse.
+func (s *SettingsManager) AddSettingsFile(path string) error {
+	settings, err := readSettingsFile(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extraSettings = append(s.extraSettings, settings)
+	s.extraSources = append(s.extraSources, path)
+	if s.initialized {
+		s.mergeSettings()
+	}
+	return nil
+}
+
+// AddSettings adds inline settings at the same precedence as AddSettingsFile.
+func (s *SettingsManager) AddSettings(settings ClaudeCodeSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extraSettings = append(s.extraSettings, settings)
+	s.extraSources = append(s.extraSources, "")
+	if s.initialized {
+		s.mergeSettings()
+	}
+}
+
+// ExtraSettings returns the merged extra settings, for passing on to the
+// CLI, and whether any were added. Their hooks are combined in precedence
+// order; an apiKeyHelper is dropped, as in GetSettings, so the CLI does not
+// run one from untrusted settings either.
+func (s *SettingsManager) ExtraSettings() (ClaudeCodeSettings, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.extraSettings) == 0 {
+		return ClaudeCodeSettings{}, false
+	}
+	layers := make([]ClaudeCodeSettings, 0, len(s.extraSettings))
+	for i := len(s.extraSettings) - 1; i >= 0; i-- {
+		layers = append(layers, s.extraSettings[i])
+	}
+	merged := mergeSettingsLayers(layers, nil)
+	for _, settings := range layers {
+		for event, matchers := range settings.Hooks {
+			if merged.Hooks == nil {
+				merged.Hooks = make(map[string][]HookMatcher)
+			}
+			merged.Hooks[event] = append(merged.Hooks[event], matchers...)
+		}
+	}
+	merged.APIKeyHelper = ""
+	return merged, true
+}
+
 // getUserSettingsPath returns the path to the user settings file.
 func (s *SettingsManager) getUserSettingsPath() string {
 	return filepath.Join(getClaudeConfigDir(), "settings.json")
@@ -306,20 +518,71 @@ func (s *SettingsManager) loadAllSettings() {
 	s.projectSettings = loadSettingsFile(s.getProjectSettingsPath())
 	s.localSettings = loadSettingsFile(s.getLocalSettingsPath())
 	s.enterpriseSettings = loadSettingsFile(getManagedSettingsPath())
+	for i, path := range s.extraSources {
+		if path != "" {
+			s.extraSettings[i] = loadSettingsFile(path)
+		}
+	}
 	s.mergeSettings()
 }
 
-// mergeSettings combines all settings sources with proper precedence.
-// For permissions, rules from all sources are combined.
-// Deny rules always take precedence during permission checks.
+// mergeSettings combines all settings sources with proper precedence:
+// enterprise > extra settings (--settings, session meta) > local > project
+// > user.
+//
+// Scalar fields (model, defaultMode, ...) and env entries come from the
+// highest precedence source that sets them. Hooks are left out: the CLI
+// runs them, loading the settings files itself and the extra settings
+// from ExtraSettings.
+// apiKeyHelper, a shell command the agent runs, is only taken from
+// enterprise and user settings: a cloned repository must not run code just
+// by being opened.
+// Permission rules from all sources are combined, ordered by source
+// precedence. Deny rules always take precedence during permission checks,
+// so an enterprise deny cannot be overridden; allow and ask rules that
+// repeat an enterprise deny are dropped.
 func (s *SettingsManager) mergeSettings() {
-	allSettings := []ClaudeCodeSettings{
-		s.userSettings,
-		s.projectSettings,
-		s.localSettings,
-		s.enterpriseSettings,
+	layers := []ClaudeCodeSettings{s.enterpriseSettings}
+	for i := len(s.extraSettings) - 1; i >= 0; i-- {
+		layers = append(layers, s.extraSettings[i])
 	}
+	layers = append(layers, s.localSettings, s.projectSettings, s.userSettings)
+
+	var enterpriseDeny []string
+	if p := s.enterpriseSettings.Permissions; p != nil {
+		enterpriseDeny = p.Deny
+	}
+	s.mergedSettings = mergeSettingsLayers(layers, enterpriseDeny)
+
+	s.mergedSettings.APIKeyHelper = cmp.Or(s.enterpriseSettings.APIKeyHelper, s.userSettings.APIKeyHelper)
+	s.warnUntrustedHelper(s.getLocalSettingsPath(), s.localSettings)
+	s.warnUntrustedHelper(s.getProjectSettingsPath(), s.projectSettings)
+	for i, settings := range s.extraSettings {
+		source := s.extraSources[i]
+		if source == "" {
+			source = fmt.Sprintf("inline settings %d", i+1)
+		}
+		s.warnUntrustedHelper(source, settings)
+	}
+}
+
+// warnUntrustedHelper logs, once per source, that an apiKeyHelper outside
+// user and managed settings was dropped. The command itself is not logged,
+// since it may hold credentials.
+func (s *SettingsManager) warnUntrustedHelper(source string, settings ClaudeCodeSettings) {
+	if settings.APIKeyHelper == "" || s.helperWarned[source] || s.logger == nil {
+		return
+	}
+	if s.helperWarned == nil {
+		s.helperWarned = map[string]bool{}
+	}
+	s.helperWarned[source] = true
+	s.logger.Warn("Dropping apiKeyHelper outside user and managed settings", "source", source)
+}
 
+// mergeSettingsLayers merges settings given highest precedence first.
+// Allow and ask rules listed in shadowingDeny are dropped.
+func mergeSettingsLayers(layers []ClaudeCodeSettings, shadowingDeny []string) ClaudeCodeSettings {
 	merged := ClaudeCodeSettings{
 		Permissions: &PermissionSettings{
 			Allow: []string{},
@@ -328,46 +591,103 @@ func (s *SettingsManager) mergeSettings() {
 		},
 	}
 
-	for _, settings := range allSettings {
-		if settings.Permissions != nil {
-			merged.Permissions.Allow = append(merged.Permissions.Allow, settings.Permissions.Allow...)
-			merged.Permissions.Deny = append(merged.Permissions.Deny, settings.Permissions.Deny...)
-			merged.Permissions.Ask = append(merged.Permissions.Ask, settings.Permissions.Ask...)
-			if len(settings.Permissions.AdditionalDirectories) > 0 {
-				merged.Permissions.AdditionalDirectories = append(
-					merged.Permissions.AdditionalDirectories,
-					settings.Permissions.AdditionalDirectories...,
-				)
+	addRules := func(dst []string, rules []string, skipDenied bool) []string {
+		for _, rule := range rules {
+			if slices.Contains(dst, rule) || (skipDenied && slices.Contains(shadowingDeny, rule)) {
+				continue
 			}
-			if settings.Permissions.DefaultMode != "" {
+			dst = append(dst, rule)
+		}
+		return dst
+	}
+
+	for _, settings := range layers {
+		if settings.Permissions != nil {
+			merged.Permissions.Deny = addRules(merged.Permissions.Deny, settings.Permissions.Deny, false)
+			merged.Permissions.Allow = addRules(merged.Permissions.Allow, settings.Permissions.Allow, true)
+			merged.Permissions.Ask = addRules(merged.Permissions.Ask, settings.Permissions.Ask, true)
+			merged.Permissions.AdditionalDirectories = addRules(
+				merged.Permissions.AdditionalDirectories,
+				settings.Permissions.AdditionalDirectories,
+				false,
+			)
+			if merged.Permissions.DefaultMode == "" {
 				merged.Permissions.DefaultMode = settings.Permissions.DefaultMode
 			}
+			if merged.Permissions.DisableBypassPermissionsMode == "" {
+				merged.Permissions.DisableBypassPermissionsMode = settings.Permissions.DisableBypassPermissionsMode
+			}
 		}
 
-		if settings.Env != nil {
+		merged.EnabledMcpjsonServers = addRules(merged.EnabledMcpjsonServers, settings.EnabledMcpjsonServers, false)
+		merged.DisabledMcpjsonServers = addRules(merged.DisabledMcpjsonServers, settings.DisabledMcpjsonServers, false)
+		mergeScalarSettings(&merged, settings)
+
+		for k, v := range settings.Env {
 			if merged.Env == nil {
 				merged.Env = make(map[string]string)
 			}
-			for k, v := range settings.Env {
+			if _, ok := merged.Env[k]; !ok {
 				merged.Env[k] = v
 			}
 		}
+	}
+
+	return merged
+}
 
-		if settings.Model != "" {
-			merged.Model = settings.Model
+// mergeScalarSettings fills the single-valued fields of merged that are
+// still unset from src. Callers pass sources in precedence order.
+func mergeScalarSettings(merged *ClaudeCodeSettings, src ClaudeCodeSettings) {
+	firstString := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
 		}
 	}
-
-	s.mergedSettings = merged
+	firstString(&merged.Model, src.Model)
+	firstString(&merged.APIKeyHelper, src.APIKeyHelper)
+	firstString(&merged.OutputStyle, src.OutputStyle)
+	firstString(&merged.ForceLoginMethod, src.ForceLoginMethod)
+	firstString(&merged.ForceLoginOrgUUID, src.ForceLoginOrgUUID)
+	firstString(&merged.AWSAuthRefresh, src.AWSAuthRefresh)
+	firstString(&merged.AWSCredentialExport, src.AWSCredentialExport)
+	firstString(&merged.HTTPProxy, src.HTTPProxy)
+	firstString(&merged.HTTPSProxy, src.HTTPSProxy)
+	firstString(&merged.NoProxy, src.NoProxy)
+
+	if merged.DisableAllHooks == nil {
+		merged.DisableAllHooks = src.DisableAllHooks
+	}
+	if merged.CleanupPeriodDays == nil {
+		merged.CleanupPeriodDays = src.CleanupPeriodDays
+	}
+	if merged.IncludeCoAuthoredBy == nil {
+		merged.IncludeCoAuthoredBy = src.IncludeCoAuthoredBy
+	}
+	if merged.StatusLine == nil {
+		merged.StatusLine = src.StatusLine
+	}
+	if merged.EnableAllProjectMcpServers == nil {
+		merged.EnableAllProjectMcpServers = src.EnableAllProjectMcpServers
+	}
+	if merged.ReadOnly == nil {
+		merged.ReadOnly = src.ReadOnly
+	}
+	if merged.AutoCommit == nil {
+		merged.AutoCommit = src.AutoCommit
+	}
 }
 
 // CheckPermission checks if a tool invocation is allowed based on the
 // loaded settings.
 //
-// Only tools with the ACP prefix (mcp__acp__) are checked.
+// Only MCP tools (mcp__<server>__<tool>) and the WebFetch/WebSearch
+// built-ins are checked; rules such as "Bash(...)" apply to the ACP tools,
+// MCP rules apply to any server.
 // Priority: deny > allow > ask > default (ask).
 func (s *SettingsManager) CheckPermission(toolName string, toolInput map[string]any) PermissionCheckResult {
-	if !strings.HasPrefix(toolName, ACPToolNamePrefix) {
+	if !strings.HasPrefix(toolName, mcpToolPrefix) &&
+		!slices.Contains(webFetchTools, toolName) && !slices.Contains(webSearchTools, toolName) {
 		return PermissionCheckResult{Decision: PermissionAsk}
 	}
 
@@ -420,6 +740,99 @@ func (s *SettingsManager) CheckPermission(toolName string, toolInput map[string]
 	return PermissionCheckResult{Decision: PermissionAsk}
 }
 
+// PermissionRules returns the merged rules from all settings sources.
+func (s *SettingsManager) PermissionRules() PermissionRules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := PermissionRules{Allow: []string{}, Deny: []string{}, Ask: []string{}}
+	if p := s.mergedSettings.Permissions; p != nil {
+		rules.Allow = append(rules.Allow, p.Allow...)
+		rules.Deny = append(rules.Deny, p.Deny...)
+		rules.Ask = append(rules.Ask, p.Ask...)
+	}
+	return rules
+}
+
+// UpdatePermissionRules adds and removes rules in the local settings layer
+// (settings.local.json). Rules from other sources are not affected. When
+// persist is set, the local settings file is rewritten, keeping any keys
+// this manager does not model.
+func (s *SettingsManager) UpdatePermissionRules(add, remove PermissionRules, persist bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.localSettings.Permissions == nil {
+		s.localSettings.Permissions = &PermissionSettings{}
+	}
+	p := s.localSettings.Permissions
+	p.Allow = updateRuleList(p.Allow, add.Allow, remove.Allow)
+	p.Deny = updateRuleList(p.Deny, add.Deny, remove.Deny)
+	p.Ask = updateRuleList(p.Ask, add.Ask, remove.Ask)
+	s.mergeSettings()
+
+	if !persist {
+		return nil
+	}
+	return writePermissionRules(s.getLocalSettingsPath(), PermissionRules{Allow: p.Allow, Deny: p.Deny, Ask: p.Ask})
+}
+
+// updateRuleList removes the rules in remove from list and appends the
+// rules in add that are not already present.
+func updateRuleList(list, add, remove []string) []string {
+	out := make([]string, 0, len(list)+len(add))
+	for _, rule := range list {
+		if !slices.Contains(remove, rule) {
+			out = append(out, rule)
+		}
+	}
+	for _, rule := range add {
+		if !slices.Contains(out, rule) {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// writePermissionRules stores rules in the settings file at filePath,
+// preserving all other content of the file.
+func writePermissionRules(filePath string, rules PermissionRules) error {
+	raw := map[string]any{}
+	data, err := os.ReadFile(filePath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("refusing to overwrite %s: %w", filePath, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	permissions, _ := raw["permissions"].(map[string]any)
+	if permissions == nil {
+		permissions = map[string]any{}
+	}
+	setOrDelete := func(key string, list []string) {
+		if len(list) == 0 {
+			delete(permissions, key)
+		} else {
+			permissions[key] = list
+		}
+	}
+	setOrDelete("allow", rules.Allow)
+	setOrDelete("deny", rules.Deny)
+	setOrDelete("ask", rules.Ask)
+	raw["permissions"] = permissions
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append(out, '\n'), 0o644)
+}
+
 // GetSettings returns the current merged settings.
 func (s *SettingsManager) GetSettings() ClaudeCodeSettings {
 	s.mu.RLock()
@@ -440,3 +853,92 @@ func (s *SettingsManager) Dispose() {
 	defer s.mu.Unlock()
 	s.initialized = false
 }
+
+// envVarRefRegexp matches ${VAR} and ${VAR:-default} references.
+var envVarRefRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandSettingsEnv returns a copy of env with ${VAR} references in values
+// expanded. References resolve against the other (unexpanded) settings
+// entries first, then the agent's environment; ${VAR:-default} supplies a
+// fallback for unset or empty variables. Bare $VAR is left untouched so
+// values containing a literal "$" survive.
+func expandSettingsEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return env
+	}
+	lookup := func(name string) string {
+		if v, ok := env[name]; ok && !strings.Contains(v, "${") {
+			return v
+		}
+		return os.Getenv(name)
+	}
+	out := make(map[string]string, len(env))
+	for k, v := range env {
+		out[k] = envVarRefRegexp.ReplaceAllStringFunc(v, func(ref string) string {
+			m := envVarRefRegexp.FindStringSubmatch(ref)
+			if val := lookup(m[1]); val != "" {
+				return val
+			}
+			return m[2]
+		})
+	}
+	return out
+}
+
+// apiKeyHelperTimeout bounds how long an apiKeyHelper script may run.
+const apiKeyHelperTimeout = 30 * time.Second
+
+// runAPIKeyHelper executes the apiKeyHelper shell command in cwd with env
+// added to the agent's environment and returns the key it prints.
+func runAPIKeyHelper(ctx context.Context, helper string, cwd string, env map[string]string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiKeyHelperTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", helper)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", helper)
+	}
+	cmd.Dir = cwd
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("apiKeyHelper failed: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("apiKeyHelper failed: %w", err)
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", fmt.Errorf("apiKeyHelper returned an empty key")
+	}
+	return key, nil
+}
+
+// resolveSessionEnv builds the extra environment for a CLI subprocess from
+// the merged settings: env values are expanded and, when apiKeyHelper is
+// set, its output is injected as ANTHROPIC_API_KEY.
+func resolveSessionEnv(ctx context.Context, settings ClaudeCodeSettings, cwd string) (map[string]string, error) {
+	env := expandSettingsEnv(settings.Env)
+	if settings.APIKeyHelper == "" {
+		return env, nil
+	}
+	key, err := runAPIKeyHelper(ctx, settings.APIKeyHelper, cwd, env)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		env = make(map[string]string)
+	} else {
+		env = maps.Clone(env)
+	}
+	env["ANTHROPIC_API_KEY"] = key
+	return env, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// sessionEnvDir returns the directory where the CLI's SessionStart hooks
+// leave environment files for the session (CLAUDE_ENV_FILE).
+func sessionEnvDir(sessionID string) string {
+	return filepath.Join(getClaudeConfigDir(), "session-env", sessionID)
+}
+
+// readSessionEnv reads the variables set by the files in dir, in file
+// name order, so later files override earlier ones. Each line is
+// KEY=VALUE, optionally preceded by "export" and with the value quoted;
+// blank lines and comments are skipped. A missing directory sets nothing.
+func readSessionEnv(dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	env := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+			key, value, ok := strings.Cut(line, "=")
+			if !ok || key == "" || strings.ContainsAny(key, " \t") {
+				continue
+			}
+			env[key] = unquoteEnvValue(value)
+		}
+	}
+	return env
+}
+
+// unquoteEnvValue strips one pair of matching single or double quotes.
+func unquoteEnvValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// terminalEnv returns the environment of a Bash terminal: the settings
+// env, overridden by the session-env files in dir, and CLAUDECODE=1,
+// sorted by name.
+func terminalEnv(settings map[string]string, dir string) []acp.EnvVariable {
+	env := maps.Clone(settings)
+	if env == nil {
+		env = map[string]string{}
+	}
+	maps.Copy(env, readSessionEnv(dir))
+	env["CLAUDECODE"] = "1"
+	vars := make([]acp.EnvVariable, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		vars = append(vars, acp.EnvVariable{Name: name, Value: env[name]})
+	}
+	return vars
+}
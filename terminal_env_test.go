@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestReadSessionEnv(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "hook-0.sh"), []byte("# from a hook\nexport NODE_ENV=development\nPATH_EXTRA='/opt/bin'\n\nnot a variable\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "hook-1.sh"), []byte(`export NODE_ENV="test"`+"\n"), 0o644)
+
+	got := readSessionEnv(dir)
+	want := map[string]string{"NODE_ENV": "test", "PATH_EXTRA": "/opt/bin"}
+	if len(got) != len(want) || got["NODE_ENV"] != want["NODE_ENV"] || got["PATH_EXTRA"] != want["PATH_EXTRA"] {
+		t.Errorf("env = %v, want %v", got, want)
+	}
+	if got := readSessionEnv(filepath.Join(dir, "missing")); got != nil {
+		t.Errorf("expected nothing from a missing directory, got %v", got)
+	}
+}
+
+func TestTerminalManager_Env(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	dir := sessionEnvDir("s1")
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "hook-0.sh"), []byte("export REGION=eu\nCLAUDECODE=0\n"), 0o644)
+
+	mgr := NewSettingsManager(t.TempDir(), nil)
+	mgr.AddSettings(ClaudeCodeSettings{Env: map[string]string{"REGION": "us", "TEAM": "core"}})
+	if err := mgr.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	client := newFakeTerminals()
+	m := newTerminalManager(client, "s1", BuiltinToolOptions{settings: mgr})
+	if _, err := m.create(context.Background(), "make", "/work/sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := client.created[0]
+	if req.Cwd == nil || *req.Cwd != "/work/sub" {
+		t.Errorf("cwd = %v, want /work/sub", req.Cwd)
+	}
+	want := []acp.EnvVariable{{Name: "CLAUDECODE", Value: "1"}, {Name: "REGION", Value: "eu"}, {Name: "TEAM", Value: "core"}}
+	if !slices.Equal(req.Env, want) {
+		t.Errorf("env = %+v, want %+v", req.Env, want)
+	}
+
+	// Settings changes apply to the next terminal.
+	mgr.AddSettings(ClaudeCodeSettings{Env: map[string]string{"TEAM": "infra"}})
+	if _, err := m.create(context.Background(), "make", ""); err != nil {
+		t.Fatal(err)
+	}
+	want[2].Value = "infra"
+	if env := client.created[1].Env; !slices.Equal(env, want) {
+		t.Errorf("env = %+v, want %+v", env, want)
+	}
+}
+
+func TestHandleBash_RelativeCwd(t *testing.T) {
+	opts := BuiltinToolOptions{cwd: "/work"}
+	client := newFakeTerminals()
+	opts.terminals = newTerminalManager(client, "s1", opts)
+	text, isError, _ := handleBash(context.Background(), nil, "s1", map[string]any{"command": "ls", "cwd": "pkg/sub", "run_in_background": true}, opts)
+	if isError {
+		t.Fatalf("unexpected error %q", text)
+	}
+	if req := client.created[0]; req.Cwd == nil || *req.Cwd != filepath.Join("/work", "pkg", "sub") {
+		t.Errorf("cwd = %v, want /work/pkg/sub", req.Cwd)
+	}
+
+	for _, cwd := range []string{"../other", "sub/../../other"} {
+		text, isError, _ := handleBash(context.Background(), nil, "s1", map[string]any{"command": "ls", "cwd": cwd}, opts)
+		if !isError || !strings.Contains(text, "outside the session's working directory") {
+			t.Errorf("cwd %q: got %q %v, want it rejected", cwd, text, isError)
+		}
+	}
+}
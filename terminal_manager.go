@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// terminalCloseTimeout bounds killing and releasing a session's terminals
+// when it ends.
+const terminalCloseTimeout = 5 * time.Second
+
+// terminalClient is the part of the client connection that runs
+// terminals.
+type terminalClient interface {
+	CreateTerminal(context.Context, acp.CreateTerminalRequest) (acp.CreateTerminalResponse, error)
+	WaitForTerminalExit(context.Context, acp.WaitForTerminalExitRequest) (acp.WaitForTerminalExitResponse, error)
+	TerminalOutput(context.Context, acp.TerminalOutputRequest) (acp.TerminalOutputResponse, error)
+	KillTerminalCommand(context.Context, acp.KillTerminalCommandRequest) (acp.KillTerminalCommandResponse, error)
+	ReleaseTerminal(context.Context, acp.ReleaseTerminalRequest) (acp.ReleaseTerminalResponse, error)
+}
+
+// BackgroundTerminal is a terminal the session created.
+type BackgroundTerminal struct {
+	ID     string
+	Status string          // "started"|"exited"|"killed"|"timedOut"|"interrupted"
+	Final  *TerminalOutput // the output once the terminal is released, until read
+
+	started   time.Time     // when the command was started
+	releasing chan struct{} // closed once released; nil while the command may run
+}
+
+// TerminalOutput holds terminal command output
+type TerminalOutput struct {
+	Output    string
+	ExitCode  *int
+	Signal    string
+	Truncated bool
+}
+
+// errTerminalClosed is returned for terminals requested after the session
+// ended.
+var errTerminalClosed = errors.New("the session has ended")
+
+// terminalLimitError is returned by create when the session already has
+// the maximum number of terminals open; its text is the tool error.
+type terminalLimitError string
+
+func (e terminalLimitError) Error() string { return string(e) }
+
+// TerminalManager owns the client terminals a session's Bash commands run
+// in. It creates them within the session's terminal limit and output byte
+// limit, with the settings and session-env environment, waits for them to
+// exit, kills and releases them, and keeps the final output of released
+// terminals until a wait or BashOutput call reads it. Closing it kills and
+// releases every terminal still open.
+type TerminalManager struct {
+	client      terminalClient
+	sessionID   acp.SessionId
+	outputLimit int
+	limiter     *toolLimiter
+	settings    *SettingsManager // supplies the env; nil for none
+	envDir      string           // the session-env directory; "" for none
+
+	mu        sync.Mutex
+	terminals map[string]*BackgroundTerminal
+	turn      []string  // created during the running turn
+	turnStart time.Time // when the running turn began
+	pending   int       // numbers the placeholders of terminals being created
+	closed    bool
+}
+
+func newTerminalManager(client terminalClient, sessionID string, opts BuiltinToolOptions) *TerminalManager {
+	m := &TerminalManager{
+		client:      client,
+		sessionID:   acp.SessionId(sessionID),
+		outputLimit: opts.outputLimit(),
+		limiter:     opts.limiter,
+		settings:    opts.settings,
+		terminals:   map[string]*BackgroundTerminal{},
+	}
+	if sessionID != "" {
+		m.envDir = sessionEnvDir(sessionID)
+	}
+	return m
+}
+
+// create starts command in a new terminal and returns its ID. The command
+// runs in cwd, or the client's default directory if cwd is empty. The
+// settings env and session-env files are read each time, since settings
+// may be reloaded and hooks may change the files.
+func (m *TerminalManager) create(ctx context.Context, command, cwd string) (string, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return "", errTerminalClosed
+	}
+	if msg, ok := m.limiter.checkTerminal(); !ok {
+		m.mu.Unlock()
+		return "", terminalLimitError(msg)
+	}
+	// Count the terminal before it exists, so parallel calls cannot
+	// exceed the limit.
+	m.pending++
+	pending := fmt.Sprintf("creating-%d", m.pending)
+	m.limiter.openTerminal(pending)
+	m.mu.Unlock()
+
+	var env map[string]string
+	if m.settings != nil {
+		env = expandSettingsEnv(m.settings.GetSettings().Env)
+	}
+	outputLimit := m.outputLimit
+	resp, err := m.client.CreateTerminal(ctx, acp.CreateTerminalRequest{
+		Command:         command,
+		Cwd:             optionalString(cwd),
+		Env:             terminalEnv(env, m.envDir),
+		SessionId:       m.sessionID,
+		OutputByteLimit: &outputLimit,
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiter.closeTerminal(pending)
+	if err != nil {
+		return "", err
+	}
+	id := resp.TerminalId
+	m.limiter.openTerminal(id)
+	m.terminals[id] = &BackgroundTerminal{ID: id, Status: "started", started: time.Now()}
+	m.turn = append(m.turn, id)
+	if m.closed {
+		// The session ended while the terminal was being created.
+		go m.release(context.Background(), id, "killed", true, nil)
+	}
+	return id, nil
+}
+
+// wait waits up to timeout for the terminal's command to exit, killing it
+// if it does not or if ctx ends first, then releases the terminal and
+// returns its final output and status, which are then forgotten.
+func (m *TerminalManager) wait(ctx context.Context, id string, timeout time.Duration) (TerminalOutput, string) {
+	defer m.forget(id)
+	if final, status, ok := m.finished(id); ok {
+		return final, status
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	exitResp, err := m.client.WaitForTerminalExit(waitCtx, acp.WaitForTerminalExitRequest{
+		SessionId:  m.sessionID,
+		TerminalId: id,
+	})
+	switch {
+	case err == nil:
+		return m.release(ctx, id, "exited", false, &exitResp)
+	case ctx.Err() != nil:
+		// The tool call was abandoned with its turn; the terminal is still
+		// released.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), terminalCloseTimeout)
+		defer cancel()
+		return m.release(releaseCtx, id, "interrupted", true, nil)
+	case waitCtx.Err() != nil:
+		return m.release(ctx, id, "timedOut", true, nil)
+	default:
+		return m.release(ctx, id, "exited", false, nil)
+	}
+}
+
+// output returns the terminal's output so far without waiting, or its
+// final output once released, which is then forgotten.
+func (m *TerminalManager) output(ctx context.Context, id string) (TerminalOutput, string, error) {
+	if final, status, ok := m.finished(id); ok {
+		m.forget(id)
+		return final, status, nil
+	}
+	out, err := m.read(ctx, id)
+	return out, "started", err
+}
+
+// read asks the client for the terminal's output and exit status.
+func (m *TerminalManager) read(ctx context.Context, id string) (TerminalOutput, error) {
+	resp, err := m.client.TerminalOutput(ctx, acp.TerminalOutputRequest{
+		SessionId:  m.sessionID,
+		TerminalId: id,
+	})
+	if err != nil {
+		return TerminalOutput{}, err
+	}
+	out := TerminalOutput{Output: resp.Output, Truncated: resp.Truncated}
+	if resp.ExitStatus != nil {
+		out.ExitCode = resp.ExitStatus.ExitCode
+		if resp.ExitStatus.Signal != nil {
+			out.Signal = *resp.ExitStatus.Signal
+		}
+	}
+	return out, nil
+}
+
+// kill stops the terminal's command and releases the terminal.
+func (m *TerminalManager) kill(ctx context.Context, id string) error {
+	if _, _, ok := m.finished(id); ok {
+		return nil
+	}
+	if _, err := m.client.KillTerminalCommand(ctx, acp.KillTerminalCommandRequest{
+		SessionId:  m.sessionID,
+		TerminalId: id,
+	}); err != nil {
+		return err
+	}
+	m.release(ctx, id, "killed", false, nil)
+	return nil
+}
+
+// beginTurn starts tracking the terminals of a new turn.
+func (m *TerminalManager) beginTurn() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turn = nil
+	m.turnStart = time.Now()
+}
+
+// cancelTurn kills and releases the terminals the cancelled turn opened,
+// which its Bash calls report as interrupted.
+func (m *TerminalManager) cancelTurn(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	var open []string
+	for _, id := range m.turn {
+		if t := m.terminals[id]; t != nil && t.releasing == nil {
+			open = append(open, id)
+		}
+	}
+	m.turn = nil
+	m.mu.Unlock()
+	for _, id := range open {
+		m.release(ctx, id, "interrupted", true, nil)
+	}
+}
+
+// Close kills and releases the terminals still open, in ID order, and
+// refuses new ones.
+func (m *TerminalManager) Close(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.closed = true
+	var open []string
+	for id, t := range m.terminals {
+		if t.releasing == nil {
+			open = append(open, id)
+		}
+	}
+	m.mu.Unlock()
+	slices.Sort(open)
+	for _, id := range open {
+		m.release(ctx, id, "killed", true, nil)
+	}
+}
+
+// later returns the later of two times.
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// finished returns the final output of a released terminal.
+func (m *TerminalManager) finished(id string) (TerminalOutput, string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.terminals[id]
+	if t == nil || t.Final == nil {
+		return TerminalOutput{}, "", false
+	}
+	return *t.Final, t.Status, true
+}
+
+// forget drops a released terminal once its final output has been read.
+func (m *TerminalManager) forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t := m.terminals[id]; t != nil && t.Final != nil {
+		delete(m.terminals, id)
+	}
+}
+
+// release reads the terminal's last output and releases it, killing its
+// command first if kill is set, and records it as finished with status.
+// exit, if the command was waited for, is its exit status. The command's
+// running time in this turn, foreground or background, is charged to the
+// turn's Bash budget. If the terminal is already being released, release
+// waits for that and returns its outcome.
+func (m *TerminalManager) release(ctx context.Context, id, status string, kill bool, exit *acp.WaitForTerminalExitResponse) (TerminalOutput, string) {
+	m.mu.Lock()
+	t := m.terminals[id]
+	if t == nil {
+		t = &BackgroundTerminal{ID: id}
+		m.terminals[id] = t
+	}
+	if t.releasing != nil {
+		releasing := t.releasing
+		m.mu.Unlock()
+		<-releasing
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return *t.Final, t.Status
+	}
+	t.releasing = make(chan struct{})
+	m.mu.Unlock()
+
+	if kill {
+		_, _ = m.client.KillTerminalCommand(ctx, acp.KillTerminalCommandRequest{
+			SessionId:  m.sessionID,
+			TerminalId: id,
+		})
+	}
+	out, _ := m.read(ctx, id)
+	if exit != nil {
+		out.ExitCode, out.Signal = exit.ExitCode, ""
+		if exit.Signal != nil {
+			out.Signal = *exit.Signal
+		}
+	}
+	_, _ = m.client.ReleaseTerminal(ctx, acp.ReleaseTerminalRequest{
+		SessionId:  m.sessionID,
+		TerminalId: id,
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiter.closeTerminal(id)
+	if !t.started.IsZero() {
+		m.limiter.addBashTime(time.Since(later(t.started, m.turnStart)))
+	}
+	t.Status, t.Final = status, &out
+	close(t.releasing)
+	return out, status
+}
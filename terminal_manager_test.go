@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// fakeTerminals is a client whose commands exit once told to or killed,
+// recording the calls made to it.
+type fakeTerminals struct {
+	mu     sync.Mutex
+	next   int
+	calls  []string
+	exited map[string]chan struct{}
+
+	created []acp.CreateTerminalRequest
+}
+
+func newFakeTerminals() *fakeTerminals {
+	return &fakeTerminals{exited: map[string]chan struct{}{}}
+}
+
+func (f *fakeTerminals) record(call, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call+" "+id)
+}
+
+func (f *fakeTerminals) exit(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.exited[id])
+}
+
+func (f *fakeTerminals) CreateTerminal(_ context.Context, req acp.CreateTerminalRequest) (acp.CreateTerminalResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	id := fmt.Sprintf("term-%d", f.next)
+	f.exited[id] = make(chan struct{})
+	f.calls = append(f.calls, "create "+id)
+	f.created = append(f.created, req)
+	return acp.CreateTerminalResponse{TerminalId: id}, nil
+}
+
+func (f *fakeTerminals) WaitForTerminalExit(ctx context.Context, req acp.WaitForTerminalExitRequest) (acp.WaitForTerminalExitResponse, error) {
+	f.mu.Lock()
+	exited := f.exited[req.TerminalId]
+	f.mu.Unlock()
+	select {
+	case <-exited:
+		return acp.WaitForTerminalExitResponse{ExitCode: acp.Ptr(0)}, nil
+	case <-ctx.Done():
+		return acp.WaitForTerminalExitResponse{}, ctx.Err()
+	}
+}
+
+func (f *fakeTerminals) TerminalOutput(_ context.Context, req acp.TerminalOutputRequest) (acp.TerminalOutputResponse, error) {
+	return acp.TerminalOutputResponse{Output: "output of " + req.TerminalId}, nil
+}
+
+func (f *fakeTerminals) KillTerminalCommand(_ context.Context, req acp.KillTerminalCommandRequest) (acp.KillTerminalCommandResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "kill "+req.TerminalId)
+	select {
+	case <-f.exited[req.TerminalId]:
+	default:
+		close(f.exited[req.TerminalId])
+	}
+	return acp.KillTerminalCommandResponse{}, nil
+}
+
+func (f *fakeTerminals) ReleaseTerminal(_ context.Context, req acp.ReleaseTerminalRequest) (acp.ReleaseTerminalResponse, error) {
+	f.record("release", req.TerminalId)
+	return acp.ReleaseTerminalResponse{}, nil
+}
+
+func TestTerminalManager(t *testing.T) {
+	client := newFakeTerminals()
+	opts := BuiltinToolOptions{limiter: newToolLimiter(ToolLimits{MaxTerminals: 2})}
+	m := newTerminalManager(client, "s1", opts)
+	ctx := context.Background()
+
+	// A command that exits is released with its final output, which is
+	// forgotten once returned.
+	id, err := m.create(ctx, "make", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.exit(id)
+	out, status := m.wait(ctx, id, time.Second)
+	if status != "exited" || out.Output != "output of term-1" || out.ExitCode == nil || *out.ExitCode != 0 {
+		t.Errorf("unexpected result %q %+v", status, out)
+	}
+	if _, _, ok := m.finished(id); ok {
+		t.Error("expected the final output dropped once read")
+	}
+
+	// Terminals count against the limit until released.
+	bg1, _ := m.create(ctx, "sleep 100", "")
+	bg2, _ := m.create(ctx, "sleep 100", "")
+	var limitErr terminalLimitError
+	if _, err := m.create(ctx, "sleep 100", ""); !errors.As(err, &limitErr) || !strings.Contains(err.Error(), "at most 2 terminals") {
+		t.Fatalf("expected the terminal limit, got %v", err)
+	}
+	if err := m.kill(ctx, bg1); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, _ := m.output(ctx, bg1); status != "killed" {
+		t.Errorf("expected the killed terminal's status kept, got %q", status)
+	}
+	if _, _, ok := m.finished(bg1); ok {
+		t.Error("expected the killed terminal dropped once read")
+	}
+
+	// A command that outlives its timeout is killed.
+	bg3, _ := m.create(ctx, "sleep 100", "")
+	if _, status := m.wait(ctx, bg3, 10*time.Millisecond); status != "timedOut" {
+		t.Errorf("status = %q, want timedOut", status)
+	}
+
+	// Ending the session kills and releases what is left, and refuses new
+	// terminals.
+	client.mu.Lock()
+	client.calls = nil
+	client.mu.Unlock()
+	m.Close(ctx)
+	if want := []string{"kill " + bg2, "release " + bg2}; !slices.Equal(client.calls, want) {
+		t.Errorf("calls = %v, want %v", client.calls, want)
+	}
+	if _, err := m.create(ctx, "make", ""); !errors.Is(err, errTerminalClosed) {
+		t.Errorf("expected no terminals after close, got %v", err)
+	}
+	if msg, ok := opts.limiter.checkTerminal(); !ok {
+		t.Errorf("expected every terminal released, got %q", msg)
+	}
+}
+
+func TestTerminalManager_CancelTurn(t *testing.T) {
+	client := newFakeTerminals()
+	opts := BuiltinToolOptions{limiter: newToolLimiter(ToolLimits{MaxTerminals: 2})}
+	m := newTerminalManager(client, "s1", opts)
+	ctx := context.Background()
+
+	// A terminal from an earlier turn is left alone.
+	earlier, _ := m.create(ctx, "sleep 100", "")
+	m.beginTurn()
+	id, err := m.create(ctx, "sleep 100", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		out    TerminalOutput
+		status string
+	}
+	done := make(chan result)
+	go func() {
+		out, status := m.wait(ctx, id, time.Minute)
+		done <- result{out, status}
+	}()
+
+	m.cancelTurn(ctx)
+	got := <-done
+	if got.status != "interrupted" || got.out.Output != "output of "+id {
+		t.Errorf("unexpected result %q %+v", got.status, got.out)
+	}
+	if text := formatToolCommandOutput(got.status, got.out.Output, got.out.ExitCode, got.out.Signal, false); !strings.HasPrefix(text, "Interrupted.") {
+		t.Errorf("expected the tool result to report the interruption, got %q", text)
+	}
+	client.mu.Lock()
+	calls := slices.Clone(client.calls)
+	client.mu.Unlock()
+	if want := []string{"create " + earlier, "create " + id, "kill " + id, "release " + id}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if _, status, _ := m.output(ctx, earlier); status != "started" {
+		t.Errorf("expected the earlier turn's terminal kept, got %q", status)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultToolUseCacheSize is the number of tool uses a session remembers
+// while waiting for their results.
+const DefaultToolUseCacheSize = 1000
+
+// ToolUseCache maps tool use IDs to the tool call that started them, so
+// results can be rendered with the tool's name and input. Entries are
+// removed once their result is delivered; when more than limit calls are
+// outstanding, the least recently used entry is evicted.
+//
+// The cache also holds the annotations of the session's MCP tools, which
+// shape how their calls are rendered.
+type ToolUseCache struct {
+	mu          sync.Mutex
+	limit       int
+	entries     map[string]*list.Element
+	order       *list.List // front is most recently used
+	annotations map[string]MCPToolAnnotations
+}
+
+// NewToolUseCache creates a cache holding at most limit entries. A limit
+// of zero or less uses DefaultToolUseCacheSize.
+func NewToolUseCache(limit int) *ToolUseCache {
+	if limit <= 0 {
+		limit = DefaultToolUseCacheSize
+	}
+	return &ToolUseCache{
+		limit:   limit,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Put stores entry under its ID, replacing any previous entry.
+func (c *ToolUseCache) Put(entry ToolUseEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.ID]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[entry.ID] = c.order.PushFront(entry)
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(ToolUseEntry).ID)
+	}
+}
+
+// Get returns the entry for id and marks it recently used.
+func (c *ToolUseCache) Get(id string) (ToolUseEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if !ok {
+		return ToolUseEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(ToolUseEntry), true
+}
+
+// Delete removes the entry for id.
+func (c *ToolUseCache) Delete(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.order.Remove(el)
+		delete(c.entries, id)
+	}
+}
+
+// SetToolAnnotations replaces the known MCP tool annotations, keyed by
+// tool name.
+func (c *ToolUseCache) SetToolAnnotations(annotations map[string]MCPToolAnnotations) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.annotations = annotations
+}
+
+// ToolAnnotations returns the annotations of the named MCP tool.
+func (c *ToolUseCache) ToolAnnotations(name string) (MCPToolAnnotations, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.annotations[name]
+	return a, ok
+}
+
+// Entries returns the cached entries, most recently used first.
+func (c *ToolUseCache) Entries() []ToolUseEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]ToolUseEntry, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		out = append(out, el.Value.(ToolUseEntry))
+	}
+	return out
+}
+
+// Len returns the number of cached entries.
+func (c *ToolUseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
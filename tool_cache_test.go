@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestToolUseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewToolUseCache(2)
+	c.Put(ToolUseEntry{ID: "a", Name: "Read"})
+	c.Put(ToolUseEntry{ID: "b", Name: "Edit"})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.Put(ToolUseEntry{ID: "c", Name: "Bash"})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := c.Get(id); !ok {
+			t.Errorf("expected %s to be cached", id)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestToolUseCache_PurgedOnResult(t *testing.T) {
+	c := NewToolUseCache(0)
+	toAcpNotifications([]any{
+		map[string]any{"type": "tool_use", "id": "tool-1", "name": "Read", "input": map[string]any{"file_path": "/a.go"}},
+	}, "assistant", "s1", c, nil)
+	if c.Len() != 1 {
+		t.Fatalf("expected tool use to be cached, Len() = %d", c.Len())
+	}
+
+	notifications := toAcpNotifications([]any{
+		map[string]any{"type": "tool_result", "tool_use_id": "tool-1", "content": "package main"},
+	}, "user", "s1", c, nil)
+	if len(notifications) != 1 || notifications[0].Update.ToolCallUpdate == nil {
+		t.Fatalf("expected a tool call update, got %+v", notifications)
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected entry to be purged after its result, Len() = %d", c.Len())
+	}
+}
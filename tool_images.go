@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // decoded for downscaling; animations keep their first frame
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// DefaultMaxImageBytes is the largest decoded image sent to the client,
+// such as a screenshot returned by a browser MCP tool.
+const DefaultMaxImageBytes = MaxImageFileSize
+
+// minImageSide is the smallest width or height an image is downscaled to
+// before it is dropped instead.
+const minImageSide = 64
+
+// maxDecodedImagePixels bounds the images decoded for downscaling, about
+// 100 MB once decoded, since a small compressed file can claim a huge size.
+const maxDecodedImagePixels = 25_000_000
+
+// limitImages fits the images of a session update within maxBytes: their
+// MIME type is taken from the data, images over the limit are downscaled,
+// and those that cannot be are replaced by a note. A maxBytes of zero or
+// less uses DefaultMaxImageBytes.
+func limitImages(n acp.SessionNotification, maxBytes int) acp.SessionNotification {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxImageBytes
+	}
+	u := &n.Update
+	switch {
+	case u.AgentMessageChunk != nil:
+		u.AgentMessageChunk.Content = limitImage(u.AgentMessageChunk.Content, maxBytes)
+	case u.UserMessageChunk != nil:
+		u.UserMessageChunk.Content = limitImage(u.UserMessageChunk.Content, maxBytes)
+	case u.ToolCall != nil:
+		limitToolCallImages(u.ToolCall.Content, maxBytes)
+	case u.ToolCallUpdate != nil:
+		limitToolCallImages(u.ToolCallUpdate.Content, maxBytes)
+	}
+	return n
+}
+
+func limitToolCallImages(content []acp.ToolCallContent, maxBytes int) {
+	for _, c := range content {
+		if c.Content != nil {
+			c.Content.Content = limitImage(c.Content.Content, maxBytes)
+		}
+	}
+}
+
+// limitImage returns an image block within maxBytes; other blocks are
+// returned unchanged.
+func limitImage(block acp.ContentBlock, maxBytes int) acp.ContentBlock {
+	img := block.Image
+	if img == nil || img.Data == "" {
+		return block
+	}
+	data, err := base64.StdEncoding.DecodeString(img.Data)
+	if err != nil {
+		return acp.TextBlock(fmt.Sprintf("[image omitted: invalid base64 data for %s]", img.MimeType))
+	}
+	if detected := http.DetectContentType(data); strings.HasPrefix(detected, "image/") {
+		img.MimeType = detected
+	}
+	if len(data) <= maxBytes {
+		return block
+	}
+	scaled, mimeType, ok := downscaleImage(data, maxBytes)
+	if !ok {
+		return acp.TextBlock(fmt.Sprintf("[image omitted: %s of %d bytes is over the %d byte limit]", img.MimeType, len(data), maxBytes))
+	}
+	img.Data = base64.StdEncoding.EncodeToString(scaled)
+	img.MimeType = mimeType
+	return block
+}
+
+// downscaleImage halves an image until its encoding fits in maxBytes.
+// JPEG images stay JPEG; others, screenshots mostly, become PNG to keep
+// text sharp. ok is false for formats that cannot be decoded, images over
+// maxDecodedImagePixels and images that stay too large.
+func downscaleImage(data []byte, maxBytes int) (scaled []byte, mimeType string, ok bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxDecodedImagePixels/cfg.Height {
+		return nil, "", false
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false
+	}
+	for {
+		b := src.Bounds()
+		if b.Dx()/2 < minImageSide || b.Dy()/2 < minImageSide {
+			return nil, "", false
+		}
+		src = halveImage(src)
+		var buf bytes.Buffer
+		mimeType = "image/png"
+		if format == "jpeg" {
+			mimeType = "image/jpeg"
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, src)
+		}
+		if err != nil {
+			return nil, "", false
+		}
+		if buf.Len() <= maxBytes {
+			return buf.Bytes(), mimeType, true
+		}
+	}
+}
+
+// halveImage scales an image to half its size, averaging each 2x2 block
+// of pixels.
+func halveImage(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var r, g, bl, a uint32
+			for _, p := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb, pa := src.At(b.Min.X+2*x+p[0], b.Min.Y+2*y+p[1]).RGBA()
+				r, g, bl, a = r+pr, g+pg, bl+pb, a+pa
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / 4 >> 8), uint8(g / 4 >> 8), uint8(bl / 4 >> 8), uint8(a / 4 >> 8)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// noisePNG encodes a size x size image of random pixels, which PNG cannot
+// compress.
+func noisePNG(t *testing.T, size int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	r := rand.New(rand.NewPCG(1, 2))
+	for y := range size {
+		for x := range size {
+			img.SetRGBA(x, y, color.RGBA{uint8(r.UintN(256)), uint8(r.UintN(256)), uint8(r.UintN(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func toolImageUpdate(data []byte, mimeType string) acp.SessionNotification {
+	return acp.SessionNotification{SessionId: "s1", Update: acp.UpdateToolCall("t1", acp.WithUpdateContent([]acp.ToolCallContent{
+		acp.ToolContent(acp.ImageBlock(base64.StdEncoding.EncodeToString(data), mimeType)),
+	}))}
+}
+
+func TestLimitImages(t *testing.T) {
+	small := noisePNG(t, 64)
+	n := limitImages(toolImageUpdate(small, "image/jpeg"), 0)
+	img := n.Update.ToolCallUpdate.Content[0].Content.Content.Image
+	if img == nil || img.MimeType != "image/png" || img.Data != base64.StdEncoding.EncodeToString(small) {
+		t.Errorf("expected the small image unchanged with its detected type, got %+v", img)
+	}
+
+	large := noisePNG(t, 512)
+	limit := len(large) / 8
+	n = limitImages(toolImageUpdate(large, "image/png"), limit)
+	img = n.Update.ToolCallUpdate.Content[0].Content.Content.Image
+	if img == nil {
+		t.Fatalf("expected a downscaled image, got %+v", n.Update.ToolCallUpdate.Content[0].Content.Content)
+	}
+	data, _ := base64.StdEncoding.DecodeString(img.Data)
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil || len(data) > limit || cfg.Width >= 512 || img.MimeType != "image/png" {
+		t.Errorf("expected a PNG under %d bytes, got %d bytes, %+v, %v", limit, len(data), cfg, err)
+	}
+
+	// An image claiming more pixels than may be decoded is not decoded.
+	huge := bytes.Clone(large)
+	binary.BigEndian.PutUint32(huge[16:], 100_000)
+	binary.BigEndian.PutUint32(huge[20:], 100_000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, _, err := image.DecodeConfig(bytes.NewReader(huge)); err != nil {
+		t.Fatal(err)
+	}
+	n = limitImages(toolImageUpdate(huge, "image/png"), limit)
+	if text := n.Update.ToolCallUpdate.Content[0].Content.Content.Text; text == nil || !strings.Contains(text.Text, "image omitted") {
+		t.Errorf("expected an oversized image to be replaced by a note, got %+v", n.Update.ToolCallUpdate.Content[0].Content.Content)
+	}
+
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), make([]byte, 100)...)
+	n = limitImages(toolImageUpdate(webp, "image/webp"), 50)
+	text := n.Update.ToolCallUpdate.Content[0].Content.Content.Text
+	if text == nil || !strings.Contains(text.Text, "image omitted: image/webp of 116 bytes is over the 50 byte limit") {
+		t.Errorf("expected an undecodable image to be replaced by a note, got %+v", n.Update.ToolCallUpdate.Content[0].Content.Content)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ToolLimits caps tool execution in a session so a runaway model cannot
+// saturate the host. Zero fields are unlimited.
+type ToolLimits struct {
+	// MaxTerminals limits the terminals a session has open at once,
+	// including background commands.
+	MaxTerminals int
+	// MaxCallsPerMinute limits built-in tool calls in any one-minute
+	// window.
+	MaxCallsPerMinute int
+	// MaxBashTime limits the total time a turn spends waiting on Bash
+	// commands.
+	MaxBashTime time.Duration
+}
+
+// toolLimiter enforces ToolLimits for one session. A nil limiter allows
+// everything.
+type toolLimiter struct {
+	limits ToolLimits
+	now    func() time.Time
+
+	mu        sync.Mutex
+	terminals map[string]bool
+	calls     []time.Time // start of each call in the last minute
+	bashTime  time.Duration
+}
+
+// newToolLimiter returns a limiter for limits, or nil if nothing is limited.
+func newToolLimiter(limits ToolLimits) *toolLimiter {
+	if limits == (ToolLimits{}) {
+		return nil
+	}
+	return &toolLimiter{limits: limits, now: time.Now, terminals: map[string]bool{}}
+}
+
+// allowCall records a tool call. It returns the tool error to report if
+// the per-minute limit is reached.
+func (l *toolLimiter) allowCall() (string, bool) {
+	if l == nil || l.limits.MaxCallsPerMinute <= 0 {
+		return "", true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	cutoff := now.Add(-time.Minute)
+	kept := l.calls[:0]
+	for _, t := range l.calls {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	l.calls = kept
+	if len(l.calls) >= l.limits.MaxCallsPerMinute {
+		retry := l.calls[0].Sub(cutoff).Round(time.Second)
+		return fmt.Sprintf("Tool call limit reached: at most %d tool calls per minute are allowed. Retry in %s, and batch work into fewer calls.",
+			l.limits.MaxCallsPerMinute, retry), false
+	}
+	l.calls = append(l.calls, now)
+	return "", true
+}
+
+// checkTerminal returns the tool error to report if the session already
+// has the maximum number of terminals open.
+func (l *toolLimiter) checkTerminal() (string, bool) {
+	if l == nil || l.limits.MaxTerminals <= 0 {
+		return "", true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.terminals) >= l.limits.MaxTerminals {
+		return fmt.Sprintf("Terminal limit reached: at most %d terminals may run at once. Wait for a background command with BashOutput or stop one with KillShell first.",
+			l.limits.MaxTerminals), false
+	}
+	return "", true
+}
+
+// openTerminal records a terminal created by the session.
+func (l *toolLimiter) openTerminal(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.terminals[id] = true
+	l.mu.Unlock()
+}
+
+// closeTerminal records that a terminal was released or killed.
+func (l *toolLimiter) closeTerminal(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.terminals, id)
+	l.mu.Unlock()
+}
+
+// bashTimeout caps a Bash wait of timeout by the turn's remaining Bash
+// time. limited reports whether the budget, not timeout, set the result;
+// a zero result means the budget is used up.
+func (l *toolLimiter) bashTimeout(timeout time.Duration) (d time.Duration, limited bool) {
+	if l == nil || l.limits.MaxBashTime <= 0 {
+		return timeout, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	remaining := max(l.limits.MaxBashTime-l.bashTime, 0)
+	if remaining < timeout {
+		return remaining, true
+	}
+	return timeout, false
+}
+
+// addBashTime charges d to the turn's Bash budget.
+func (l *toolLimiter) addBashTime(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bashTime += d
+	l.mu.Unlock()
+}
+
+// resetTurn starts a new turn's Bash budget.
+func (l *toolLimiter) resetTurn() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bashTime = 0
+	l.mu.Unlock()
+}
+
+// bashTimeExhausted is the tool error returned once a turn has used its
+// Bash budget.
+func (l *toolLimiter) bashTimeExhausted() string {
+	return fmt.Sprintf("Bash time limit reached: commands in this turn may run for %s in total. Finish the task without running more commands.",
+		l.limits.MaxBashTime)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolLimiter_CallsPerMinute(t *testing.T) {
+	l := newToolLimiter(ToolLimits{MaxCallsPerMinute: 2})
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if msg, ok := l.allowCall(); !ok {
+			t.Fatalf("call %d rejected: %s", i, msg)
+		}
+	}
+	now = now.Add(20 * time.Second)
+	if msg, ok := l.allowCall(); ok || !strings.Contains(msg, "Retry in 40s") {
+		t.Errorf("expected the third call to be rejected, got %q, %v", msg, ok)
+	}
+	now = now.Add(41 * time.Second)
+	if msg, ok := l.allowCall(); !ok {
+		t.Errorf("call after the window rejected: %s", msg)
+	}
+}
+
+func TestToolLimiter_Terminals(t *testing.T) {
+	l := newToolLimiter(ToolLimits{MaxTerminals: 1})
+	l.openTerminal("t1")
+	if msg, ok := l.checkTerminal(); ok || !strings.Contains(msg, "at most 1 terminals") {
+		t.Errorf("expected the terminal limit, got %q, %v", msg, ok)
+	}
+	l.closeTerminal("t1")
+	if _, ok := l.checkTerminal(); !ok {
+		t.Error("closed terminal still counted")
+	}
+}
+
+func TestToolLimiter_BashTime(t *testing.T) {
+	l := newToolLimiter(ToolLimits{MaxBashTime: time.Minute})
+	if d, limited := l.bashTimeout(2 * time.Minute); d != time.Minute || !limited {
+		t.Errorf("bashTimeout = %v, %v", d, limited)
+	}
+	l.addBashTime(50 * time.Second)
+	if d, limited := l.bashTimeout(5 * time.Second); d != 5*time.Second || limited {
+		t.Errorf("bashTimeout = %v, %v", d, limited)
+	}
+	l.addBashTime(20 * time.Second)
+	if d, _ := l.bashTimeout(time.Second); d != 0 {
+		t.Errorf("expected the budget to be used up, got %v", d)
+	}
+	l.resetTurn()
+	if d, _ := l.bashTimeout(time.Second); d != time.Second {
+		t.Errorf("budget not reset, got %v", d)
+	}
+}
+
+func TestToolLimiter_Unlimited(t *testing.T) {
+	l := newToolLimiter(ToolLimits{})
+	if l != nil {
+		t.Fatal("expected no limiter without limits")
+	}
+	l.openTerminal("t1")
+	if _, ok := l.allowCall(); !ok {
+		t.Error("nil limiter rejected a call")
+	}
+	if d, limited := l.bashTimeout(time.Hour); d != time.Hour || limited {
+		t.Errorf("bashTimeout = %v, %v", d, limited)
+	}
+}
+
+func TestHandleBuiltinTool_Limits(t *testing.T) {
+	opts := BuiltinToolOptions{limiter: newToolLimiter(ToolLimits{MaxCallsPerMinute: 1, MaxBashTime: time.Second})}
+	opts.limiter.addBashTime(time.Second)
+
+	result, err := handleBuiltinTool(context.Background(), nil, "s1", "Bash", map[string]any{"command": "make"}, opts)
+	if err != nil || !result.IsError || !strings.Contains(result.Text, "Bash time limit reached") {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+	result, err = handleBuiltinTool(context.Background(), nil, "s1", "Bash", map[string]any{"command": "make"}, opts)
+	if err != nil || !result.IsError || !strings.Contains(result.Text, "Tool call limit reached") {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+}
+
+func TestBashBudget_Background(t *testing.T) {
+	opts := BuiltinToolOptions{limiter: newToolLimiter(ToolLimits{MaxBashTime: time.Minute})}
+	client := newFakeTerminals()
+	opts.terminals = newTerminalManager(client, "s1", opts)
+	opts.terminals.beginTurn()
+
+	// A background command is charged for its running time once released.
+	text, isError, _ := handleBash(context.Background(), nil, "s1", map[string]any{"command": "make", "run_in_background": true}, opts)
+	if isError {
+		t.Fatalf("unexpected error %q", text)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := opts.terminals.kill(context.Background(), "term-1"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := opts.limiter.bashTimeout(time.Minute); d > time.Minute-20*time.Millisecond {
+		t.Errorf("expected the background command charged, %v left", d)
+	}
+
+	// Once the budget is spent, background commands cannot start either.
+	opts.limiter.addBashTime(time.Minute)
+	text, isError, _ = handleBash(context.Background(), nil, "s1", map[string]any{"command": "make", "run_in_background": true}, opts)
+	if !isError || !strings.Contains(text, "Bash time limit reached") {
+		t.Errorf("unexpected result %q", text)
+	}
+}
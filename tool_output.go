@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// DefaultMaxToolOutputBytes is the largest text of a single tool call
+// content sent to the client.
+const DefaultMaxToolOutputBytes = 256 << 10
+
+// toolOutputStore keeps the full text of truncated tool output in a
+// temporary directory that lives as long as the session.
+type toolOutputStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// save writes text to a new file for the tool call and returns its path.
+func (s *toolOutputStore) save(toolCallID, text string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "acp-tool-output-*")
+		if err != nil {
+			return "", err
+		}
+		s.dir = dir
+	}
+	f, err := os.CreateTemp(s.dir, toolCallID+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// remove deletes the saved output with the session.
+func (s *toolOutputStore) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return nil
+	}
+	dir := s.dir
+	s.dir = ""
+	return os.RemoveAll(dir)
+}
+
+// limitToolOutput caps the text of each tool call content at maxBytes,
+// keeping its head and tail around a marker. The full text is saved to a
+// file whose path the content's _meta notes. A raw output over the cap is
+// dropped, noting its size in the update's claudeCodeMeta. A maxBytes of
+// zero or less uses DefaultMaxToolOutputBytes.
+func (s *Session) limitToolOutput(n acp.SessionNotification, maxBytes int) acp.SessionNotification {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxToolOutputBytes
+	}
+	var id acp.ToolCallId
+	var content []acp.ToolCallContent
+	var rawOutput *any
+	switch u := &n.Update; {
+	case u.ToolCall != nil:
+		id, content, rawOutput = u.ToolCall.ToolCallId, u.ToolCall.Content, &u.ToolCall.RawOutput
+	case u.ToolCallUpdate != nil:
+		id, content, rawOutput = u.ToolCallUpdate.ToolCallId, u.ToolCallUpdate.Content, &u.ToolCallUpdate.RawOutput
+	default:
+		return n
+	}
+
+	for _, c := range content {
+		if c.Content == nil || c.Content.Content.Text == nil || len(c.Content.Content.Text.Text) <= maxBytes {
+			continue
+		}
+		text := c.Content.Content.Text
+		truncated := map[string]any{"bytes": len(text.Text)}
+		if path, err := s.toolOutputs.save(string(id), text.Text); err != nil {
+			s.log().Warn("Saving truncated tool output failed", "toolCallId", id, "error", err)
+		} else {
+			truncated["path"] = path
+		}
+		text.Text = truncateMiddle(text.Text, maxBytes)
+		text.Meta = withClaudeCodeMeta(text.Meta, "truncatedOutput", truncated)
+	}
+
+	if *rawOutput != nil {
+		if data, err := json.Marshal(*rawOutput); err == nil && len(data) > maxBytes {
+			*rawOutput = nil
+			claudeCodeMetaOf(&n)["rawOutputOmittedBytes"] = len(data)
+		}
+	}
+	return n
+}
+
+// truncateMiddle shortens text to about maxBytes by removing its middle,
+// which a marker stating the removed size replaces. Cuts fall on rune
+// boundaries.
+func truncateMiddle(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	head := maxBytes / 2
+	for head > 0 && !utf8.RuneStart(text[head]) {
+		head--
+	}
+	tail := len(text) - maxBytes/2
+	for tail < len(text) && !utf8.RuneStart(text[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n\n[… truncated %d bytes …]\n\n%s", text[:head], tail-head, text[tail:])
+}
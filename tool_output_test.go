@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestTruncateMiddle(t *testing.T) {
+	text := strings.Repeat("é", 50) + strings.Repeat("x", 100)
+	got := truncateMiddle(text, 40)
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, "éééé") || !strings.HasSuffix(got, "xxxx") {
+		t.Errorf("unexpected truncation: %q", got)
+	}
+	if !strings.Contains(got, "[… truncated 160 bytes …]") {
+		t.Errorf("expected a marker for the removed bytes, got %q", got)
+	}
+	if truncateMiddle("short", 40) != "short" {
+		t.Error("expected short text unchanged")
+	}
+}
+
+func TestLimitToolOutput(t *testing.T) {
+	session := &Session{}
+	defer session.toolOutputs.remove()
+	output := strings.Repeat("a", 600) + strings.Repeat("b", 600)
+	n := acp.SessionNotification{SessionId: "s1", Update: acp.UpdateToolCall("t1",
+		acp.WithUpdateContent([]acp.ToolCallContent{acp.ToolContent(acp.TextBlock(output)), acp.ToolContent(acp.TextBlock("ok"))}),
+		acp.WithUpdateRawOutput(output),
+	)}
+
+	n = session.limitToolOutput(n, 100)
+	u := n.Update.ToolCallUpdate
+	text := u.Content[0].Content.Content.Text
+	if len(text.Text) > 200 || !strings.HasPrefix(text.Text, "aaa") || !strings.HasSuffix(text.Text, "bbb") {
+		t.Errorf("expected head and tail kept, got %q", text.Text)
+	}
+	truncated := text.Meta.(map[string]any)["claudeCode"].(map[string]any)["truncatedOutput"].(map[string]any)
+	path, _ := truncated["path"].(string)
+	if saved, err := os.ReadFile(path); err != nil || string(saved) != output || truncated["bytes"] != len(output) {
+		t.Errorf("expected the full output saved at %q, got %v, %v", path, truncated, err)
+	}
+	if u.Content[1].Content.Content.Text.Text != "ok" || u.Content[1].Content.Content.Text.Meta != nil {
+		t.Error("expected short content unchanged")
+	}
+	if u.RawOutput != nil || claudeCodeMetaOf(&n)["rawOutputOmittedBytes"] != len(output)+2 {
+		t.Errorf("expected the raw output dropped, got %v and meta %v", u.RawOutput, u.Meta)
+	}
+
+	if err := session.toolOutputs.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected saved output removed with the session, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// DefaultMaxParallelTools is how many read-only built-in tool calls run at
+// once by default.
+const DefaultMaxParallelTools = 4
+
+// parallelTools are the built-in tools that only read, and can run
+// alongside each other.
+var parallelTools = []string{"Read", "LS", "BashOutput", "WebFetch"}
+
+// toolScheduler runs a session's built-in tool calls. When the model asks
+// for several tools in one message, the CLI calls them together: read-only
+// tools then run concurrently, up to a limit, and the others run alone.
+// Calls return in the order they arrived, which is the order of the
+// tool_use blocks, so the CLI reports their results in that order too.
+type toolScheduler struct {
+	rw    sync.RWMutex // held shared by read-only calls, exclusively by others
+	slots chan struct{}
+
+	mu   sync.Mutex
+	last chan struct{} // closed once the latest call has returned
+}
+
+func newToolScheduler(parallel int) *toolScheduler {
+	return &toolScheduler{slots: make(chan struct{}, max(parallel, 1))}
+}
+
+// run runs fn for a call of the named tool. A nil scheduler runs it
+// directly.
+func (s *toolScheduler) run(ctx context.Context, toolName string, fn func()) error {
+	if s == nil {
+		fn()
+		return nil
+	}
+	s.mu.Lock()
+	prev, done := s.last, make(chan struct{})
+	s.last = done
+	s.mu.Unlock()
+	defer close(done)
+
+	if slices.Contains(parallelTools, toolName) {
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.rw.RLock()
+		fn()
+		s.rw.RUnlock()
+		<-s.slots
+	} else {
+		s.rw.Lock()
+		fn()
+		s.rw.Unlock()
+	}
+
+	if prev != nil {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// parallelLimit is how many read-only tools may run at once.
+func (o BuiltinToolOptions) parallelLimit() int {
+	if o.MaxParallelTools > 0 {
+		return o.MaxParallelTools
+	}
+	return DefaultMaxParallelTools
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestToolScheduler_ParallelReads(t *testing.T) {
+	s := newToolScheduler(2)
+	ctx := context.Background()
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var returned []int
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx, "Read", func() {
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				// Earlier calls take longer, so they finish last.
+				time.Sleep(time.Duration(4-i) * 20 * time.Millisecond)
+				running.Add(-1)
+			})
+			mu.Lock()
+			returned = append(returned, i)
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond) // arrive in order
+	}
+	wg.Wait()
+	if p := peak.Load(); p != 2 {
+		t.Errorf("peak concurrency = %d, want 2", p)
+	}
+	for i, n := range returned {
+		if n != i {
+			t.Fatalf("calls returned in order %v", returned)
+		}
+	}
+}
+
+func TestToolScheduler_WritesRunAlone(t *testing.T) {
+	s := newToolScheduler(4)
+	ctx := context.Background()
+	var running, overlap atomic.Int32
+	var wg sync.WaitGroup
+	for _, tool := range []string{"Read", "Edit", "Read", "Bash"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx, tool, func() {
+				n := running.Add(1)
+				if tool != "Read" && n > 1 {
+					overlap.Add(1)
+				}
+				time.Sleep(20 * time.Millisecond)
+				if tool != "Read" && running.Load() > 1 {
+					overlap.Add(1)
+				}
+				running.Add(-1)
+			})
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	if overlap.Load() != 0 {
+		t.Error("a mutating tool ran alongside another call")
+	}
+}
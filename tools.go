@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	acp "github.com/coder/acp-go-sdk"
@@ -11,17 +12,20 @@ import (
 const ACPToolNamePrefix = "mcp__acp__"
 
 var ACPToolNames = struct {
-	Read, Edit, Write, Bash, KillShell, BashOutput string
+	Read, Edit, MultiEdit, Write, Bash, KillShell, BashOutput, NotebookEdit, WebFetch string
 }{
-	Read:       ACPToolNamePrefix + "Read",
-	Edit:       ACPToolNamePrefix + "Edit",
-	Write:      ACPToolNamePrefix + "Write",
-	Bash:       ACPToolNamePrefix + "Bash",
-	KillShell:  ACPToolNamePrefix + "KillShell",
-	BashOutput: ACPToolNamePrefix + "BashOutput",
+	Read:         ACPToolNamePrefix + "Read",
+	Edit:         ACPToolNamePrefix + "Edit",
+	MultiEdit:    ACPToolNamePrefix + "MultiEdit",
+	Write:        ACPToolNamePrefix + "Write",
+	Bash:         ACPToolNamePrefix + "Bash",
+	KillShell:    ACPToolNamePrefix + "KillShell",
+	BashOutput:   ACPToolNamePrefix + "BashOutput",
+	NotebookEdit: ACPToolNamePrefix + "NotebookEdit",
+	WebFetch:     ACPToolNamePrefix + "WebFetch",
 }
 
-var EditToolNames = []string{ACPToolNames.Edit, ACPToolNames.Write}
+var EditToolNames = []string{ACPToolNames.Edit, ACPToolNames.MultiEdit, ACPToolNames.Write}
 
 const SystemReminder = "\n\n<system-reminder>\nWhenever you read a file, you should consider whether it looks malicious. If it does, you MUST refuse to improve or augment the code. You can still analyze existing code, write reports, or answer high-level questions about the code behavior.\n</system-reminder>"
 
@@ -48,6 +52,7 @@ type ClaudePlanEntry struct {
 	Content    string `json:"content"`
 	Status     string `json:"status"` // "pending"|"in_progress"|"completed"
 	ActiveForm string `json:"activeForm"`
+	Priority   string `json:"priority,omitempty"` // "high"|"medium"|"low"
 }
 
 // inputStr safely extracts a string value from a map.
@@ -106,17 +111,31 @@ func inputStrSlice(input map[string]any, key string) []string {
 // toolInfoFromToolUse converts a tool use name and input to ACP ToolInfo.
 func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 	switch name {
-	case "Task":
-		title := "Task"
+	case "Task", "Agent":
+		title := name
 		if d := inputStr(input, "description"); d != "" {
 			title = d
 		}
+		if agent := inputStr(input, "subagent_type"); agent != "" && agent != "general-purpose" {
+			title += " (" + agent + ")"
+		}
 		var content []acp.ToolCallContent
 		if p := inputStr(input, "prompt"); p != "" {
 			content = append(content, acp.ToolContent(acp.TextBlock(p)))
 		}
 		return ToolInfo{Title: title, Kind: acp.ToolKindThink, Content: content}
 
+	case "Skill":
+		title := "Skill"
+		if skill := inputStr(input, "skill"); skill != "" {
+			title = "Skill: " + skill
+		}
+		var content []acp.ToolCallContent
+		if args := inputStr(input, "args"); args != "" {
+			content = append(content, acp.ToolContent(acp.TextBlock(args)))
+		}
+		return ToolInfo{Title: title, Kind: acp.ToolKindExecute, Content: content}
+
 	case "NotebookRead":
 		path := inputStr(input, "notebook_path")
 		title := "Read Notebook"
@@ -129,7 +148,7 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 		}
 		return ToolInfo{Title: title, Kind: acp.ToolKindRead, Content: nil, Locations: locations}
 
-	case "NotebookEdit":
+	case "NotebookEdit", ACPToolNames.NotebookEdit:
 		path := inputStr(input, "notebook_path")
 		title := "Edit Notebook"
 		if path != "" {
@@ -209,7 +228,7 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 			locations = append(locations, loc)
 		}
 		return ToolInfo{Title: "Read File", Kind: acp.ToolKindRead, Locations: locations}
-	case "LS":
+	case "LS", ACPToolNamePrefix + "LS":
 		path := inputStr(input, "path")
 		title := "List the "
 		if path != "" {
@@ -242,6 +261,25 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 		}
 		return ToolInfo{Title: title, Kind: acp.ToolKindEdit, Content: content, Locations: locations}
 
+	case ACPToolNames.MultiEdit, "MultiEdit":
+		filePath := inputStr(input, "file_path")
+		if filePath == "" {
+			return ToolInfo{Title: "Edit", Kind: acp.ToolKindEdit}
+		}
+		var content []acp.ToolCallContent
+		edits, _ := input["edits"].([]any)
+		for _, item := range edits {
+			if e, ok := item.(map[string]any); ok {
+				content = append(content, acp.ToolDiffContent(filePath, inputStr(e, "new_string"), inputStr(e, "old_string")))
+			}
+		}
+		return ToolInfo{
+			Title:     "Edit `" + filePath + "`",
+			Kind:      acp.ToolKindEdit,
+			Content:   content,
+			Locations: []acp.ToolCallLocation{{Path: filePath}},
+		}
+
 	case ACPToolNamePrefix + "Write":
 		filePath := inputStr(input, "file_path")
 		fileContent := inputStr(input, "content")
@@ -336,7 +374,7 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 		}
 		return ToolInfo{Title: label, Kind: acp.ToolKindSearch}
 
-	case "WebFetch":
+	case "WebFetch", ACPToolNames.WebFetch:
 		url := inputStr(input, "url")
 		title := "Fetch"
 		if url != "" {
@@ -383,6 +421,19 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 		}
 		return ToolInfo{Title: title, Kind: acp.ToolKindSwitchMode, Content: content}
 
+	case "SlashCommand":
+		command := strings.TrimSpace(inputStr(input, "command"))
+		name, args, _ := strings.Cut(command, " ")
+		title := "Slash Command"
+		if name != "" {
+			title = name
+		}
+		var content []acp.ToolCallContent
+		if args = strings.TrimSpace(args); args != "" {
+			content = append(content, acp.ToolContent(acp.TextBlock(args)))
+		}
+		return ToolInfo{Title: title, Kind: acp.ToolKindOther, Content: content}
+
 	case "Other":
 		var output string
 		data, err := json.MarshalIndent(input, "", "  ")
@@ -401,7 +452,13 @@ func toolInfoFromToolUse(name string, input map[string]any) ToolInfo {
 			Content: []acp.ToolCallContent{acp.ToolContent(acp.TextBlock("```json\n" + output + "```"))},
 		}
 
+	case ideGetDiagnostics, ideExecuteCode:
+		return ideToolInfo(name, input)
+
 	default:
+		if info, ok := mcpToolInfo(name, input, nil); ok {
+			return info
+		}
 		title := name
 		if title == "" {
 			title = "Unknown Tool"
@@ -444,7 +501,10 @@ func toAcpContentBlock(content map[string]any, isError bool) acp.ContentBlock {
 	case "web_search_result":
 		title, _ := content["title"].(string)
 		url, _ := content["url"].(string)
-		return wrapText(title + " (" + url + ")")
+		if url == "" {
+			return wrapText(title)
+		}
+		return webSearchResultLink(title, url, content)
 	case "web_search_tool_result_error":
 		code, _ := content["error_code"].(string)
 		return wrapText("Error: " + code)
@@ -527,6 +587,20 @@ func toAcpContentBlock(content map[string]any, isError bool) acp.ContentBlock {
 	}
 }
 
+// webSearchResultLink converts a web search result to a link editors can
+// render as a citation. The page age, when known, describes it.
+func webSearchResultLink(title, url string, content map[string]any) acp.ContentBlock {
+	pageAge, _ := content["page_age"].(string)
+	name := title
+	if name == "" {
+		name = url
+	}
+	block := acp.ResourceLinkBlock(name, url)
+	block.ResourceLink.Title = optionalString(title)
+	block.ResourceLink.Description = optionalString(pageAge)
+	return block
+}
+
 // toAcpContentUpdate converts tool result content to ACP ToolCallContent slice.
 func toAcpContentUpdate(content any, isError bool) ToolUpdate {
 	switch c := content.(type) {
@@ -612,7 +686,7 @@ func toolUpdateFromToolResult(toolResult map[string]any, toolUse *ToolUseEntry)
 			}
 		}
 		return ToolUpdate{}
-	case ACPToolNames.Edit:
+	case ACPToolNames.Edit, ACPToolNames.MultiEdit:
 		// Parse unified diff from the result content.
 		var resultContent []acp.ToolCallContent
 		var locations []acp.ToolCallLocation
@@ -663,12 +737,48 @@ func toolUpdateFromToolResult(toolResult map[string]any, toolUse *ToolUseEntry)
 			result.Locations = locations
 		}
 		return result
+	case ACPToolNames.NotebookEdit:
+		if arr, ok := content.([]any); ok && len(arr) > 0 {
+			if first, ok := arr[0].(map[string]any); ok {
+				if text, ok := first["text"].(string); ok {
+					return notebookCellDiffs(text)
+				}
+			}
+		}
+		return ToolUpdate{}
 	case ACPToolNames.Bash, "edit", "Edit", ACPToolNames.Write, "Write":
 		return ToolUpdate{}
 
 	case "ExitPlanMode":
 		return ToolUpdate{Title: acp.Ptr("Exited Plan Mode")}
 
+	case ideGetDiagnostics:
+		if update, ok := diagnosticsToolUpdate(content); ok {
+			return update
+		}
+		return toAcpContentUpdate(content, isError)
+
+	case "SlashCommand":
+		// The command's output is markdown; pass it through unfenced.
+		var texts []string
+		if s, ok := content.(string); ok {
+			texts = append(texts, s)
+		} else if arr, ok := content.([]any); ok {
+			for _, item := range arr {
+				if m, ok := item.(map[string]any); ok && m["type"] == "text" {
+					text, _ := m["text"].(string)
+					texts = append(texts, text)
+				}
+			}
+		}
+		var result []acp.ToolCallContent
+		for _, text := range texts {
+			if text = strings.TrimSpace(strings.ReplaceAll(text, SystemReminder, "")); text != "" {
+				result = append(result, acp.ToolContent(acp.TextBlock(text)))
+			}
+		}
+		return ToolUpdate{Content: result}
+
 	default:
 		return toAcpContentUpdate(content, isError)
 	}
@@ -687,45 +797,142 @@ type toolsDiffHunk struct {
 	lines    []string
 }
 
-// parseUnifiedDiff parses a unified diff string into patches.
+// parseUnifiedDiff parses a unified diff string into patches. Besides plain
+// "diff -u" output it accepts git diffs: "diff --git" headers, renames and
+// copies without hunks, quoted file names, and "\ No newline at end of file"
+// markers. /dev/null file names are returned as "".
 func parseUnifiedDiff(text string) []toolsDiffPatch {
-	lines := strings.Split(text, "\n")
 	var patches []toolsDiffPatch
 	var current *toolsDiffPatch
 	var currentHunk *toolsDiffHunk
+	// gitHeader is set between a "diff --git" line and its first "---" or
+	// hunk, so the "---" line does not start another patch.
+	gitHeader := false
+	// oldLeft and newLeft count the lines still expected in the current
+	// hunk, so removed lines that look like "--- " headers stay in it.
+	oldLeft, newLeft := 0, 0
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "--- ") {
-			if current != nil {
-				if currentHunk != nil {
-					current.hunks = append(current.hunks, *currentHunk)
-					currentHunk = nil
-				}
-				patches = append(patches, *current)
-			}
-			current = &toolsDiffPatch{oldFileName: strings.TrimPrefix(line, "--- ")}
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if currentHunk != nil {
+			current.hunks = append(current.hunks, *currentHunk)
 			currentHunk = nil
-		} else if strings.HasPrefix(line, "+++ ") && current != nil {
-			current.newFileName = strings.TrimPrefix(line, "+++ ")
-		} else if strings.HasPrefix(line, "@@") && current != nil {
+		}
+		patches = append(patches, *current)
+		current = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		inHunk := currentHunk != nil && (oldLeft > 0 || newLeft > 0)
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" annotates the previous line.
+		case inHunk && (line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '+'):
+			if line == "" {
+				line = " " // an empty context line whose space was stripped
+			}
+			if line[0] != '+' {
+				oldLeft--
+			}
+			if line[0] != '-' {
+				newLeft--
+			}
+			currentHunk.lines = append(currentHunk.lines, line)
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			current = &toolsDiffPatch{}
+			current.oldFileName, current.newFileName = parseGitDiffHeader(strings.TrimPrefix(line, "diff --git "))
+			gitHeader = true
+		case current != nil && gitHeader && (strings.HasPrefix(line, "rename from ") || strings.HasPrefix(line, "copy from ")):
+			_, name, _ := strings.Cut(line, " from ")
+			current.oldFileName = parseDiffFileName(name)
+		case current != nil && gitHeader && (strings.HasPrefix(line, "rename to ") || strings.HasPrefix(line, "copy to ")):
+			_, name, _ := strings.Cut(line, " to ")
+			current.newFileName = parseDiffFileName(name)
+		case strings.HasPrefix(line, "--- "):
+			if !gitHeader {
+				flush()
+				current = &toolsDiffPatch{}
+			}
+			gitHeader = false
+			current.oldFileName = parseDiffFileName(strings.TrimPrefix(line, "--- "))
+		case strings.HasPrefix(line, "+++ ") && current != nil:
+			current.newFileName = parseDiffFileName(strings.TrimPrefix(line, "+++ "))
+		case strings.HasPrefix(line, "@@") && current != nil:
 			if currentHunk != nil {
 				current.hunks = append(current.hunks, *currentHunk)
 			}
-			newStart := parseHunkHeader(line)
-			currentHunk = &toolsDiffHunk{newStart: newStart}
-		} else if currentHunk != nil {
+			gitHeader = false
+			currentHunk = &toolsDiffHunk{newStart: parseHunkHeader(line)}
+			oldLeft, newLeft = parseHunkLineCounts(line)
+		case currentHunk != nil:
+			// Past the line counts of the header, which hand-written diffs
+			// get wrong; keep anything that looks like a hunk line.
 			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ") {
 				currentHunk.lines = append(currentHunk.lines, line)
 			}
 		}
 	}
-	if current != nil {
-		if currentHunk != nil {
-			current.hunks = append(current.hunks, *currentHunk)
+	flush()
+	return patches
+}
+
+// parseDiffFileName extracts the file name from the rest of a "---", "+++"
+// or rename line. Git quotes names with special characters using C-style
+// escapes, and diff -u appends a tab and a timestamp.
+func parseDiffFileName(s string) string {
+	name := s
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, _, ok := unquoteDiffFileName(s); ok {
+			name = unquoted
+		}
+	} else if i := strings.IndexByte(s, '\t'); i >= 0 {
+		name = s[:i]
+	}
+	if name == "/dev/null" {
+		return ""
+	}
+	return name
+}
+
+// parseGitDiffHeader extracts the old and new names from the rest of a
+// "diff --git" line. Unquoted names containing spaces are ambiguous there;
+// they are split at " b/" and corrected by the "---" and "+++" lines.
+func parseGitDiffHeader(s string) (oldName, newName string) {
+	if strings.HasPrefix(s, `"`) {
+		name, rest, ok := unquoteDiffFileName(s)
+		if !ok {
+			return "", ""
 		}
-		patches = append(patches, *current)
+		return name, parseDiffFileName(strings.TrimPrefix(rest, " "))
 	}
-	return patches
+	if i := strings.Index(s, ` "`); i >= 0 {
+		return s[:i], parseDiffFileName(s[i+1:])
+	}
+	if i := strings.Index(s, " b/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return "", ""
+}
+
+// unquoteDiffFileName unquotes the quoted name at the start of s and
+// returns the rest of s after it.
+func unquoteDiffFileName(s string) (name, rest string, ok bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			name, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", false
+			}
+			return name, s[i+1:], true
+		}
+	}
+	return "", "", false
 }
 
 // parseHunkHeader extracts the new start line from a @@ hunk header.
@@ -737,21 +944,41 @@ func parseHunkHeader(line string) int {
 	}
 	numStr := strings.SplitN(parts[1], ",", 2)[0]
 	numStr = strings.SplitN(numStr, " ", 2)[0]
-	n := 0
-	for _, c := range numStr {
-		if c >= '0' && c <= '9' {
-			n = n*10 + int(c-'0')
-		} else {
-			break
+	digits := len(numStr) - len(strings.TrimLeft(numStr, "0123456789"))
+	n, err := strconv.Atoi(numStr[:digits])
+	if err != nil || n == 0 {
+		return 1 // missing, or too large to be a line number
+	}
+	return n
+}
+
+// parseHunkLineCounts extracts the old and new line counts from a @@ hunk
+// header. An omitted count is 1. It returns zeros if the header is
+// malformed.
+func parseHunkLineCounts(line string) (oldCount, newCount int) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0
+	}
+	count := func(r string) (int, bool) {
+		_, c, found := strings.Cut(r[1:], ",")
+		if !found {
+			return 1, true
 		}
+		n, err := strconv.Atoi(c)
+		return n, err == nil && n >= 0
 	}
-	if n == 0 {
-		return 1
+	oldCount, ok1 := count(fields[1])
+	newCount, ok2 := count(fields[2])
+	if !ok1 || !ok2 {
+		return 0, 0
 	}
-	return n
+	return oldCount, newCount
 }
 
-// planEntries converts Claude plan entries to ACP PlanEntry format.
+// planEntries converts Claude plan entries to ACP PlanEntry format, in the
+// order Claude listed them. Entries without a priority are medium; the
+// activeForm label shown while an entry is in progress is carried in _meta.
 func planEntries(todos []ClaudePlanEntry) []acp.PlanEntry {
 	entries := make([]acp.PlanEntry, 0, len(todos))
 	for _, t := range todos {
@@ -762,11 +989,26 @@ func planEntries(todos []ClaudePlanEntry) []acp.PlanEntry {
 		case "completed":
 			status = acp.PlanEntryStatusCompleted
 		}
-		entries = append(entries, acp.PlanEntry{
+		priority := acp.PlanEntryPriorityMedium
+		switch t.Priority {
+		case "high":
+			priority = acp.PlanEntryPriorityHigh
+		case "low":
+			priority = acp.PlanEntryPriorityLow
+		}
+		entry := acp.PlanEntry{
 			Content:  t.Content,
 			Status:   status,
-			Priority: acp.PlanEntryPriorityMedium,
-		})
+			Priority: priority,
+		}
+		if t.ActiveForm != "" {
+			entry.Meta = map[string]any{
+				"claudeCode": map[string]any{
+					"activeForm": t.ActiveForm,
+				},
+			}
+		}
+		entries = append(entries, entry)
 	}
 	return entries
 }
@@ -777,7 +1019,7 @@ func toAcpNotifications(
 	content any,
 	role string,
 	sessionID string,
-	toolUseCache map[string]ToolUseEntry,
+	toolUseCache *ToolUseCache,
 	parentToolCallID *string,
 ) []acp.SessionNotification {
 	sid := acp.SessionId(sessionID)
@@ -843,12 +1085,12 @@ func toAcpNotifications(
 			name, _ := chunk["name"].(string)
 			inputRaw, _ := chunk["input"].(map[string]any)
 
-			toolUseCache[id] = ToolUseEntry{
+			toolUseCache.Put(ToolUseEntry{
 				Type:  chunkType,
 				ID:    id,
 				Name:  name,
 				Input: inputRaw,
-			}
+			})
 
 			if name == "TodoWrite" {
 				if inputRaw != nil {
@@ -860,6 +1102,7 @@ func toAcpNotifications(
 									Content:    inputStr(m, "content"),
 									Status:     inputStr(m, "status"),
 									ActiveForm: inputStr(m, "activeForm"),
+									Priority:   inputStr(m, "priority"),
 								})
 							}
 						}
@@ -871,12 +1114,12 @@ func toAcpNotifications(
 				}
 			} else {
 				info := toolInfoFromToolUse(name, inputRaw)
-				meta := map[string]any{
-					"claudeCode": map[string]any{
-						"toolName":         name,
-						"parentToolCallId": parentToolCallID,
-					},
+				if annotations, ok := toolUseCache.ToolAnnotations(name); ok {
+					if mcpInfo, ok := mcpToolInfo(name, inputRaw, &annotations); ok {
+						info = mcpInfo
+					}
 				}
+				meta := toolCallMeta(name, parentToolCallID)
 				opts := []acp.ToolCallStartOpt{
 					acp.WithStartKind(info.Kind),
 					acp.WithStartStatus(acp.ToolCallStatusPending),
@@ -902,10 +1145,12 @@ func toAcpNotifications(
 			"bash_code_execution_tool_result", "text_editor_code_execution_tool_result",
 			"mcp_tool_result":
 			toolUseID, _ := chunk["tool_use_id"].(string)
-			cachedToolUse, exists := toolUseCache[toolUseID]
+			cachedToolUse, exists := toolUseCache.Get(toolUseID)
 			if !exists {
 				continue
 			}
+			// Each tool use has a single result, so the entry is no longer needed.
+			toolUseCache.Delete(toolUseID)
 			if cachedToolUse.Name == "TodoWrite" {
 				continue
 			}
@@ -919,12 +1164,7 @@ func toAcpNotifications(
 			toolResultMap := chunk
 			tu := toolUpdateFromToolResult(toolResultMap, &cachedToolUse)
 
-			meta := map[string]any{
-				"claudeCode": map[string]any{
-					"toolName":         cachedToolUse.Name,
-					"parentToolCallId": parentToolCallID,
-				},
-			}
+			meta := toolCallMeta(cachedToolUse.Name, parentToolCallID)
 
 			updateOpts := []acp.ToolCallUpdateOpt{
 				acp.WithUpdateStatus(status),
@@ -944,10 +1184,27 @@ func toAcpNotifications(
 				update.ToolCallUpdate.Meta = meta
 			}
 			notification = &acp.SessionNotification{SessionId: sid, Update: update}
-		case "document", "search_result", "redacted_thinking",
+		case "document", "search_result":
+			var contentBlock acp.ContentBlock
+			if chunkType == "document" {
+				contentBlock, ok = documentContentBlock(chunk)
+			} else {
+				contentBlock, ok = searchResultContentBlock(chunk)
+			}
+			if !ok {
+				continue
+			}
+			var update acp.SessionUpdate
+			if role == "assistant" {
+				update = acp.UpdateAgentMessage(contentBlock)
+			} else {
+				update = acp.UpdateUserMessage(contentBlock)
+			}
+			notification = &acp.SessionNotification{SessionId: sid, Update: update}
+		case "redacted_thinking",
 			"input_json_delta", "citations_delta", "signature_delta",
 			"container_upload", "compaction", "compaction_delta":
-			// Ignored block types.
+			// Ignored block types. Compaction is reported by compactionTracker.
 			continue
 
 		default:
@@ -966,7 +1223,7 @@ func toAcpNotifications(
 func streamEventToAcpNotifications(
 	msg map[string]any,
 	sessionID string,
-	toolUseCache map[string]ToolUseEntry,
+	toolUseCache *ToolUseCache,
 	parentToolCallID *string,
 ) []acp.SessionNotification {
 	event, _ := msg["event"].(map[string]any)
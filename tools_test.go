@@ -1,6 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	acp "github.com/coder/acp-go-sdk"
@@ -18,6 +26,38 @@ func TestToolInfoFromToolUse_Task(t *testing.T) {
 	}
 }
 
+func TestToolInfoFromToolUse_Agent(t *testing.T) {
+	info := toolInfoFromToolUse("Agent", map[string]any{
+		"description":   "Review the diff",
+		"prompt":        "Look for bugs",
+		"subagent_type": "code-reviewer",
+	})
+	if info.Title != "Review the diff (code-reviewer)" {
+		t.Errorf("expected title with agent name, got %q", info.Title)
+	}
+	if info.Kind != acp.ToolKindThink || len(info.Content) != 1 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	info = toolInfoFromToolUse("Task", map[string]any{"description": "Search", "subagent_type": "general-purpose"})
+	if info.Title != "Search" {
+		t.Errorf("expected default agent to be omitted, got %q", info.Title)
+	}
+}
+
+func TestToolInfoFromToolUse_Skill(t *testing.T) {
+	info := toolInfoFromToolUse("Skill", map[string]any{"skill": "pdf", "args": "report.pdf"})
+	if info.Title != "Skill: pdf" {
+		t.Errorf("expected title 'Skill: pdf', got %q", info.Title)
+	}
+	if info.Kind != acp.ToolKindExecute {
+		t.Errorf("expected kind=execute, got %v", info.Kind)
+	}
+	if len(info.Content) != 1 || info.Content[0].Content.Content.Text.Text != "report.pdf" {
+		t.Errorf("expected arguments as content, got %+v", info.Content)
+	}
+}
+
 func TestToolInfoFromToolUse_Bash(t *testing.T) {
 	info := toolInfoFromToolUse("Bash", map[string]any{
 		"command": "npm run test",
@@ -206,6 +246,31 @@ func TestPlanEntries(t *testing.T) {
 	}
 }
 
+func TestPlanEntries_PriorityAndActiveForm(t *testing.T) {
+	entries := planEntries([]ClaudePlanEntry{
+		{Content: "Fix bug", Status: "in_progress", ActiveForm: "Fixing bug", Priority: "high"},
+		{Content: "Write docs", Status: "pending", Priority: "low"},
+		{Content: "Refactor", Status: "pending"},
+	})
+	wantPriorities := []acp.PlanEntryPriority{acp.PlanEntryPriorityHigh, acp.PlanEntryPriorityLow, acp.PlanEntryPriorityMedium}
+	for i, want := range wantPriorities {
+		if entries[i].Priority != want {
+			t.Errorf("entry %d: expected priority %v, got %v", i, want, entries[i].Priority)
+		}
+	}
+	meta, _ := entries[0].Meta.(map[string]any)
+	claudeCode, _ := meta["claudeCode"].(map[string]any)
+	if claudeCode["activeForm"] != "Fixing bug" {
+		t.Errorf("expected activeForm in meta, got %v", entries[0].Meta)
+	}
+	if entries[1].Meta != nil {
+		t.Errorf("expected no meta without activeForm, got %v", entries[1].Meta)
+	}
+	if entries[2].Content != "Refactor" {
+		t.Errorf("expected input order to be kept, got %q last", entries[2].Content)
+	}
+}
+
 func TestToolUpdateFromToolResult_ReadTool(t *testing.T) {
 	toolUse := &ToolUseEntry{Name: "Read", ID: "123"}
 	result := map[string]any{
@@ -242,6 +307,29 @@ func TestToolUpdateFromToolResult_ExitPlanMode(t *testing.T) {
 	}
 }
 
+func TestToolUpdateFromToolResult_WebSearchResults(t *testing.T) {
+	toolUse := &ToolUseEntry{Name: "web_search", ID: "srvtoolu_1"}
+	result := map[string]any{
+		"content": []any{
+			map[string]any{"type": "web_search_result", "title": "Go 1.25", "url": "https://go.dev/doc/go1.25", "page_age": "2 days ago"},
+			map[string]any{"type": "web_search_result", "url": "https://example.com"},
+		},
+	}
+	update := toolUpdateFromToolResult(result, toolUse)
+	if len(update.Content) != 2 {
+		t.Fatalf("expected 2 links, got %+v", update.Content)
+	}
+	link := update.Content[0].Content.Content.ResourceLink
+	if link == nil || link.Uri != "https://go.dev/doc/go1.25" || link.Name != "Go 1.25" ||
+		link.Title == nil || *link.Title != "Go 1.25" || link.Description == nil || *link.Description != "2 days ago" {
+		t.Errorf("unexpected link: %+v", link)
+	}
+	link = update.Content[1].Content.Content.ResourceLink
+	if link == nil || link.Name != "https://example.com" || link.Title != nil || link.Description != nil {
+		t.Errorf("expected an untitled link named by its URL, got %+v", link)
+	}
+}
+
 func TestParseUnifiedDiff(t *testing.T) {
 	diff := `--- a/file.go
 +++ b/file.go
@@ -269,8 +357,72 @@ func TestParseUnifiedDiff(t *testing.T) {
 	}
 }
 
+func TestParseUnifiedDiff_Corpus(t *testing.T) {
+	type patch struct {
+		oldName, newName string
+		hunks            [][]string
+	}
+	tests := []struct {
+		file string
+		want []patch
+	}{
+		{
+			file: "git.diff",
+			want: []patch{
+				{"a/dash.txt", "b/dash.txt", [][]string{{" keep", "--- a", " end"}}},
+				{"a/del.txt", "", [][]string{{"-gone"}}},
+				{"a/old name.txt", "b/new é.txt", [][]string{{" x", "-y", "+Y", " z"}}},
+				{"a/noeol.txt", "b/noeol.txt", [][]string{{" one", "-two", "-three", "+2", "+three"}}},
+				{"same.txt", "renamed.txt", nil},
+			},
+		},
+		{
+			file: "plain.diff",
+			want: []patch{
+				{"f.txt", "f.txt", [][]string{{" a", "-b", "+c"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			patches := parseUnifiedDiff(string(data))
+			if len(patches) != len(tt.want) {
+				t.Fatalf("expected %d patches, got %d: %+v", len(tt.want), len(patches), patches)
+			}
+			for i, want := range tt.want {
+				got := patches[i]
+				if got.oldFileName != want.oldName || got.newFileName != want.newName {
+					t.Errorf("patch %d: names = %q, %q; want %q, %q", i, got.oldFileName, got.newFileName, want.oldName, want.newName)
+				}
+				var hunks [][]string
+				for _, h := range got.hunks {
+					hunks = append(hunks, h.lines)
+				}
+				if !reflect.DeepEqual(hunks, want.hunks) {
+					t.Errorf("patch %d: hunks = %q, want %q", i, hunks, want.hunks)
+				}
+			}
+		})
+	}
+}
+
+func TestParseUnifiedDiff_EmptyContextLine(t *testing.T) {
+	patches := parseUnifiedDiff("--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n\n-b\n+c\n")
+	if len(patches) != 1 || len(patches[0].hunks) != 1 {
+		t.Fatalf("unexpected patches: %+v", patches)
+	}
+	want := []string{" a", " ", "-b", "+c"}
+	if got := patches[0].hunks[0].lines; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
 func TestToAcpNotifications_TextContent(t *testing.T) {
-	cache := make(map[string]ToolUseEntry)
+	cache := NewToolUseCache(0)
 	notifications := toAcpNotifications("hello world", "assistant", "session-1", cache, nil)
 	if len(notifications) != 1 {
 		t.Fatalf("expected 1 notification, got %d", len(notifications))
@@ -281,7 +433,7 @@ func TestToAcpNotifications_TextContent(t *testing.T) {
 }
 
 func TestToAcpNotifications_ThinkingBlock(t *testing.T) {
-	cache := make(map[string]ToolUseEntry)
+	cache := NewToolUseCache(0)
 	blocks := []any{
 		map[string]any{"type": "thinking", "thinking": "Let me think..."},
 	}
@@ -295,7 +447,7 @@ func TestToAcpNotifications_ThinkingBlock(t *testing.T) {
 }
 
 func TestToAcpNotifications_ToolUseBlock(t *testing.T) {
-	cache := make(map[string]ToolUseEntry)
+	cache := NewToolUseCache(0)
 	blocks := []any{
 		map[string]any{
 			"type":  "tool_use",
@@ -312,13 +464,13 @@ func TestToAcpNotifications_ToolUseBlock(t *testing.T) {
 		t.Error("expected tool call update")
 	}
 	// Verify it was cached
-	if _, ok := cache["tool-1"]; !ok {
+	if _, ok := cache.Get("tool-1"); !ok {
 		t.Error("expected tool use to be cached")
 	}
 }
 
 func TestStreamEventToAcpNotifications_ContentBlockStart(t *testing.T) {
-	cache := make(map[string]ToolUseEntry)
+	cache := NewToolUseCache(0)
 	msg := map[string]any{
 		"event": map[string]any{
 			"type": "content_block_start",
@@ -335,7 +487,7 @@ func TestStreamEventToAcpNotifications_ContentBlockStart(t *testing.T) {
 }
 
 func TestStreamEventToAcpNotifications_MessageStop(t *testing.T) {
-	cache := make(map[string]ToolUseEntry)
+	cache := NewToolUseCache(0)
 	msg := map[string]any{
 		"event": map[string]any{
 			"type": "message_stop",
@@ -346,3 +498,208 @@ func TestStreamEventToAcpNotifications_MessageStop(t *testing.T) {
 		t.Errorf("expected 0 notifications for message_stop, got %d", len(notifications))
 	}
 }
+
+func TestToAcpNotifications_DocumentAndSearchResult(t *testing.T) {
+	cache := NewToolUseCache(0)
+	blocks := []any{
+		map[string]any{
+			"type":   "document",
+			"title":  "Design notes",
+			"source": map[string]any{"type": "text", "media_type": "text/plain", "data": "Use ACP."},
+		},
+		map[string]any{
+			"type":   "document",
+			"source": map[string]any{"type": "url", "url": "https://example.com/spec.pdf"},
+		},
+		map[string]any{
+			"type":    "search_result",
+			"source":  "https://example.com/docs",
+			"title":   "Docs",
+			"content": []any{map[string]any{"type": "text", "text": "first"}, map[string]any{"type": "text", "text": "second"}},
+		},
+		map[string]any{
+			"type":   "document",
+			"source": map[string]any{"type": "file", "file_id": "file_123"},
+		},
+	}
+	notifications := toAcpNotifications(blocks, "assistant", "session-1", cache, nil)
+	if len(notifications) != 3 {
+		t.Fatalf("expected 3 notifications, got %d", len(notifications))
+	}
+	for _, n := range notifications {
+		if n.Update.AgentMessageChunk == nil {
+			t.Fatal("expected agent message chunks")
+		}
+	}
+
+	doc := notifications[0].Update.AgentMessageChunk.Content.Resource
+	if doc == nil || doc.Resource.TextResourceContents == nil {
+		t.Fatal("expected embedded text resource for text document")
+	}
+	if res := doc.Resource.TextResourceContents; res.Uri != "document:Design%20notes" || res.Text != "Use ACP." {
+		t.Errorf("unexpected document resource: %+v", res)
+	}
+
+	link := notifications[1].Update.AgentMessageChunk.Content.ResourceLink
+	if link == nil || link.Uri != "https://example.com/spec.pdf" {
+		t.Errorf("expected resource link for url document, got %+v", link)
+	}
+
+	result := notifications[2].Update.AgentMessageChunk.Content.Resource
+	if result == nil || result.Resource.TextResourceContents == nil {
+		t.Fatal("expected embedded text resource for search result")
+	}
+	if res := result.Resource.TextResourceContents; res.Uri != "https://example.com/docs" || res.Text != "first\n\nsecond" {
+		t.Errorf("unexpected search result resource: %+v", res)
+	}
+}
+
+func TestToolInfoFromToolUse_SlashCommand(t *testing.T) {
+	info := toolInfoFromToolUse("SlashCommand", map[string]any{"command": "/review 42"})
+	if info.Title != "/review" {
+		t.Errorf("expected title=/review, got %q", info.Title)
+	}
+	if len(info.Content) != 1 || info.Content[0].Content.Content.Text.Text != "42" {
+		t.Errorf("expected arguments as content, got %+v", info.Content)
+	}
+
+	info = toolInfoFromToolUse("SlashCommand", map[string]any{"command": "/compact"})
+	if info.Title != "/compact" || len(info.Content) != 0 {
+		t.Errorf("unexpected info for command without arguments: %+v", info)
+	}
+}
+
+func TestToolUpdateFromToolResult_SlashCommand(t *testing.T) {
+	toolUse := &ToolUseEntry{Name: "SlashCommand", ID: "sc1"}
+	update := toolUpdateFromToolResult(map[string]any{
+		"content": []any{map[string]any{"type": "text", "text": "## Review\n\n- looks good\n"}},
+	}, toolUse)
+	if len(update.Content) != 1 {
+		t.Fatalf("expected 1 content block, got %d", len(update.Content))
+	}
+	if text := update.Content[0].Content.Content.Text.Text; text != "## Review\n\n- looks good" {
+		t.Errorf("expected unfenced markdown, got %q", text)
+	}
+}
+
+// transcriptSeeds returns the message contents and stream events of the
+// recorded CLI output in testdata/transcripts, as JSON.
+func transcriptSeeds(f *testing.F) [][]byte {
+	f.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.ndjson"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	var seeds [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var line struct {
+				Message struct {
+					Content json.RawMessage `json:"content"`
+				} `json:"message"`
+				Event json.RawMessage `json:"event"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &line) != nil {
+				continue
+			}
+			for _, seed := range []json.RawMessage{line.Message.Content, line.Event} {
+				if len(seed) > 0 {
+					seeds = append(seeds, seed)
+				}
+			}
+		}
+	}
+	return seeds
+}
+
+func BenchmarkToAcpNotifications(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		oldContent, newContent := largeEditFixture(n)
+		b.Run(fmt.Sprintf("lines=%d/text", n), func(b *testing.B) {
+			content := []any{map[string]any{"type": "text", "text": newContent}}
+			for i := 0; i < b.N; i++ {
+				toAcpNotifications(content, "assistant", "s1", NewToolUseCache(0), nil)
+			}
+		})
+		b.Run(fmt.Sprintf("lines=%d/edit", n), func(b *testing.B) {
+			toolUse := []any{map[string]any{"type": "tool_use", "id": "t1", "name": "Edit", "input": map[string]any{
+				"file_path": "/work/big.go", "old_string": oldContent, "new_string": newContent,
+			}}}
+			toolResult := []any{map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": newContent}}
+			for i := 0; i < b.N; i++ {
+				cache := NewToolUseCache(0)
+				toAcpNotifications(toolUse, "assistant", "s1", cache, nil)
+				toAcpNotifications(toolResult, "user", "s1", cache, nil)
+			}
+		})
+	}
+}
+
+func FuzzToAcpNotifications(f *testing.F) {
+	for _, seed := range transcriptSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var content any
+		if json.Unmarshal(data, &content) != nil {
+			return
+		}
+		cache := NewToolUseCache(0)
+		parent := "toolu_parent"
+		for _, role := range []string{"assistant", "user"} {
+			for _, n := range toAcpNotifications(content, role, "s1", cache, &parent) {
+				if n.SessionId != "s1" {
+					t.Fatalf("notification for session %q", n.SessionId)
+				}
+			}
+		}
+		streamEventToAcpNotifications(map[string]any{"event": content}, "s1", cache, nil)
+	})
+}
+
+func FuzzParseUnifiedDiff(f *testing.F) {
+	diffs, _ := filepath.Glob(filepath.Join("testdata", "*.diff"))
+	for _, path := range diffs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+	f.Add(createUnifiedDiff("a.txt", "one\ntwo\n", "one\n2\nthree"))
+	f.Add("--- a\n+++ b\n@@ -1,2 +1 @@\n--- x\n-y\n+z\n")
+	f.Fuzz(func(t *testing.T, text string) {
+		for _, p := range parseUnifiedDiff(text) {
+			for _, h := range p.hunks {
+				if h.newStart < 1 {
+					t.Fatalf("hunk starts at line %d", h.newStart)
+				}
+				for _, line := range h.lines {
+					if line == "" || !strings.ContainsRune(" +-", rune(line[0])) {
+						t.Fatalf("hunk line %q", line)
+					}
+				}
+			}
+		}
+	})
+}
+
+func FuzzParseHunkHeader(f *testing.F) {
+	for _, seed := range []string{"@@ -1,3 +1,4 @@", "@@ -0,0 +1 @@ func main() {", "@@ -12 +15,0 @@", "@@ +", "@@", "@@ -1 +99999999999999999999 @@", "@@ -1,-5 +1 @@"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		if n := parseHunkHeader(line); n < 1 {
+			t.Fatalf("parseHunkHeader(%q) = %d", line, n)
+		}
+		if oldCount, newCount := parseHunkLineCounts(line); oldCount < 0 || newCount < 0 {
+			t.Fatalf("parseHunkLineCounts(%q) = %d, %d", line, oldCount, newCount)
+		}
+	})
+}
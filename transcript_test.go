@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the transcript tests")
+
+// transcriptResult is what a turn replaying a transcript sends the client,
+// as stored in a golden file.
+type transcriptResult struct {
+	Updates    []json.RawMessage `json:"updates"`
+	StopReason acp.StopReason    `json:"stopReason,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// TestTranscripts replays the recorded CLI output in testdata/transcripts
+// through a prompt turn and compares the session updates sent to the client
+// with the transcript's .golden.json file. Run with -update to rewrite the
+// golden files after an intended change in conversion.
+func TestTranscripts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no transcripts")
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".ndjson")
+		t.Run(name, func(t *testing.T) {
+			cliOutput, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(replayTranscript(t, cliOutput), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			golden := strings.TrimSuffix(path, ".ndjson") + ".golden.json"
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("updates differ from %s (run with -update to accept them):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// replayTranscript runs a prompt turn for a session whose CLI writes
+// cliOutput, collecting the session updates the client receives.
+func replayTranscript(t *testing.T, cliOutput []byte) transcriptResult {
+	t.Helper()
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	agent.sessions["s1"] = &Session{
+		cwd: "/work",
+		process: &ClaudeCodeProcess{
+			stdin:   nopWriteCloser{io.Discard},
+			decoder: newNDJSONDecoder(bytes.NewReader(cliOutput)),
+		},
+		toolUseCache: NewToolUseCache(0),
+	}
+
+	c2aR, c2aW := io.Pipe()
+	a2cR, a2cW := io.Pipe()
+	newAgentConnection(agent, a2cW, c2aR, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	collected := make(chan []json.RawMessage)
+	go func() {
+		var updates []json.RawMessage
+		scanner := bufio.NewScanner(a2cR)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var msg struct {
+				Method string `json:"method"`
+				Params struct {
+					Update json.RawMessage `json:"update"`
+				} `json:"params"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Method == acp.ClientMethodSessionUpdate {
+				updates = append(updates, msg.Params.Update)
+			}
+		}
+		collected <- updates
+	}()
+
+	resp, err := agent.Prompt(context.Background(), acp.PromptRequest{
+		SessionId: "s1",
+		Prompt:    []acp.ContentBlock{acp.TextBlock("hello")},
+	})
+	c2aW.Close()
+	a2cW.Close()
+	result := transcriptResult{Updates: <-collected, StopReason: resp.StopReason}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
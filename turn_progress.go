@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// turnProgressMethod is the extension notification sent while a turn goes
+// quiet, so clients can show that it is still running. Clients opt in by
+// advertising it in their capabilities' _meta.
+const turnProgressMethod = extMethodPrefix + "turn_progress"
+
+// turnProgressInterval is how long a turn is silent before, and between,
+// progress notifications.
+const turnProgressInterval = 5 * time.Second
+
+// Turn states reported by _claude/turn_progress.
+const (
+	turnStateThinking    = "thinking"
+	turnStateResponding  = "responding"
+	turnStateToolRunning = "tool_running"
+)
+
+// turnProgressParams is the payload of _claude/turn_progress.
+type turnProgressParams struct {
+	SessionID string `json:"sessionId"`
+	State     string `json:"state"`
+	ElapsedMs int64  `json:"elapsedMs"` // since the turn started
+	IdleMs    int64  `json:"idleMs"`    // since the CLI last sent a message
+}
+
+// turnProgress tracks what a turn is doing from the CLI's messages and
+// reports it while no updates flow.
+type turnProgress struct {
+	send    func(turnProgressParams)
+	started time.Time
+	stopped chan struct{}
+
+	mu    sync.Mutex
+	state string
+	last  time.Time
+}
+
+// startTurnProgress reports the progress of the session's turn until stop,
+// if the client asked for it; otherwise it returns nil, which does nothing.
+func (a *ClaudeAcpAgent) startTurnProgress(sessionID string, session *Session) *turnProgress {
+	if !a.clientSupportsExt(turnProgressMethod) {
+		return nil
+	}
+	return newTurnProgress(turnProgressInterval, func(p turnProgressParams) {
+		p.SessionID = sessionID
+		if err := a.sendExtNotification(turnProgressMethod, p); err != nil {
+			session.log().Debug("Failed to send turn progress", "error", err)
+		}
+	})
+}
+
+func newTurnProgress(interval time.Duration, send func(turnProgressParams)) *turnProgress {
+	now := time.Now()
+	p := &turnProgress{send: send, started: now, stopped: make(chan struct{}), state: turnStateThinking, last: now}
+	go p.run(interval)
+	return p
+}
+
+func (p *turnProgress) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			idle := now.Sub(p.last)
+			state := p.state
+			p.mu.Unlock()
+			if idle >= interval {
+				p.send(turnProgressParams{State: state, ElapsedMs: now.Sub(p.started).Milliseconds(), IdleMs: idle.Milliseconds()})
+			}
+		}
+	}
+}
+
+// observe notes a CLI message and the state it puts the turn in.
+func (p *turnProgress) observe(resp *SDKResponse) {
+	if p == nil {
+		return
+	}
+	state := turnStateAfter(resp.Raw())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = time.Now()
+	if state != "" {
+		p.state = state
+	}
+}
+
+// stop ends the reports with the turn.
+func (p *turnProgress) stop() {
+	if p != nil {
+		close(p.stopped)
+	}
+}
+
+// turnStateAfter returns the state a CLI message leaves the turn in, or ""
+// if it does not change it. The model thinks until it streams text; once
+// its message calls tools they run until their results come back.
+func turnStateAfter(msg map[string]any) string {
+	switch msg["type"] {
+	case "stream_event":
+		event, _ := msg["event"].(map[string]any)
+		if event["type"] != "content_block_start" {
+			return ""
+		}
+		block, _ := event["content_block"].(map[string]any)
+		switch block["type"] {
+		case "thinking", "redacted_thinking":
+			return turnStateThinking
+		default:
+			return turnStateResponding
+		}
+	case "assistant":
+		message, _ := msg["message"].(map[string]any)
+		content, _ := message["content"].([]any)
+		for _, block := range content {
+			if b, _ := block.(map[string]any); b["type"] == "tool_use" {
+				return turnStateToolRunning
+			}
+		}
+	case "user":
+		return turnStateThinking
+	}
+	return ""
+}
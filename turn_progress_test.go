@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTurnStateAfter(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}}`, turnStateThinking},
+		{`{"type":"stream_event","event":{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}}`, turnStateResponding},
+		{`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"hi"}}}`, ""},
+		{`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`, turnStateToolRunning},
+		{`{"type":"assistant","message":{"content":[{"type":"text","text":"done"}]}}`, ""},
+		{`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"t1"}]}}`, turnStateThinking},
+		{`{"type":"system","subtype":"init"}`, ""},
+	}
+	for _, tt := range tests {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := turnStateAfter(msg); got != tt.want {
+			t.Errorf("%s: state = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestTurnProgress(t *testing.T) {
+	sent := make(chan turnProgressParams, 16)
+	p := newTurnProgress(20*time.Millisecond, func(params turnProgressParams) { sent <- params })
+	defer p.stop()
+
+	p.observe(&SDKResponse{RawLine: json.RawMessage(`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`)})
+	select {
+	case params := <-sent:
+		if params.State != turnStateToolRunning || params.IdleMs < 20 || params.ElapsedMs < params.IdleMs {
+			t.Errorf("unexpected progress %+v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no progress sent while the turn was silent")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+// turnUsageMethod is the extension notification sent when a turn ends,
+// with the stop reason and token usage of its API calls.
+const turnUsageMethod = extMethodPrefix + "turn_usage"
+
+// tokenUsage counts the tokens of one or more API calls.
+type tokenUsage struct {
+	InputTokens              int `json:"inputTokens"`
+	CacheCreationInputTokens int `json:"cacheCreationInputTokens"`
+	CacheReadInputTokens     int `json:"cacheReadInputTokens"`
+	OutputTokens             int `json:"outputTokens"`
+}
+
+func (u *tokenUsage) set(usage map[string]any) {
+	setUsageField(usage, "input_tokens", &u.InputTokens)
+	setUsageField(usage, "cache_creation_input_tokens", &u.CacheCreationInputTokens)
+	setUsageField(usage, "cache_read_input_tokens", &u.CacheReadInputTokens)
+	setUsageField(usage, "output_tokens", &u.OutputTokens)
+}
+
+func (u *tokenUsage) add(o tokenUsage) {
+	u.InputTokens += o.InputTokens
+	u.CacheCreationInputTokens += o.CacheCreationInputTokens
+	u.CacheReadInputTokens += o.CacheReadInputTokens
+	u.OutputTokens += o.OutputTokens
+}
+
+// turnUsageParams is the payload of _claude/turn_usage.
+type turnUsageParams struct {
+	SessionID  string     `json:"sessionId"`
+	StopReason string     `json:"stopReason,omitempty"` // of the last API call
+	Messages   int        `json:"messages"`             // API calls made by the turn
+	Usage      tokenUsage `json:"usage"`
+}
+
+// turnUsage accounts for a turn from its streamed message_start and
+// message_delta events, which report usage as each API call runs rather
+// than only in the result message. message_delta usage is cumulative for
+// its call, so each call's latest counts are summed once the next starts.
+type turnUsage struct {
+	stopReason string
+	messages   int
+	done       tokenUsage // calls that have finished
+	current    tokenUsage // the call in progress
+}
+
+// observe applies a top-level stream event.
+func (t *turnUsage) observe(event map[string]any) {
+	switch event["type"] {
+	case "message_start":
+		t.done.add(t.current)
+		t.current = tokenUsage{}
+		t.messages++
+		msg, _ := event["message"].(map[string]any)
+		usage, _ := msg["usage"].(map[string]any)
+		t.current.set(usage)
+	case "message_delta":
+		if delta, ok := event["delta"].(map[string]any); ok {
+			if reason, ok := delta["stop_reason"].(string); ok {
+				t.stopReason = reason
+			}
+		}
+		usage, _ := event["usage"].(map[string]any)
+		t.current.set(usage)
+	}
+}
+
+// params returns the turn's accounting for the session.
+func (t *turnUsage) params(sessionID string) turnUsageParams {
+	usage := t.done
+	usage.add(t.current)
+	return turnUsageParams{SessionID: sessionID, StopReason: t.stopReason, Messages: t.messages, Usage: usage}
+}
+
+// meta returns the accounting as PromptResponse metadata.
+func (t *turnUsage) meta() map[string]any {
+	p := t.params("")
+	turn := map[string]any{"messages": p.Messages, "usage": p.Usage}
+	if p.StopReason != "" {
+		turn["stopReason"] = p.StopReason
+	}
+	return map[string]any{"claudeCode": map[string]any{"turn": turn}}
+}
+
+// sendTurnUsage reports the accounting of the session's finished turn.
+func (a *ClaudeAcpAgent) sendTurnUsage(sessionID string, session *Session, t *turnUsage) {
+	if err := a.sendExtNotification(turnUsageMethod, t.params(sessionID)); err != nil {
+		session.log().Warn("Failed to send turn usage", "error", err)
+	}
+}
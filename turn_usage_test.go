@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestTurnUsage(t *testing.T) {
+	var turn turnUsage
+	for _, event := range []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":10,"cache_read_input_tokens":3000,"output_tokens":1}}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":40}}`,
+		`{"type":"message_start","message":{"usage":{"input_tokens":5,"cache_read_input_tokens":3100,"output_tokens":1}}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_delta","usage":{"output_tokens":25}}`,
+	} {
+		turn.observe(decodeTestMap(t, event))
+	}
+	got := turn.params("s1")
+	want := turnUsageParams{SessionID: "s1", StopReason: "max_tokens", Messages: 2, Usage: tokenUsage{InputTokens: 15, CacheReadInputTokens: 6100, OutputTokens: 65}}
+	if got != want {
+		t.Errorf("params = %+v, want %+v", got, want)
+	}
+}
+
+func TestPrompt_TurnUsage(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{CoalesceWindow: -1})
+	var ext strings.Builder
+	agent.extOut = &ext
+	cliOutput := `{"type":"stream_event","event":{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}}` + "\n" +
+		`{"type":"stream_event","event":{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":40}}}` + "\n" +
+		`{"type":"result","subtype":"success"}` + "\n"
+	agent.sessions["s1"] = &Session{
+		process:      &ClaudeCodeProcess{stdin: nopWriteCloser{io.Discard}, decoder: newNDJSONDecoder(strings.NewReader(cliOutput))},
+		toolUseCache: NewToolUseCache(0),
+		updates:      newUpdateQueue(func(acp.SessionNotification) {}, 0),
+	}
+
+	resp, err := agent.Prompt(context.Background(), acp.PromptRequest{SessionId: "s1", Prompt: []acp.ContentBlock{acp.TextBlock("hello")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != acp.StopReasonMaxTokens {
+		t.Errorf("stop reason = %q, want the streamed max_tokens", resp.StopReason)
+	}
+	meta, _ := json.Marshal(resp.Meta)
+	if want := `{"claudeCode":{"turn":{"messages":1,"stopReason":"max_tokens","usage":{"inputTokens":10,"cacheCreationInputTokens":0,"cacheReadInputTokens":0,"outputTokens":40}},"turnId":1}}`; string(meta) != want {
+		t.Errorf("meta = %s, want %s", meta, want)
+	}
+	if !strings.Contains(ext.String(), `"method":"_claude/turn_usage"`) || !strings.Contains(ext.String(), `"outputTokens":40`) {
+		t.Errorf("expected a turn_usage notification, got %s", ext.String())
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// turnInterruptGrace is how long an idle turn has to end after it is
+// interrupted before the CLI is stopped.
+const turnInterruptGrace = 30 * time.Second
+
+// turnWatchdog ends turns whose CLI goes quiet: once no message has been
+// read for the timeout it warns the client and interrupts the turn, and if
+// the turn still does not end, stops the CLI. Time spent waiting for the
+// user to answer a permission request, or for a built-in tool call, does
+// not count.
+type turnWatchdog struct {
+	timeout time.Duration
+	idle    func() // warns and interrupts the turn
+	stuck   func() // stops the CLI
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	paused int
+	fired  bool
+	done   bool
+}
+
+// newTurnWatchdog starts a watchdog; a timeout of zero or less returns nil,
+// which does nothing.
+func newTurnWatchdog(timeout time.Duration, idle, stuck func()) *turnWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &turnWatchdog{timeout: timeout, idle: idle, stuck: stuck}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = time.AfterFunc(timeout, w.expire)
+	return w
+}
+
+func (w *turnWatchdog) expire() {
+	w.mu.Lock()
+	if w.done || w.fired {
+		w.mu.Unlock()
+		return
+	}
+	if w.paused > 0 {
+		w.timer.Reset(w.timeout)
+		w.mu.Unlock()
+		return
+	}
+	w.fired = true
+	w.timer = time.AfterFunc(turnInterruptGrace, func() {
+		w.mu.Lock()
+		done := w.done
+		w.mu.Unlock()
+		if !done {
+			w.stuck()
+		}
+	})
+	w.mu.Unlock()
+	w.idle()
+}
+
+// activity restarts the idle timeout after a CLI message.
+func (w *turnWatchdog) activity() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.fired && !w.done {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// pause suspends the timeout until the matching resume, while the turn
+// waits on the user or a built-in tool.
+func (w *turnWatchdog) pause() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused++
+}
+
+func (w *turnWatchdog) resume() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused--
+	if w.paused == 0 && !w.fired && !w.done {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// expired reports whether the turn was interrupted for being idle.
+func (w *turnWatchdog) expired() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fired
+}
+
+// stop ends the watchdog with the turn.
+func (w *turnWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	w.timer.Stop()
+}
+
+// startTurnWatchdog watches the session's running turn, if the agent has
+// a turn idle timeout.
+func (a *ClaudeAcpAgent) startTurnWatchdog(sessionID string, session *Session, out *notificationCoalescer) *turnWatchdog {
+	timeout := a.opts.TurnIdleTimeout
+	w := newTurnWatchdog(timeout, func() {
+		session.log().Warn("Turn idle, interrupting it", "timeout", timeout)
+		out.Push(acp.SessionNotification{
+			SessionId: acp.SessionId(sessionID),
+			Update:    acp.UpdateAgentMessageText(fmt.Sprintf("⚠️ Claude Code produced no output for %s; interrupting the turn.\n\n", timeout)),
+		})
+		if err := session.proc().Interrupt(); err != nil {
+			session.log().Warn("Interrupting idle turn failed", "error", err)
+		}
+	}, func() {
+		session.log().Warn("Idle turn did not end after the interrupt, stopping the CLI")
+		_ = session.proc().Close()
+	})
+	session.mu.Lock()
+	session.watchdog = w
+	session.mu.Unlock()
+	return w
+}
+
+// runningWatchdog returns the watchdog of the session's running turn, or
+// nil.
+func (s *Session) runningWatchdog() *turnWatchdog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watchdog
+}
+
+// stopWatchdog stops the watchdog of the turn that is ending.
+func (s *Session) stopWatchdog() {
+	s.mu.Lock()
+	w := s.watchdog
+	s.watchdog = nil
+	s.mu.Unlock()
+	w.stop()
+}
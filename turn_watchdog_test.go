@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// idleProcess is a CLI that sends nothing until interrupted, then ends the
+// turn.
+type idleProcess struct {
+	msgs       chan *SDKResponse
+	done       chan struct{}
+	interrupts int
+	mu         sync.Mutex
+}
+
+func newIdleProcess() *idleProcess {
+	return &idleProcess{msgs: make(chan *SDKResponse, 1), done: make(chan struct{})}
+}
+
+func (p *idleProcess) SendMessage(SDKUserMessage) error { return nil }
+func (p *idleProcess) Done() <-chan struct{}            { return p.done }
+func (p *idleProcess) Close() error                     { return nil }
+
+func (p *idleProcess) ReadMessage() (*SDKResponse, error) {
+	return <-p.msgs, nil
+}
+
+func (p *idleProcess) Interrupt() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interrupts++
+	p.msgs <- &SDKResponse{Type: "result", Subtype: "error_during_execution"}
+	return nil
+}
+
+func TestPrompt_TurnIdleTimeout(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{TurnIdleTimeout: 50 * time.Millisecond, CoalesceWindow: -1})
+	proc := newIdleProcess()
+	var mu sync.Mutex
+	var updates []acp.SessionNotification
+	agent.sessions["s1"] = &Session{
+		process:      proc,
+		toolUseCache: NewToolUseCache(0),
+		updates: newUpdateQueue(func(n acp.SessionNotification) {
+			mu.Lock()
+			defer mu.Unlock()
+			updates = append(updates, n)
+		}, 0),
+	}
+
+	_, err := agent.Prompt(context.Background(), acp.PromptRequest{SessionId: "s1", Prompt: []acp.ContentBlock{acp.TextBlock("hello")}})
+	var reqErr *acp.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Data.(errorData).Kind != errKindTurnTimeout {
+		t.Fatalf("expected a turn timeout, got %v", err)
+	}
+	if proc.interrupts != 1 {
+		t.Errorf("expected the turn to be interrupted once, got %d", proc.interrupts)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updates) == 0 || !strings.Contains(updates[0].Update.AgentMessageChunk.Content.Text.Text, "no output for 50ms") {
+		t.Errorf("expected a warning update, got %+v", updates)
+	}
+}
+
+func TestTurnWatchdog_ActivityAndPause(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	w := newTurnWatchdog(30*time.Millisecond, func() { fired <- struct{}{} }, func() {})
+	defer w.stop()
+
+	// Activity and a pending permission request keep the turn alive.
+	for range 4 {
+		time.Sleep(10 * time.Millisecond)
+		w.activity()
+	}
+	w.pause()
+	time.Sleep(60 * time.Millisecond)
+	if w.expired() {
+		t.Fatal("watchdog fired while paused")
+	}
+	w.resume()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not fire once idle")
+	}
+	if !w.expired() {
+		t.Error("expected the watchdog to report expiry")
+	}
+
+	if newTurnWatchdog(0, nil, nil) != nil {
+		t.Error("expected no watchdog without a timeout")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// Session update delivery. A failed write is retried a few times, in case
+// the transport hiccuped; once maxUpdateFailures updates of a session in a
+// row are lost the client is taken to be unreachable and its turn ends.
+const (
+	updateAttempts     = 4
+	updateRetryBackoff = 25 * time.Millisecond // doubled after each attempt
+	maxUpdateFailures  = 3
+)
+
+// deliverUpdate records n, an update of s, for export and mirrors it to
+// the session's viewers, then sends it to the client, retrying with
+// backoff. Updates that still fail are dropped and counted towards s's
+// clientUnreachable.
+func (a *ClaudeAcpAgent) deliverUpdate(s *Session, n acp.SessionNotification) {
+	a.recordUpdate(n)
+	a.mirrorUpdate(n)
+	backoff := updateRetryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = a.conn.SessionUpdate(context.Background(), n); err == nil {
+			s.updateFailures.Store(0)
+			return
+		}
+		if attempt == updateAttempts || a.connClosed() {
+			break
+		}
+		a.logger.Debug("Retrying session update", "session", n.SessionId, "attempt", attempt, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	failures := s.updateFailures.Add(1)
+	a.logger.Warn("Dropped session update", "session", n.SessionId, "consecutiveFailures", failures, "error", err)
+}
+
+// clientUnreachable reports whether the session's latest updates could
+// not be delivered.
+func (s *Session) clientUnreachable() bool {
+	return s.updateFailures.Load() >= maxUpdateFailures
+}
+
+// connClosed reports whether the client connection has closed, so retries
+// are pointless.
+func (a *ClaudeAcpAgent) connClosed() bool {
+	if a.conn == nil {
+		return false
+	}
+	select {
+	case <-a.conn.Done():
+		return true
+	default:
+		return false
+	}
+}
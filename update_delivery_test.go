@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// flakyWriter fails the next fails writes.
+type flakyWriter struct {
+	mu     sync.Mutex
+	fails  int
+	writes int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fails > 0 {
+		w.fails--
+		return 0, errors.New("transport hiccup")
+	}
+	w.writes++
+	return len(p), nil
+}
+
+func TestDeliverUpdate(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	w := &flakyWriter{fails: updateAttempts - 1}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	agent.SetAgentConnection(acp.NewAgentSideConnection(agent, w, pr))
+	session, other := &Session{}, &Session{}
+	agent.sessions["s1"] = session
+	n := acp.SessionNotification{SessionId: "s1", Update: acp.UpdateAgentMessageText("hi")}
+
+	agent.deliverUpdate(session, n)
+	if w.writes != 1 || session.updateFailures.Load() != 0 {
+		t.Fatalf("expected the update to be delivered on the last attempt, got %d writes and %d failures", w.writes, session.updateFailures.Load())
+	}
+	// Retries resend the update but record it only once.
+	if updates, _ := session.history.snapshot(); len(updates) != 1 {
+		t.Errorf("expected the update recorded once, got %d", len(updates))
+	}
+
+	w.fails = 100
+	for i := 1; i <= maxUpdateFailures; i++ {
+		if session.clientUnreachable() {
+			t.Fatalf("client unreachable after %d failed updates", i-1)
+		}
+		agent.deliverUpdate(session, n)
+	}
+	if !session.clientUnreachable() {
+		t.Errorf("expected the client to be unreachable after %d failed updates", maxUpdateFailures)
+	}
+	if other.clientUnreachable() {
+		t.Error("expected other sessions to be unaffected")
+	}
+
+	w.fails = 0
+	agent.deliverUpdate(session, n)
+	if session.clientUnreachable() {
+		t.Error("expected a delivered update to reset the failures")
+	}
+}
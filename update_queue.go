@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+// DefaultUpdateQueueSize is how many session updates may wait to be written
+// to a connection by default.
+const DefaultUpdateQueueSize = 256
+
+// updateQueue decouples a session's updates from the turns producing them,
+// so a slow client does not stall reading the CLI's output, and a backlog
+// in one session does not hold up the others. One writer goroutine runs
+// while updates are queued, delivering them in order. Once the queue is half
+// full, text chunks are merged into a queued text chunk they follow; once
+// it is full, thought chunks are dropped and other updates wait for room.
+type updateQueue struct {
+	send  func(acp.SessionNotification)
+	limit int
+
+	mu      sync.Mutex
+	items   []acp.SessionNotification
+	writing bool          // the writer goroutine is running
+	space   chan struct{} // closed when an update is taken off the queue
+	idle    chan struct{} // closed when the writer has written every update
+	stats   updateQueueStats
+}
+
+// updateQueueStats counts the updates a queue has handled.
+type updateQueueStats struct {
+	Queued  int `json:"queued"`  // waiting to be written
+	Written int `json:"written"` // sent to the client
+	Merged  int `json:"merged"`  // text chunks merged into a queued chunk
+	Dropped int `json:"dropped"` // thought chunks, and updates abandoned on cancellation
+}
+
+// newUpdateQueue creates a queue writing through send. A limit of zero or
+// less uses DefaultUpdateQueueSize.
+func newUpdateQueue(send func(acp.SessionNotification), limit int) *updateQueue {
+	if limit <= 0 {
+		limit = DefaultUpdateQueueSize
+	}
+	return &updateQueue{send: send, limit: limit, space: make(chan struct{}), idle: make(chan struct{})}
+}
+
+// push queues n for writing. It blocks while the queue is full and n can be
+// neither merged nor dropped, until there is room or ctx is done.
+func (q *updateQueue) push(ctx context.Context, n acp.SessionNotification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.items) >= q.limit/2 && q.mergeLocked(n) {
+			q.stats.Merged++
+			return
+		}
+		if len(q.items) < q.limit {
+			break
+		}
+		if n.Update.AgentThoughtChunk != nil {
+			q.stats.Dropped++
+			return
+		}
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-space:
+			q.mu.Lock()
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.stats.Dropped++
+			return
+		}
+	}
+	q.items = append(q.items, n)
+	if !q.writing {
+		q.writing = true
+		go q.write()
+	}
+}
+
+// mergeLocked appends n's text to the last queued update if both are text
+// chunks of the same kind for the same session.
+func (q *updateQueue) mergeLocked(n acp.SessionNotification) bool {
+	if len(q.items) == 0 {
+		return false
+	}
+	last := &q.items[len(q.items)-1]
+	text, thought, ok := coalescableText(n)
+	if !ok || last.SessionId != n.SessionId {
+		return false
+	}
+	lastText, lastThought, ok := coalescableText(*last)
+	if !ok || lastThought != thought || last.Meta != n.Meta {
+		return false
+	}
+	last.Update = acp.UpdateAgentMessageText(lastText + text)
+	if thought {
+		last.Update = acp.UpdateAgentThoughtText(lastText + text)
+	}
+	return true
+}
+
+// write sends the queued updates in order, returning once the queue is
+// empty.
+func (q *updateQueue) write() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.writing = false
+			close(q.idle)
+			q.idle = make(chan struct{})
+			q.mu.Unlock()
+			return
+		}
+		n := q.items[0]
+		q.items[0] = acp.SessionNotification{}
+		q.items = q.items[1:]
+		close(q.space)
+		q.space = make(chan struct{})
+		q.mu.Unlock()
+
+		q.send(n)
+
+		q.mu.Lock()
+		q.stats.Written++
+		q.mu.Unlock()
+	}
+}
+
+// wait blocks until every queued update has been written, or ctx is done.
+func (q *updateQueue) wait(ctx context.Context) {
+	q.mu.Lock()
+	if !q.writing {
+		q.mu.Unlock()
+		return
+	}
+	idle := q.idle
+	q.mu.Unlock()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+}
+
+// sessionUpdates returns the queue delivering the session's updates,
+// creating it on first use.
+func (a *ClaudeAcpAgent) sessionUpdates(s *Session) *updateQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.updates == nil {
+		s.updates = newUpdateQueue(func(n acp.SessionNotification) {
+			a.deliverUpdate(s, n)
+		}, a.opts.UpdateQueueSize)
+	}
+	return s.updates
+}
+
+// snapshot returns the queue's counters.
+func (q *updateQueue) snapshot() updateQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Queued = len(q.items)
+	return stats
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestUpdateQueue_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent []acp.SessionNotification
+	q := newUpdateQueue(func(n acp.SessionNotification) {
+		<-release
+		mu.Lock()
+		sent = append(sent, n)
+		mu.Unlock()
+	}, 4)
+	ctx := context.Background()
+	update := func(u acp.SessionUpdate) acp.SessionNotification {
+		return acp.SessionNotification{SessionId: "s1", Update: u}
+	}
+
+	// The first update is taken by the writer, which blocks on release.
+	q.push(ctx, update(acp.UpdateAgentMessageText("start")))
+	for q.snapshot().Queued != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	q.push(ctx, update(acp.StartToolCall("t1", "Read")))
+	q.push(ctx, update(acp.UpdateAgentMessageText("a")))
+	q.push(ctx, update(acp.UpdateAgentMessageText("b"))) // half full: merged
+	q.push(ctx, update(acp.UpdateAgentThoughtText("hm")))
+	q.push(ctx, update(acp.StartToolCall("t2", "Read")))
+	q.push(ctx, update(acp.UpdateAgentThoughtText("dropped"))) // full
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	q.push(cancelled, update(acp.StartToolCall("t3", "Read"))) // full, abandoned
+
+	stats := q.snapshot()
+	if stats != (updateQueueStats{Queued: 4, Merged: 1, Dropped: 2}) {
+		t.Errorf("stats: got %+v", stats)
+	}
+
+	close(release)
+	q.wait(ctx)
+	want := []string{"start", "t1", "ab", "hm", "t2"}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d updates, want %d", len(sent), len(want))
+	}
+	for i, n := range sent {
+		var got string
+		switch u := n.Update; {
+		case u.AgentMessageChunk != nil:
+			got = u.AgentMessageChunk.Content.Text.Text
+		case u.AgentThoughtChunk != nil:
+			got = u.AgentThoughtChunk.Content.Text.Text
+		case u.ToolCall != nil:
+			got = string(u.ToolCall.ToolCallId)
+		}
+		if got != want[i] {
+			t.Errorf("update %d: got %q, want %q", i, got, want[i])
+		}
+	}
+	if stats := q.snapshot(); stats.Written != 5 || stats.Queued != 0 {
+		t.Errorf("after writing: got %+v", stats)
+	}
+}
+
+func TestSessionUpdates_PerSession(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	s1, s2 := &Session{}, &Session{}
+	q1 := agent.sessionUpdates(s1)
+	if agent.sessionUpdates(s1) != q1 || agent.sessionUpdates(s2) == q1 {
+		t.Fatal("expected one queue per session")
+	}
+
+	// A session whose client writes are stuck does not hold up another's.
+	release := make(chan struct{})
+	q1.send = func(acp.SessionNotification) { <-release }
+	var sent []acp.SessionNotification
+	q2 := agent.sessionUpdates(s2)
+	q2.send = func(n acp.SessionNotification) { sent = append(sent, n) }
+	ctx := context.Background()
+	for range DefaultUpdateQueueSize + 1 {
+		q1.push(ctx, acp.SessionNotification{SessionId: "s1", Update: acp.StartToolCall("t", "Read")})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q2.push(ctx, acp.SessionNotification{SessionId: "s2", Update: acp.UpdateAgentMessageText("hi")})
+		q2.wait(ctx)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session s2 blocked behind s1")
+	}
+	close(release)
+	q1.wait(ctx)
+	if len(sent) != 1 {
+		t.Errorf("expected s2's update to be sent, got %v", sent)
+	}
+}
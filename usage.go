@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// defaultContextWindow is assumed until the CLI reports the model's context
+// window in a result message.
+const defaultContextWindow = 200_000
+
+// extendedContextWindow applies to models selected with the "[1m]" suffix.
+const extendedContextWindow = 1_000_000
+
+// contextUsage is the payload of the _claude/context_usage notification.
+// Used counts the tokens occupied by the most recent API call: its input,
+// cached input and output.
+type contextUsage struct {
+	SessionID                string `json:"sessionId"`
+	Model                    string `json:"model,omitempty"`
+	Used                     int    `json:"used"`
+	Size                     int    `json:"size"`
+	InputTokens              int    `json:"inputTokens"`
+	CacheCreationInputTokens int    `json:"cacheCreationInputTokens"`
+	CacheReadInputTokens     int    `json:"cacheReadInputTokens"`
+	OutputTokens             int    `json:"outputTokens"`
+}
+
+// usageTracker accumulates token usage reported by the CLI for one session.
+type usageTracker struct {
+	mu      sync.Mutex
+	usage   contextUsage
+	windows map[string]int // context window per model, from result messages
+}
+
+// update applies a usage object from message_start, message_delta or an
+// assistant message. It reports whether the tracked usage changed.
+func (u *usageTracker) update(usage map[string]any, model string) bool {
+	if usage == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	prev := u.usage
+	if model != "" {
+		u.usage.Model = model
+	}
+	setUsageField(usage, "input_tokens", &u.usage.InputTokens)
+	setUsageField(usage, "cache_creation_input_tokens", &u.usage.CacheCreationInputTokens)
+	setUsageField(usage, "cache_read_input_tokens", &u.usage.CacheReadInputTokens)
+	setUsageField(usage, "output_tokens", &u.usage.OutputTokens)
+	return u.usage != prev
+}
+
+// updateModelUsage records the context windows from a result message's
+// modelUsage map. It reports whether the current model's window changed.
+func (u *usageTracker) updateModelUsage(modelUsage map[string]any) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	before := u.contextWindow()
+	for model, v := range modelUsage {
+		m, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if w, ok := m["contextWindow"].(float64); ok && w > 0 {
+			if u.windows == nil {
+				u.windows = map[string]int{}
+			}
+			u.windows[model] = int(w)
+		}
+	}
+	return u.contextWindow() != before
+}
+
+// reset forgets the usage of the previous conversation. Context windows
+// reported for each model are kept.
+func (u *usageTracker) reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.usage = contextUsage{Model: u.usage.Model}
+}
+
+// snapshot returns the current usage with Used and Size filled in.
+func (u *usageTracker) snapshot(sessionID string) contextUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.usage
+	s.SessionID = sessionID
+	s.Used = s.InputTokens + s.CacheCreationInputTokens + s.CacheReadInputTokens + s.OutputTokens
+	s.Size = u.contextWindow()
+	return s
+}
+
+// contextWindow returns the window of the current model. Callers hold mu.
+func (u *usageTracker) contextWindow() int {
+	if w, ok := u.windows[u.usage.Model]; ok {
+		return w
+	}
+	if len(u.windows) == 1 && u.usage.Model == "" {
+		for _, w := range u.windows {
+			return w
+		}
+	}
+	if strings.HasSuffix(strings.ToLower(u.usage.Model), "[1m]") {
+		return extendedContextWindow
+	}
+	return defaultContextWindow
+}
+
+// setUsageField copies a token count from usage into dst when present.
+// message_delta only carries output_tokens, so absent fields keep their
+// value from message_start.
+func setUsageField(usage map[string]any, key string, dst *int) {
+	if v, ok := usage[key].(float64); ok {
+		*dst = int(v)
+	}
+}
+
+// streamEventUsage extracts the usage object and model from a
+// message_start or message_delta stream event.
+func streamEventUsage(event map[string]any) (usage map[string]any, model string) {
+	switch event["type"] {
+	case "message_start":
+		msg, _ := event["message"].(map[string]any)
+		usage, _ = msg["usage"].(map[string]any)
+		model, _ = msg["model"].(string)
+	case "message_delta":
+		usage, _ = event["usage"].(map[string]any)
+	}
+	return usage, model
+}
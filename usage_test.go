@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeTestMap(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUsageTracker_StreamEvents(t *testing.T) {
+	var u usageTracker
+	start := decodeTestMap(t, `{"type":"message_start","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":10,"cache_creation_input_tokens":200,"cache_read_input_tokens":3000,"output_tokens":1}}}`)
+	delta := decodeTestMap(t, `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":40}}`)
+
+	for _, event := range []map[string]any{start, delta} {
+		usage, model := streamEventUsage(event)
+		if !u.update(usage, model) {
+			t.Fatalf("expected %v to change usage", event["type"])
+		}
+	}
+	got := u.snapshot("s1")
+	if got.Used != 3250 || got.Size != defaultContextWindow || got.Model != "claude-sonnet-4-5" || got.SessionID != "s1" {
+		t.Errorf("unexpected snapshot: %+v", got)
+	}
+
+	usage, model := streamEventUsage(delta)
+	if u.update(usage, model) {
+		t.Error("repeated usage reported as a change")
+	}
+}
+
+func TestUsageTracker_ContextWindow(t *testing.T) {
+	var u usageTracker
+	u.update(map[string]any{"input_tokens": float64(5)}, "claude-sonnet-4-5[1m]")
+	if got := u.snapshot("s").Size; got != extendedContextWindow {
+		t.Errorf("size = %d, want %d", got, extendedContextWindow)
+	}
+
+	modelUsage := decodeTestMap(t, `{"claude-sonnet-4-5[1m]":{"inputTokens":5,"contextWindow":500000},"claude-haiku-4-5":{"contextWindow":200000}}`)
+	if !u.updateModelUsage(modelUsage) {
+		t.Error("expected reported context window to change size")
+	}
+	if got := u.snapshot("s").Size; got != 500000 {
+		t.Errorf("size = %d, want 500000", got)
+	}
+	if u.updateModelUsage(modelUsage) {
+		t.Error("unchanged context window reported as a change")
+	}
+}
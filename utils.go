@@ -37,8 +37,9 @@ func extractLinesWithByteLimit(fullContent string, maxContentLength int) Extract
 		nextIndex := strings.Index(fullContent[index:], "\n")
 
 		if nextIndex < 0 {
-			// Last line in file (no trailing newline)
-			if linesSeen > 0 && len(fullContent) > maxContentLength {
+			// Last line in file (no trailing newline). The empty line after
+			// a final newline adds nothing, so it is never limited.
+			if linesSeen > 0 && index < len(fullContent) && len(fullContent) > maxContentLength {
 				wasLimited = true
 				break
 			}
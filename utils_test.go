@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -182,3 +183,45 @@ func TestMarkdownEscape_NoTrailingNewline(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, result)
 	}
 }
+
+func BenchmarkExtractLinesWithByteLimit(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		content, _ := largeEditFixture(n)
+		for _, limit := range []int{MaxFileSize, len(content) / 2} {
+			b.Run(fmt.Sprintf("lines=%d/limit=%d", n, limit), func(b *testing.B) {
+				b.SetBytes(int64(len(content)))
+				for i := 0; i < b.N; i++ {
+					extractLinesWithByteLimit(content, limit)
+				}
+			})
+		}
+	}
+}
+
+func FuzzExtractLinesWithByteLimit(f *testing.F) {
+	f.Add("line 1\nline 2\nline 3\n", 10)
+	f.Add("no newline", 3)
+	f.Add("\n\n\n", 0)
+	f.Add("first\r\nsecond", -1)
+	f.Fuzz(func(t *testing.T, content string, limit int) {
+		r := extractLinesWithByteLimit(content, limit)
+		if !strings.HasPrefix(content, r.Content) {
+			t.Fatalf("content %q is not a prefix of the input", r.Content)
+		}
+		if r.WasLimited == (r.Content == content) && content != "" {
+			t.Fatalf("WasLimited = %v for %d of %d bytes", r.WasLimited, len(r.Content), len(content))
+		}
+		if r.WasLimited && r.LinesRead > 1 && len(r.Content) > limit {
+			t.Fatalf("%d bytes past the limit of %d", len(r.Content), limit)
+		}
+		// Unless limited, the text after the last newline counts as a line
+		// even when empty.
+		want := strings.Count(r.Content, "\n")
+		if !r.WasLimited {
+			want++
+		}
+		if r.LinesRead != want {
+			t.Fatalf("LinesRead = %d for %q", r.LinesRead, r.Content)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty are filled from the Go build info where available.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// defaultVersion is reported when neither ldflags nor the module version
+// identify the release.
+const defaultVersion = "0.1.0"
+
+// versionString describes the running binary, e.g.
+// "1.2.3 (commit 0a1b2c3d4e5f, built 2025-01-02T03:04:05Z)".
+func versionString() string {
+	return formatVersion(version, commit, buildDate, readBuildInfo())
+}
+
+func readBuildInfo() *debug.BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	return info
+}
+
+func formatVersion(v, rev, date string, info *debug.BuildInfo) string {
+	modified := false
+	if info != nil {
+		// Untagged builds get "(devel)" or a v0.0.0 pseudo-version, which
+		// says less than the default plus the commit below.
+		mv := strings.TrimSuffix(info.Main.Version, "+dirty")
+		if v == "" && mv != "" && mv != "(devel)" && !strings.HasPrefix(mv, "v0.0.0-") {
+			v = strings.TrimPrefix(mv, "v")
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = s.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = defaultVersion
+	}
+
+	var details []string
+	if rev != "" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if modified {
+			rev += "-dirty"
+		}
+		details = append(details, "commit "+rev)
+	}
+	if date != "" {
+		details = append(details, "built "+date)
+	}
+	if len(details) == 0 {
+		return v
+	}
+	return fmt.Sprintf("%s (%s)", v, strings.Join(details, ", "))
+}
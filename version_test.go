@@ -0,0 +1,38 @@
+package main
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestFormatVersion(t *testing.T) {
+	vcs := &debug.BuildInfo{
+		Main: debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2025-01-02T03:04:05Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		v, rev, date string
+		info         *debug.BuildInfo
+		want         string
+	}{
+		{"nothing known", "", "", "", nil, "0.1.0"},
+		{"ldflags only", "1.2.3", "abc", "2025-06-01", nil, "1.2.3 (commit abc, built 2025-06-01)"},
+		{"build info", "", "", "", vcs, "0.1.0 (commit 0123456789ab-dirty, built 2025-01-02T03:04:05Z)"},
+		{"ldflags win", "1.2.3", "feed", "", vcs, "1.2.3 (commit feed-dirty, built 2025-01-02T03:04:05Z)"},
+		{"module version", "", "", "", &debug.BuildInfo{Main: debug.Module{Version: "v0.4.0"}}, "0.4.0"},
+		{"pseudo version", "", "", "", &debug.BuildInfo{Main: debug.Module{Version: "v0.0.0-20250102030405-0123456789ab+dirty"}}, "0.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatVersion(tt.v, tt.rev, tt.date, tt.info); got != tt.want {
+				t.Errorf("formatVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
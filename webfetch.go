@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// webFetchMethod is the client extension request fetching a URL. Clients
+// advertise it in their capabilities' _meta to fetch through their own
+// network stack; without it, WebFetch fetches from the agent.
+const webFetchMethod = extMethodPrefix + "web/fetch"
+
+// WebFetch limits.
+const (
+	webFetchTimeout      = 30 * time.Second
+	maxWebFetchBytes     = 10 << 20
+	maxWebFetchRedirects = 10
+)
+
+// webFetchParams is the payload of _claude/web/fetch.
+type webFetchParams struct {
+	SessionID string `json:"sessionId"`
+	URL       string `json:"url"`
+}
+
+// webFetchResult is a fetched page, and the client's answer to
+// _claude/web/fetch. URL is the page's final URL after redirects; Location
+// is set for a redirect to another host, which is not followed.
+type webFetchResult struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body"`
+	Location    string `json:"location,omitempty"`
+}
+
+// webFetcher fetches a URL.
+type webFetcher func(ctx context.Context, rawURL string) (webFetchResult, error)
+
+// clientWebFetcher returns a fetcher for the session that asks the client,
+// or nil if the client cannot fetch.
+func (a *ClaudeAcpAgent) clientWebFetcher(sessionID string) webFetcher {
+	if !a.clientSupportsExt(webFetchMethod) {
+		return nil
+	}
+	return func(ctx context.Context, rawURL string) (webFetchResult, error) {
+		var result webFetchResult
+		err := a.callClientExt(ctx, webFetchMethod, webFetchParams{SessionID: sessionID, URL: rawURL}, &result)
+		return result, err
+	}
+}
+
+// httpWebFetcher returns a fetcher that fetches from the agent through the
+// given proxies, or those of the environment where unset. Redirects are
+// followed within the host, and to or from its www. subdomain, only, so
+// the permission rules for a URL cannot be escaped through a redirect.
+func httpWebFetcher(proxy ProxySettings) webFetcher {
+	cfg := httpproxy.FromEnvironment()
+	if proxy.HTTPProxy != "" {
+		cfg.HTTPProxy = proxy.HTTPProxy
+	}
+	if proxy.HTTPSProxy != "" {
+		cfg.HTTPSProxy = proxy.HTTPSProxy
+	}
+	if proxy.NoProxy != "" {
+		cfg.NoProxy = proxy.NoProxy
+	}
+	proxyFunc := cfg.ProxyFunc()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) { return proxyFunc(req.URL) }
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   webFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebFetchRedirects)
+			}
+			if strings.TrimPrefix(req.URL.Hostname(), "www.") != strings.TrimPrefix(via[0].URL.Hostname(), "www.") {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	return func(ctx context.Context, rawURL string) (webFetchResult, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return webFetchResult{}, err
+		}
+		req.Header.Set("Accept", "text/markdown, text/html, text/plain;q=0.9, */*;q=0.8")
+		req.Header.Set("User-Agent", "claude-code-acp/"+strings.Fields(versionString())[0])
+		resp, err := client.Do(req)
+		if err != nil {
+			return webFetchResult{}, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebFetchBytes+1))
+		if err != nil {
+			return webFetchResult{}, err
+		}
+		if len(body) > maxWebFetchBytes {
+			return webFetchResult{}, fmt.Errorf("the response is larger than %s", formatByteSize(maxWebFetchBytes))
+		}
+		result := webFetchResult{
+			URL:         resp.Request.URL.String(),
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        string(body),
+		}
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+			if loc, err := resp.Location(); err == nil {
+				result.Location = loc.String()
+			}
+		}
+		return result, nil
+	}
+}
+
+// handleWebFetch fetches a URL and returns its content as markdown. The
+// URL must not be denied by the session's WebFetch rules.
+func handleWebFetch(ctx context.Context, input map[string]any, opts BuiltinToolOptions) (string, bool, error) {
+	rawURL := inputStr(input, "url")
+	if rawURL == "" {
+		return "url is required", true, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Sprintf("Invalid URL %q: only absolute http and https URLs can be fetched", rawURL), true, nil
+	}
+	if opts.settings != nil {
+		check := opts.settings.CheckPermission(ACPToolNames.WebFetch, map[string]any{"url": rawURL})
+		if check.Decision == PermissionDeny {
+			return fmt.Sprintf("Fetching %s is denied by the rule %q", rawURL, check.Rule), true, nil
+		}
+	}
+	fetch := opts.fetch
+	if fetch == nil {
+		fetch = httpWebFetcher(ProxySettings{})
+	}
+	result, err := fetch(ctx, rawURL)
+	if err != nil {
+		return "Fetching URL failed: " + err.Error(), true, nil
+	}
+	if result.Location != "" {
+		return fmt.Sprintf("The URL redirects to a different host.\nOriginal URL: %s\nRedirect URL: %s\nCall WebFetch again with the redirect URL to fetch its content.", rawURL, result.Location), false, nil
+	}
+	if result.Status >= 400 {
+		return fmt.Sprintf("Fetching URL failed: %d %s", result.Status, http.StatusText(result.Status)), true, nil
+	}
+	content, err := webFetchContent(result)
+	if err != nil {
+		return "Fetching URL failed: " + err.Error(), true, nil
+	}
+	if result.URL != "" && result.URL != rawURL {
+		content = fmt.Sprintf("Redirected to %s\n\n%s", result.URL, content)
+	}
+	return truncateText(content, opts.readLimit()), false, nil
+}
+
+// webFetchContent returns a fetched page as text for the model: HTML
+// converted to markdown, other text as is.
+func webFetchContent(result webFetchResult) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(result.ContentType)
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType([]byte(result.Body)))
+	}
+	body := strings.ToValidUTF8(result.Body, string(utf8.RuneError))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		base, _ := url.Parse(result.URL)
+		return htmlToMarkdown(body, base)
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"), mediaType == "application/javascript":
+		return body, nil
+	default:
+		return "", errors.New("unsupported content type " + mediaType)
+	}
+}
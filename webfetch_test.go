@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	acp "github.com/coder/acp-go-sdk"
+)
+
+func TestHandleWebFetch_HTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><head><title>T</title><script>x()</script></head><body><h1>Docs</h1><p>See <a href="/api">the API</a>.</p></body></html>`)
+		case "/old":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, "https://example.com/", http.StatusFound)
+		case "/big":
+			w.Write(make([]byte, maxWebFetchBytes+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	opts := BuiltinToolOptions{fetch: httpWebFetcher(ProxySettings{})}
+	fetch := func(path string) (string, bool) {
+		out, isErr, _ := handleWebFetch(context.Background(), map[string]any{"url": srv.URL + path}, opts)
+		return out, isErr
+	}
+
+	out, isErr := fetch("/page")
+	if isErr || out != "# Docs\n\nSee [the API]("+srv.URL+"/api).\n" {
+		t.Errorf("page: got %q", out)
+	}
+	if out, isErr := fetch("/old"); isErr || !strings.HasPrefix(out, "Redirected to "+srv.URL+"/page\n\n# Docs") {
+		t.Errorf("same-host redirect: got %q", out)
+	}
+	if out, isErr := fetch("/away"); isErr || !strings.Contains(out, "Redirect URL: https://example.com/") {
+		t.Errorf("cross-host redirect: got %q", out)
+	}
+	if out, isErr := fetch("/missing"); !isErr || !strings.Contains(out, "404") {
+		t.Errorf("not found: got %q", out)
+	}
+	if out, isErr := fetch("/big"); !isErr || !strings.Contains(out, "larger than") {
+		t.Errorf("oversized: got %q", out)
+	}
+	if out, isErr, _ := handleWebFetch(context.Background(), map[string]any{"url": "file:///etc/passwd"}, opts); !isErr {
+		t.Errorf("file URL: got %q", out)
+	}
+}
+
+func TestHandleWebFetch_DenyRule(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", t.TempDir())
+	mgr := NewSettingsManager(t.TempDir(), nil)
+	mgr.Initialize()
+	if err := mgr.UpdatePermissionRules(PermissionRules{Deny: []string{"WebFetch(domain:*.internal.example)"}}, PermissionRules{}, false); err != nil {
+		t.Fatal(err)
+	}
+	fetched := false
+	opts := BuiltinToolOptions{settings: mgr, fetch: func(context.Context, string) (webFetchResult, error) {
+		fetched = true
+		return webFetchResult{}, nil
+	}}
+	out, isErr, _ := handleWebFetch(context.Background(), map[string]any{"url": "https://wiki.internal.example/x"}, opts)
+	if !isErr || fetched || !strings.Contains(out, "denied") {
+		t.Errorf("got %q, fetched %v", out, fetched)
+	}
+}
+
+func TestHandleWebFetch_Client(t *testing.T) {
+	agent := NewClaudeAcpAgent(slog.New(slog.NewTextHandler(io.Discard, nil)), AgentOptions{})
+	send, recv := extTestConn(t, agent)
+	if agent.clientWebFetcher("s1") != nil {
+		t.Fatal("fetcher without client support")
+	}
+	agent.clientCapabilities = &acp.ClientCapabilities{Meta: map[string]any{webFetchMethod: true}}
+	opts := BuiltinToolOptions{fetch: agent.clientWebFetcher("s1")}
+
+	done := make(chan string)
+	go func() {
+		out, _, _ := handleWebFetch(context.Background(), map[string]any{"url": "https://example.com/a.json"}, opts)
+		done <- out
+	}()
+	req := recv()
+	if req["method"] != webFetchMethod || req["params"].(map[string]any)["url"] != "https://example.com/a.json" {
+		t.Fatalf("unexpected request %v", req)
+	}
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"url":"https://example.com/a.json","status":200,"contentType":"application/json","body":"{\"ok\":true}"}}`, req["id"]))
+	if out := <-done; out != `{"ok":true}` {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://example.com/docs/")
+	src := `<body>
+<nav><a href="#top">Skip</a></nav>
+<h2>Install  the <code>cli</code></h2>
+<p>Run <strong>one</strong> of:<br>these</p>
+<ul>
+  <li>npm</li>
+  <li>brew
+    <ol><li>tap</li><li>install</li></ol>
+  </li>
+</ul>
+<pre><code class="language-sh">npm i -g x
+
+x --help
+</code></pre>
+<blockquote><p>Note</p><p>Twice</p></blockquote>
+<table><tr><th>Flag</th><th>Use</th></tr><tr><td>-v</td><td>a|b</td></tr></table>
+<img src="logo.png" alt="Logo">
+</body>`
+	want := "Skip\n\n" +
+		"## Install the `cli`\n\n" +
+		"Run **one** of:\nthese\n\n" +
+		"- npm\n" +
+		"- brew\n" +
+		"  1. tap\n" +
+		"  2. install\n\n" +
+		"```sh\nnpm i -g x\n\nx --help\n```\n\n" +
+		"> Note\n>\n> Twice\n\n" +
+		"| Flag | Use |\n| --- | --- |\n| -v | a\\|b |\n\n" +
+		"![Logo](https://example.com/docs/logo.png)\n"
+	got, err := htmlToMarkdown(src, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 
-	acp "github.com/coder/acp-go-sdk"
 	"github.com/gorilla/websocket"
 )
 
@@ -69,13 +70,34 @@ func (w *wsReadWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// wsAuthorized reports whether r carries token, either as an
+// "Authorization: Bearer" header or a "token" query parameter.
+func wsAuthorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		got = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
 // RunWebSocketServer starts a WebSocket server that accepts ACP connections.
 // Each incoming WebSocket connection gets its own AgentSideConnection and
 // ClaudeAcpAgent instance, mirroring the TypeScript implementation pattern.
-func RunWebSocketServer(host string, port int, logger *slog.Logger) error {
+// The agents share a session registry so other clients can watch a session.
+// A non-empty token is required from every client.
+func RunWebSocketServer(host string, port int, token string, logger *slog.Logger, opts AgentOptions) error {
 	mux := http.NewServeMux()
+	hub := newSessionHub()
+
+	mux.HandleFunc("GET /debug/sessions", debugSessionsHandler(hub, token))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !wsAuthorized(r, token) {
+			logger.Warn("Rejected unauthorized WebSocket connection", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			logger.Error("WebSocket upgrade failed", "error", err)
@@ -86,13 +108,13 @@ func RunWebSocketServer(host string, port int, logger *slog.Logger) error {
 		logger.Info("New WebSocket connection from client")
 
 		rw := newWSReadWriter(conn)
-		agent := NewClaudeAcpAgent(logger)
-		acpConn := acp.NewAgentSideConnection(agent, rw, rw)
-		acpConn.SetLogger(logger)
-		agent.SetAgentConnection(acpConn)
+		agent := NewClaudeAcpAgent(logger, opts)
+		agent.hub = hub
+		acpConn := newAgentConnection(agent, rw, rw, logger)
 
 		// Block until the ACP connection is closed (peer disconnects).
 		<-acpConn.Done()
+		agent.closeSessions()
 		logger.Info("WebSocket connection closed")
 	})
 
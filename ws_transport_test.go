@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWSAuthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		want   bool
+	}{
+		{"bearer header", "Bearer s3cret", "", true},
+		{"query param", "", "s3cret", true},
+		{"wrong token", "Bearer nope", "", false},
+		{"missing", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/?token="+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := wsAuthorized(r, "s3cret"); got != tt.want {
+				t.Errorf("wsAuthorized = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}